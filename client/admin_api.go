@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/fatedier/frp/client/proxy"
 	"github.com/fatedier/frp/models/config"
@@ -99,6 +100,17 @@ type ProxyStatusResp struct {
 	LocalAddr  string `json:"local_addr"`
 	Plugin     string `json:"plugin"`
 	RemoteAddr string `json:"remote_addr"`
+
+	HealthCheck *HealthCheckStatusResp `json:"health_check,omitempty"`
+}
+
+type HealthCheckStatusResp struct {
+	Type string `json:"type"`
+	// ok, failed or unknown if no check has been done yet
+	Status        string `json:"status"`
+	LastCheckTime int64  `json:"last_check_time"`
+	LatencyMs     int64  `json:"latency_ms"`
+	Err           string `json:"err"`
 }
 
 type ByProxyStatusResp []ProxyStatusResp
@@ -114,6 +126,21 @@ func NewProxyStatusResp(status *proxy.ProxyStatus, serverAddr string) ProxyStatu
 		Status: status.Status,
 		Err:    status.Err,
 	}
+	if checkType := status.Cfg.GetBaseInfo().HealthCheckType; checkType != "" {
+		psr.HealthCheck = &HealthCheckStatusResp{
+			Type:   checkType,
+			Status: "unknown",
+		}
+		if res := status.LastHealthCheck; res != nil {
+			psr.HealthCheck.Status = "ok"
+			psr.HealthCheck.LastCheckTime = res.Time.Unix()
+			psr.HealthCheck.LatencyMs = int64(res.Latency / time.Millisecond)
+			if res.Err != nil {
+				psr.HealthCheck.Status = "failed"
+				psr.HealthCheck.Err = res.Err.Error()
+			}
+		}
+	}
 	switch cfg := status.Cfg.(type) {
 	case *config.TcpProxyConf:
 		if cfg.LocalPort != 0 {
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/client/health"
+	"github.com/fatedier/frp/client/proxy"
+	"github.com/fatedier/frp/models/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyStatusRespHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := &config.TcpProxyConf{}
+	cfg.LocalIp = "127.0.0.1"
+	cfg.LocalPort = 22
+	status := &proxy.ProxyStatus{Name: "ssh", Type: "tcp", Cfg: cfg}
+
+	psr := NewProxyStatusResp(status, "127.0.0.1")
+	assert.Nil(psr.HealthCheck)
+
+	cfg.HealthCheckType = "tcp"
+	psr = NewProxyStatusResp(status, "127.0.0.1")
+	if assert.NotNil(psr.HealthCheck) {
+		assert.Equal("tcp", psr.HealthCheck.Type)
+		assert.Equal("unknown", psr.HealthCheck.Status)
+	}
+
+	now := time.Now()
+	status.LastHealthCheck = &health.CheckResult{Time: now, Latency: 15 * time.Millisecond}
+	psr = NewProxyStatusResp(status, "127.0.0.1")
+	assert.Equal("ok", psr.HealthCheck.Status)
+	assert.Equal(now.Unix(), psr.HealthCheck.LastCheckTime)
+	assert.Equal(int64(15), psr.HealthCheck.LatencyMs)
+	assert.Equal("", psr.HealthCheck.Err)
+
+	status.LastHealthCheck.Err = errors.New("connection refused")
+	psr = NewProxyStatusResp(status, "127.0.0.1")
+	assert.Equal("failed", psr.HealthCheck.Status)
+	assert.Equal("connection refused", psr.HealthCheck.Err)
+}
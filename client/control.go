@@ -321,6 +321,10 @@ func (ctl *Control) msgHandler() {
 				go ctl.HandleReqWorkConn(m)
 			case *msg.NewProxyResp:
 				ctl.HandleNewProxyResp(m)
+			case *msg.Ping:
+				// frps checks if this client is responsive for load balancing groups
+				xl.Debug("receive probe from server")
+				ctl.sendCh <- &msg.Pong{}
 			case *msg.Pong:
 				if m.Error != "" {
 					xl.Error("Pong contains error: %s", m.Error)
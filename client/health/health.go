@@ -15,15 +15,23 @@
 package health
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/utils/util"
 	"github.com/fatedier/frp/utils/xlog"
 )
 
@@ -31,49 +39,116 @@ var (
 	ErrHealthCheckType = errors.New("error health check type")
 )
 
+const (
+	// max size of http response body or udp response to check
+	maxCheckBodySize = 64 * 1024
+)
+
+// CheckResult is the result of one health check.
+type CheckResult struct {
+	Time    time.Time
+	Latency time.Duration
+	Err     error
+}
+
 type HealthCheckMonitor struct {
 	checkType      string
 	interval       time.Duration
 	timeout        time.Duration
 	maxFailedTimes int
 
-	// For tcp
+	// For tcp and udp
 	addr string
 
-	// For http
-	url string
+	// For http and https
+	url            string
+	method         string
+	headers        map[string]string
+	expectedStatus map[int]struct{}
+	client         *http.Client
+
+	// For http, https and udp
+	bodyRegex *regexp.Regexp
+
+	// For udp
+	udpPayload []byte
+
+	// For exec
+	command []string
 
 	failedTimes    uint64
 	statusOK       bool
 	statusNormalFn func()
 	statusFailedFn func()
 
+	lastResult *CheckResult
+	mu         sync.RWMutex
+
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
-func NewHealthCheckMonitor(ctx context.Context, checkType string,
-	intervalS int, timeoutS int, maxFailedTimes int,
-	addr string, url string,
+func NewHealthCheckMonitor(ctx context.Context, cfg config.HealthCheckConf,
 	statusNormalFn func(), statusFailedFn func()) *HealthCheckMonitor {
 
+	intervalS := cfg.HealthCheckIntervalS
 	if intervalS <= 0 {
 		intervalS = 10
 	}
+	timeoutS := cfg.HealthCheckTimeoutS
 	if timeoutS <= 0 {
 		timeoutS = 3
 	}
+	maxFailedTimes := cfg.HealthCheckMaxFailed
 	if maxFailedTimes <= 0 {
 		maxFailedTimes = 1
 	}
+	method := cfg.HealthCheckHttpMethod
+	if method == "" {
+		method = "GET"
+	}
+
+	// config has been checked before, errors can be ignored here
+	var expectedStatus map[int]struct{}
+	if cfg.HealthCheckExpectedStatus != "" {
+		expectedStatus = make(map[int]struct{})
+		codes, _ := util.ParseRangeNumbers(cfg.HealthCheckExpectedStatus)
+		for _, code := range codes {
+			expectedStatus[int(code)] = struct{}{}
+		}
+	}
+	var bodyRegex *regexp.Regexp
+	if cfg.HealthCheckBodyRegex != "" {
+		bodyRegex, _ = regexp.Compile(cfg.HealthCheckBodyRegex)
+	}
+
 	newctx, cancel := context.WithCancel(ctx)
 	return &HealthCheckMonitor{
-		checkType:      checkType,
+		checkType:      cfg.HealthCheckType,
 		interval:       time.Duration(intervalS) * time.Second,
 		timeout:        time.Duration(timeoutS) * time.Second,
 		maxFailedTimes: maxFailedTimes,
-		addr:           addr,
-		url:            url,
+		addr:           cfg.HealthCheckAddr,
+		url:            cfg.HealthCheckUrl,
+		method:         method,
+		headers:        cfg.HealthCheckHttpHeaders,
+		expectedStatus: expectedStatus,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:             http.ProxyFromEnvironment,
+				DisableKeepAlives: true,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: cfg.HealthCheckTlsSkipVerify,
+				},
+			},
+			// redirects are considered as results
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		bodyRegex:      bodyRegex,
+		udpPayload:     []byte(cfg.HealthCheckUdpPayload),
+		command:        strings.Fields(cfg.HealthCheckCommand),
 		statusOK:       false,
 		statusNormalFn: statusNormalFn,
 		statusFailedFn: statusFailedFn,
@@ -90,10 +165,23 @@ func (monitor *HealthCheckMonitor) Stop() {
 	monitor.cancel()
 }
 
+// LastResult returns the result of the last health check, or nil if there is
+// no finished check yet.
+func (monitor *HealthCheckMonitor) LastResult() *CheckResult {
+	monitor.mu.RLock()
+	defer monitor.mu.RUnlock()
+	if monitor.lastResult == nil {
+		return nil
+	}
+	res := *monitor.lastResult
+	return &res
+}
+
 func (monitor *HealthCheckMonitor) checkWorker() {
 	xl := xlog.FromContextSafe(monitor.ctx)
 	for {
 		doCtx, cancel := context.WithDeadline(monitor.ctx, time.Now().Add(monitor.timeout))
+		start := time.Now()
 		err := monitor.doCheck(doCtx)
 
 		// check if this monitor has been closed
@@ -105,6 +193,14 @@ func (monitor *HealthCheckMonitor) checkWorker() {
 			cancel()
 		}
 
+		monitor.mu.Lock()
+		monitor.lastResult = &CheckResult{
+			Time:    start,
+			Latency: time.Since(start),
+			Err:     err,
+		}
+		monitor.mu.Unlock()
+
 		if err == nil {
 			xl.Trace("do one health check success")
 			if !monitor.statusOK && monitor.statusNormalFn != nil {
@@ -130,8 +226,12 @@ func (monitor *HealthCheckMonitor) doCheck(ctx context.Context) error {
 	switch monitor.checkType {
 	case "tcp":
 		return monitor.doTcpCheck(ctx)
-	case "http":
+	case "http", "https":
 		return monitor.doHttpCheck(ctx)
+	case "udp":
+		return monitor.doUdpCheck(ctx)
+	case "exec":
+		return monitor.doExecCheck(ctx)
 	default:
 		return ErrHealthCheckType
 	}
@@ -153,19 +253,97 @@ func (monitor *HealthCheckMonitor) doTcpCheck(ctx context.Context) error {
 }
 
 func (monitor *HealthCheckMonitor) doHttpCheck(ctx context.Context) error {
-	req, err := http.NewRequest("GET", monitor.url, nil)
+	req, err := http.NewRequest(monitor.method, monitor.url, nil)
 	if err != nil {
 		return err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	req = req.WithContext(ctx)
+	for k, v := range monitor.headers {
+		if strings.EqualFold(k, "host") {
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+
+	resp, err := monitor.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	io.Copy(ioutil.Discard, resp.Body)
 
-	if resp.StatusCode/100 != 2 {
+	if monitor.expectedStatus != nil {
+		if _, ok := monitor.expectedStatus[resp.StatusCode]; !ok {
+			io.Copy(ioutil.Discard, resp.Body)
+			return fmt.Errorf("do http health check, StatusCode is [%d] not expected", resp.StatusCode)
+		}
+	} else if resp.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, resp.Body)
 		return fmt.Errorf("do http health check, StatusCode is [%d] not 2xx", resp.StatusCode)
 	}
+
+	if monitor.bodyRegex == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCheckBodySize))
+	if err != nil {
+		return err
+	}
+	if !monitor.bodyRegex.Match(body) {
+		return fmt.Errorf("do http health check, response body doesn't match [%s]", monitor.bodyRegex.String())
+	}
+	return nil
+}
+
+func (monitor *HealthCheckMonitor) doUdpCheck(ctx context.Context) error {
+	// if udp address is not specified, always return nil
+	if monitor.addr == "" {
+		return nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", monitor.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write(monitor.udpPayload); err != nil {
+		return err
+	}
+	buf := make([]byte, maxCheckBodySize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("do udp health check, read response error: %v", err)
+	}
+	if monitor.bodyRegex != nil && !monitor.bodyRegex.Match(buf[:n]) {
+		return fmt.Errorf("do udp health check, response doesn't match [%s]", monitor.bodyRegex.String())
+	}
+	return nil
+}
+
+func (monitor *HealthCheckMonitor) doExecCheck(ctx context.Context) error {
+	if len(monitor.command) == 0 {
+		return nil
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, monitor.command[0], monitor.command[1:]...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		out := strings.TrimSpace(output.String())
+		if len(out) > 256 {
+			out = out[:256]
+		}
+		if out != "" {
+			return fmt.Errorf("do exec health check error: %v, output: %s", err, out)
+		}
+		return fmt.Errorf("do exec health check error: %v", err)
+	}
 	return nil
 }
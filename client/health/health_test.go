@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doCheck(cfg config.HealthCheckConf) error {
+	monitor := NewHealthCheckMonitor(context.Background(), cfg, nil, nil)
+	defer monitor.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return monitor.doCheck(ctx)
+}
+
+func TestHttpCheck(t *testing.T) {
+	assert := assert.New(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{"status": "ready"}`))
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/header":
+			if r.Host != "example.com" || r.Header.Get("X-Check") != "1" || r.Method != "HEAD" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+
+	assert.NoError(doCheck(config.HealthCheckConf{HealthCheckType: "http", HealthCheckUrl: s.URL + "/ok"}))
+	assert.Error(doCheck(config.HealthCheckConf{HealthCheckType: "http", HealthCheckUrl: s.URL + "/down"}))
+
+	// redirects are not followed, 302 is not 2xx
+	assert.Error(doCheck(config.HealthCheckConf{HealthCheckType: "http", HealthCheckUrl: s.URL + "/redirect"}))
+	assert.NoError(doCheck(config.HealthCheckConf{
+		HealthCheckType:           "http",
+		HealthCheckUrl:            s.URL + "/redirect",
+		HealthCheckExpectedStatus: "200,300-399",
+	}))
+	assert.Error(doCheck(config.HealthCheckConf{
+		HealthCheckType:           "http",
+		HealthCheckUrl:            s.URL + "/ok",
+		HealthCheckExpectedStatus: "204",
+	}))
+
+	assert.NoError(doCheck(config.HealthCheckConf{
+		HealthCheckType:      "http",
+		HealthCheckUrl:       s.URL + "/ok",
+		HealthCheckBodyRegex: `"status":\s*"ready"`,
+	}))
+	assert.Error(doCheck(config.HealthCheckConf{
+		HealthCheckType:      "http",
+		HealthCheckUrl:       s.URL + "/ok",
+		HealthCheckBodyRegex: `"status":\s*"starting"`,
+	}))
+
+	assert.NoError(doCheck(config.HealthCheckConf{
+		HealthCheckType:        "http",
+		HealthCheckUrl:         s.URL + "/header",
+		HealthCheckHttpMethod:  "HEAD",
+		HealthCheckHttpHeaders: map[string]string{"Host": "example.com", "X-Check": "1"},
+	}))
+}
+
+func TestHttpsCheck(t *testing.T) {
+	assert := assert.New(t)
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	assert.Error(doCheck(config.HealthCheckConf{HealthCheckType: "https", HealthCheckUrl: s.URL}))
+	assert.NoError(doCheck(config.HealthCheckConf{
+		HealthCheckType:          "https",
+		HealthCheckUrl:           s.URL,
+		HealthCheckTlsSkipVerify: true,
+	}))
+}
+
+func TestUdpCheck(t *testing.T) {
+	assert := assert.New(t)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(err)
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, raddr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "ping" {
+				conn.WriteToUDP([]byte("pong"), raddr)
+			}
+		}
+	}()
+	addr := conn.LocalAddr().String()
+
+	assert.NoError(doCheck(config.HealthCheckConf{
+		HealthCheckType:       "udp",
+		HealthCheckAddr:       addr,
+		HealthCheckUdpPayload: "ping",
+		HealthCheckBodyRegex:  "^pong$",
+	}))
+	assert.Error(doCheck(config.HealthCheckConf{
+		HealthCheckType:       "udp",
+		HealthCheckAddr:       addr,
+		HealthCheckUdpPayload: "ping",
+		HealthCheckBodyRegex:  "^ok$",
+	}))
+	// no response in timeout
+	assert.Error(doCheck(config.HealthCheckConf{
+		HealthCheckType:       "udp",
+		HealthCheckAddr:       addr,
+		HealthCheckUdpPayload: "hello",
+	}))
+}
+
+func TestExecCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(doCheck(config.HealthCheckConf{HealthCheckType: "exec", HealthCheckCommand: "true"}))
+	assert.Error(doCheck(config.HealthCheckConf{HealthCheckType: "exec", HealthCheckCommand: "false"}))
+	err := doCheck(config.HealthCheckConf{HealthCheckType: "exec", HealthCheckCommand: "ls /not-exist"})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "output: ")
+	}
+	// killed at timeout
+	assert.Error(doCheck(config.HealthCheckConf{HealthCheckType: "exec", HealthCheckCommand: "sleep 5"}))
+}
+
+func TestLastResult(t *testing.T) {
+	assert := assert.New(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	monitor := NewHealthCheckMonitor(context.Background(), config.HealthCheckConf{
+		HealthCheckType: "http",
+		HealthCheckUrl:  s.URL,
+	}, nil, nil)
+	assert.Nil(monitor.LastResult())
+	monitor.Start()
+	defer monitor.Stop()
+
+	for i := 0; i < 100 && monitor.LastResult() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	res := monitor.LastResult()
+	if assert.NotNil(res) {
+		assert.Error(res.Err)
+		assert.False(res.Time.IsZero())
+	}
+}
@@ -21,13 +21,13 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/models/nathole"
 	plugin "github.com/fatedier/frp/models/plugin/client"
 	"github.com/fatedier/frp/models/proto/udp"
 	"github.com/fatedier/frp/utils/limit"
@@ -283,6 +283,9 @@ func (pxy *XtcpProxy) Run() (err error) {
 			return
 		}
 	}
+	if pxy.serverUDPPort != 0 {
+		nathole.DiscoverInBackground(pxy.clientCfg.ServerAddr, pxy.serverUDPPort)
+	}
 	return
 }
 
@@ -294,6 +297,15 @@ func (pxy *XtcpProxy) Close() {
 
 func (pxy *XtcpProxy) InWorkConn(conn net.Conn, m *msg.StartWorkConn) {
 	xl := pxy.xl
+	// Work connections are relayed by frps for visitors failed to make a nat
+	// hole, others are used to make nat holes.
+	if m.Relay {
+		xl.Debug("xtcp connection relayed by server")
+		HandleTcpWorkConnection(pxy.ctx, &pxy.cfg.LocalSvrConf, pxy.proxyPlugin, &pxy.cfg.BaseProxyConf, pxy.limiter,
+			conn, []byte(pxy.clientCfg.Token), m)
+		return
+	}
+
 	defer conn.Close()
 	var natHoleSidMsg msg.NatHoleSid
 	err := msg.ReadMsgInto(conn, &natHoleSidMsg)
@@ -302,9 +314,12 @@ func (pxy *XtcpProxy) InWorkConn(conn net.Conn, m *msg.StartWorkConn) {
 		return
 	}
 
+	feature := pxy.natFeature()
 	natHoleClientMsg := &msg.NatHoleClient{
 		ProxyName: pxy.cfg.ProxyName,
 		Sid:       natHoleSidMsg.Sid,
+		NatType:   feature.NatType,
+		PortDelta: feature.PortDelta,
 	}
 	raddr, _ := net.ResolveUDPAddr("udp",
 		fmt.Sprintf("%s:%d", pxy.clientCfg.ServerAddr, pxy.serverUDPPort))
@@ -342,23 +357,20 @@ func (pxy *XtcpProxy) InWorkConn(conn net.Conn, m *msg.StartWorkConn) {
 
 	xl.Trace("get natHoleRespMsg, sid [%s], client address [%s] visitor address [%s]", natHoleRespMsg.Sid, natHoleRespMsg.ClientAddr, natHoleRespMsg.VisitorAddr)
 
-	// Send detect message
-	array := strings.Split(natHoleRespMsg.VisitorAddr, ":")
-	if len(array) <= 1 {
-		xl.Error("get NatHoleResp visitor address error: %v", natHoleRespMsg.VisitorAddr)
-	}
-	laddr, _ := net.ResolveUDPAddr("udp", clientConn.LocalAddr().String())
-	/*
-		for i := 1000; i < 65000; i++ {
-			pxy.sendDetectMsg(array[0], int64(i), laddr, "a")
-		}
-	*/
-	port, err := strconv.ParseInt(array[1], 10, 64)
+	// Send detect message to all possible addresses of visitor, so the
+	// visitor's packets can pass through our NAT.
+	visitorAddr, err := net.ResolveUDPAddr("udp", natHoleRespMsg.VisitorAddr)
 	if err != nil {
 		xl.Error("get natHoleResp visitor address error: %v", natHoleRespMsg.VisitorAddr)
 		return
 	}
-	pxy.sendDetectMsg(array[0], int(port), laddr, []byte(natHoleRespMsg.Sid))
+	laddr, _ := net.ResolveUDPAddr("udp", clientConn.LocalAddr().String())
+	daddrs, strategy := nathole.PredictAddrs(visitorAddr, natHoleRespMsg.VisitorNatType, natHoleRespMsg.VisitorPortDelta)
+	xl.Debug("nat type [%s], visitor nat type [%s], send detect msg to %d addresses by %s",
+		feature.NatType, natHoleRespMsg.VisitorNatType, len(daddrs), strategy)
+	for _, daddr := range daddrs {
+		pxy.sendDetectMsg(daddr, laddr, []byte(natHoleRespMsg.Sid))
+	}
 	xl.Trace("send all detect msg done")
 
 	msg.WriteMsg(conn, &msg.NatHoleClientDetectOK{})
@@ -414,12 +426,13 @@ func (pxy *XtcpProxy) InWorkConn(conn net.Conn, m *msg.StartWorkConn) {
 		muxConn, []byte(pxy.cfg.Sk), m)
 }
 
-func (pxy *XtcpProxy) sendDetectMsg(addr string, port int, laddr *net.UDPAddr, content []byte) (err error) {
-	daddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", addr, port))
-	if err != nil {
-		return err
-	}
+// natFeature returns the NAT feature discovered in the background, so making
+// nat holes doesn't wait for the discovery.
+func (pxy *XtcpProxy) natFeature() *nathole.NatFeature {
+	return nathole.LastFeature(pxy.clientCfg.ServerAddr, pxy.serverUDPPort)
+}
 
+func (pxy *XtcpProxy) sendDetectMsg(daddr *net.UDPAddr, laddr *net.UDPAddr, content []byte) (err error) {
 	tConn, err := net.DialUDP("udp", laddr, daddr)
 	if err != nil {
 		return err
@@ -756,7 +769,7 @@ func HandleTcpWorkConnection(ctx context.Context, localInfo *config.LocalSvrConf
 	if proxyPlugin != nil {
 		// if plugin is set, let plugin handle connections first
 		xl.Debug("handle by plugin: %s", proxyPlugin.Name())
-		proxyPlugin.Handle(remote, workConn, extraInfo)
+		proxyPlugin.Handle(remote, pluginRealConn(proxyPlugin, workConn, m), extraInfo)
 		xl.Debug("handle by plugin finished")
 		return
 	} else {
@@ -778,3 +791,16 @@ func HandleTcpWorkConnection(ctx context.Context, localInfo *config.LocalSvrConf
 		xl.Debug("join connections closed")
 	}
 }
+
+// pluginRealConn reports the address of the user as the remote address of
+// workConn for plugins which need it, others see the address of frps.
+func pluginRealConn(proxyPlugin plugin.Plugin, workConn net.Conn, m *msg.StartWorkConn) net.Conn {
+	if p, ok := proxyPlugin.(plugin.UserAddrPlugin); !ok || !p.NeedUserAddr() {
+		return workConn
+	}
+	ip := net.ParseIP(m.SrcAddr)
+	if ip == nil {
+		return workConn
+	}
+	return frpNet.WrapRemoteAddrConn(workConn, &net.TCPAddr{IP: ip, Port: int(m.SrcPort)})
+}
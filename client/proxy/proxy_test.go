@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/models/msg"
+	plugin "github.com/fatedier/frp/models/plugin/client"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPluginRealConn(t *testing.T) {
+	assert := assert.New(t)
+
+	workConn, other := net.Pipe()
+	defer workConn.Close()
+	defer other.Close()
+	m := &msg.StartWorkConn{
+		SrcAddr: "1.2.3.4",
+		SrcPort: 5678,
+	}
+
+	// plugins which check addresses of users see the address of the user
+	for _, name := range []string{plugin.PluginSocks5, plugin.PluginHttpProxy} {
+		p, err := plugin.Create(name, map[string]string{})
+		if !assert.NoError(err, name) {
+			continue
+		}
+		assert.Equal("1.2.3.4:5678", pluginRealConn(p, workConn, m).RemoteAddr().String(), name)
+
+		// work connection is kept if the address of the user is unknown
+		assert.Equal(workConn, pluginRealConn(p, workConn, &msg.StartWorkConn{}), name)
+		p.Close()
+	}
+
+	// others see the work connection
+	p, err := plugin.Create(plugin.PluginUnixDomainSocket, map[string]string{
+		"plugin_unix_path": "/tmp/frp_test.sock",
+	})
+	if assert.NoError(err) {
+		assert.Equal(workConn, pluginRealConn(p, workConn, m))
+		p.Close()
+	}
+}
+
+func TestXtcpProxyRelayWorkConn(t *testing.T) {
+	assert := assert.New(t)
+
+	accepted := make(chan struct{}, 10)
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	cfg := &config.XtcpProxyConf{}
+	cfg.ProxyName = "xtcp"
+	cfg.ProxyType = "xtcp"
+	cfg.LocalIp = "127.0.0.1"
+	cfg.LocalPort = echo.Addr().(*net.TCPAddr).Port
+	pxy := NewProxy(context.Background(), cfg, config.GetDefaultClientConf(), 0)
+	assert.NoError(pxy.Run())
+	defer pxy.Close()
+
+	// relayed work connections are joined with the local service like stcp
+	workConn, frps := net.Pipe()
+	defer frps.Close()
+	go pxy.InWorkConn(workConn, &msg.StartWorkConn{ProxyName: "xtcp", Relay: true})
+	go frps.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	frps.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(frps, buf)
+	assert.NoError(err)
+	assert.Equal("ping", string(buf))
+	assert.Len(accepted, 1)
+
+	// others are used to make nat holes, the local service isn't connected
+	workConn2, frps2 := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		pxy.InWorkConn(workConn2, &msg.StartWorkConn{ProxyName: "xtcp"})
+		close(done)
+	}()
+	frps2.Write([]byte("not a message"))
+	frps2.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("work connection is not closed")
+	}
+	assert.Len(accepted, 1)
+}
@@ -41,6 +41,10 @@ type ProxyStatus struct {
 
 	// Got from server.
 	RemoteAddr string `json:"remote_addr"`
+
+	// Result of the last health check, nil if health check is disabled or
+	// hasn't been done yet.
+	LastHealthCheck *health.CheckResult `json:"-"`
 }
 
 type ProxyWrapper struct {
@@ -86,9 +90,8 @@ func NewProxyWrapper(ctx context.Context, cfg config.ProxyConf, clientCfg config
 
 	if baseInfo.HealthCheckType != "" {
 		pw.health = 1 // means failed
-		pw.monitor = health.NewHealthCheckMonitor(pw.ctx, baseInfo.HealthCheckType, baseInfo.HealthCheckIntervalS,
-			baseInfo.HealthCheckTimeoutS, baseInfo.HealthCheckMaxFailed, baseInfo.HealthCheckAddr,
-			baseInfo.HealthCheckUrl, pw.statusNormalCallback, pw.statusFailedCallback)
+		pw.monitor = health.NewHealthCheckMonitor(pw.ctx, baseInfo.HealthCheckConf,
+			pw.statusNormalCallback, pw.statusFailedCallback)
 		xl.Trace("enable health check monitor")
 	}
 
@@ -246,5 +249,8 @@ func (pw *ProxyWrapper) GetStatus() *ProxyStatus {
 		Cfg:        pw.Cfg,
 		RemoteAddr: pw.RemoteAddr,
 	}
+	if pw.monitor != nil {
+		ps.LastHealthCheck = pw.monitor.LastResult()
+	}
 	return ps
 }
@@ -26,6 +26,8 @@ import (
 
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/models/nathole"
+	plugin "github.com/fatedier/frp/models/plugin/visitor"
 	"github.com/fatedier/frp/models/proto/udp"
 	frpNet "github.com/fatedier/frp/utils/net"
 	"github.com/fatedier/frp/utils/util"
@@ -73,12 +75,45 @@ func NewVisitor(ctx context.Context, ctl *Control, cfg config.VisitorConf) (visi
 type BaseVisitor struct {
 	ctl    *Control
 	l      net.Listener
+	plugin plugin.Plugin
 	closed bool
 
 	mu  sync.RWMutex
 	ctx context.Context
 }
 
+// startPlugin creates the plugin of cfg, connections of local users are sent
+// to it instead of being joined with connections opened by dial.
+func (v *BaseVisitor) startPlugin(cfg *config.BaseVisitorConf, dial plugin.DialFunc) (err error) {
+	if cfg.Plugin == "" {
+		return nil
+	}
+	v.plugin, err = plugin.Create(cfg.Plugin, cfg.PluginParams, dial)
+	return
+}
+
+func (v *BaseVisitor) closePlugin() {
+	if v.plugin != nil {
+		v.plugin.Close()
+	}
+}
+
+func (v *BaseVisitor) handleUserConn(userConn net.Conn, dial plugin.DialFunc) {
+	xl := xlog.FromContextSafe(v.ctx)
+	if v.plugin != nil {
+		v.plugin.Handle(userConn)
+		return
+	}
+
+	defer userConn.Close()
+	remote, err := dial()
+	if err != nil {
+		xl.Warn("%v", err)
+		return
+	}
+	frpIo.Join(userConn, remote)
+}
+
 type StcpVisitor struct {
 	*BaseVisitor
 
@@ -86,8 +121,12 @@ type StcpVisitor struct {
 }
 
 func (sv *StcpVisitor) Run() (err error) {
+	if err = sv.startPlugin(&sv.cfg.BaseVisitorConf, sv.openConn); err != nil {
+		return
+	}
 	sv.l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", sv.cfg.BindAddr, sv.cfg.BindPort))
 	if err != nil {
+		sv.closePlugin()
 		return
 	}
 
@@ -97,6 +136,7 @@ func (sv *StcpVisitor) Run() (err error) {
 
 func (sv *StcpVisitor) Close() {
 	sv.l.Close()
+	sv.closePlugin()
 }
 
 func (sv *StcpVisitor) worker() {
@@ -108,64 +148,77 @@ func (sv *StcpVisitor) worker() {
 			return
 		}
 
-		go sv.handleConn(conn)
+		xlog.FromContextSafe(sv.ctx).Debug("get a new stcp user connection")
+		if sv.cfg.Multiplexer != "" {
+			go sv.handleMuxConn(conn)
+		} else {
+			go sv.handleUserConn(conn, sv.openConn)
+		}
 	}
 }
 
-func (sv *StcpVisitor) handleConn(userConn net.Conn) {
-	xl := xlog.FromContextSafe(sv.ctx)
-	defer userConn.Close()
+// openConn opens a new connection to the proxy of server_name through frps.
+func (sv *StcpVisitor) openConn() (conn net.Conn, err error) {
+	return sv.openConnTo(sv.cfg.ServerName, sv.cfg.Sk)
+}
 
-	xl.Debug("get a new stcp user connection")
-	visitorConn, err := sv.ctl.connectServer()
+func (sv *StcpVisitor) openConnTo(serverName string, sk string) (conn net.Conn, err error) {
+	return sv.openRelayConn(&sv.cfg.BaseVisitorConf, serverName, sk)
+}
+
+// openRelayConn opens a new connection to the proxy which is relayed by frps.
+func (v *BaseVisitor) openRelayConn(cfg *config.BaseVisitorConf, serverName string, sk string) (conn net.Conn, err error) {
+	xl := xlog.FromContextSafe(v.ctx)
+	visitorConn, err := v.ctl.connectServer()
 	if err != nil {
-		return
+		return nil, fmt.Errorf("connect to server error: %v", err)
 	}
-	defer visitorConn.Close()
+	defer func() {
+		if err != nil {
+			visitorConn.Close()
+		}
+	}()
 
 	now := time.Now().Unix()
 	newVisitorConnMsg := &msg.NewVisitorConn{
-		ProxyName:      sv.cfg.ServerName,
-		SignKey:        util.GetAuthKey(sv.cfg.Sk, now),
+		ProxyName:      serverName,
+		SignKey:        util.GetAuthKey(sk, now),
 		Timestamp:      now,
-		UseEncryption:  sv.cfg.UseEncryption,
-		UseCompression: sv.cfg.UseCompression,
+		UseEncryption:  cfg.UseEncryption,
+		UseCompression: cfg.UseCompression,
 	}
 	err = msg.WriteMsg(visitorConn, newVisitorConnMsg)
 	if err != nil {
-		xl.Warn("send newVisitorConnMsg to server error: %v", err)
-		return
+		return nil, fmt.Errorf("send newVisitorConnMsg to server error: %v", err)
 	}
 
 	var newVisitorConnRespMsg msg.NewVisitorConnResp
 	visitorConn.SetReadDeadline(time.Now().Add(10 * time.Second))
 	err = msg.ReadMsgInto(visitorConn, &newVisitorConnRespMsg)
 	if err != nil {
-		xl.Warn("get newVisitorConnRespMsg error: %v", err)
-		return
+		return nil, fmt.Errorf("get newVisitorConnRespMsg error: %v", err)
 	}
 	visitorConn.SetReadDeadline(time.Time{})
 
 	if newVisitorConnRespMsg.Error != "" {
-		xl.Warn("start new visitor connection error: %s", newVisitorConnRespMsg.Error)
-		return
+		err = fmt.Errorf("start new visitor connection error: %s", newVisitorConnRespMsg.Error)
+		return nil, err
 	}
 
 	var remote io.ReadWriteCloser
 	remote = visitorConn
-	if sv.cfg.UseEncryption {
-		remote, err = frpIo.WithEncryption(remote, []byte(sv.cfg.Sk))
+	if cfg.UseEncryption {
+		remote, err = frpIo.WithEncryption(remote, []byte(sk))
 		if err != nil {
 			xl.Error("create encryption stream error: %v", err)
-			return
+			return nil, err
 		}
 	}
 
-	if sv.cfg.UseCompression {
+	if cfg.UseCompression {
 		remote = frpIo.WithCompression(remote)
 	}
-
-	frpIo.Join(userConn, remote)
+	return frpNet.WrapReadWriteCloserToConn(remote, visitorConn), nil
 }
 
 type XtcpVisitor struct {
@@ -175,10 +228,17 @@ type XtcpVisitor struct {
 }
 
 func (sv *XtcpVisitor) Run() (err error) {
+	if err = sv.startPlugin(&sv.cfg.BaseVisitorConf, sv.openConn); err != nil {
+		return
+	}
 	sv.l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", sv.cfg.BindAddr, sv.cfg.BindPort))
 	if err != nil {
+		sv.closePlugin()
 		return
 	}
+	if sv.ctl.serverUDPPort != 0 {
+		nathole.DiscoverInBackground(sv.ctl.clientCfg.ServerAddr, sv.ctl.serverUDPPort)
+	}
 
 	go sv.worker()
 	return
@@ -186,6 +246,7 @@ func (sv *XtcpVisitor) Run() (err error) {
 
 func (sv *XtcpVisitor) Close() {
 	sv.l.Close()
+	sv.closePlugin()
 }
 
 func (sv *XtcpVisitor) worker() {
@@ -197,44 +258,95 @@ func (sv *XtcpVisitor) worker() {
 			return
 		}
 
-		go sv.handleConn(conn)
+		xlog.FromContextSafe(sv.ctx).Debug("get a new xtcp user connection")
+		go sv.handleUserConn(conn, sv.openConn)
 	}
 }
 
-func (sv *XtcpVisitor) handleConn(userConn net.Conn) {
+// openConn tries to make a nat hole to the proxy at first, the connection is
+// relayed by frps if it fails in nat_hole_timeout and relay_fallback is
+// enabled.
+func (sv *XtcpVisitor) openConn() (conn net.Conn, err error) {
+	return sv.openConnWithFallback(sv.openNatHoleConn, func() (net.Conn, error) {
+		return sv.openRelayConn(&sv.cfg.BaseVisitorConf, sv.cfg.ServerName, sv.cfg.Sk)
+	})
+}
+
+func (sv *XtcpVisitor) openConnWithFallback(natHoleFn, relayFn func() (net.Conn, error)) (conn net.Conn, err error) {
 	xl := xlog.FromContextSafe(sv.ctx)
-	defer userConn.Close()
+	if !sv.cfg.RelayFallback {
+		return natHoleFn()
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		conn, err := natHoleFn()
+		resultCh <- result{conn: conn, err: err}
+	}()
+
+	timer := time.NewTimer(time.Duration(sv.cfg.NatHoleTimeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case res := <-resultCh:
+		if res.err == nil {
+			xl.Info("xtcp connection to [%s] is made by p2p", sv.cfg.ServerName)
+			return res.conn, nil
+		}
+		xl.Warn("make nat hole error: %v, fall back to relay by server", res.err)
+	case <-timer.C:
+		xl.Warn("make nat hole timeout, fall back to relay by server")
+		go func() {
+			if res := <-resultCh; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+	}
+
+	conn, err = relayFn()
+	if err != nil {
+		return nil, err
+	}
+	xl.Info("xtcp connection to [%s] is relayed by server", sv.cfg.ServerName)
+	return conn, nil
+}
 
-	xl.Debug("get a new xtcp user connection")
+// openNatHoleConn makes a nat hole to the proxy and opens a stream on it, the
+// returned connection closes the hole too.
+func (sv *XtcpVisitor) openNatHoleConn() (conn net.Conn, err error) {
+	xl := xlog.FromContextSafe(sv.ctx)
 	if sv.ctl.serverUDPPort == 0 {
-		xl.Error("xtcp is not supported by server")
-		return
+		return nil, fmt.Errorf("xtcp is not supported by server")
 	}
 
 	raddr, err := net.ResolveUDPAddr("udp",
 		fmt.Sprintf("%s:%d", sv.ctl.clientCfg.ServerAddr, sv.ctl.serverUDPPort))
 	if err != nil {
-		xl.Error("resolve server UDP addr error")
-		return
+		return nil, fmt.Errorf("resolve server UDP addr error")
 	}
 
 	visitorConn, err := net.DialUDP("udp", nil, raddr)
 	if err != nil {
-		xl.Warn("dial server udp addr error: %v", err)
-		return
+		return nil, fmt.Errorf("dial server udp addr error: %v", err)
 	}
 	defer visitorConn.Close()
 
+	feature := nathole.LastFeature(sv.ctl.clientCfg.ServerAddr, sv.ctl.serverUDPPort)
+
 	now := time.Now().Unix()
 	natHoleVisitorMsg := &msg.NatHoleVisitor{
 		ProxyName: sv.cfg.ServerName,
 		SignKey:   util.GetAuthKey(sv.cfg.Sk, now),
 		Timestamp: now,
+		NatType:   feature.NatType,
+		PortDelta: feature.PortDelta,
 	}
 	err = msg.WriteMsg(visitorConn, natHoleVisitorMsg)
 	if err != nil {
-		xl.Warn("send natHoleVisitorMsg to server error: %v", err)
-		return
+		return nil, fmt.Errorf("send natHoleVisitorMsg to server error: %v", err)
 	}
 
 	// Wait for client address at most 10 seconds.
@@ -243,21 +355,18 @@ func (sv *XtcpVisitor) handleConn(userConn net.Conn) {
 	buf := pool.GetBuf(1024)
 	n, err := visitorConn.Read(buf)
 	if err != nil {
-		xl.Warn("get natHoleRespMsg error: %v", err)
-		return
+		return nil, fmt.Errorf("get natHoleRespMsg error: %v", err)
 	}
 
 	err = msg.ReadMsgInto(bytes.NewReader(buf[:n]), &natHoleRespMsg)
 	if err != nil {
-		xl.Warn("get natHoleRespMsg error: %v", err)
-		return
+		return nil, fmt.Errorf("get natHoleRespMsg error: %v", err)
 	}
 	visitorConn.SetReadDeadline(time.Time{})
 	pool.PutBuf(buf)
 
 	if natHoleRespMsg.Error != "" {
-		xl.Error("natHoleRespMsg get error info: %s", natHoleRespMsg.Error)
-		return
+		return nil, fmt.Errorf("natHoleRespMsg get error info: %s", natHoleRespMsg.Error)
 	}
 
 	xl.Trace("get natHoleRespMsg, sid [%s], client address [%s], visitor address [%s]", natHoleRespMsg.Sid, natHoleRespMsg.ClientAddr, natHoleRespMsg.VisitorAddr)
@@ -265,45 +374,53 @@ func (sv *XtcpVisitor) handleConn(userConn net.Conn) {
 	// Close visitorConn, so we can use it's local address.
 	visitorConn.Close()
 
-	// send sid message to client
+	// send sid message to all possible addresses of client
 	laddr, _ := net.ResolveUDPAddr("udp", visitorConn.LocalAddr().String())
-	daddr, err := net.ResolveUDPAddr("udp", natHoleRespMsg.ClientAddr)
+	clientAddr, err := net.ResolveUDPAddr("udp", natHoleRespMsg.ClientAddr)
 	if err != nil {
-		xl.Error("resolve client udp address error: %v", err)
-		return
+		return nil, fmt.Errorf("resolve client udp address error: %v", err)
 	}
-	lConn, err := net.DialUDP("udp", laddr, daddr)
+	lConn, err := net.ListenUDP("udp", laddr)
 	if err != nil {
-		xl.Error("dial client udp address error: %v", err)
-		return
+		return nil, fmt.Errorf("listen udp address error: %v", err)
 	}
-	defer lConn.Close()
+	defer func() {
+		if err != nil {
+			lConn.Close()
+		}
+	}()
 
-	lConn.Write([]byte(natHoleRespMsg.Sid))
+	daddrs, strategy := nathole.PredictAddrs(clientAddr, natHoleRespMsg.ClientNatType, natHoleRespMsg.ClientPortDelta)
+	xl.Debug("nat type [%s], client nat type [%s], send sid to %d addresses by %s",
+		feature.NatType, natHoleRespMsg.ClientNatType, len(daddrs), strategy)
+	for _, daddr := range daddrs {
+		lConn.WriteToUDP([]byte(natHoleRespMsg.Sid), daddr)
+	}
 
-	// read ack sid from client
+	// read ack sid from client, the address it comes from is used
+	var uAddr *net.UDPAddr
 	sidBuf := pool.GetBuf(1024)
 	lConn.SetReadDeadline(time.Now().Add(8 * time.Second))
-	n, err = lConn.Read(sidBuf)
-	if err != nil {
-		xl.Warn("get sid from client error: %v", err)
-		return
+	for {
+		n, uAddr, err = lConn.ReadFromUDP(sidBuf)
+		if err != nil {
+			return nil, fmt.Errorf("get sid from client error: %v", err)
+		}
+		if string(sidBuf[:n]) == natHoleRespMsg.Sid {
+			break
+		}
 	}
 	lConn.SetReadDeadline(time.Time{})
-	if string(sidBuf[:n]) != natHoleRespMsg.Sid {
-		xl.Warn("incorrect sid from client")
-		return
-	}
 	pool.PutBuf(sidBuf)
 
-	xl.Info("nat hole connection make success, sid [%s]", natHoleRespMsg.Sid)
+	xl.Info("nat hole connection make success, sid [%s], client address [%s]", natHoleRespMsg.Sid, uAddr)
+	sv.reportNatHoleOK(raddr, natHoleRespMsg.Sid)
 
 	// wrap kcp connection
 	var remote io.ReadWriteCloser
-	remote, err = frpNet.NewKcpConnFromUdp(lConn, true, natHoleRespMsg.ClientAddr)
+	remote, err = frpNet.NewKcpConnFromUdp(lConn, false, uAddr.String())
 	if err != nil {
-		xl.Error("create kcp connection from udp connection error: %v", err)
-		return
+		return nil, fmt.Errorf("create kcp connection from udp connection error: %v", err)
 	}
 
 	fmuxCfg := fmux.DefaultConfig()
@@ -311,30 +428,47 @@ func (sv *XtcpVisitor) handleConn(userConn net.Conn) {
 	fmuxCfg.LogOutput = ioutil.Discard
 	sess, err := fmux.Client(remote, fmuxCfg)
 	if err != nil {
-		xl.Error("create yamux session error: %v", err)
-		return
+		return nil, fmt.Errorf("create yamux session error: %v", err)
 	}
-	defer sess.Close()
 	muxConn, err := sess.Open()
 	if err != nil {
-		xl.Error("open yamux stream error: %v", err)
-		return
+		sess.Close()
+		return nil, fmt.Errorf("open yamux stream error: %v", err)
 	}
 
 	var muxConnRWCloser io.ReadWriteCloser = muxConn
 	if sv.cfg.UseEncryption {
 		muxConnRWCloser, err = frpIo.WithEncryption(muxConnRWCloser, []byte(sv.cfg.Sk))
 		if err != nil {
-			xl.Error("create encryption stream error: %v", err)
-			return
+			sess.Close()
+			return nil, fmt.Errorf("create encryption stream error: %v", err)
 		}
 	}
 	if sv.cfg.UseCompression {
 		muxConnRWCloser = frpIo.WithCompression(muxConnRWCloser)
 	}
 
-	frpIo.Join(userConn, muxConnRWCloser)
-	xl.Debug("join connections closed")
+	closeFn := func() error {
+		muxConnRWCloser.Close()
+		sess.Close()
+		xl.Debug("join connections closed")
+		return lConn.Close()
+	}
+	return frpNet.WrapReadWriteCloserToConn(frpIo.WrapReadWriteCloser(muxConnRWCloser, muxConnRWCloser, closeFn), muxConn), nil
+}
+
+// reportNatHoleOK tells frps the nat hole of sid is made, it's only used by
+// metrics so errors are ignored.
+func (sv *XtcpVisitor) reportNatHoleOK(raddr *net.UDPAddr, sid string) {
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	msg.WriteMsg(conn, &msg.NatHoleVisitorOK{
+		ProxyName: sv.cfg.ServerName,
+		Sid:       sid,
+	})
 }
 
 type SudpVisitor struct {
@@ -0,0 +1,190 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fatedier/frp/models/consts"
+	"github.com/fatedier/frp/utils/xlog"
+
+	frpIo "github.com/fatedier/golib/io"
+)
+
+// handleMuxConn reads the target proxy from the handshake of userConn, then
+// joins it with a connection to the target.
+func (sv *StcpVisitor) handleMuxConn(userConn net.Conn) {
+	xl := xlog.FromContextSafe(sv.ctx)
+	defer userConn.Close()
+
+	var (
+		target string
+		reply  func(w io.Writer, ok bool)
+		err    error
+	)
+	rd := bufio.NewReader(userConn)
+	userConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	switch sv.cfg.Multiplexer {
+	case consts.HttpConnectTcpMultiplexer:
+		target, reply, err = readHttpConnectTarget(rd, userConn)
+	case consts.Socks5TcpMultiplexer:
+		target, reply, err = readSocks5Target(rd, userConn)
+	}
+	if err != nil {
+		xl.Debug("read target of %s connection error: %v", sv.cfg.Multiplexer, err)
+		return
+	}
+	userConn.SetReadDeadline(time.Time{})
+
+	sk, err := sv.lookupSk(target)
+	if err != nil {
+		xl.Warn("read sk_file error: %v", err)
+		reply(userConn, false)
+		return
+	}
+	remote, err := sv.openConnTo(target, sk)
+	if err != nil {
+		xl.Warn("connect to [%s] error: %v", target, err)
+		reply(userConn, false)
+		return
+	}
+	xl.Debug("new connection to [%s]", target)
+	reply(userConn, true)
+	frpIo.Join(frpIo.WrapReadWriteCloser(rd, userConn, userConn.Close), remote)
+}
+
+// lookupSk returns sk of the first pattern in sk_file which matches target.
+// The file is read for each connection so sks can be changed without
+// reloading.
+func (sv *StcpVisitor) lookupSk(target string) (string, error) {
+	if sv.cfg.SkFile == "" {
+		return sv.cfg.Sk, nil
+	}
+	f, err := os.Open(sv.cfg.SkFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if matched, _ := path.Match(fields[0], target); matched {
+			return fields[1], nil
+		}
+	}
+	return sv.cfg.Sk, scanner.Err()
+}
+
+// readHttpConnectTarget reads "CONNECT <target>:<port> HTTP/1.1", the port is
+// ignored.
+func readHttpConnectTarget(rd *bufio.Reader, w io.Writer) (target string, reply func(w io.Writer, ok bool), err error) {
+	req, err := http.ReadRequest(rd)
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		w.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\n\r\n"))
+		return "", nil, fmt.Errorf("unsupported method [%s]", req.Method)
+	}
+	target = req.URL.Host
+	if host, _, splitErr := net.SplitHostPort(target); splitErr == nil {
+		target = host
+	}
+	if target == "" {
+		w.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		return "", nil, fmt.Errorf("empty target")
+	}
+	reply = func(w io.Writer, ok bool) {
+		if ok {
+			w.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		} else {
+			w.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		}
+	}
+	return
+}
+
+// readSocks5Target supports CONNECT requests with domain names without
+// authentication, the port is ignored.
+func readSocks5Target(rd *bufio.Reader, w io.Writer) (target string, reply func(w io.Writer, ok bool), err error) {
+	// VER NMETHODS METHODS
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(rd, header); err != nil {
+		return
+	}
+	if header[0] != 5 {
+		return "", nil, fmt.Errorf("unsupported socks version [%d]", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err = io.ReadFull(rd, methods); err != nil {
+		return
+	}
+	if bytes.IndexByte(methods, 0) < 0 {
+		w.Write([]byte{5, 0xff})
+		return "", nil, fmt.Errorf("no acceptable authentication methods")
+	}
+	if _, err = w.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// VER CMD RSV ATYP
+	writeReply := func(w io.Writer, rep byte) {
+		w.Write([]byte{5, rep, 0, 1, 0, 0, 0, 0, 0, 0})
+	}
+	request := make([]byte, 4)
+	if _, err = io.ReadFull(rd, request); err != nil {
+		return
+	}
+	if request[1] != 1 {
+		writeReply(w, 7)
+		return "", nil, fmt.Errorf("unsupported command [%d]", request[1])
+	}
+	if request[3] != 3 {
+		writeReply(w, 8)
+		return "", nil, fmt.Errorf("target should be a domain name")
+	}
+	n, err := rd.ReadByte()
+	if err != nil {
+		return
+	}
+	// DST.ADDR DST.PORT
+	buf := make([]byte, int(n)+2)
+	if _, err = io.ReadFull(rd, buf); err != nil {
+		return
+	}
+	target = string(buf[:n])
+	reply = func(w io.Writer, ok bool) {
+		if ok {
+			writeReply(w, 0)
+		} else {
+			// host unreachable
+			writeReply(w, 4)
+		}
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatedier/frp/models/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadHttpConnectTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		request string
+		target  string
+		resp    string
+	}{
+		{"CONNECT alice.web:80 HTTP/1.1\r\nHost: alice.web:80\r\n\r\n", "alice.web", ""},
+		{"CONNECT alice.web HTTP/1.1\r\nHost: alice.web\r\n\r\n", "alice.web", ""},
+		{"GET http://alice.web/ HTTP/1.1\r\nHost: alice.web\r\n\r\n", "", "HTTP/1.1 405 Method Not Allowed\r\n\r\n"},
+		{"CONNECT :80 HTTP/1.1\r\nHost: :80\r\n\r\n", "", "HTTP/1.1 400 Bad Request\r\n\r\n"},
+	}
+	for _, test := range tests {
+		w := &bytes.Buffer{}
+		target, reply, err := readHttpConnectTarget(bufio.NewReader(strings.NewReader(test.request)), w)
+		assert.Equal(test.resp, w.String(), test.request)
+		if test.target == "" {
+			assert.Error(err, test.request)
+			continue
+		}
+		if !assert.NoError(err, test.request) {
+			continue
+		}
+		assert.Equal(test.target, target)
+		reply(w, true)
+		assert.Equal("HTTP/1.1 200 Connection established\r\n\r\n", w.String())
+		w.Reset()
+		reply(w, false)
+		assert.Equal("HTTP/1.1 502 Bad Gateway\r\n\r\n", w.String())
+	}
+}
+
+func TestReadSocks5Target(t *testing.T) {
+	assert := assert.New(t)
+
+	request := func(cmd byte, atyp byte, addr []byte) []byte {
+		buf := []byte{5, 1, 0, 5, cmd, 0, atyp}
+		buf = append(buf, addr...)
+		return append(buf, 0, 80)
+	}
+	domain := append([]byte{byte(len("alice.web"))}, "alice.web"...)
+
+	tests := []struct {
+		name    string
+		request []byte
+		target  string
+		resp    []byte
+	}{
+		{"connect", request(1, 3, domain), "alice.web", []byte{5, 0}},
+		{"no acceptable methods", []byte{5, 1, 2}, "", []byte{5, 0xff}},
+		{"unsupported version", []byte{4, 1, 0}, "", nil},
+		{"unsupported command", request(3, 3, domain), "", []byte{5, 0, 5, 7, 0, 1, 0, 0, 0, 0, 0, 0}},
+		{"ipv4 target", request(1, 1, []byte{127, 0, 0, 1}), "", []byte{5, 0, 5, 8, 0, 1, 0, 0, 0, 0, 0, 0}},
+		{"truncated", request(1, 3, domain)[:12], "", []byte{5, 0}},
+	}
+	for _, test := range tests {
+		w := &bytes.Buffer{}
+		target, reply, err := readSocks5Target(bufio.NewReader(bytes.NewReader(test.request)), w)
+		assert.Equal(test.resp, w.Bytes(), test.name)
+		if test.target == "" {
+			assert.Error(err, test.name)
+			continue
+		}
+		if !assert.NoError(err, test.name) {
+			continue
+		}
+		assert.Equal(test.target, target)
+		w.Reset()
+		reply(w, true)
+		assert.Equal([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}, w.Bytes())
+		w.Reset()
+		reply(w, false)
+		assert.Equal([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0}, w.Bytes())
+	}
+}
+
+func TestLookupSk(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_sk_file")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	skFile := filepath.Join(dir, "sk")
+	err = ioutil.WriteFile(skFile, []byte(strings.Join([]string{
+		"# comments are skipped",
+		"#alice.* commented",
+		"alice.web sk1",
+		"alice.* sk2",
+		"invalid line here",
+		"*.db sk3",
+		"",
+	}, "\n")), 0600)
+	assert.NoError(err)
+
+	cfg := &config.StcpVisitorConf{}
+	cfg.Sk = "default"
+	sv := &StcpVisitor{cfg: cfg}
+	sk, err := sv.lookupSk("alice.web")
+	assert.NoError(err)
+	assert.Equal("default", sk)
+
+	cfg.SkFile = skFile
+	tests := []struct {
+		target string
+		sk     string
+	}{
+		// the first matched pattern is used
+		{"alice.web", "sk1"},
+		{"alice.db", "sk2"},
+		{"bob.db", "sk3"},
+		{"bob.web", "default"},
+		{"invalid", "default"},
+	}
+	for _, test := range tests {
+		sk, err = sv.lookupSk(test.target)
+		assert.NoError(err)
+		assert.Equal(test.sk, sk, test.target)
+	}
+
+	cfg.SkFile = filepath.Join(dir, "not-exist")
+	_, err = sv.lookupSk("alice.web")
+	assert.Error(err)
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sub
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/models/nathole"
+)
+
+var (
+	natHoleServerUdpPort int
+	natHoleTimeout       int
+)
+
+func init() {
+	natholeDiscoverCmd.Flags().IntVarP(&natHoleServerUdpPort, "server_udp_port", "", 0, "bind_udp_port of frps, default is server_udp_port in the config file")
+	natholeDiscoverCmd.Flags().IntVarP(&natHoleTimeout, "timeout", "", 3, "timeout seconds of each discovery step")
+
+	natholeCmd.AddCommand(natholeDiscoverCmd)
+	rootCmd.AddCommand(natholeCmd)
+}
+
+var natholeCmd = &cobra.Command{
+	Use:   "nathole",
+	Short: "Actions about nat hole of xtcp",
+}
+
+var natholeDiscoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Discover the NAT type by frps",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		iniContent, err := config.GetRenderedConfFromFile(cfgFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		clientCfg, err := parseClientCommonCfg(CfgFileTypeIni, iniContent)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		err = discoverNat(clientCfg)
+		if err != nil {
+			fmt.Printf("frpc discover nat type error: %v\n", err)
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func discoverNat(clientCfg config.ClientCommonConf) error {
+	serverUdpPort := natHoleServerUdpPort
+	if serverUdpPort <= 0 {
+		serverUdpPort = clientCfg.ServerUdpPort
+	}
+	if serverUdpPort <= 0 {
+		return fmt.Errorf("server_udp_port should be set to bind_udp_port of frps in the config file or by --server_udp_port")
+	}
+
+	feature, err := nathole.Discover(clientCfg.ServerAddr, serverUdpPort, time.Duration(natHoleTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("NAT type:           %s\n", feature.NatType)
+	fmt.Printf("Mapping behavior:   %s\n", feature.MappingBehavior)
+	fmt.Printf("Filtering behavior: %s\n", feature.FilteringBehavior)
+	fmt.Printf("Local address:      %s\n", feature.LocalAddr)
+	fmt.Printf("Mapped address:     %s\n", feature.MappedAddr)
+	if feature.MappedAddr2 != "" {
+		fmt.Printf("Mapped address 2:   %s\n", feature.MappedAddr2)
+		fmt.Printf("Port delta:         %d\n", feature.PortDelta)
+	}
+	if feature.NatType == nathole.NatTypeUnknown {
+		fmt.Println("Set nat_hole_discovery_port in frps to detect the NAT type.")
+	}
+	return nil
+}
@@ -36,6 +36,10 @@ type ClientCommonConf struct {
 	// ServerPort specifies the port to connect to the server on. By default,
 	// this value is 7000.
 	ServerPort int `json:"server_port"`
+	// ServerUdpPort specifies bind_udp_port of the server. It's only used by
+	// "frpc nathole discover", frpc gets the port from the server after login.
+	// By default, this value is 0.
+	ServerUdpPort int `json:"server_udp_port"`
 	// HttpProxy specifies a proxy address to connect to the server through. If
 	// this value is "", the server will be connected to directly. By default,
 	// this value is read from the "http_proxy" environment variable.
@@ -176,6 +180,15 @@ func UnmarshalClientConfFromIni(content string) (cfg ClientCommonConf, err error
 		cfg.ServerPort = int(v)
 	}
 
+	if tmpStr, ok = conf.Get("common", "server_udp_port"); ok {
+		v, err = strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("Parse conf error: invalid server_udp_port")
+			return
+		}
+		cfg.ServerUdpPort = int(v)
+	}
+
 	if tmpStr, ok = conf.Get("common", "disable_log_color"); ok && tmpStr == "true" {
 		cfg.DisableLogColor = true
 	}
@@ -15,8 +15,15 @@
 package config
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -210,11 +217,11 @@ func (cfg *BaseProxyConf) UnmarshalFromIni(prefix string, name string, section i
 		return err
 	}
 
-	if cfg.HealthCheckType == "tcp" && cfg.Plugin == "" {
+	if (cfg.HealthCheckType == "tcp" || cfg.HealthCheckType == "udp") && cfg.Plugin == "" {
 		cfg.HealthCheckAddr = cfg.LocalIp + fmt.Sprintf(":%d", cfg.LocalPort)
 	}
-	if cfg.HealthCheckType == "http" && cfg.Plugin == "" && cfg.HealthCheckUrl != "" {
-		s := fmt.Sprintf("http://%s:%d", cfg.LocalIp, cfg.LocalPort)
+	if (cfg.HealthCheckType == "http" || cfg.HealthCheckType == "https") && cfg.Plugin == "" && cfg.HealthCheckUrl != "" {
+		s := fmt.Sprintf("%s://%s:%d", cfg.HealthCheckType, cfg.LocalIp, cfg.LocalPort)
 		if !strings.HasPrefix(cfg.HealthCheckUrl, "/") {
 			s += "/"
 		}
@@ -341,6 +348,15 @@ func (cfg *DomainConf) check() (err error) {
 		err = fmt.Errorf("custom_domains and subdomain should set at least one of them")
 		return
 	}
+	for _, domain := range cfg.CustomDomains {
+		if strings.HasPrefix(domain, "~") {
+			if _, err = regexp.Compile(domain[1:]); err != nil {
+				return fmt.Errorf("invalid regexp domain [%s]: %v", domain, err)
+			}
+		} else if strings.Count(domain, "*") > 1 {
+			return fmt.Errorf("wildcard domain [%s] should contain only one '*'", domain)
+		}
+	}
 	return
 }
 
@@ -375,6 +391,425 @@ func (cfg *DomainConf) checkForSvr(serverCfg ServerCommonConf) (err error) {
 	return
 }
 
+// HttpRouteConf configures how frps routes and rewrites http requests. It's
+// used by http proxies and https proxies with tls termination enabled.
+type HttpRouteConf struct {
+	Locations         []string          `json:"locations"`
+	HttpUser          string            `json:"http_user"`
+	HttpPwd           string            `json:"http_pwd"`
+	HostHeaderRewrite string            `json:"host_header_rewrite"`
+	Headers           map[string]string `json:"headers"`
+
+	// ResponseHeaders are set to responses from the local service.
+	ResponseHeaders map[string]string `json:"response_headers"`
+	// RemoveRequestHeaders are removed from requests before Headers is
+	// applied.
+	RemoveRequestHeaders []string `json:"remove_request_headers"`
+	// RemoveResponseHeaders are removed from responses before
+	// ResponseHeaders is applied.
+	RemoveResponseHeaders []string `json:"remove_response_headers"`
+
+	// frps sets X-Forwarded-For and X-Forwarded-Proto headers unless they are
+	// disabled, and sets X-Real-IP header if AddXRealIp is true.
+	DisableXForwardedFor   bool `json:"disable_x_forwarded_for"`
+	DisableXForwardedProto bool `json:"disable_x_forwarded_proto"`
+	AddXRealIp             bool `json:"add_x_real_ip"`
+
+	// LocalHttp2 specifies whether frps forwards requests to the local
+	// service by HTTP/2 without TLS (h2c). It's required by gRPC services.
+	// By default, this value is false.
+	LocalHttp2 bool `json:"local_http2"`
+
+	// HttpCache specifies whether responses of this proxy can be cached by
+	// frps according to their Cache-Control and ETag headers. It only works
+	// if http_cache_max_size_mb is set in frps. By default, this value is
+	// false.
+	HttpCache bool `json:"http_cache"`
+	// HttpCompression specifies whether frps compresses responses by gzip or
+	// brotli for clients which accept them. By default, this value is false.
+	HttpCompression bool `json:"http_compression"`
+	// HttpInspect specifies the number of the last requests and responses
+	// recorded by frps for debugging, they can be viewed and replayed by the
+	// dashboard API. If this value is 0, nothing is recorded. By default,
+	// this value is 0.
+	HttpInspect int `json:"http_inspect"`
+
+	// StripPrefix is removed from the request path before the path is
+	// rewritten, it's added back to the Location header of redirects.
+	StripPrefix string `json:"strip_prefix"`
+	// PathRewrites are rules in "<regexp> <replacement>" format, the first
+	// rule which matches the request path is used to rewrite it. In ini
+	// files, they are set by keys prefixed by "path_rewrite_" and ordered
+	// by the key names.
+	PathRewrites []string `json:"path_rewrites"`
+	// AddPrefix is added to the request path after the path is rewritten,
+	// it's removed from the Location header of redirects.
+	AddPrefix string `json:"add_prefix"`
+
+	// ErrorPages are templates of error pages returned by frps, named by
+	// status codes like "502.html" and "502.json", or "maintenance.html" for
+	// maintenance pages. The JSON one is used if the client accepts JSON but
+	// not HTML. In ini files, they are loaded from files set by
+	// "error_page_<status>" and "error_page_<status>_json".
+	ErrorPages map[string]string `json:"error_pages"`
+
+	// A request is allowed if it passes any of the following authentication
+	// options or HttpUser and HttpPwd.
+	//
+	// HttpUsers are bcrypt hashed passwords by user names. In ini files,
+	// they are loaded from an htpasswd file set by "http_users_file".
+	HttpUsers map[string]string `json:"http_users"`
+	// HttpBearerTokens are accepted in "Authorization: Bearer <token>" header.
+	HttpBearerTokens []string `json:"http_bearer_tokens"`
+	// HttpForwardAuthUrl specifies an http endpoint which authenticates
+	// requests by their headers. Requests are allowed if it returns 2xx,
+	// otherwise its response is returned to the client.
+	HttpForwardAuthUrl string `json:"http_forward_auth_url"`
+	// HttpForwardAuthResponseHeaders are copied from responses of
+	// HttpForwardAuthUrl to the allowed requests.
+	HttpForwardAuthResponseHeaders []string `json:"http_forward_auth_response_headers"`
+	// HttpOidcIssuer enables OIDC login, users without a login session are
+	// redirected to the OIDC provider. The redirect url registered in the
+	// provider should be "<scheme>://<domain>/.frp/oidc/callback".
+	HttpOidcIssuer       string `json:"http_oidc_issuer"`
+	HttpOidcClientId     string `json:"http_oidc_client_id"`
+	HttpOidcClientSecret string `json:"http_oidc_client_secret"`
+	// HttpOidcAllowedEmails limits users who can login, all users of the
+	// OIDC provider are allowed if it's empty.
+	HttpOidcAllowedEmails []string `json:"http_oidc_allowed_emails"`
+}
+
+func (cfg *HttpRouteConf) compare(cmp *HttpRouteConf) bool {
+	if strings.Join(cfg.Locations, " ") != strings.Join(cmp.Locations, " ") ||
+		cfg.HostHeaderRewrite != cmp.HostHeaderRewrite ||
+		cfg.HttpUser != cmp.HttpUser ||
+		cfg.HttpPwd != cmp.HttpPwd ||
+		!compareHeaders(cfg.Headers, cmp.Headers) ||
+		!compareHeaders(cfg.ResponseHeaders, cmp.ResponseHeaders) ||
+		strings.Join(cfg.RemoveRequestHeaders, " ") != strings.Join(cmp.RemoveRequestHeaders, " ") ||
+		strings.Join(cfg.RemoveResponseHeaders, " ") != strings.Join(cmp.RemoveResponseHeaders, " ") ||
+		cfg.DisableXForwardedFor != cmp.DisableXForwardedFor ||
+		cfg.DisableXForwardedProto != cmp.DisableXForwardedProto ||
+		cfg.AddXRealIp != cmp.AddXRealIp ||
+		cfg.LocalHttp2 != cmp.LocalHttp2 ||
+		cfg.HttpCache != cmp.HttpCache ||
+		cfg.HttpCompression != cmp.HttpCompression ||
+		cfg.HttpInspect != cmp.HttpInspect ||
+		cfg.StripPrefix != cmp.StripPrefix ||
+		strings.Join(cfg.PathRewrites, "\n") != strings.Join(cmp.PathRewrites, "\n") ||
+		cfg.AddPrefix != cmp.AddPrefix ||
+		!compareHeaders(cfg.ErrorPages, cmp.ErrorPages) ||
+		!compareHeaders(cfg.HttpUsers, cmp.HttpUsers) ||
+		strings.Join(cfg.HttpBearerTokens, " ") != strings.Join(cmp.HttpBearerTokens, " ") ||
+		cfg.HttpForwardAuthUrl != cmp.HttpForwardAuthUrl ||
+		strings.Join(cfg.HttpForwardAuthResponseHeaders, " ") != strings.Join(cmp.HttpForwardAuthResponseHeaders, " ") ||
+		cfg.HttpOidcIssuer != cmp.HttpOidcIssuer ||
+		cfg.HttpOidcClientId != cmp.HttpOidcClientId ||
+		cfg.HttpOidcClientSecret != cmp.HttpOidcClientSecret ||
+		strings.Join(cfg.HttpOidcAllowedEmails, " ") != strings.Join(cmp.HttpOidcAllowedEmails, " ") {
+		return false
+	}
+	return true
+}
+
+func compareHeaders(headers map[string]string, cmp map[string]string) bool {
+	if len(headers) != len(cmp) {
+		return false
+	}
+	for k, v := range headers {
+		if v2, ok := cmp[k]; !ok || v != v2 {
+			return false
+		}
+	}
+	return true
+}
+
+func (cfg *HttpRouteConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
+	cfg.Locations = pMsg.Locations
+	cfg.HostHeaderRewrite = pMsg.HostHeaderRewrite
+	cfg.HttpUser = pMsg.HttpUser
+	cfg.HttpPwd = pMsg.HttpPwd
+	cfg.Headers = pMsg.Headers
+	cfg.ResponseHeaders = pMsg.ResponseHeaders
+	cfg.RemoveRequestHeaders = pMsg.RemoveRequestHeaders
+	cfg.RemoveResponseHeaders = pMsg.RemoveResponseHeaders
+	cfg.DisableXForwardedFor = pMsg.DisableXForwardedFor
+	cfg.DisableXForwardedProto = pMsg.DisableXForwardedProto
+	cfg.AddXRealIp = pMsg.AddXRealIp
+	cfg.LocalHttp2 = pMsg.LocalHttp2
+	cfg.HttpCache = pMsg.HttpCache
+	cfg.HttpCompression = pMsg.HttpCompression
+	cfg.HttpInspect = pMsg.HttpInspect
+	cfg.StripPrefix = pMsg.StripPrefix
+	cfg.PathRewrites = pMsg.PathRewrites
+	cfg.AddPrefix = pMsg.AddPrefix
+	cfg.ErrorPages = pMsg.ErrorPages
+	cfg.HttpUsers = pMsg.HttpUsers
+	cfg.HttpBearerTokens = pMsg.HttpBearerTokens
+	cfg.HttpForwardAuthUrl = pMsg.HttpForwardAuthUrl
+	cfg.HttpForwardAuthResponseHeaders = pMsg.HttpForwardAuthResponseHeaders
+	cfg.HttpOidcIssuer = pMsg.HttpOidcIssuer
+	cfg.HttpOidcClientId = pMsg.HttpOidcClientId
+	cfg.HttpOidcClientSecret = pMsg.HttpOidcClientSecret
+	cfg.HttpOidcAllowedEmails = pMsg.HttpOidcAllowedEmails
+}
+
+func (cfg *HttpRouteConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
+	var (
+		tmpStr string
+		ok     bool
+	)
+	if tmpStr, ok = section["locations"]; ok {
+		cfg.Locations = strings.Split(tmpStr, ",")
+	} else {
+		cfg.Locations = []string{""}
+	}
+
+	cfg.HostHeaderRewrite = section["host_header_rewrite"]
+	cfg.HttpUser = section["http_user"]
+	cfg.HttpPwd = section["http_pwd"]
+	cfg.Headers = make(map[string]string)
+	cfg.ResponseHeaders = make(map[string]string)
+	cfg.ErrorPages = make(map[string]string)
+	pathRewriteKeys := make([]string, 0)
+
+	for k, v := range section {
+		if strings.HasPrefix(k, "header_") {
+			cfg.Headers[strings.TrimPrefix(k, "header_")] = v
+		} else if strings.HasPrefix(k, "response_header_") {
+			cfg.ResponseHeaders[strings.TrimPrefix(k, "response_header_")] = v
+		} else if strings.HasPrefix(k, "path_rewrite_") {
+			pathRewriteKeys = append(pathRewriteKeys, k)
+		} else if strings.HasPrefix(k, "error_page_") {
+			if err = cfg.loadErrorPage(strings.TrimPrefix(k, "error_page_"), v); err != nil {
+				return fmt.Errorf("Parse conf error: proxy [%s] load %s error: %v", name, k, err)
+			}
+		}
+	}
+
+	sort.Strings(pathRewriteKeys)
+	cfg.PathRewrites = make([]string, 0, len(pathRewriteKeys))
+	for _, k := range pathRewriteKeys {
+		cfg.PathRewrites = append(cfg.PathRewrites, section[k])
+	}
+	cfg.StripPrefix = section["strip_prefix"]
+	cfg.AddPrefix = section["add_prefix"]
+
+	if tmpStr, ok = section["http_users_file"]; ok {
+		if cfg.HttpUsers, err = loadHtpasswd(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] load http_users_file error: %v", name, err)
+		}
+	}
+	cfg.HttpBearerTokens = splitCommaList(section["http_bearer_tokens"])
+	cfg.HttpForwardAuthUrl = section["http_forward_auth_url"]
+	cfg.HttpForwardAuthResponseHeaders = splitCommaList(section["http_forward_auth_response_headers"])
+	cfg.HttpOidcIssuer = section["http_oidc_issuer"]
+	cfg.HttpOidcClientId = section["http_oidc_client_id"]
+	cfg.HttpOidcClientSecret = section["http_oidc_client_secret"]
+	cfg.HttpOidcAllowedEmails = splitCommaList(section["http_oidc_allowed_emails"])
+
+	cfg.RemoveRequestHeaders = splitCommaList(section["remove_request_headers"])
+	cfg.RemoveResponseHeaders = splitCommaList(section["remove_response_headers"])
+
+	if tmpStr, ok = section["disable_x_forwarded_for"]; ok && tmpStr == "true" {
+		cfg.DisableXForwardedFor = true
+	}
+	if tmpStr, ok = section["disable_x_forwarded_proto"]; ok && tmpStr == "true" {
+		cfg.DisableXForwardedProto = true
+	}
+	if tmpStr, ok = section["add_x_real_ip"]; ok && tmpStr == "true" {
+		cfg.AddXRealIp = true
+	}
+	if tmpStr, ok = section["local_http2"]; ok && tmpStr == "true" {
+		cfg.LocalHttp2 = true
+	}
+	if tmpStr, ok = section["http_cache"]; ok && tmpStr == "true" {
+		cfg.HttpCache = true
+	}
+	if tmpStr, ok = section["http_compression"]; ok && tmpStr == "true" {
+		cfg.HttpCompression = true
+	}
+	if tmpStr, ok = section["http_inspect"]; ok {
+		if cfg.HttpInspect, err = strconv.Atoi(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] http_inspect error", name)
+		}
+	}
+	return
+}
+
+// loadErrorPage loads the error page template of key like "502" or
+// "502_json" from path.
+func (cfg *HttpRouteConf) loadErrorPage(key string, path string) error {
+	pageName := key + ".html"
+	if strings.HasSuffix(key, "_json") {
+		key = strings.TrimSuffix(key, "_json")
+		pageName = key + ".json"
+	}
+	if key != "maintenance" {
+		if status, err := strconv.Atoi(key); err != nil || status < 400 || status > 599 {
+			return fmt.Errorf("invalid status code [%s]", key)
+		}
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg.ErrorPages[pageName] = string(buf)
+	return nil
+}
+
+// loadHtpasswd loads users from an htpasswd file, only bcrypt hashed
+// passwords are supported.
+func loadHtpasswd(path string) (users map[string]string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	users = make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		arrs := strings.SplitN(line, ":", 2)
+		if len(arrs) != 2 {
+			return nil, fmt.Errorf("invalid line [%s]", line)
+		}
+		if !strings.HasPrefix(arrs[1], "$2a$") && !strings.HasPrefix(arrs[1], "$2b$") && !strings.HasPrefix(arrs[1], "$2y$") {
+			return nil, fmt.Errorf("password of user [%s] is not hashed by bcrypt", arrs[0])
+		}
+		users[arrs[0]] = arrs[1]
+	}
+	return users, scanner.Err()
+}
+
+// splitCommaList splits a comma separated list and ignores empty items
+func splitCommaList(str string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (cfg *HttpRouteConf) MarshalToMsg(pMsg *msg.NewProxy) {
+	pMsg.Locations = cfg.Locations
+	pMsg.HostHeaderRewrite = cfg.HostHeaderRewrite
+	pMsg.HttpUser = cfg.HttpUser
+	pMsg.HttpPwd = cfg.HttpPwd
+	pMsg.Headers = cfg.Headers
+	pMsg.ResponseHeaders = cfg.ResponseHeaders
+	pMsg.RemoveRequestHeaders = cfg.RemoveRequestHeaders
+	pMsg.RemoveResponseHeaders = cfg.RemoveResponseHeaders
+	pMsg.DisableXForwardedFor = cfg.DisableXForwardedFor
+	pMsg.DisableXForwardedProto = cfg.DisableXForwardedProto
+	pMsg.AddXRealIp = cfg.AddXRealIp
+	pMsg.LocalHttp2 = cfg.LocalHttp2
+	pMsg.HttpCache = cfg.HttpCache
+	pMsg.HttpCompression = cfg.HttpCompression
+	pMsg.HttpInspect = cfg.HttpInspect
+	pMsg.StripPrefix = cfg.StripPrefix
+	pMsg.PathRewrites = cfg.PathRewrites
+	pMsg.AddPrefix = cfg.AddPrefix
+	pMsg.ErrorPages = cfg.ErrorPages
+	pMsg.HttpUsers = cfg.HttpUsers
+	pMsg.HttpBearerTokens = cfg.HttpBearerTokens
+	pMsg.HttpForwardAuthUrl = cfg.HttpForwardAuthUrl
+	pMsg.HttpForwardAuthResponseHeaders = cfg.HttpForwardAuthResponseHeaders
+	pMsg.HttpOidcIssuer = cfg.HttpOidcIssuer
+	pMsg.HttpOidcClientId = cfg.HttpOidcClientId
+	pMsg.HttpOidcClientSecret = cfg.HttpOidcClientSecret
+	pMsg.HttpOidcAllowedEmails = cfg.HttpOidcAllowedEmails
+}
+
+func (cfg *HttpRouteConf) check() error {
+	if cfg.HttpInspect < 0 || cfg.HttpInspect > 1000 {
+		return fmt.Errorf("http_inspect should be between 0 and 1000")
+	}
+	if cfg.StripPrefix != "" && !strings.HasPrefix(cfg.StripPrefix, "/") {
+		return fmt.Errorf("strip_prefix should start with '/'")
+	}
+	if cfg.AddPrefix != "" && !strings.HasPrefix(cfg.AddPrefix, "/") {
+		return fmt.Errorf("add_prefix should start with '/'")
+	}
+	for _, rule := range cfg.PathRewrites {
+		if _, _, err := ParsePathRewrite(rule); err != nil {
+			return err
+		}
+	}
+	if cfg.HttpForwardAuthUrl != "" {
+		if u, err := url.Parse(cfg.HttpForwardAuthUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("http_forward_auth_url should be an http or https url")
+		}
+	}
+	if (cfg.HttpOidcIssuer != "" || cfg.HttpOidcClientId != "") &&
+		(cfg.HttpOidcIssuer == "" || cfg.HttpOidcClientId == "") {
+		return fmt.Errorf("http_oidc_issuer and http_oidc_client_id should be set together")
+	}
+	return nil
+}
+
+// checkForSvr checks http_forward_auth_url against
+// http_forward_auth_allowed_urls of frps, since it's requested by frps.
+func (cfg *HttpRouteConf) checkForSvr(serverCfg ServerCommonConf) error {
+	if cfg.HttpForwardAuthUrl == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.HttpForwardAuthUrl)
+	if err != nil {
+		return fmt.Errorf("http_forward_auth_url should be an http or https url")
+	}
+	urlPath := path.Clean("/" + u.Path)
+	for _, allowed := range serverCfg.HttpForwardAuthAllowedUrls {
+		au, err := url.Parse(allowed)
+		if err != nil || u.Scheme != au.Scheme || !strings.EqualFold(u.Host, au.Host) || u.User != nil {
+			continue
+		}
+		prefix := strings.TrimSuffix(au.Path, "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("http_forward_auth_url [%s] is not allowed by http_forward_auth_allowed_urls of remote frps", cfg.HttpForwardAuthUrl)
+}
+
+// isEmpty returns true if no option is set
+func (cfg *HttpRouteConf) isEmpty() bool {
+	return strings.Join(cfg.Locations, "") == "" && cfg.HostHeaderRewrite == "" &&
+		cfg.HttpUser == "" && cfg.HttpPwd == "" && len(cfg.Headers) == 0 &&
+		len(cfg.ResponseHeaders) == 0 && len(cfg.RemoveRequestHeaders) == 0 &&
+		len(cfg.RemoveResponseHeaders) == 0 && !cfg.DisableXForwardedFor &&
+		!cfg.DisableXForwardedProto && !cfg.AddXRealIp && !cfg.LocalHttp2 && !cfg.HttpCache &&
+		!cfg.HttpCompression && cfg.HttpInspect == 0 && cfg.StripPrefix == "" &&
+		len(cfg.PathRewrites) == 0 && cfg.AddPrefix == "" && len(cfg.ErrorPages) == 0 && len(cfg.HttpUsers) == 0 &&
+		len(cfg.HttpBearerTokens) == 0 && cfg.HttpForwardAuthUrl == "" &&
+		len(cfg.HttpForwardAuthResponseHeaders) == 0 && cfg.HttpOidcIssuer == "" &&
+		cfg.HttpOidcClientId == "" && cfg.HttpOidcClientSecret == "" &&
+		len(cfg.HttpOidcAllowedEmails) == 0
+}
+
+// ParsePathRewrite parses a path rewrite rule in "<regexp> <replacement>"
+// format, the replacement can refer to submatches of the regexp by $1, $2...
+func ParsePathRewrite(rule string) (pattern *regexp.Regexp, replacement string, err error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 2 {
+		err = fmt.Errorf("path rewrite rule [%s] should be in '<regexp> <replacement>' format", rule)
+		return
+	}
+	if pattern, err = regexp.Compile(fields[0]); err != nil {
+		err = fmt.Errorf("path rewrite rule [%s] error: %v", rule, err)
+		return
+	}
+	replacement = fields[1]
+	return
+}
+
 // LocalSvrConf configures what location the client will proxy to, or what
 // plugin will be used.
 type LocalSvrConf struct {
@@ -454,16 +889,23 @@ func (cfg *LocalSvrConf) checkForCli() (err error) {
 // balancing purposes to detect and remove proxies to failing services.
 type HealthCheckConf struct {
 	// HealthCheckType specifies what protocol to use for health checking.
-	// Valid values include "tcp", "http", and "". If this value is "", health
-	// checking will not be performed. By default, this value is "".
+	// Valid values include "tcp", "http", "https", "udp", "exec" and "". If
+	// this value is "", health checking will not be performed. By default,
+	// this value is "".
 	//
 	// If the type is "tcp", a connection will be attempted to the target
 	// server. If a connection cannot be established, the health check fails.
 	//
-	// If the type is "http", a GET request will be made to the endpoint
-	// specified by HealthCheckUrl. If the response is not a 200, the health
-	// check fails.
-	HealthCheckType string `json:"health_check_type"` // tcp | http
+	// If the type is "http" or "https", a request will be made to the
+	// endpoint specified by HealthCheckUrl. If the response status is not
+	// expected, the health check fails.
+	//
+	// If the type is "udp", HealthCheckUdpPayload will be sent to the target
+	// server. If no response is received, the health check fails.
+	//
+	// If the type is "exec", HealthCheckCommand will be run. If it doesn't
+	// exit with status 0, the health check fails.
+	HealthCheckType string `json:"health_check_type"` // tcp | http | https | udp | exec
 	// HealthCheckTimeoutS specifies the number of seconds to wait for a health
 	// check attempt to connect. If the timeout is reached, this counts as a
 	// health check failure. By default, this value is 3.
@@ -478,8 +920,32 @@ type HealthCheckConf struct {
 	// health check type is "http".
 	HealthCheckUrl string `json:"health_check_url"`
 	// HealthCheckAddr specifies the address to connect to if the health check
-	// type is "tcp".
+	// type is "tcp" or "udp".
 	HealthCheckAddr string `json:"-"`
+	// HealthCheckHttpMethod specifies the method of requests sent by "http"
+	// and "https" health checks. By default, this value is "GET".
+	HealthCheckHttpMethod string `json:"health_check_http_method"`
+	// HealthCheckHttpHeaders specifies extra headers of requests sent by
+	// "http" and "https" health checks. They are set by params with prefix
+	// "health_check_header_".
+	HealthCheckHttpHeaders map[string]string `json:"health_check_http_headers"`
+	// HealthCheckExpectedStatus specifies the response status codes which
+	// are considered healthy, such as "200,204,300-399". If this value is "",
+	// any 2xx status code is healthy. By default, this value is "".
+	HealthCheckExpectedStatus string `json:"health_check_expected_status"`
+	// HealthCheckBodyRegex specifies a regular expression which the response
+	// body of "http" and "https" health checks, or the response of "udp"
+	// health checks, should match. By default, this value is "".
+	HealthCheckBodyRegex string `json:"health_check_body_regex"`
+	// HealthCheckTlsSkipVerify disables server certificate verification for
+	// "https" health checks. By default, this value is false.
+	HealthCheckTlsSkipVerify bool `json:"health_check_tls_skip_verify"`
+	// HealthCheckUdpPayload specifies the content sent to the target server
+	// by "udp" health checks. By default, this value is "".
+	HealthCheckUdpPayload string `json:"health_check_udp_payload"`
+	// HealthCheckCommand specifies the command line run by "exec" health
+	// checks. Arguments are separated by spaces.
+	HealthCheckCommand string `json:"health_check_command"`
 }
 
 func (cfg *HealthCheckConf) compare(cmp *HealthCheckConf) bool {
@@ -487,7 +953,14 @@ func (cfg *HealthCheckConf) compare(cmp *HealthCheckConf) bool {
 		cfg.HealthCheckTimeoutS != cmp.HealthCheckTimeoutS ||
 		cfg.HealthCheckMaxFailed != cmp.HealthCheckMaxFailed ||
 		cfg.HealthCheckIntervalS != cmp.HealthCheckIntervalS ||
-		cfg.HealthCheckUrl != cmp.HealthCheckUrl {
+		cfg.HealthCheckUrl != cmp.HealthCheckUrl ||
+		cfg.HealthCheckHttpMethod != cmp.HealthCheckHttpMethod ||
+		cfg.HealthCheckExpectedStatus != cmp.HealthCheckExpectedStatus ||
+		cfg.HealthCheckBodyRegex != cmp.HealthCheckBodyRegex ||
+		cfg.HealthCheckTlsSkipVerify != cmp.HealthCheckTlsSkipVerify ||
+		cfg.HealthCheckUdpPayload != cmp.HealthCheckUdpPayload ||
+		cfg.HealthCheckCommand != cmp.HealthCheckCommand ||
+		!reflect.DeepEqual(cfg.HealthCheckHttpHeaders, cmp.HealthCheckHttpHeaders) {
 		return false
 	}
 	return true
@@ -496,6 +969,22 @@ func (cfg *HealthCheckConf) compare(cmp *HealthCheckConf) bool {
 func (cfg *HealthCheckConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
 	cfg.HealthCheckType = section["health_check_type"]
 	cfg.HealthCheckUrl = section["health_check_url"]
+	cfg.HealthCheckHttpMethod = section["health_check_http_method"]
+	cfg.HealthCheckExpectedStatus = section["health_check_expected_status"]
+	cfg.HealthCheckBodyRegex = section["health_check_body_regex"]
+	cfg.HealthCheckUdpPayload = section["health_check_udp_payload"]
+	cfg.HealthCheckCommand = section["health_check_command"]
+
+	if tmpStr, ok := section["health_check_tls_skip_verify"]; ok && tmpStr == "true" {
+		cfg.HealthCheckTlsSkipVerify = true
+	}
+
+	cfg.HealthCheckHttpHeaders = make(map[string]string)
+	for k, v := range section {
+		if strings.HasPrefix(k, "health_check_header_") {
+			cfg.HealthCheckHttpHeaders[strings.TrimPrefix(k, "health_check_header_")] = v
+		}
+	}
 
 	if tmpStr, ok := section["health_check_timeout_s"]; ok {
 		if cfg.HealthCheckTimeoutS, err = strconv.Atoi(tmpStr); err != nil {
@@ -518,14 +1007,102 @@ func (cfg *HealthCheckConf) UnmarshalFromIni(prefix string, name string, section
 }
 
 func (cfg *HealthCheckConf) checkForCli() error {
-	if cfg.HealthCheckType != "" && cfg.HealthCheckType != "tcp" && cfg.HealthCheckType != "http" {
+	switch cfg.HealthCheckType {
+	case "", "tcp", "udp":
+	case "http", "https":
+		if cfg.HealthCheckUrl == "" {
+			return fmt.Errorf("health_check_url is required for health check type '%s'", cfg.HealthCheckType)
+		}
+	case "exec":
+		if strings.TrimSpace(cfg.HealthCheckCommand) == "" {
+			return fmt.Errorf("health_check_command is required for health check type 'exec'")
+		}
+	default:
 		return fmt.Errorf("unsupport health check type")
 	}
-	if cfg.HealthCheckType != "" {
-		if cfg.HealthCheckType == "http" && cfg.HealthCheckUrl == "" {
-			return fmt.Errorf("health_check_url is required for health check type 'http'")
+
+	if cfg.HealthCheckExpectedStatus != "" {
+		if _, err := util.ParseRangeNumbers(cfg.HealthCheckExpectedStatus); err != nil {
+			return fmt.Errorf("health_check_expected_status error: %v", err)
+		}
+	}
+	if cfg.HealthCheckBodyRegex != "" {
+		if _, err := regexp.Compile(cfg.HealthCheckBodyRegex); err != nil {
+			return fmt.Errorf("health_check_body_regex error: %v", err)
+		}
+	}
+	return nil
+}
+
+// LimitConf limits new connections of tcp, tcpmux and https proxies, or new
+// requests of http proxies and https proxies with tls termination enabled.
+// Connections or requests over the limits are rejected by frps, http
+// requests get 429 responses. Zero values mean no limit.
+type LimitConf struct {
+	// RateLimit specifies the number of new connections or requests allowed
+	// per second.
+	RateLimit float64 `json:"rate_limit"`
+	// MaxConcurrent specifies the number of connections or requests allowed
+	// at the same time.
+	MaxConcurrent int `json:"max_concurrent"`
+	// PerIpRateLimit and PerIpMaxConcurrent are the same as RateLimit and
+	// MaxConcurrent but are counted for each source ip.
+	PerIpRateLimit     float64 `json:"per_ip_rate_limit"`
+	PerIpMaxConcurrent int     `json:"per_ip_max_concurrent"`
+}
+
+func (cfg *LimitConf) compare(cmp *LimitConf) bool {
+	if cfg.RateLimit != cmp.RateLimit ||
+		cfg.MaxConcurrent != cmp.MaxConcurrent ||
+		cfg.PerIpRateLimit != cmp.PerIpRateLimit ||
+		cfg.PerIpMaxConcurrent != cmp.PerIpMaxConcurrent {
+		return false
+	}
+	return true
+}
+
+func (cfg *LimitConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
+	cfg.RateLimit = pMsg.RateLimit
+	cfg.MaxConcurrent = pMsg.MaxConcurrent
+	cfg.PerIpRateLimit = pMsg.PerIpRateLimit
+	cfg.PerIpMaxConcurrent = pMsg.PerIpMaxConcurrent
+}
+
+func (cfg *LimitConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
+	if tmpStr, ok := section["rate_limit"]; ok {
+		if cfg.RateLimit, err = strconv.ParseFloat(tmpStr, 64); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] rate_limit error", name)
+		}
+	}
+	if tmpStr, ok := section["max_concurrent"]; ok {
+		if cfg.MaxConcurrent, err = strconv.Atoi(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] max_concurrent error", name)
+		}
+	}
+	if tmpStr, ok := section["per_ip_rate_limit"]; ok {
+		if cfg.PerIpRateLimit, err = strconv.ParseFloat(tmpStr, 64); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] per_ip_rate_limit error", name)
+		}
+	}
+	if tmpStr, ok := section["per_ip_max_concurrent"]; ok {
+		if cfg.PerIpMaxConcurrent, err = strconv.Atoi(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] per_ip_max_concurrent error", name)
 		}
 	}
+	return
+}
+
+func (cfg *LimitConf) MarshalToMsg(pMsg *msg.NewProxy) {
+	pMsg.RateLimit = cfg.RateLimit
+	pMsg.MaxConcurrent = cfg.MaxConcurrent
+	pMsg.PerIpRateLimit = cfg.PerIpRateLimit
+	pMsg.PerIpMaxConcurrent = cfg.PerIpMaxConcurrent
+}
+
+func (cfg *LimitConf) check() error {
+	if cfg.RateLimit < 0 || cfg.MaxConcurrent < 0 || cfg.PerIpRateLimit < 0 || cfg.PerIpMaxConcurrent < 0 {
+		return fmt.Errorf("rate_limit, max_concurrent, per_ip_rate_limit and per_ip_max_concurrent should not be negative")
+	}
 	return nil
 }
 
@@ -533,6 +1110,7 @@ func (cfg *HealthCheckConf) checkForCli() error {
 type TcpProxyConf struct {
 	BaseProxyConf
 	BindInfoConf
+	LimitConf
 }
 
 func (cfg *TcpProxyConf) Compare(cmp ProxyConf) bool {
@@ -542,7 +1120,8 @@ func (cfg *TcpProxyConf) Compare(cmp ProxyConf) bool {
 	}
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
-		!cfg.BindInfoConf.compare(&cmpConf.BindInfoConf) {
+		!cfg.BindInfoConf.compare(&cmpConf.BindInfoConf) ||
+		!cfg.LimitConf.compare(&cmpConf.LimitConf) {
 		return false
 	}
 	return true
@@ -551,6 +1130,7 @@ func (cfg *TcpProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *TcpProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.BindInfoConf.UnmarshalFromMsg(pMsg)
+	cfg.LimitConf.UnmarshalFromMsg(pMsg)
 }
 
 func (cfg *TcpProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -560,27 +1140,37 @@ func (cfg *TcpProxyConf) UnmarshalFromIni(prefix string, name string, section in
 	if err = cfg.BindInfoConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+	if err = cfg.LimitConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
+	}
 	return
 }
 
 func (cfg *TcpProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	cfg.BindInfoConf.MarshalToMsg(pMsg)
+	cfg.LimitConf.MarshalToMsg(pMsg)
 }
 
 func (cfg *TcpProxyConf) CheckForCli() (err error) {
 	if err = cfg.BaseProxyConf.checkForCli(); err != nil {
 		return err
 	}
+	if err = cfg.LimitConf.check(); err != nil {
+		return err
+	}
 	return
 }
 
-func (cfg *TcpProxyConf) CheckForSvr(serverCfg ServerCommonConf) error { return nil }
+func (cfg *TcpProxyConf) CheckForSvr(serverCfg ServerCommonConf) error {
+	return cfg.LimitConf.check()
+}
 
 // TCP Multiplexer
 type TcpMuxProxyConf struct {
 	BaseProxyConf
 	DomainConf
+	LimitConf
 
 	Multiplexer string `json:"multiplexer"`
 }
@@ -593,6 +1183,7 @@ func (cfg *TcpMuxProxyConf) Compare(cmp ProxyConf) bool {
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
 		!cfg.DomainConf.compare(&cmpConf.DomainConf) ||
+		!cfg.LimitConf.compare(&cmpConf.LimitConf) ||
 		cfg.Multiplexer != cmpConf.Multiplexer {
 		return false
 	}
@@ -602,6 +1193,7 @@ func (cfg *TcpMuxProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *TcpMuxProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.DomainConf.UnmarshalFromMsg(pMsg)
+	cfg.LimitConf.UnmarshalFromMsg(pMsg)
 	cfg.Multiplexer = pMsg.Multiplexer
 }
 
@@ -612,6 +1204,9 @@ func (cfg *TcpMuxProxyConf) UnmarshalFromIni(prefix string, name string, section
 	if err = cfg.DomainConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+	if err = cfg.LimitConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
+	}
 
 	cfg.Multiplexer = section["multiplexer"]
 	if cfg.Multiplexer != consts.HttpConnectTcpMultiplexer {
@@ -623,6 +1218,7 @@ func (cfg *TcpMuxProxyConf) UnmarshalFromIni(prefix string, name string, section
 func (cfg *TcpMuxProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	cfg.DomainConf.MarshalToMsg(pMsg)
+	cfg.LimitConf.MarshalToMsg(pMsg)
 	pMsg.Multiplexer = cfg.Multiplexer
 }
 
@@ -633,6 +1229,9 @@ func (cfg *TcpMuxProxyConf) CheckForCli() (err error) {
 	if err = cfg.DomainConf.checkForCli(); err != nil {
 		return err
 	}
+	if err = cfg.LimitConf.check(); err != nil {
+		return err
+	}
 	if cfg.Multiplexer != consts.HttpConnectTcpMultiplexer {
 		return fmt.Errorf("parse conf error: incorrect multiplexer [%s]", cfg.Multiplexer)
 	}
@@ -652,6 +1251,9 @@ func (cfg *TcpMuxProxyConf) CheckForSvr(serverCfg ServerCommonConf) (err error)
 		err = fmt.Errorf("proxy [%s] domain conf check error: %v", cfg.ProxyName, err)
 		return
 	}
+	if err = cfg.LimitConf.check(); err != nil {
+		return
+	}
 	return
 }
 
@@ -707,12 +1309,8 @@ func (cfg *UdpProxyConf) CheckForSvr(serverCfg ServerCommonConf) error { return
 type HttpProxyConf struct {
 	BaseProxyConf
 	DomainConf
-
-	Locations         []string          `json:"locations"`
-	HttpUser          string            `json:"http_user"`
-	HttpPwd           string            `json:"http_pwd"`
-	HostHeaderRewrite string            `json:"host_header_rewrite"`
-	Headers           map[string]string `json:"headers"`
+	HttpRouteConf
+	LimitConf
 }
 
 func (cfg *HttpProxyConf) Compare(cmp ProxyConf) bool {
@@ -723,35 +1321,18 @@ func (cfg *HttpProxyConf) Compare(cmp ProxyConf) bool {
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
 		!cfg.DomainConf.compare(&cmpConf.DomainConf) ||
-		strings.Join(cfg.Locations, " ") != strings.Join(cmpConf.Locations, " ") ||
-		cfg.HostHeaderRewrite != cmpConf.HostHeaderRewrite ||
-		cfg.HttpUser != cmpConf.HttpUser ||
-		cfg.HttpPwd != cmpConf.HttpPwd ||
-		len(cfg.Headers) != len(cmpConf.Headers) {
+		!cfg.HttpRouteConf.compare(&cmpConf.HttpRouteConf) ||
+		!cfg.LimitConf.compare(&cmpConf.LimitConf) {
 		return false
 	}
-
-	for k, v := range cfg.Headers {
-		if v2, ok := cmpConf.Headers[k]; !ok {
-			return false
-		} else {
-			if v != v2 {
-				return false
-			}
-		}
-	}
 	return true
 }
 
 func (cfg *HttpProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.DomainConf.UnmarshalFromMsg(pMsg)
-
-	cfg.Locations = pMsg.Locations
-	cfg.HostHeaderRewrite = pMsg.HostHeaderRewrite
-	cfg.HttpUser = pMsg.HttpUser
-	cfg.HttpPwd = pMsg.HttpPwd
-	cfg.Headers = pMsg.Headers
+	cfg.HttpRouteConf.UnmarshalFromMsg(pMsg)
+	cfg.LimitConf.UnmarshalFromMsg(pMsg)
 }
 
 func (cfg *HttpProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -761,26 +1342,11 @@ func (cfg *HttpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 	if err = cfg.DomainConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
-
-	var (
-		tmpStr string
-		ok     bool
-	)
-	if tmpStr, ok = section["locations"]; ok {
-		cfg.Locations = strings.Split(tmpStr, ",")
-	} else {
-		cfg.Locations = []string{""}
+	if err = cfg.HttpRouteConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
 	}
-
-	cfg.HostHeaderRewrite = section["host_header_rewrite"]
-	cfg.HttpUser = section["http_user"]
-	cfg.HttpPwd = section["http_pwd"]
-	cfg.Headers = make(map[string]string)
-
-	for k, v := range section {
-		if strings.HasPrefix(k, "header_") {
-			cfg.Headers[strings.TrimPrefix(k, "header_")] = v
-		}
+	if err = cfg.LimitConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
 	}
 	return
 }
@@ -788,12 +1354,8 @@ func (cfg *HttpProxyConf) UnmarshalFromIni(prefix string, name string, section i
 func (cfg *HttpProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	cfg.DomainConf.MarshalToMsg(pMsg)
-
-	pMsg.Locations = cfg.Locations
-	pMsg.HostHeaderRewrite = cfg.HostHeaderRewrite
-	pMsg.HttpUser = cfg.HttpUser
-	pMsg.HttpPwd = cfg.HttpPwd
-	pMsg.Headers = cfg.Headers
+	cfg.HttpRouteConf.MarshalToMsg(pMsg)
+	cfg.LimitConf.MarshalToMsg(pMsg)
 }
 
 func (cfg *HttpProxyConf) CheckForCli() (err error) {
@@ -803,6 +1365,12 @@ func (cfg *HttpProxyConf) CheckForCli() (err error) {
 	if err = cfg.DomainConf.checkForCli(); err != nil {
 		return
 	}
+	if err = cfg.HttpRouteConf.check(); err != nil {
+		return
+	}
+	if err = cfg.LimitConf.check(); err != nil {
+		return
+	}
 	return
 }
 
@@ -814,6 +1382,15 @@ func (cfg *HttpProxyConf) CheckForSvr(serverCfg ServerCommonConf) (err error) {
 		err = fmt.Errorf("proxy [%s] domain conf check error: %v", cfg.ProxyName, err)
 		return
 	}
+	if err = cfg.HttpRouteConf.check(); err != nil {
+		return
+	}
+	if err = cfg.HttpRouteConf.checkForSvr(serverCfg); err != nil {
+		return
+	}
+	if err = cfg.LimitConf.check(); err != nil {
+		return
+	}
 	return
 }
 
@@ -821,6 +1398,23 @@ func (cfg *HttpProxyConf) CheckForSvr(serverCfg ServerCommonConf) (err error) {
 type HttpsProxyConf struct {
 	BaseProxyConf
 	DomainConf
+	// HttpRouteConf is only used if TlsTermination is true.
+	HttpRouteConf
+	// LimitConf limits requests if TlsTermination is true, otherwise it
+	// limits connections.
+	LimitConf
+
+	// TlsTermination specifies whether frps decrypts https requests with its
+	// own certificates and forwards plain http requests to this proxy. By
+	// default, this value is false.
+	TlsTermination bool `json:"tls_termination"`
+
+	// GroupStrategy specifies how the server selects a proxy in the group for
+	// a new connection. Valid values are "round_robin" and "sticky", sticky
+	// sends connections from the same client ip to the same proxy. It should
+	// be the same among proxies of the same group. By default, this value is
+	// "round_robin".
+	GroupStrategy string `json:"group_strategy"`
 }
 
 func (cfg *HttpsProxyConf) Compare(cmp ProxyConf) bool {
@@ -830,7 +1424,11 @@ func (cfg *HttpsProxyConf) Compare(cmp ProxyConf) bool {
 	}
 
 	if !cfg.BaseProxyConf.compare(&cmpConf.BaseProxyConf) ||
-		!cfg.DomainConf.compare(&cmpConf.DomainConf) {
+		!cfg.DomainConf.compare(&cmpConf.DomainConf) ||
+		!cfg.HttpRouteConf.compare(&cmpConf.HttpRouteConf) ||
+		!cfg.LimitConf.compare(&cmpConf.LimitConf) ||
+		cfg.TlsTermination != cmpConf.TlsTermination ||
+		cfg.GroupStrategy != cmpConf.GroupStrategy {
 		return false
 	}
 	return true
@@ -839,6 +1437,10 @@ func (cfg *HttpsProxyConf) Compare(cmp ProxyConf) bool {
 func (cfg *HttpsProxyConf) UnmarshalFromMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.UnmarshalFromMsg(pMsg)
 	cfg.DomainConf.UnmarshalFromMsg(pMsg)
+	cfg.HttpRouteConf.UnmarshalFromMsg(pMsg)
+	cfg.LimitConf.UnmarshalFromMsg(pMsg)
+	cfg.TlsTermination = pMsg.TlsTermination
+	cfg.GroupStrategy = pMsg.GroupStrategy
 }
 
 func (cfg *HttpsProxyConf) UnmarshalFromIni(prefix string, name string, section ini.Section) (err error) {
@@ -848,12 +1450,26 @@ func (cfg *HttpsProxyConf) UnmarshalFromIni(prefix string, name string, section
 	if err = cfg.DomainConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+	if err = cfg.HttpRouteConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
+	}
+	if err = cfg.LimitConf.UnmarshalFromIni(prefix, name, section); err != nil {
+		return
+	}
+	if tmpStr, ok := section["tls_termination"]; ok && tmpStr == "true" {
+		cfg.TlsTermination = true
+	}
+	cfg.GroupStrategy = section["group_strategy"]
 	return
 }
 
 func (cfg *HttpsProxyConf) MarshalToMsg(pMsg *msg.NewProxy) {
 	cfg.BaseProxyConf.MarshalToMsg(pMsg)
 	cfg.DomainConf.MarshalToMsg(pMsg)
+	cfg.HttpRouteConf.MarshalToMsg(pMsg)
+	cfg.LimitConf.MarshalToMsg(pMsg)
+	pMsg.TlsTermination = cfg.TlsTermination
+	pMsg.GroupStrategy = cfg.GroupStrategy
 }
 
 func (cfg *HttpsProxyConf) CheckForCli() (err error) {
@@ -863,9 +1479,30 @@ func (cfg *HttpsProxyConf) CheckForCli() (err error) {
 	if err = cfg.DomainConf.checkForCli(); err != nil {
 		return
 	}
+	if err = checkGroupStrategy(cfg.GroupStrategy); err != nil {
+		return
+	}
+	if err = cfg.checkTlsTermination(); err != nil {
+		return
+	}
+	if err = cfg.LimitConf.check(); err != nil {
+		return
+	}
 	return
 }
 
+func (cfg *HttpsProxyConf) checkTlsTermination() error {
+	if cfg.TlsTermination {
+		if cfg.Group != "" {
+			return fmt.Errorf("group is not supported when tls_termination is true")
+		}
+		return cfg.HttpRouteConf.check()
+	} else if !cfg.HttpRouteConf.isEmpty() {
+		return fmt.Errorf("http options like locations, http_user and headers are only supported when tls_termination is true")
+	}
+	return nil
+}
+
 func (cfg *HttpsProxyConf) CheckForSvr(serverCfg ServerCommonConf) (err error) {
 	if serverCfg.VhostHttpsPort == 0 {
 		return fmt.Errorf("type [https] not support when vhost_https_port is not set")
@@ -874,9 +1511,35 @@ func (cfg *HttpsProxyConf) CheckForSvr(serverCfg ServerCommonConf) (err error) {
 		err = fmt.Errorf("proxy [%s] domain conf check error: %v", cfg.ProxyName, err)
 		return
 	}
+	if err = checkGroupStrategy(cfg.GroupStrategy); err != nil {
+		return
+	}
+	if err = cfg.checkTlsTermination(); err != nil {
+		return
+	}
+	if cfg.TlsTermination {
+		if err = cfg.HttpRouteConf.checkForSvr(serverCfg); err != nil {
+			return
+		}
+	}
+	if err = cfg.LimitConf.check(); err != nil {
+		return
+	}
+	if cfg.TlsTermination && !serverCfg.IsTlsTerminationEnabled() {
+		return fmt.Errorf("tls_termination is not supported because this feature is not enabled in remote frps")
+	}
 	return
 }
 
+func checkGroupStrategy(strategy string) error {
+	switch strategy {
+	case "", consts.RoundRobinGroupStrategy, consts.StickyGroupStrategy:
+		return nil
+	default:
+		return fmt.Errorf("group_strategy should be one of %s and %s", consts.RoundRobinGroupStrategy, consts.StickyGroupStrategy)
+	}
+}
+
 // SUDP
 type SudpProxyConf struct {
 	BaseProxyConf
@@ -0,0 +1,52 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpForwardAuthAllowedUrls(t *testing.T) {
+	assert := assert.New(t)
+
+	serverCfg := GetDefaultServerConf()
+	cfg := &HttpRouteConf{}
+	assert.NoError(cfg.checkForSvr(serverCfg))
+
+	cfg.HttpForwardAuthUrl = "http://auth.example.com/verify"
+	assert.Error(cfg.checkForSvr(serverCfg))
+
+	serverCfg.HttpForwardAuthAllowedUrls = []string{"https://auth.example.com/frp/", "http://127.0.0.1:9000"}
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://auth.example.com/frp/verify", true},
+		{"https://AUTH.example.com/frp", true},
+		{"https://auth.example.com/frp/../admin", false},
+		{"https://auth.example.com/frpx", false},
+		{"http://auth.example.com/frp/verify", false},
+		{"https://user@auth.example.com/frp/verify", false},
+		{"http://127.0.0.1:9000/any", true},
+		{"http://127.0.0.1:9001/any", false},
+	}
+	for _, test := range tests {
+		cfg.HttpForwardAuthUrl = test.url
+		err := cfg.checkForSvr(serverCfg)
+		assert.Equal(test.allowed, err == nil, test.url)
+	}
+}
@@ -41,6 +41,15 @@ type ServerCommonConf struct {
 	// value is 0, the server will not listen for UDP connections. By default,
 	// this value is 0
 	BindUdpPort int `json:"bind_udp_port"`
+	// NatHoleDiscoveryPort specifies the second UDP port used by clients to
+	// detect their NAT types, it is required to tell symmetric NATs from
+	// others. If this value is 0, the second port is disabled. By default,
+	// this value is 0.
+	NatHoleDiscoveryPort int `json:"nat_hole_discovery_port"`
+	// AllowXtcpRelay specifies whether connections of xtcp visitors failed to
+	// make a nat hole are relayed by the server like stcp, it costs bandwidth
+	// of the server. By default, this value is false.
+	AllowXtcpRelay bool `json:"allow_xtcp_relay"`
 	// BindKcpPort specifies the KCP port that the server listens on. If this
 	// value is 0, the server will not listen for KCP connections. By default,
 	// this value is 0.
@@ -113,6 +122,11 @@ type ServerCommonConf struct {
 	// "test", the resulting URL would be "test.frps.com". By default, this
 	// value is "".
 	SubDomainHost string `json:"subdomain_host"`
+	// HttpForwardAuthAllowedUrls specifies urls that http_forward_auth_url of
+	// proxies can use. An url is allowed if it has the same scheme and host
+	// as one of them, and its path starts with the path. By default, this
+	// value is empty and http_forward_auth_url is not allowed.
+	HttpForwardAuthAllowedUrls []string `json:"http_forward_auth_allowed_urls"`
 	// TcpMux toggles TCP stream multiplexing. This allows multiple requests
 	// from a client to share a single TCP connection. By default, this value
 	// is true.
@@ -121,6 +135,58 @@ type ServerCommonConf struct {
 	// value is "", a default page will be displayed. By default, this value is
 	// "".
 	Custom404Page string `json:"custom_404_page"`
+	// CustomErrorPagesDir specifies a directory of error page templates for
+	// http requests, named by status codes like "502.html" and "502.json".
+	// Templates in its sub directories named by domains are used for these
+	// domains first. Templates can use {{.Status}}, {{.StatusText}},
+	// {{.RequestId}}, {{.Host}} and {{.Timestamp}}. If this value is "",
+	// default pages will be displayed. By default, this value is "".
+	CustomErrorPagesDir string `json:"custom_error_pages_dir"`
+	// HttpCacheMaxSizeMb specifies the max size of responses cached for http
+	// proxies with http_cache enabled, in MB. If this value is 0, responses
+	// are not cached. By default, this value is 0.
+	HttpCacheMaxSizeMb int64 `json:"http_cache_max_size_mb"`
+	// HttpCacheMaxObjectSizeKb specifies the max size of a single cached
+	// response, in KB. By default, this value is 1024.
+	HttpCacheMaxObjectSizeKb int64 `json:"http_cache_max_object_size_kb"`
+	// HttpCacheDir specifies a directory to store bodies of cached responses.
+	// If this value is "", they are kept in memory. By default, this value
+	// is "".
+	HttpCacheDir string `json:"http_cache_dir"`
+	// HttpTombstoneTtl specifies how long routes of offline http proxies are
+	// kept to serve maintenance pages (503) instead of 404, in seconds. If
+	// this value is 0, routes are removed at once. By default, this value is
+	// 0.
+	HttpTombstoneTtl int64 `json:"http_tombstone_ttl"`
+	// HttpMaintenanceRetryAfter specifies the default Retry-After header of
+	// maintenance pages, in seconds. By default, this value is 60.
+	HttpMaintenanceRetryAfter int64 `json:"http_maintenance_retry_after"`
+	// VhostHttpsCertFile specifies the path of the certificate file used by
+	// https proxies with tls_termination enabled. If ACME is also enabled,
+	// this certificate is used for the domains it is valid for. By default,
+	// this value is "".
+	VhostHttpsCertFile string `json:"vhost_https_cert_file"`
+	// VhostHttpsKeyFile specifies the path of the private key file of
+	// VhostHttpsCertFile. By default, this value is "".
+	VhostHttpsKeyFile string `json:"vhost_https_key_file"`
+	// AcmeEnable specifies whether to obtain certificates for https proxies
+	// with tls_termination enabled from an ACME server. HTTP-01 challenges are
+	// answered on VhostHttpPort. By default, this value is false.
+	AcmeEnable bool `json:"acme_enable"`
+	// AcmeEmail specifies the contact email of the ACME account. By default,
+	// this value is "".
+	AcmeEmail string `json:"acme_email"`
+	// AcmeDirectoryUrl specifies the directory URL of the ACME server. By
+	// default, this value is the production directory of Let's Encrypt.
+	AcmeDirectoryUrl string `json:"acme_directory_url"`
+	// AcmeCacheDir specifies the directory to store the ACME account key and
+	// certificates. By default, this value is "./acme".
+	AcmeCacheDir string `json:"acme_cache_dir"`
+	// AcmeCaFile specifies the path of a CA certificate file to trust when
+	// connecting to the ACME server, it's useful for testing against a local
+	// ACME server. If this value is "", system CAs are used. By default, this
+	// value is "".
+	AcmeCaFile string `json:"acme_ca_file"`
 
 	// AllowPorts specifies a set of ports that clients are able to proxy to.
 	// If the length of this value is 0, all ports are allowed. By default,
@@ -143,6 +209,22 @@ type ServerCommonConf struct {
 	// UserConnTimeout specifies the maximum time to wait for a work
 	// connection. By default, this value is 10.
 	UserConnTimeout int64 `json:"user_conn_timeout"`
+	// GroupMaxFails specifies the number of consecutive failures to get a
+	// work connection from a proxy in a load balancing group, after which the
+	// proxy is temporarily ejected from the group. If this value is 0, proxies
+	// will never be ejected. By default, this value is 3.
+	GroupMaxFails int64 `json:"group_max_fails"`
+	// GroupEjectTime specifies the time in seconds that an ejected proxy is
+	// skipped by its group. The time grows with each consecutive ejection of
+	// the same proxy. By default, this value is 30.
+	GroupEjectTime int64 `json:"group_eject_time"`
+	// GroupHealthCheckInterval specifies the interval in seconds at which
+	// frps pings the client of every proxy in load balancing groups over its
+	// control connection to check if it's healthy. Clients not answering in
+	// UserConnTimeout are counted as failed. If this value is 0, proxies are
+	// only checked passively by user connections. By default, this value is
+	// 0.
+	GroupHealthCheckInterval int64 `json:"group_health_check_interval"`
 	// HTTPPlugins specify the server plugins support HTTP protocol.
 	HTTPPlugins map[string]plugin.HTTPPluginOptions `json:"http_plugins"`
 	// Frp Adapter Server Address
@@ -153,38 +235,56 @@ type ServerCommonConf struct {
 // defaults.
 func GetDefaultServerConf() ServerCommonConf {
 	return ServerCommonConf{
-		BindAddr:                "0.0.0.0",
-		BindPort:                7000,
-		BindUdpPort:             0,
-		KcpBindPort:             0,
-		ProxyBindAddr:           "0.0.0.0",
-		VhostHttpPort:           0,
-		VhostHttpsPort:          0,
-		TcpMuxHttpConnectPort:   0,
-		VhostHttpTimeout:        60,
-		DashboardAddr:           "0.0.0.0",
-		DashboardPort:           0,
-		DashboardUser:           "admin",
-		DashboardPwd:            "admin",
-		EnablePrometheus:        false,
-		AssetsDir:               "",
-		LogFile:                 "console",
-		LogWay:                  "console",
-		LogLevel:                "info",
-		LogMaxDays:              3,
-		DisableLogColor:         false,
-		DetailedErrorsToClient:  true,
-		SubDomainHost:           "",
-		TcpMux:                  true,
-		AllowPorts:              make(map[int]struct{}),
-		MaxPoolCount:            5,
-		MaxPortsPerClient:       0,
-		TlsOnly:                 false,
-		HeartBeatTimeout:        90,
-		UserConnTimeout:         10,
-		Custom404Page:           "",
-		HTTPPlugins:             make(map[string]plugin.HTTPPluginOptions),
-		FrpAdapterServerAddress: "",
+		BindAddr:                  "0.0.0.0",
+		BindPort:                  7000,
+		BindUdpPort:               0,
+		NatHoleDiscoveryPort:      0,
+		AllowXtcpRelay:            false,
+		KcpBindPort:               0,
+		ProxyBindAddr:             "0.0.0.0",
+		VhostHttpPort:             0,
+		VhostHttpsPort:            0,
+		TcpMuxHttpConnectPort:     0,
+		VhostHttpTimeout:          60,
+		DashboardAddr:             "0.0.0.0",
+		DashboardPort:             0,
+		DashboardUser:             "admin",
+		DashboardPwd:              "admin",
+		EnablePrometheus:          false,
+		AssetsDir:                 "",
+		LogFile:                   "console",
+		LogWay:                    "console",
+		LogLevel:                  "info",
+		LogMaxDays:                3,
+		DisableLogColor:           false,
+		DetailedErrorsToClient:    true,
+		SubDomainHost:             "",
+		TcpMux:                    true,
+		AllowPorts:                make(map[int]struct{}),
+		MaxPoolCount:              5,
+		MaxPortsPerClient:         0,
+		TlsOnly:                   false,
+		HeartBeatTimeout:          90,
+		UserConnTimeout:           10,
+		GroupMaxFails:             3,
+		GroupEjectTime:            30,
+		GroupHealthCheckInterval:  0,
+		Custom404Page:             "",
+		CustomErrorPagesDir:       "",
+		HttpCacheMaxSizeMb:        0,
+		HttpCacheMaxObjectSizeKb:  1024,
+		HttpCacheDir:              "",
+		HttpTombstoneTtl:          0,
+		HttpMaintenanceRetryAfter: 60,
+		VhostHttpsCertFile:        "",
+		VhostHttpsKeyFile:         "",
+		AcmeEnable:                false,
+		AcmeEmail:                 "",
+		AcmeDirectoryUrl:          "https://acme-v02.api.letsencrypt.org/directory",
+		AcmeCacheDir:              "./acme",
+		AcmeCaFile:                "",
+		HTTPPlugins:               make(map[string]plugin.HTTPPluginOptions),
+		FrpAdapterServerAddress:   "",
 	}
 }
 
@@ -230,6 +330,19 @@ func UnmarshalServerConfFromIni(content string) (cfg ServerCommonConf, err error
 		}
 	}
 
+	if tmpStr, ok = conf.Get("common", "nat_hole_discovery_port"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
+			err = fmt.Errorf("Parse conf error: invalid nat_hole_discovery_port")
+			return
+		} else {
+			cfg.NatHoleDiscoveryPort = int(v)
+		}
+	}
+
+	if tmpStr, ok = conf.Get("common", "allow_xtcp_relay"); ok && tmpStr == "true" {
+		cfg.AllowXtcpRelay = true
+	}
+
 	if tmpStr, ok = conf.Get("common", "kcp_bind_port"); ok {
 		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil {
 			err = fmt.Errorf("Parse conf error: invalid kcp_bind_port")
@@ -394,6 +507,10 @@ func UnmarshalServerConfFromIni(content string) (cfg ServerCommonConf, err error
 		cfg.SubDomainHost = strings.ToLower(strings.TrimSpace(tmpStr))
 	}
 
+	if tmpStr, ok = conf.Get("common", "http_forward_auth_allowed_urls"); ok {
+		cfg.HttpForwardAuthAllowedUrls = splitCommaList(tmpStr)
+	}
+
 	if tmpStr, ok = conf.Get("common", "tcp_mux"); ok && tmpStr == "false" {
 		cfg.TcpMux = false
 	} else {
@@ -404,6 +521,46 @@ func UnmarshalServerConfFromIni(content string) (cfg ServerCommonConf, err error
 		cfg.Custom404Page = tmpStr
 	}
 
+	if tmpStr, ok = conf.Get("common", "custom_error_pages_dir"); ok {
+		cfg.CustomErrorPagesDir = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "http_cache_max_size_mb"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid http_cache_max_size_mb")
+			return
+		}
+		cfg.HttpCacheMaxSizeMb = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "http_cache_max_object_size_kb"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v <= 0 {
+			err = fmt.Errorf("Parse conf error: invalid http_cache_max_object_size_kb")
+			return
+		}
+		cfg.HttpCacheMaxObjectSizeKb = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "http_cache_dir"); ok {
+		cfg.HttpCacheDir = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "http_tombstone_ttl"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid http_tombstone_ttl")
+			return
+		}
+		cfg.HttpTombstoneTtl = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "http_maintenance_retry_after"); ok {
+		if v, err = strconv.ParseInt(tmpStr, 10, 64); err != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid http_maintenance_retry_after")
+			return
+		}
+		cfg.HttpMaintenanceRetryAfter = v
+	}
+
 	if tmpStr, ok = conf.Get("common", "heartbeat_timeout"); ok {
 		v, errRet := strconv.ParseInt(tmpStr, 10, 64)
 		if errRet != nil {
@@ -414,6 +571,63 @@ func UnmarshalServerConfFromIni(content string) (cfg ServerCommonConf, err error
 		}
 	}
 
+	if tmpStr, ok = conf.Get("common", "group_max_fails"); ok {
+		v, errRet := strconv.ParseInt(tmpStr, 10, 64)
+		if errRet != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid group_max_fails")
+			return
+		}
+		cfg.GroupMaxFails = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "group_eject_time"); ok {
+		v, errRet := strconv.ParseInt(tmpStr, 10, 64)
+		if errRet != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid group_eject_time")
+			return
+		}
+		cfg.GroupEjectTime = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "group_health_check_interval"); ok {
+		v, errRet := strconv.ParseInt(tmpStr, 10, 64)
+		if errRet != nil || v < 0 {
+			err = fmt.Errorf("Parse conf error: invalid group_health_check_interval")
+			return
+		}
+		cfg.GroupHealthCheckInterval = v
+	}
+
+	if tmpStr, ok = conf.Get("common", "vhost_https_cert_file"); ok {
+		cfg.VhostHttpsCertFile = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "vhost_https_key_file"); ok {
+		cfg.VhostHttpsKeyFile = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "acme_enable"); ok && tmpStr == "true" {
+		cfg.AcmeEnable = true
+	} else {
+		cfg.AcmeEnable = false
+	}
+
+	if tmpStr, ok = conf.Get("common", "acme_email"); ok {
+		cfg.AcmeEmail = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "acme_directory_url"); ok {
+		cfg.AcmeDirectoryUrl = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "acme_cache_dir"); ok {
+		cfg.AcmeCacheDir = tmpStr
+	}
+
+	if tmpStr, ok = conf.Get("common", "acme_ca_file"); ok {
+		cfg.AcmeCaFile = tmpStr
+	}
+
 	if tmpStr, ok = conf.Get("common", "tls_only"); ok && tmpStr == "true" {
 		cfg.TlsOnly = true
 	} else {
@@ -444,5 +658,25 @@ func UnmarshalPluginsFromIni(sections ini.File, cfg *ServerCommonConf) {
 }
 
 func (cfg *ServerCommonConf) Check() (err error) {
+	if (cfg.VhostHttpsCertFile == "") != (cfg.VhostHttpsKeyFile == "") {
+		err = fmt.Errorf("vhost_https_cert_file and vhost_https_key_file should be set together")
+		return
+	}
+	if cfg.AcmeEnable {
+		if cfg.VhostHttpsPort == 0 || cfg.VhostHttpPort == 0 {
+			err = fmt.Errorf("acme_enable requires both vhost_http_port and vhost_https_port")
+			return
+		}
+		if cfg.AcmeDirectoryUrl == "" {
+			err = fmt.Errorf("acme_directory_url is required when acme_enable is true")
+			return
+		}
+	}
 	return
 }
+
+// IsTlsTerminationEnabled returns true if frps holds certificates for https
+// proxies with tls_termination enabled.
+func (cfg *ServerCommonConf) IsTlsTerminationEnabled() bool {
+	return cfg.VhostHttpsPort > 0 && (cfg.VhostHttpsCertFile != "" || cfg.AcmeEnable)
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/fatedier/frp/models/consts"
 
@@ -81,6 +82,13 @@ type BaseVisitorConf struct {
 	ServerName     string `json:"server_name"`
 	BindAddr       string `json:"bind_addr"`
 	BindPort       int    `json:"bind_port"`
+
+	// Plugin specifies the visitor plugin which handles connections of local
+	// users, they are joined with connections to the proxy directly if it's
+	// empty.
+	Plugin string `json:"plugin"`
+	// PluginParams specify parameters to be passed to the plugin.
+	PluginParams map[string]string `json:"plugin_params"`
 }
 
 func (cfg *BaseVisitorConf) GetBaseInfo() *BaseVisitorConf {
@@ -99,6 +107,16 @@ func (cfg *BaseVisitorConf) compare(cmp *BaseVisitorConf) bool {
 		cfg.BindPort != cmp.BindPort {
 		return false
 	}
+	if cfg.Plugin != cmp.Plugin ||
+		len(cfg.PluginParams) != len(cmp.PluginParams) {
+		return false
+	}
+	for k, v := range cfg.PluginParams {
+		value, ok := cmp.PluginParams[k]
+		if !ok || v != value {
+			return false
+		}
+	}
 	return true
 }
 
@@ -150,6 +168,17 @@ func (cfg *BaseVisitorConf) UnmarshalFromIni(prefix string, name string, section
 	} else {
 		return fmt.Errorf("Parse conf error: proxy [%s] bind_port not found", name)
 	}
+
+	cfg.Plugin = section["plugin"]
+	cfg.PluginParams = make(map[string]string)
+	if cfg.Plugin != "" {
+		// get params begin with "plugin_"
+		for k, v := range section {
+			if strings.HasPrefix(k, "plugin_") {
+				cfg.PluginParams[k] = v
+			}
+		}
+	}
 	return nil
 }
 
@@ -180,11 +209,26 @@ func (cfg *SudpVisitorConf) Check() (err error) {
 	if err = cfg.BaseVisitorConf.check(); err != nil {
 		return
 	}
+	// plugins handle connections of local users, but sudp visitors relay
+	// udp packets without connections, so there is nothing for them
+	if cfg.Plugin != "" {
+		err = fmt.Errorf("plugin is not supported by sudp visitors")
+		return
+	}
 	return
 }
 
 type StcpVisitorConf struct {
 	BaseVisitorConf
+
+	// Multiplexer selects the target proxy for each connection by the
+	// handshake of local users, it can be "httpconnect" or "socks5". Targets
+	// are full names of proxies like "<user>.<proxy>", ServerName is not used.
+	Multiplexer string `json:"multiplexer"`
+	// SkFile contains "<proxy name pattern> <sk>" lines, sk of the first
+	// matched pattern is used for a target proxy, Sk is used if no pattern
+	// matches.
+	SkFile string `json:"sk_file"`
 }
 
 func (cfg *StcpVisitorConf) Compare(cmp VisitorConf) bool {
@@ -193,7 +237,9 @@ func (cfg *StcpVisitorConf) Compare(cmp VisitorConf) bool {
 		return false
 	}
 
-	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) {
+	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) ||
+		cfg.Multiplexer != cmpConf.Multiplexer ||
+		cfg.SkFile != cmpConf.SkFile {
 		return false
 	}
 	return true
@@ -203,6 +249,8 @@ func (cfg *StcpVisitorConf) UnmarshalFromIni(prefix string, name string, section
 	if err = cfg.BaseVisitorConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+	cfg.Multiplexer = section["multiplexer"]
+	cfg.SkFile = section["sk_file"]
 	return
 }
 
@@ -210,11 +258,31 @@ func (cfg *StcpVisitorConf) Check() (err error) {
 	if err = cfg.BaseVisitorConf.check(); err != nil {
 		return
 	}
+	switch cfg.Multiplexer {
+	case "":
+	case consts.HttpConnectTcpMultiplexer, consts.Socks5TcpMultiplexer:
+		if cfg.Plugin != "" {
+			err = fmt.Errorf("plugin is not supported with multiplexer")
+			return
+		}
+	default:
+		err = fmt.Errorf("multiplexer should be %s or %s", consts.HttpConnectTcpMultiplexer, consts.Socks5TcpMultiplexer)
+		return
+	}
 	return
 }
 
 type XtcpVisitorConf struct {
 	BaseVisitorConf
+
+	// RelayFallback specifies whether connections are relayed by frps like
+	// stcp if the nat hole can't be made in NatHoleTimeout seconds.
+	// By default, this value is true.
+	RelayFallback bool `json:"relay_fallback"`
+	// NatHoleTimeout specifies the timeout in seconds of making a nat hole
+	// before falling back to relay. By default, this value is 20, making a
+	// nat hole takes up to 18 seconds when the peers are slow to respond.
+	NatHoleTimeout int `json:"nat_hole_timeout"`
 }
 
 func (cfg *XtcpVisitorConf) Compare(cmp VisitorConf) bool {
@@ -223,7 +291,9 @@ func (cfg *XtcpVisitorConf) Compare(cmp VisitorConf) bool {
 		return false
 	}
 
-	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) {
+	if !cfg.BaseVisitorConf.compare(&cmpConf.BaseVisitorConf) ||
+		cfg.RelayFallback != cmpConf.RelayFallback ||
+		cfg.NatHoleTimeout != cmpConf.NatHoleTimeout {
 		return false
 	}
 	return true
@@ -233,6 +303,18 @@ func (cfg *XtcpVisitorConf) UnmarshalFromIni(prefix string, name string, section
 	if err = cfg.BaseVisitorConf.UnmarshalFromIni(prefix, name, section); err != nil {
 		return
 	}
+
+	cfg.RelayFallback = true
+	if tmpStr, ok := section["relay_fallback"]; ok && tmpStr == "false" {
+		cfg.RelayFallback = false
+	}
+
+	cfg.NatHoleTimeout = 20
+	if tmpStr, ok := section["nat_hole_timeout"]; ok {
+		if cfg.NatHoleTimeout, err = strconv.Atoi(tmpStr); err != nil {
+			return fmt.Errorf("Parse conf error: proxy [%s] nat_hole_timeout incorrect", name)
+		}
+	}
 	return
 }
 
@@ -240,5 +322,9 @@ func (cfg *XtcpVisitorConf) Check() (err error) {
 	if err = cfg.BaseVisitorConf.check(); err != nil {
 		return
 	}
+	if cfg.NatHoleTimeout <= 0 {
+		err = fmt.Errorf("nat_hole_timeout should be greater than 0")
+		return
+	}
 	return
 }
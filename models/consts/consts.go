@@ -38,4 +38,9 @@ var (
 
 	// tcp multiplexer
 	HttpConnectTcpMultiplexer string = "httpconnect"
+	Socks5TcpMultiplexer      string = "socks5"
+
+	// group load balancing strategy
+	RoundRobinGroupStrategy string = "round_robin"
+	StickyGroupStrategy     string = "sticky"
 )
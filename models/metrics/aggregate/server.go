@@ -91,3 +91,9 @@ func (m *serverMetrics) AddTrafficOut(name string, proxyType string, trafficByte
 		v.AddTrafficOut(name, proxyType, trafficBytes)
 	}
 }
+
+func (m *serverMetrics) AddXtcpConnection(name string, path string) {
+	for _, v := range m.ms {
+		v.AddXtcpConnection(name, path)
+	}
+}
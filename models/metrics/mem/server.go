@@ -172,6 +172,11 @@ func (m *serverMetrics) AddTrafficOut(name string, proxyType string, trafficByte
 	}
 }
 
+// AddXtcpConnection does nothing, paths of xtcp connections are not shown in
+// dashboard.
+func (m *serverMetrics) AddXtcpConnection(name string, path string) {
+}
+
 // Get stats data api.
 
 func (m *serverMetrics) GetServer() *ServerStats {
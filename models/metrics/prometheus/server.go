@@ -19,6 +19,7 @@ type serverMetrics struct {
 	connectionCount *prometheus.GaugeVec
 	trafficIn       *prometheus.CounterVec
 	trafficOut      *prometheus.CounterVec
+	xtcpConnection  *prometheus.CounterVec
 }
 
 func (m *serverMetrics) NewClient() {
@@ -53,6 +54,10 @@ func (m *serverMetrics) AddTrafficOut(name string, proxyType string, trafficByte
 	m.trafficOut.WithLabelValues(name, proxyType).Add(float64(trafficBytes))
 }
 
+func (m *serverMetrics) AddXtcpConnection(name string, path string) {
+	m.xtcpConnection.WithLabelValues(name, path).Inc()
+}
+
 func newServerMetrics() *serverMetrics {
 	m := &serverMetrics{
 		clientCount: prometheus.NewGauge(prometheus.GaugeOpts{
@@ -85,11 +90,18 @@ func newServerMetrics() *serverMetrics {
 			Name:      "traffic_out",
 			Help:      "The total out traffic",
 		}, []string{"name", "type"}),
+		xtcpConnection: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: serverSubsystem,
+			Name:      "xtcp_connections",
+			Help:      "The total xtcp connections by path",
+		}, []string{"name", "path"}),
 	}
 	prometheus.MustRegister(m.clientCount)
 	prometheus.MustRegister(m.proxyCount)
 	prometheus.MustRegister(m.connectionCount)
 	prometheus.MustRegister(m.trafficIn)
 	prometheus.MustRegister(m.trafficOut)
+	prometheus.MustRegister(m.xtcpConnection)
 	return m
 }
@@ -34,7 +34,10 @@ const (
 	TypeNatHoleClient         = 'n'
 	TypeNatHoleResp           = 'm'
 	TypeNatHoleClientDetectOK = 'd'
+	TypeNatHoleVisitorOK      = 'q'
 	TypeNatHoleSid            = '5'
+	TypeNatHoleDiscovery      = 'j'
+	TypeNatHoleDiscoveryResp  = 'k'
 )
 
 var (
@@ -56,7 +59,10 @@ var (
 		TypeNatHoleClient:         NatHoleClient{},
 		TypeNatHoleResp:           NatHoleResp{},
 		TypeNatHoleClientDetectOK: NatHoleClientDetectOK{},
+		TypeNatHoleVisitorOK:      NatHoleVisitorOK{},
 		TypeNatHoleSid:            NatHoleSid{},
+		TypeNatHoleDiscovery:      NatHoleDiscovery{},
+		TypeNatHoleDiscoveryResp:  NatHoleDiscoveryResp{},
 	}
 )
 
@@ -107,6 +113,40 @@ type NewProxy struct {
 	HostHeaderRewrite string            `json:"host_header_rewrite"`
 	Headers           map[string]string `json:"headers"`
 
+	ResponseHeaders        map[string]string `json:"response_headers"`
+	RemoveRequestHeaders   []string          `json:"remove_request_headers"`
+	RemoveResponseHeaders  []string          `json:"remove_response_headers"`
+	DisableXForwardedFor   bool              `json:"disable_x_forwarded_for"`
+	DisableXForwardedProto bool              `json:"disable_x_forwarded_proto"`
+	AddXRealIp             bool              `json:"add_x_real_ip"`
+	LocalHttp2             bool              `json:"local_http2"`
+	HttpCache              bool              `json:"http_cache"`
+	HttpCompression        bool              `json:"http_compression"`
+	HttpInspect            int               `json:"http_inspect"`
+	StripPrefix            string            `json:"strip_prefix"`
+	PathRewrites           []string          `json:"path_rewrites"`
+	AddPrefix              string            `json:"add_prefix"`
+	ErrorPages             map[string]string `json:"error_pages"`
+
+	HttpUsers                      map[string]string `json:"http_users"`
+	HttpBearerTokens               []string          `json:"http_bearer_tokens"`
+	HttpForwardAuthUrl             string            `json:"http_forward_auth_url"`
+	HttpForwardAuthResponseHeaders []string          `json:"http_forward_auth_response_headers"`
+	HttpOidcIssuer                 string            `json:"http_oidc_issuer"`
+	HttpOidcClientId               string            `json:"http_oidc_client_id"`
+	HttpOidcClientSecret           string            `json:"http_oidc_client_secret"`
+	HttpOidcAllowedEmails          []string          `json:"http_oidc_allowed_emails"`
+
+	// tcp, tcpmux, http and https only
+	RateLimit          float64 `json:"rate_limit"`
+	MaxConcurrent      int     `json:"max_concurrent"`
+	PerIpRateLimit     float64 `json:"per_ip_rate_limit"`
+	PerIpMaxConcurrent int     `json:"per_ip_max_concurrent"`
+
+	// https only
+	TlsTermination bool   `json:"tls_termination"`
+	GroupStrategy  string `json:"group_strategy"`
+
 	// stcp
 	Sk string `json:"sk"`
 
@@ -140,6 +180,9 @@ type StartWorkConn struct {
 	SrcPort   uint16 `json:"src_port"`
 	DstPort   uint16 `json:"dst_port"`
 	Error     string `json:"error"`
+	// Relay is true if the connection of a xtcp visitor is relayed by server
+	// instead of a nat hole.
+	Relay bool `json:"relay"`
 }
 
 type NewVisitorConn struct {
@@ -174,23 +217,53 @@ type NatHoleVisitor struct {
 	ProxyName string `json:"proxy_name"`
 	SignKey   string `json:"sign_key"`
 	Timestamp int64  `json:"timestamp"`
+	NatType   string `json:"nat_type"`
+	PortDelta int    `json:"port_delta"`
 }
 
 type NatHoleClient struct {
 	ProxyName string `json:"proxy_name"`
 	Sid       string `json:"sid"`
+	NatType   string `json:"nat_type"`
+	PortDelta int    `json:"port_delta"`
 }
 
 type NatHoleResp struct {
-	Sid         string `json:"sid"`
-	VisitorAddr string `json:"visitor_addr"`
-	ClientAddr  string `json:"client_addr"`
-	Error       string `json:"error"`
+	Sid              string `json:"sid"`
+	VisitorAddr      string `json:"visitor_addr"`
+	VisitorNatType   string `json:"visitor_nat_type"`
+	VisitorPortDelta int    `json:"visitor_port_delta"`
+	ClientAddr       string `json:"client_addr"`
+	ClientNatType    string `json:"client_nat_type"`
+	ClientPortDelta  int    `json:"client_port_delta"`
+	Error            string `json:"error"`
 }
 
 type NatHoleClientDetectOK struct {
 }
 
+// NatHoleVisitorOK is sent to the udp port of server by visitors after the
+// nat hole is made.
+type NatHoleVisitorOK struct {
+	ProxyName string `json:"proxy_name"`
+	Sid       string `json:"sid"`
+}
+
 type NatHoleSid struct {
 	Sid string `json:"sid"`
 }
+
+// NatHoleDiscovery is sent to udp ports of server to get the mapped address.
+// The response is sent from the other port if ChangePort is true.
+type NatHoleDiscovery struct {
+	TransactionID string `json:"transaction_id"`
+	ChangePort    bool   `json:"change_port"`
+}
+
+type NatHoleDiscoveryResp struct {
+	TransactionID string `json:"transaction_id"`
+	MappedAddr    string `json:"mapped_addr"`
+	// DiscoveryPort is 0 if the second port is disabled in server.
+	DiscoveryPort int    `json:"discovery_port"`
+	Error         string `json:"error"`
+}
@@ -0,0 +1,279 @@
+package nathole
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/utils/util"
+)
+
+const (
+	NatTypeUnknown            = "unknown"
+	NatTypeNone               = "none"
+	NatTypeCone               = "cone"
+	NatTypePortRestrictedCone = "port restricted cone"
+	NatTypeSymmetric          = "symmetric"
+
+	BehaviorUnknown             = "unknown"
+	BehaviorEndpointIndependent = "endpoint independent"
+	BehaviorPortDependent       = "port dependent"
+)
+
+var (
+	// FeatureCacheTime is how long the result of CachedDiscover is used.
+	FeatureCacheTime = 10 * time.Minute
+	// FeatureErrorCacheTime is how long a failed discovery is remembered, so
+	// a lost packet doesn't disable nat hole punching for FeatureCacheTime.
+	FeatureErrorCacheTime = 5 * time.Second
+
+	featureCache   = make(map[string]*cachedFeature)
+	featureCacheMu sync.Mutex
+	// lastFeatures keeps the last successful result for each server, it's
+	// used by LastFeature while the cache is refreshed.
+	lastFeatures = make(map[string]*NatFeature)
+
+	// discover is replaced in tests.
+	discover = Discover
+)
+
+// NatFeature describes the NAT in front of the local host. As frps only
+// listens on one IP, address dependent behaviors are reported as endpoint
+// independent.
+type NatFeature struct {
+	NatType string
+	// MappingBehavior is endpoint independent if the same mapped address is
+	// used for different ports of server.
+	MappingBehavior string
+	// FilteringBehavior is endpoint independent if packets from other ports
+	// of server are accepted.
+	FilteringBehavior string
+
+	LocalAddr   string
+	MappedAddr  string
+	MappedAddr2 string
+	// PortDelta is the difference between ports mapped for the two ports of
+	// server, it's used to predict ports of symmetric NATs.
+	PortDelta int
+}
+
+type cachedFeature struct {
+	feature  *NatFeature
+	err      error
+	expireAt time.Time
+	// done is not nil while the discovery is running and closed when it's
+	// finished.
+	done chan struct{}
+}
+
+// CachedDiscover is like Discover but reuses the result in FeatureCacheTime,
+// or FeatureErrorCacheTime if it failed. Concurrent callers for the same
+// server wait for a single discovery.
+func CachedDiscover(serverAddr string, serverPort int) (*NatFeature, error) {
+	key := fmt.Sprintf("%s:%d", serverAddr, serverPort)
+
+	featureCacheMu.Lock()
+	if c, ok := featureCache[key]; ok {
+		if done := c.done; done != nil {
+			featureCacheMu.Unlock()
+			<-done
+			return c.feature, c.err
+		}
+		if time.Now().Before(c.expireAt) {
+			featureCacheMu.Unlock()
+			return c.feature, c.err
+		}
+	}
+	c := &cachedFeature{done: make(chan struct{})}
+	featureCache[key] = c
+	featureCacheMu.Unlock()
+
+	c.feature, c.err = discover(serverAddr, serverPort, time.Second)
+	cacheTime := FeatureCacheTime
+	if c.err != nil {
+		cacheTime = FeatureErrorCacheTime
+	}
+
+	featureCacheMu.Lock()
+	c.expireAt = time.Now().Add(cacheTime)
+	if c.err == nil {
+		lastFeatures[key] = c.feature
+	}
+	done := c.done
+	c.done = nil
+	featureCacheMu.Unlock()
+	close(done)
+	return c.feature, c.err
+}
+
+// DiscoverInBackground starts CachedDiscover without waiting for it, so the
+// result is ready when nat holes are made.
+func DiscoverInBackground(serverAddr string, serverPort int) {
+	go CachedDiscover(serverAddr, serverPort)
+}
+
+// LastFeature returns the last discovered NAT feature without waiting for a
+// discovery, the NAT type is unknown if no discovery has succeeded. A new
+// discovery is started in the background if the cached result is expired.
+func LastFeature(serverAddr string, serverPort int) *NatFeature {
+	key := fmt.Sprintf("%s:%d", serverAddr, serverPort)
+
+	featureCacheMu.Lock()
+	c, ok := featureCache[key]
+	expired := !ok || (c.done == nil && !time.Now().Before(c.expireAt))
+	feature := lastFeatures[key]
+	featureCacheMu.Unlock()
+
+	if expired {
+		DiscoverInBackground(serverAddr, serverPort)
+	}
+	if feature == nil {
+		return &NatFeature{
+			NatType:           NatTypeUnknown,
+			MappingBehavior:   BehaviorUnknown,
+			FilteringBehavior: BehaviorUnknown,
+		}
+	}
+	return feature
+}
+
+// Discover detects the NAT behaviors by sending discovery messages to the
+// nat hole port of server and its discovery port. Each step waits timeout
+// for the response.
+func Discover(serverAddr string, serverPort int, timeout time.Duration) (*NatFeature, error) {
+	raddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", serverAddr, serverPort))
+	if err != nil {
+		return nil, fmt.Errorf("resolve server udp addr error: %v", err)
+	}
+
+	// Connected socket is not used since responses may come from the other
+	// port, the local IP used to reach server is bound instead.
+	localIP, err := localIPTo(raddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	laddr := conn.LocalAddr().(*net.UDPAddr)
+
+	feature := &NatFeature{
+		NatType:           NatTypeUnknown,
+		MappingBehavior:   BehaviorUnknown,
+		FilteringBehavior: BehaviorUnknown,
+		LocalAddr:         laddr.String(),
+	}
+
+	resp, err := discoveryExchange(conn, raddr, false, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("get mapped address error: %v", err)
+	}
+	feature.MappedAddr = resp.MappedAddr
+	mapped, err := net.ResolveUDPAddr("udp", feature.MappedAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapped address [%s]", feature.MappedAddr)
+	}
+	if mapped.IP.Equal(laddr.IP) && mapped.Port == laddr.Port {
+		feature.NatType = NatTypeNone
+		return feature, nil
+	}
+	if resp.DiscoveryPort == 0 {
+		return feature, nil
+	}
+
+	// Test filtering before sending anything to the discovery port, or the
+	// NAT will accept packets from it anyway.
+	if _, err = discoveryExchange(conn, raddr, true, timeout); err == nil {
+		feature.FilteringBehavior = BehaviorEndpointIndependent
+	} else {
+		feature.FilteringBehavior = BehaviorPortDependent
+	}
+
+	raddr2 := &net.UDPAddr{IP: raddr.IP, Port: resp.DiscoveryPort, Zone: raddr.Zone}
+	resp2, err := discoveryExchange(conn, raddr2, false, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("get mapped address of discovery port error: %v", err)
+	}
+	feature.MappedAddr2 = resp2.MappedAddr
+
+	mapped2, err := net.ResolveUDPAddr("udp", feature.MappedAddr2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapped address [%s]", feature.MappedAddr2)
+	}
+	feature.PortDelta = mapped2.Port - mapped.Port
+
+	switch {
+	case feature.MappedAddr != feature.MappedAddr2:
+		feature.MappingBehavior = BehaviorPortDependent
+		feature.NatType = NatTypeSymmetric
+	case feature.FilteringBehavior == BehaviorEndpointIndependent:
+		feature.MappingBehavior = BehaviorEndpointIndependent
+		feature.NatType = NatTypeCone
+	default:
+		feature.MappingBehavior = BehaviorEndpointIndependent
+		feature.NatType = NatTypePortRestrictedCone
+	}
+	return feature, nil
+}
+
+// localIPTo returns the local IP used to send packets to raddr.
+func localIPTo(raddr *net.UDPAddr) (net.IP, error) {
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// discoveryExchange sends the discovery message to raddr until the response
+// is received or timeout.
+func discoveryExchange(conn *net.UDPConn, raddr *net.UDPAddr, changePort bool, timeout time.Duration) (*msg.NatHoleDiscoveryResp, error) {
+	tid, err := util.RandId()
+	if err != nil {
+		return nil, err
+	}
+	b := bytes.NewBuffer(nil)
+	if err = msg.WriteMsg(b, &msg.NatHoleDiscovery{
+		TransactionID: tid,
+		ChangePort:    changePort,
+	}); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1024)
+	for time.Now().Before(deadline) {
+		if _, err = conn.WriteToUDP(b.Bytes(), raddr); err != nil {
+			return nil, err
+		}
+
+		retryAt := time.Now().Add(200 * time.Millisecond)
+		if retryAt.After(deadline) {
+			retryAt = deadline
+		}
+		conn.SetReadDeadline(retryAt)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			var resp msg.NatHoleDiscoveryResp
+			if err = msg.ReadMsgInto(bytes.NewReader(buf[:n]), &resp); err != nil || resp.TransactionID != tid {
+				continue
+			}
+			conn.SetReadDeadline(time.Time{})
+			if resp.Error != "" {
+				return nil, fmt.Errorf("%s", resp.Error)
+			}
+			return &resp, nil
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	return nil, fmt.Errorf("timeout")
+}
@@ -0,0 +1,192 @@
+package nathole
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverNoNat(t *testing.T) {
+	assert := assert.New(t)
+
+	nc, err := NewNatHoleController("127.0.0.1:0", "127.0.0.1:0")
+	assert.NoError(err)
+	go nc.Run()
+	defer nc.listener.Close()
+	defer nc.discoveryListener.Close()
+
+	port := nc.listener.LocalAddr().(*net.UDPAddr).Port
+	feature, err := Discover("127.0.0.1", port, time.Second)
+	assert.NoError(err)
+	assert.Equal(NatTypeNone, feature.NatType)
+	assert.Equal(feature.LocalAddr, feature.MappedAddr)
+
+	// Responses from the other port are accepted without NAT.
+	conn, err := net.ListenUDP("udp", nil)
+	assert.NoError(err)
+	defer conn.Close()
+	raddr := nc.listener.LocalAddr().(*net.UDPAddr)
+	resp, err := discoveryExchange(conn, raddr, true, time.Second)
+	assert.NoError(err)
+	assert.Equal(nc.discoveryListener.LocalAddr().(*net.UDPAddr).Port, resp.DiscoveryPort)
+}
+
+func TestDiscoverWithoutDiscoveryPort(t *testing.T) {
+	assert := assert.New(t)
+
+	nc, err := NewNatHoleController("127.0.0.1:0", "")
+	assert.NoError(err)
+	go nc.Run()
+	defer nc.listener.Close()
+
+	conn, err := net.ListenUDP("udp", nil)
+	assert.NoError(err)
+	defer conn.Close()
+	raddr := nc.listener.LocalAddr().(*net.UDPAddr)
+	resp, err := discoveryExchange(conn, raddr, false, time.Second)
+	assert.NoError(err)
+	assert.Equal(0, resp.DiscoveryPort)
+
+	_, err = discoveryExchange(conn, raddr, true, time.Second)
+	assert.Error(err)
+}
+
+func TestCachedDiscover(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	started, block := make(chan struct{}), make(chan struct{})
+	discover = func(serverAddr string, serverPort int, timeout time.Duration) (*NatFeature, error) {
+		atomic.AddInt32(&calls, 1)
+		if serverAddr == "slow" {
+			close(started)
+			<-block
+			return &NatFeature{NatType: NatTypeCone}, nil
+		}
+		return nil, fmt.Errorf("timeout")
+	}
+	oldErrorCacheTime := FeatureErrorCacheTime
+	FeatureErrorCacheTime = 100 * time.Millisecond
+	defer func() {
+		discover = Discover
+		FeatureErrorCacheTime = oldErrorCacheTime
+		featureCache = make(map[string]*cachedFeature)
+		lastFeatures = make(map[string]*NatFeature)
+	}()
+
+	// Concurrent callers share one discovery.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			feature, err := CachedDiscover("slow", 1)
+			assert.NoError(err)
+			assert.Equal(NatTypeCone, feature.NatType)
+		}()
+	}
+
+	<-started
+
+	// Other servers aren't blocked by a running discovery.
+	_, err := CachedDiscover("fail", 1)
+	assert.Error(err)
+	_, err = CachedDiscover("fail", 1)
+	assert.Error(err)
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+
+	close(block)
+	wg.Wait()
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+	_, err = CachedDiscover("slow", 1)
+	assert.NoError(err)
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+
+	// Errors are only cached for FeatureErrorCacheTime.
+	time.Sleep(150 * time.Millisecond)
+	_, err = CachedDiscover("fail", 1)
+	assert.Error(err)
+	assert.EqualValues(3, atomic.LoadInt32(&calls))
+}
+
+func TestLastFeature(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	block := make(chan struct{})
+	natType := NatTypeCone
+	discover = func(serverAddr string, serverPort int, timeout time.Duration) (*NatFeature, error) {
+		atomic.AddInt32(&calls, 1)
+		<-block
+		return &NatFeature{NatType: natType}, nil
+	}
+	oldCacheTime := FeatureCacheTime
+	FeatureCacheTime = 100 * time.Millisecond
+	defer func() {
+		discover = Discover
+		FeatureCacheTime = oldCacheTime
+		featureCache = make(map[string]*cachedFeature)
+		lastFeatures = make(map[string]*NatFeature)
+	}()
+
+	waitCalls := func(n int32) {
+		for i := 0; i < 100 && atomic.LoadInt32(&calls) < n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.EqualValues(n, atomic.LoadInt32(&calls))
+	}
+	waitFeature := func(natType string) {
+		for i := 0; i < 100 && LastFeature("server", 1).NatType != natType; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(natType, LastFeature("server", 1).NatType)
+	}
+
+	// It doesn't wait for the running discovery.
+	DiscoverInBackground("server", 1)
+	waitCalls(1)
+	assert.Equal(NatTypeUnknown, LastFeature("server", 1).NatType)
+	block <- struct{}{}
+	waitFeature(NatTypeCone)
+	assert.EqualValues(1, atomic.LoadInt32(&calls))
+
+	// The expired result is used while it's discovered again.
+	time.Sleep(150 * time.Millisecond)
+	natType = NatTypeSymmetric
+	assert.Equal(NatTypeCone, LastFeature("server", 1).NatType)
+	waitCalls(2)
+	assert.Equal(NatTypeCone, LastFeature("server", 1).NatType)
+	block <- struct{}{}
+	waitFeature(NatTypeSymmetric)
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+}
+
+func TestPredictAddrs(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 10000}
+	addrs, strategy := PredictAddrs(addr, NatTypePortRestrictedCone, 0)
+	assert.Equal(StrategyDirect, strategy)
+	assert.Len(addrs, 1)
+
+	addrs, strategy = PredictAddrs(addr, NatTypeSymmetric, 2)
+	assert.Equal(StrategyPortPrediction, strategy)
+	assert.Len(addrs, PredictCount+1)
+	assert.Equal(10002, addrs[1].Port)
+	assert.Equal(10000+2*PredictCount, addrs[PredictCount].Port)
+
+	addrs, strategy = PredictAddrs(addr, NatTypeSymmetric, 3000)
+	assert.Equal(StrategyMultiPortBurst, strategy)
+	assert.Len(addrs, 2*BurstRange+1)
+
+	addr.Port = 65500
+	addrs, _ = PredictAddrs(addr, NatTypeSymmetric, 3000)
+	for _, a := range addrs {
+		assert.True(a.Port <= 65535)
+	}
+}
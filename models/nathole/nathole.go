@@ -21,10 +21,15 @@ var NatHoleTimeout int64 = 10
 type SidRequest struct {
 	Sid      string
 	NotifyCh chan struct{}
+	// OkCh is closed when the visitor reports the nat hole is made.
+	OkCh chan struct{}
 }
 
 type NatHoleController struct {
 	listener *net.UDPConn
+	// discoveryListener is the second udp port for nat type discovery, it
+	// may be nil.
+	discoveryListener *net.UDPConn
 
 	clientCfgs map[string]*NatHoleClientCfg
 	sessions   map[string]*NatHoleSession
@@ -32,7 +37,9 @@ type NatHoleController struct {
 	mu sync.RWMutex
 }
 
-func NewNatHoleController(udpBindAddr string) (nc *NatHoleController, err error) {
+// NewNatHoleController listens on udpBindAddr, and discoveryBindAddr if it's
+// not empty.
+func NewNatHoleController(udpBindAddr string, discoveryBindAddr string) (nc *NatHoleController, err error) {
 	addr, err := net.ResolveUDPAddr("udp", udpBindAddr)
 	if err != nil {
 		return nil, err
@@ -46,6 +53,19 @@ func NewNatHoleController(udpBindAddr string) (nc *NatHoleController, err error)
 		clientCfgs: make(map[string]*NatHoleClientCfg),
 		sessions:   make(map[string]*NatHoleSession),
 	}
+
+	if discoveryBindAddr != "" {
+		addr, err = net.ResolveUDPAddr("udp", discoveryBindAddr)
+		if err != nil {
+			lconn.Close()
+			return nil, err
+		}
+		nc.discoveryListener, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			lconn.Close()
+			return nil, err
+		}
+	}
 	return nc, nil
 }
 
@@ -68,6 +88,10 @@ func (nc *NatHoleController) CloseClient(name string) {
 }
 
 func (nc *NatHoleController) Run() {
+	if nc.discoveryListener != nil {
+		go nc.runDiscovery()
+	}
+
 	for {
 		buf := pool.GetBuf(1024)
 		n, raddr, err := nc.listener.ReadFromUDP(buf)
@@ -88,6 +112,10 @@ func (nc *NatHoleController) Run() {
 			go nc.HandleVisitor(m, raddr)
 		case *msg.NatHoleClient:
 			go nc.HandleClient(m, raddr)
+		case *msg.NatHoleVisitorOK:
+			nc.HandleVisitorOK(m)
+		case *msg.NatHoleDiscovery:
+			nc.HandleDiscovery(m, raddr, nc.listener)
 		default:
 			log.Trace("error nat hole message type")
 			continue
@@ -96,6 +124,57 @@ func (nc *NatHoleController) Run() {
 	}
 }
 
+// runDiscovery only handles discovery messages on the second port.
+func (nc *NatHoleController) runDiscovery() {
+	buf := make([]byte, 1024)
+	for {
+		n, raddr, err := nc.discoveryListener.ReadFromUDP(buf)
+		if err != nil {
+			log.Trace("nat hole discovery listener read from udp error: %v", err)
+			return
+		}
+
+		rawMsg, err := msg.ReadMsg(bytes.NewReader(buf[:n]))
+		if err != nil {
+			log.Trace("read nat hole discovery message error: %v", err)
+			continue
+		}
+		if m, ok := rawMsg.(*msg.NatHoleDiscovery); ok {
+			nc.HandleDiscovery(m, raddr, nc.discoveryListener)
+		}
+	}
+}
+
+// HandleDiscovery tells the mapped address of raddr, the response is sent from
+// the other port if it's requested.
+func (nc *NatHoleController) HandleDiscovery(m *msg.NatHoleDiscovery, raddr *net.UDPAddr, from *net.UDPConn) {
+	resp := &msg.NatHoleDiscoveryResp{
+		TransactionID: m.TransactionID,
+		MappedAddr:    raddr.String(),
+	}
+	if nc.discoveryListener != nil {
+		resp.DiscoveryPort = nc.discoveryListener.LocalAddr().(*net.UDPAddr).Port
+	}
+
+	sender := from
+	if m.ChangePort {
+		switch {
+		case nc.discoveryListener == nil:
+			resp.Error = "discovery port is not enabled in server"
+		case from == nc.listener:
+			sender = nc.discoveryListener
+		default:
+			sender = nc.listener
+		}
+	}
+
+	b := bytes.NewBuffer(nil)
+	if err := msg.WriteMsg(b, resp); err != nil {
+		return
+	}
+	sender.WriteToUDP(b.Bytes(), raddr)
+}
+
 func (nc *NatHoleController) GenSid() string {
 	t := time.Now().Unix()
 	id, _ := util.RandId()
@@ -105,9 +184,13 @@ func (nc *NatHoleController) GenSid() string {
 func (nc *NatHoleController) HandleVisitor(m *msg.NatHoleVisitor, raddr *net.UDPAddr) {
 	sid := nc.GenSid()
 	session := &NatHoleSession{
-		Sid:         sid,
-		VisitorAddr: raddr,
-		NotifyCh:    make(chan struct{}, 0),
+		Sid:              sid,
+		ProxyName:        m.ProxyName,
+		VisitorAddr:      raddr,
+		VisitorNatType:   m.NatType,
+		VisitorPortDelta: m.PortDelta,
+		NotifyCh:         make(chan struct{}, 0),
+		OkCh:             make(chan struct{}),
 	}
 	nc.mu.Lock()
 	clientCfg, ok := nc.clientCfgs[m.ProxyName]
@@ -140,6 +223,7 @@ func (nc *NatHoleController) HandleVisitor(m *msg.NatHoleVisitor, raddr *net.UDP
 		clientCfg.SidCh <- &SidRequest{
 			Sid:      sid,
 			NotifyCh: session.NotifyCh,
+			OkCh:     session.OkCh,
 		}
 	})
 	if err != nil {
@@ -155,6 +239,27 @@ func (nc *NatHoleController) HandleVisitor(m *msg.NatHoleVisitor, raddr *net.UDP
 	case <-time.After(time.Duration(NatHoleTimeout) * time.Second):
 		return
 	}
+
+	// The session is kept until the visitor reports the result.
+	select {
+	case <-session.OkCh:
+	case <-time.After(time.Duration(NatHoleTimeout) * time.Second):
+	}
+}
+
+// HandleVisitorOK closes OkCh of the session, sid is only known by the
+// visitor and the client of the session.
+func (nc *NatHoleController) HandleVisitorOK(m *msg.NatHoleVisitorOK) {
+	nc.mu.RLock()
+	session, ok := nc.sessions[m.Sid]
+	nc.mu.RUnlock()
+	if !ok || session.ProxyName != m.ProxyName {
+		return
+	}
+	log.Trace("nat hole of sid [%s] is made", session.Sid)
+	session.okOnce.Do(func() {
+		close(session.OkCh)
+	})
 }
 
 func (nc *NatHoleController) HandleClient(m *msg.NatHoleClient, raddr *net.UDPAddr) {
@@ -166,6 +271,8 @@ func (nc *NatHoleController) HandleClient(m *msg.NatHoleClient, raddr *net.UDPAd
 	}
 	log.Trace("handle client message, sid [%s]", session.Sid)
 	session.ClientAddr = raddr
+	session.ClientNatType = m.NatType
+	session.ClientPortDelta = m.PortDelta
 
 	resp := nc.GenNatHoleResponse(session, "")
 	log.Trace("send nat hole response to client")
@@ -173,21 +280,17 @@ func (nc *NatHoleController) HandleClient(m *msg.NatHoleClient, raddr *net.UDPAd
 }
 
 func (nc *NatHoleController) GenNatHoleResponse(session *NatHoleSession, errInfo string) []byte {
-	var (
-		sid         string
-		visitorAddr string
-		clientAddr  string
-	)
-	if session != nil {
-		sid = session.Sid
-		visitorAddr = session.VisitorAddr.String()
-		clientAddr = session.ClientAddr.String()
-	}
 	m := &msg.NatHoleResp{
-		Sid:         sid,
-		VisitorAddr: visitorAddr,
-		ClientAddr:  clientAddr,
-		Error:       errInfo,
+		Error: errInfo,
+	}
+	if session != nil {
+		m.Sid = session.Sid
+		m.VisitorAddr = session.VisitorAddr.String()
+		m.VisitorNatType = session.VisitorNatType
+		m.VisitorPortDelta = session.VisitorPortDelta
+		m.ClientAddr = session.ClientAddr.String()
+		m.ClientNatType = session.ClientNatType
+		m.ClientPortDelta = session.ClientPortDelta
 	}
 	b := bytes.NewBuffer(nil)
 	err := msg.WriteMsg(b, m)
@@ -198,11 +301,18 @@ func (nc *NatHoleController) GenNatHoleResponse(session *NatHoleSession, errInfo
 }
 
 type NatHoleSession struct {
-	Sid         string
-	VisitorAddr *net.UDPAddr
-	ClientAddr  *net.UDPAddr
+	Sid              string
+	ProxyName        string
+	VisitorAddr      *net.UDPAddr
+	VisitorNatType   string
+	VisitorPortDelta int
+	ClientAddr       *net.UDPAddr
+	ClientNatType    string
+	ClientPortDelta  int
 
 	NotifyCh chan struct{}
+	OkCh     chan struct{}
+	okOnce   sync.Once
 }
 
 type NatHoleClientCfg struct {
@@ -0,0 +1,52 @@
+package nathole
+
+import (
+	"net"
+)
+
+const (
+	StrategyDirect         = "direct"
+	StrategyPortPrediction = "port prediction"
+	StrategyMultiPortBurst = "multi-port burst"
+)
+
+var (
+	// MaxPredictableDelta is the max port delta of symmetric NATs which
+	// allocate ports sequentially.
+	MaxPredictableDelta = 16
+	// PredictCount is how many ports are predicted by port delta.
+	PredictCount = 16
+	// BurstRange is how many ports around the mapped port are tried for
+	// symmetric NATs with unpredictable ports.
+	BurstRange = 128
+)
+
+// PredictAddrs returns addresses that packets to the peer should be sent to.
+// addr is the mapped address of peer seen by server, natType and portDelta
+// are discovered by peer.
+func PredictAddrs(addr *net.UDPAddr, natType string, portDelta int) (addrs []*net.UDPAddr, strategy string) {
+	addrs = []*net.UDPAddr{addr}
+	if natType != NatTypeSymmetric {
+		return addrs, StrategyDirect
+	}
+
+	newAddr := func(port int) {
+		if port > 0 && port <= 65535 && port != addr.Port {
+			addrs = append(addrs, &net.UDPAddr{IP: addr.IP, Port: port, Zone: addr.Zone})
+		}
+	}
+
+	if portDelta != 0 && portDelta >= -MaxPredictableDelta && portDelta <= MaxPredictableDelta {
+		// New mappings are allocated after the one seen by server.
+		for i := 1; i <= PredictCount; i++ {
+			newAddr(addr.Port + portDelta*i)
+		}
+		return addrs, StrategyPortPrediction
+	}
+
+	for i := 1; i <= BurstRange; i++ {
+		newAddr(addr.Port + i)
+		newAddr(addr.Port - i)
+	}
+	return addrs, StrategyMultiPortBurst
+}
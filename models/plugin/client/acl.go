@@ -0,0 +1,244 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errDestinationDenied = errors.New("destination is not allowed")
+
+// destinationRule matches destinations by host and port range. host can be
+// "*", a domain name, a domain pattern like "*.example.com", an ip or a cidr.
+type destinationRule struct {
+	host      string
+	ipNet     *net.IPNet
+	startPort int
+	endPort   int
+}
+
+// parseDestinationRule parses "<host>[:<port>[-<port>]]", ipv6 addresses
+// with ports should be in brackets like "[fd00::]/8:443".
+func parseDestinationRule(s string) (*destinationRule, error) {
+	host, ports := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("invalid destination [%s]", s)
+		}
+		host, ports = s[1:end], s[end+1:]
+		if i := strings.LastIndex(ports, ":"); i >= 0 {
+			host, ports = host+ports[:i], ports[i+1:]
+		} else {
+			host, ports = host+ports, ""
+		}
+	} else if strings.Count(s, ":") == 1 {
+		i := strings.Index(s, ":")
+		host, ports = s[:i], s[i+1:]
+	}
+
+	rule := &destinationRule{
+		host:      normalizeDomain(host),
+		startPort: 0,
+		endPort:   65535,
+	}
+	if strings.Contains(host, "/") {
+		_, ipNet, err := net.ParseCIDR(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination [%s]: %v", s, err)
+		}
+		rule.ipNet = ipNet
+	} else if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		rule.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else if host == "" {
+		return nil, fmt.Errorf("invalid destination [%s]", s)
+	}
+
+	if ports != "" && ports != "*" {
+		arr := strings.SplitN(ports, "-", 2)
+		start, err := strconv.Atoi(arr[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port of destination [%s]", s)
+		}
+		end := start
+		if len(arr) == 2 {
+			if end, err = strconv.Atoi(arr[1]); err != nil {
+				return nil, fmt.Errorf("invalid port of destination [%s]", s)
+			}
+		}
+		if start < 0 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range of destination [%s]", s)
+		}
+		rule.startPort, rule.endPort = start, end
+	}
+	return rule, nil
+}
+
+// match checks both the domain name and the resolved ip of a destination,
+// domain is empty if the destination is an ip.
+func (r *destinationRule) match(domain string, ip net.IP, port int) bool {
+	if port < r.startPort || port > r.endPort {
+		return false
+	}
+	domain = normalizeDomain(domain)
+	switch {
+	case r.host == "*":
+		return true
+	case r.ipNet != nil:
+		return ip != nil && r.ipNet.Contains(ip)
+	case domain == "":
+		return false
+	case strings.HasPrefix(r.host, "*."):
+		return strings.HasSuffix(domain, r.host[1:])
+	default:
+		return domain == r.host
+	}
+}
+
+// normalizeDomain returns the canonical form of domain, "Example.COM." is
+// the same host as "example.com".
+func normalizeDomain(domain string) string {
+	return strings.TrimRight(strings.ToLower(domain), ".")
+}
+
+// destinationACL denies destinations matching any deny rules. If there are
+// allow rules, destinations must match one of them.
+type destinationACL struct {
+	allow []*destinationRule
+	deny  []*destinationRule
+}
+
+// newDestinationACL parses rules separated by spaces or commas.
+func newDestinationACL(allow string, deny string) (*destinationACL, error) {
+	acl := &destinationACL{}
+	parse := func(s string) (rules []*destinationRule, err error) {
+		for _, v := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+			rule, err := parseDestinationRule(v)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+		return
+	}
+	var err error
+	if acl.allow, err = parse(allow); err != nil {
+		return nil, err
+	}
+	if acl.deny, err = parse(deny); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func (acl *destinationACL) allowed(domain string, ip net.IP, port int) bool {
+	for _, rule := range acl.deny {
+		if rule.match(domain, ip, port) {
+			return false
+		}
+	}
+	if len(acl.allow) == 0 {
+		return true
+	}
+	for _, rule := range acl.allow {
+		if rule.match(domain, ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the ip of host and checks it with acl. Connections should
+// be made to the returned ip so that rules of ips can't be bypassed by dns.
+func (acl *destinationACL) resolve(host string, port int) (net.IP, error) {
+	domain := ""
+	ip := net.ParseIP(host)
+	if ip == nil {
+		domain = host
+		addr, err := net.ResolveIPAddr("ip", host)
+		if err != nil {
+			return nil, err
+		}
+		ip = addr.IP
+	}
+	if !acl.allowed(domain, ip, port) {
+		return nil, errDestinationDenied
+	}
+	return ip, nil
+}
+
+func (acl *destinationACL) dial(host string, port int) (net.Conn, error) {
+	ip, err := acl.resolve(host, port)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), 10*time.Second)
+}
+
+// credentials validates users by a single user and password or a file with
+// "user:password" lines. The file is read for each validation so users can
+// be changed without restarting frpc.
+type credentials struct {
+	user   string
+	passwd string
+	file   string
+}
+
+func (c *credentials) required() bool {
+	return c.user != "" || c.passwd != "" || c.file != ""
+}
+
+func (c *credentials) valid(user string, passwd string) bool {
+	if (c.user != "" || c.passwd != "") && equalString(user, c.user) && equalString(passwd, c.passwd) {
+		return true
+	}
+	if c.file == "" {
+		return false
+	}
+
+	f, err := os.Open(c.file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		arr := strings.SplitN(line, ":", 2)
+		if len(arr) == 2 && equalString(user, arr[0]) && equalString(passwd, arr[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalString(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDestinationRule(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		rule      string
+		host      string
+		cidr      string
+		startPort int
+		endPort   int
+	}{
+		{"*", "*", "", 0, 65535},
+		{"*:25", "*", "", 25, 25},
+		{"Example.COM.", "example.com", "", 0, 65535},
+		{"*.example.com:443", "*.example.com", "", 443, 443},
+		{"example.com:8000-9000", "example.com", "", 8000, 9000},
+		{"example.com:*", "example.com", "", 0, 65535},
+		{"192.168.1.1", "192.168.1.1", "192.168.1.1/32", 0, 65535},
+		{"192.168.1.0/24:22", "192.168.1.0/24", "192.168.1.0/24", 22, 22},
+		{"fd00::1", "fd00::1", "fd00::1/128", 0, 65535},
+		{"[fd00::1]:443", "fd00::1", "fd00::1/128", 443, 443},
+		{"[fd00::]/8:80-90", "fd00::/8", "fd00::/8", 80, 90},
+	}
+	for _, test := range tests {
+		rule, err := parseDestinationRule(test.rule)
+		if !assert.NoError(err, test.rule) {
+			continue
+		}
+		assert.Equal(test.host, rule.host, test.rule)
+		if test.cidr != "" {
+			assert.Equal(test.cidr, rule.ipNet.String(), test.rule)
+		} else {
+			assert.Nil(rule.ipNet, test.rule)
+		}
+		assert.Equal(test.startPort, rule.startPort, test.rule)
+		assert.Equal(test.endPort, rule.endPort, test.rule)
+	}
+
+	for _, rule := range []string{"", ":80", "[fd00::1", "10.0.0.0/33", "example.com:a", "example.com:90-80", "example.com:70000"} {
+		_, err := parseDestinationRule(rule)
+		assert.Error(err, rule)
+	}
+}
+
+func TestDestinationACL(t *testing.T) {
+	assert := assert.New(t)
+
+	acl, err := newDestinationACL("192.168.1.0/24 *.example.com:443,[fd00::]/8:8000-8080 internal.corp",
+		"192.168.1.1 *:25 secret.example.com internal.corp:22")
+	assert.NoError(err)
+
+	tests := []struct {
+		domain  string
+		ip      string
+		port    int
+		allowed bool
+	}{
+		{"", "192.168.1.2", 80, true},
+		{"", "192.168.2.2", 80, false},
+		// deny rules are checked before allow rules
+		{"", "192.168.1.1", 80, false},
+		{"", "192.168.1.2", 25, false},
+		{"a.example.com", "1.1.1.1", 443, true},
+		{"A.Example.COM.", "1.1.1.1", 443, true},
+		{"a.example.com", "1.1.1.1", 80, false},
+		{"example.com", "1.1.1.1", 443, false},
+		{"secret.example.com", "1.1.1.1", 443, false},
+		{"SECRET.example.com.", "1.1.1.1", 443, false},
+		// domains are resolved to ips matching ip rules
+		{"host.lan", "192.168.1.1", 80, false},
+		{"host.lan", "192.168.1.3", 80, true},
+		{"", "fd00::1", 8080, true},
+		{"", "fd00::1", 8081, false},
+		{"", "fe80::1", 8080, false},
+		{"internal.corp", "10.0.0.1", 80, true},
+		{"internal.corp", "10.0.0.1", 22, false},
+		{"internal.corp.", "10.0.0.1", 22, false},
+	}
+	for _, test := range tests {
+		assert.Equal(test.allowed, acl.allowed(test.domain, net.ParseIP(test.ip), test.port), "%s %s:%d", test.domain, test.ip, test.port)
+	}
+
+	// non-canonical domains can't bypass deny rules
+	acl, err = newDestinationACL("", "internal.corp *.example.com")
+	assert.NoError(err)
+	for _, domain := range []string{"internal.corp", "internal.corp.", "Internal.CORP", "a.example.com.", "A.EXAMPLE.COM"} {
+		assert.False(acl.allowed(domain, net.ParseIP("10.0.0.1"), 80), domain)
+	}
+	assert.True(acl.allowed("internal.corp.com", net.ParseIP("10.0.0.1"), 80))
+
+	// everything is allowed without rules
+	acl, err = newDestinationACL("", "")
+	assert.NoError(err)
+	assert.True(acl.allowed("example.com", net.ParseIP("1.1.1.1"), 80))
+}
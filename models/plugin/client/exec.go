@@ -0,0 +1,513 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	frpLog "github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
+)
+
+const PluginExec = "exec"
+
+func init() {
+	Register(PluginExec, NewExecPlugin)
+}
+
+const (
+	// a process is started for each connection, its stdin and stdout are
+	// connected to the connection like inetd
+	execModeInetd = "inetd"
+	// a process is started once, connections are sent through its stdin and
+	// stdout by frames
+	execModeFramed = "framed"
+)
+
+// Frames of framed mode are "<type:1> <id:4> <length:4> <payload>", numbers
+// are in big endian. Payload of open frames is the remote address of the
+// connection, frames of data and close types can be sent by both sides.
+const (
+	execFrameOpen  = 1
+	execFrameData  = 2
+	execFrameClose = 3
+
+	execFrameHeaderSize     = 9
+	execFrameMaxPayloadSize = 64 * 1024
+
+	// max number of data frames waiting to be written to a connection, the
+	// connection is closed if it's too slow to read them
+	execFrameQueueSize = 64
+)
+
+type ExecPlugin struct {
+	mode         string
+	command      []string
+	workDir      string
+	env          []string
+	maxProcesses int
+	// processes of inetd mode are killed if they don't exit in exitTimeout
+	// after stdin is closed, 0 means no timeout
+	exitTimeout time.Duration
+
+	// processes of inetd mode
+	processes map[*exec.Cmd]struct{}
+	// the process of framed mode
+	framed *execFramedProcess
+
+	closed bool
+	mu     sync.Mutex
+}
+
+func NewExecPlugin(params map[string]string) (Plugin, error) {
+	p := &ExecPlugin{
+		mode:      execModeInetd,
+		workDir:   params["plugin_work_dir"],
+		env:       os.Environ(),
+		processes: make(map[*exec.Cmd]struct{}),
+	}
+	command, err := splitCommandLine(params["plugin_command"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin_command: %v", err)
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("plugin_command is required")
+	}
+	p.command = command
+	if v := params["plugin_exec_mode"]; v != "" {
+		if v != execModeInetd && v != execModeFramed {
+			return nil, fmt.Errorf("plugin_exec_mode should be inetd or framed")
+		}
+		p.mode = v
+	}
+	if v := params["plugin_max_processes"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid plugin_max_processes [%s]", v)
+		}
+		p.maxProcesses = n
+	}
+	if v := params["plugin_exit_timeout"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid plugin_exit_timeout [%s]", v)
+		}
+		p.exitTimeout = time.Duration(n) * time.Second
+	}
+	for k, v := range params {
+		if strings.HasPrefix(k, "plugin_env_") {
+			p.env = append(p.env, strings.TrimPrefix(k, "plugin_env_")+"="+v)
+		}
+	}
+
+	if p.mode == execModeFramed {
+		p.framed = &execFramedProcess{
+			plugin: p,
+			conns:  make(map[uint32]*execFramedConn),
+		}
+		if err := p.framed.start(); err != nil {
+			return nil, err
+		}
+		go p.framed.run()
+	}
+	return p, nil
+}
+
+func (p *ExecPlugin) newCommand(env ...string) *exec.Cmd {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Dir = p.workDir
+	cmd.Env = append(append([]string{}, p.env...), env...)
+	cmd.Stderr = &execLogWriter{name: p.command[0]}
+	return cmd
+}
+
+func (p *ExecPlugin) Handle(conn io.ReadWriteCloser, realConn net.Conn, extraBufToLocal []byte) {
+	remoteAddr := ""
+	if realConn != nil && realConn.RemoteAddr() != nil {
+		remoteAddr = realConn.RemoteAddr().String()
+	}
+	if p.mode == execModeFramed {
+		p.framed.handle(frpNet.WrapReadWriteCloserToConn(conn, realConn), remoteAddr, extraBufToLocal)
+		return
+	}
+	p.handleInetd(conn, remoteAddr, extraBufToLocal)
+}
+
+func (p *ExecPlugin) handleInetd(conn io.ReadWriteCloser, remoteAddr string, extraBufToLocal []byte) {
+	defer conn.Close()
+
+	cmd := p.newCommand("FRP_REMOTE_ADDR=" + remoteAddr)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed || (p.maxProcesses > 0 && len(p.processes) >= p.maxProcesses) {
+		p.mu.Unlock()
+		frpLog.Warn("exec [%s] connection from [%s] is refused, too many processes", p.command[0], remoteAddr)
+		return
+	}
+	if err = cmd.Start(); err != nil {
+		p.mu.Unlock()
+		frpLog.Warn("exec start [%s] error: %v", p.command[0], err)
+		return
+	}
+	p.processes[cmd] = struct{}{}
+	p.mu.Unlock()
+
+	exited := make(chan struct{})
+	go func() {
+		if len(extraBufToLocal) > 0 {
+			stdin.Write(extraBufToLocal)
+		}
+		io.Copy(stdin, conn)
+		stdin.Close()
+		// the process may still be writing its output after stdin is closed
+		if p.exitTimeout <= 0 {
+			return
+		}
+		select {
+		case <-exited:
+		case <-time.After(p.exitTimeout):
+			frpLog.Debug("exec [%s] for [%s] doesn't exit in %v after stdin is closed", p.command[0], remoteAddr, p.exitTimeout)
+			cmd.Process.Kill()
+			// children of the process may still hold stdout
+			stdout.Close()
+		}
+	}()
+	io.Copy(conn, stdout)
+	conn.Close()
+	cmd.Process.Kill()
+	cmd.Wait()
+	close(exited)
+
+	p.mu.Lock()
+	delete(p.processes, cmd)
+	p.mu.Unlock()
+}
+
+func (p *ExecPlugin) Name() string {
+	return PluginExec
+}
+
+func (p *ExecPlugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for cmd := range p.processes {
+		cmd.Process.Kill()
+	}
+	if p.framed != nil {
+		p.framed.kill()
+	}
+	return nil
+}
+
+// execFramedProcess serves all connections by one process, the process is
+// restarted if it exits.
+type execFramedProcess struct {
+	plugin *ExecPlugin
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	conns  map[uint32]*execFramedConn
+	nextId uint32
+
+	// protects writing to stdin
+	writeMu sync.Mutex
+	mu      sync.Mutex
+}
+
+func (fp *execFramedProcess) start() error {
+	cmd := fp.plugin.newCommand()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("exec start [%s] error: %v", cmd.Path, err)
+	}
+	fp.mu.Lock()
+	fp.cmd, fp.stdin, fp.stdout = cmd, stdin, stdout
+	fp.mu.Unlock()
+	return nil
+}
+
+// run reads frames from the process, and restarts it after it exits.
+func (fp *execFramedProcess) run() {
+	for {
+		err := fp.readFrames()
+		fp.cmd.Wait()
+		frpLog.Warn("exec [%s] exited: %v", fp.plugin.command[0], err)
+
+		fp.mu.Lock()
+		for id, fc := range fp.conns {
+			fc.conn.Close()
+			close(fc.ch)
+			delete(fp.conns, id)
+		}
+		fp.stdin = nil
+		fp.mu.Unlock()
+
+		for {
+			time.Sleep(time.Second)
+			fp.plugin.mu.Lock()
+			closed := fp.plugin.closed
+			fp.plugin.mu.Unlock()
+			if closed {
+				return
+			}
+			if err = fp.start(); err == nil {
+				break
+			}
+			frpLog.Warn("%v", err)
+		}
+	}
+}
+
+func (fp *execFramedProcess) readFrames() error {
+	header := make([]byte, execFrameHeaderSize)
+	payload := make([]byte, execFrameMaxPayloadSize)
+	for {
+		if _, err := io.ReadFull(fp.stdout, header); err != nil {
+			return err
+		}
+		typ := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		length := binary.BigEndian.Uint32(header[5:9])
+		if length > execFrameMaxPayloadSize {
+			fp.kill()
+			return fmt.Errorf("frame payload is too large [%d]", length)
+		}
+		if _, err := io.ReadFull(fp.stdout, payload[:length]); err != nil {
+			return err
+		}
+
+		// frames are never blocked by connections, or a slow connection
+		// would stall all others
+		fp.mu.Lock()
+		if fc, ok := fp.conns[id]; ok {
+			switch typ {
+			case execFrameData:
+				select {
+				case fc.ch <- append([]byte(nil), payload[:length]...):
+				default:
+					// handle sends the close frame to the process
+					frpLog.Debug("exec [%s] connection [%d] is too slow, close it", fp.plugin.command[0], id)
+					fc.conn.Close()
+				}
+			case execFrameClose:
+				// the connection is closed after queued data is written
+				delete(fp.conns, id)
+				close(fc.ch)
+			}
+		}
+		fp.mu.Unlock()
+	}
+}
+
+func (fp *execFramedProcess) writeFrame(typ byte, id uint32, payload []byte) error {
+	buf := bytes.NewBuffer(make([]byte, 0, execFrameHeaderSize+len(payload)))
+	buf.WriteByte(typ)
+	binary.Write(buf, binary.BigEndian, id)
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+
+	fp.mu.Lock()
+	stdin := fp.stdin
+	fp.mu.Unlock()
+	if stdin == nil {
+		return fmt.Errorf("process is not running")
+	}
+	fp.writeMu.Lock()
+	defer fp.writeMu.Unlock()
+	_, err := stdin.Write(buf.Bytes())
+	return err
+}
+
+func (fp *execFramedProcess) handle(conn net.Conn, remoteAddr string, extraBufToLocal []byte) {
+	defer conn.Close()
+
+	fp.mu.Lock()
+	if fp.stdin == nil {
+		fp.mu.Unlock()
+		return
+	}
+	fp.nextId++
+	id := fp.nextId
+	fc := &execFramedConn{
+		conn: conn,
+		ch:   make(chan []byte, execFrameQueueSize),
+	}
+	fp.conns[id] = fc
+	fp.mu.Unlock()
+	go fc.writer()
+
+	closed := false
+	if err := fp.writeFrame(execFrameOpen, id, []byte(remoteAddr)); err != nil {
+		closed = true
+	}
+	if !closed && len(extraBufToLocal) > 0 {
+		closed = fp.writeFrame(execFrameData, id, extraBufToLocal) != nil
+	}
+	buf := make([]byte, execFrameMaxPayloadSize)
+	for !closed {
+		n, err := conn.Read(buf)
+		if n > 0 && fp.writeFrame(execFrameData, id, buf[:n]) != nil {
+			break
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	fp.mu.Lock()
+	_, ok := fp.conns[id]
+	if ok {
+		delete(fp.conns, id)
+		close(fc.ch)
+	}
+	fp.mu.Unlock()
+	// the process is not notified if it has closed the connection
+	if ok {
+		fp.writeFrame(execFrameClose, id, nil)
+	}
+}
+
+func (fp *execFramedProcess) kill() {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if fp.cmd != nil && fp.cmd.Process != nil {
+		fp.cmd.Process.Kill()
+	}
+}
+
+// execFramedConn is a connection of framed mode. Data frames are queued in ch
+// and written by writer, ch is closed when the connection is removed from
+// execFramedProcess.
+type execFramedConn struct {
+	conn net.Conn
+	ch   chan []byte
+}
+
+func (fc *execFramedConn) writer() {
+	for buf := range fc.ch {
+		if _, err := fc.conn.Write(buf); err != nil {
+			fc.conn.Close()
+		}
+	}
+	fc.conn.Close()
+}
+
+// splitCommandLine splits s into arguments like a shell without expansions.
+// Arguments can be quoted by single or double quotes, and backslash escapes
+// spaces, quotes and backslash outside of single quotes.
+func splitCommandLine(s string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range s {
+		switch {
+		case escaped:
+			// backslash is kept if it doesn't escape anything, so windows
+			// paths can be used
+			escapable := " \t\n'\"\\"
+			if quote == '"' {
+				escapable = "\"\\$`"
+			}
+			if !strings.ContainsRune(escapable, c) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// execLogWriter writes stderr of processes to logs line by line.
+type execLogWriter struct {
+	name string
+	buf  []byte
+	mu   sync.Mutex
+}
+
+func (w *execLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		frpLog.Warn("exec [%s] stderr: %s", w.name, strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) > 4096 {
+		frpLog.Warn("exec [%s] stderr: %s", w.name, w.buf)
+		w.buf = w.buf[:0]
+	}
+	return len(p), nil
+}
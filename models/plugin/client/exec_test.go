@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExecHelperProcess isn't a real test, it's the process of framed mode
+// started by tests. It echoes data frames, and handles some commands.
+func TestExecHelperProcess(t *testing.T) {
+	if os.Getenv("FRP_EXEC_HELPER") != "framed" {
+		return
+	}
+	defer os.Exit(0)
+
+	writeFrame := func(typ byte, id uint32, payload []byte) {
+		buf := bytes.NewBuffer(nil)
+		buf.WriteByte(typ)
+		binary.Write(buf, binary.BigEndian, id)
+		binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+		buf.Write(payload)
+		os.Stdout.Write(buf.Bytes())
+	}
+	header := make([]byte, execFrameHeaderSize)
+	for {
+		if _, err := io.ReadFull(os.Stdin, header); err != nil {
+			return
+		}
+		id := binary.BigEndian.Uint32(header[1:5])
+		payload := make([]byte, binary.BigEndian.Uint32(header[5:9]))
+		if _, err := io.ReadFull(os.Stdin, payload); err != nil {
+			return
+		}
+		switch header[0] {
+		case execFrameOpen:
+			writeFrame(execFrameData, id, []byte("open "+string(payload)+"\n"))
+		case execFrameData:
+			switch string(payload) {
+			case "flood":
+				data := bytes.Repeat([]byte("x"), execFrameMaxPayloadSize)
+				for i := 0; i < execFrameQueueSize*2; i++ {
+					writeFrame(execFrameData, id, data)
+				}
+			case "bye":
+				writeFrame(execFrameData, id, []byte("bye"))
+				writeFrame(execFrameClose, id, nil)
+			case "exit":
+				return
+			default:
+				writeFrame(execFrameData, id, payload)
+			}
+		}
+	}
+}
+
+// newExecTestConn returns a connection handled by p, and the connection of
+// the other side.
+func newExecTestConn(t *testing.T, p Plugin, extraBufToLocal []byte) *net.TCPConn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Handle(sc, sc, extraBufToLocal)
+	return c.(*net.TCPConn)
+}
+
+func readExecTestConn(c net.Conn, n int) string {
+	buf := make([]byte, n)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _ = io.ReadFull(c, buf)
+	return string(buf[:n])
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		s    string
+		args []string
+	}{
+		{"", nil},
+		{"  cmd  a\tb ", []string{"cmd", "a", "b"}},
+		{`cmd "a b" 'c "d"' e\ f`, []string{"cmd", "a b", `c "d"`, "e f"}},
+		{`cmd "a \"b\" \$c \d" 'e\f'`, []string{"cmd", `a "b" $c \d`, `e\f`}},
+		{`cmd "" ''`, []string{"cmd", "", ""}},
+		{`cmd a"b c"'d'`, []string{"cmd", "ab cd"}},
+		{`C:\tools\bridge.exe --port COM1`, []string{`C:\tools\bridge.exe`, "--port", "COM1"}},
+	}
+	for _, test := range tests {
+		args, err := splitCommandLine(test.s)
+		assert.NoError(err, test.s)
+		assert.Equal(test.args, args, test.s)
+	}
+
+	for _, s := range []string{`cmd "a`, `cmd 'a`, `cmd a\`} {
+		_, err := splitCommandLine(s)
+		assert.Error(err, s)
+	}
+}
+
+func TestExecInetd(t *testing.T) {
+	assert := assert.New(t)
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run by /bin/sh")
+	}
+
+	p, err := NewExecPlugin(map[string]string{
+		"plugin_command":       `/bin/sh -c 'echo "$1|$FOO|$FRP_REMOTE_ADDR"; cat' sh "a  b"`,
+		"plugin_env_FOO":       "bar",
+		"plugin_max_processes": "1",
+	})
+	assert.NoError(err)
+	defer p.Close()
+
+	c := newExecTestConn(t, p, []byte("extra "))
+	line := readExecTestConn(c, len("a  b|bar|"))
+	assert.Equal("a  b|bar|", line)
+	c.Write([]byte("data"))
+	c.CloseWrite()
+	buf, _ := ioutil.ReadAll(c)
+	assert.True(strings.HasSuffix(string(buf), "\nextra data"), string(buf))
+	c.Close()
+
+	// the second process is refused while the first one is running
+	c1 := newExecTestConn(t, p, nil)
+	defer c1.Close()
+	assert.Equal("a  b|bar|", readExecTestConn(c1, len("a  b|bar|")))
+	c2 := newExecTestConn(t, p, nil)
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c2.Read(make([]byte, 1))
+	assert.Equal(io.EOF, err)
+}
+
+func TestExecInetdExitTimeout(t *testing.T) {
+	assert := assert.New(t)
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run by /bin/sh")
+	}
+
+	// output after stdin is closed is sent, the process isn't killed
+	p, err := NewExecPlugin(map[string]string{
+		"plugin_command": `/bin/sh -c 'cat; sleep 1; echo done'`,
+	})
+	assert.NoError(err)
+	defer p.Close()
+	c := newExecTestConn(t, p, nil)
+	c.Write([]byte("data "))
+	c.CloseWrite()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf, _ := ioutil.ReadAll(c)
+	assert.Equal("data done\n", string(buf))
+	c.Close()
+
+	// killed after plugin_exit_timeout
+	p2, err := NewExecPlugin(map[string]string{
+		"plugin_command":      `/bin/sh -c 'cat; sleep 3; echo done'`,
+		"plugin_exit_timeout": "1",
+	})
+	assert.NoError(err)
+	defer p2.Close()
+	c = newExecTestConn(t, p2, nil)
+	c.Write([]byte("data "))
+	c.CloseWrite()
+	start := time.Now()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf, _ = ioutil.ReadAll(c)
+	assert.Equal("data ", string(buf))
+	assert.True(time.Since(start) < 2500*time.Millisecond)
+	c.Close()
+
+	_, err = NewExecPlugin(map[string]string{
+		"plugin_command":      "cat",
+		"plugin_exit_timeout": "-1",
+	})
+	assert.Error(err)
+}
+
+func TestExecFramed(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := NewExecPlugin(map[string]string{
+		"plugin_command":             `'` + os.Args[0] + `' -test.run=^TestExecHelperProcess$`,
+		"plugin_exec_mode":           "framed",
+		"plugin_env_FRP_EXEC_HELPER": "framed",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer p.Close()
+
+	// open frames have the remote address, data is echoed for each connection
+	c1 := newExecTestConn(t, p, []byte("extra"))
+	defer c1.Close()
+	c2 := newExecTestConn(t, p, nil)
+	defer c2.Close()
+	open1 := "open " + c1.LocalAddr().String() + "\n"
+	open2 := "open " + c2.LocalAddr().String() + "\n"
+	assert.Equal(open1+"extra", readExecTestConn(c1, len(open1)+len("extra")))
+	assert.Equal(open2, readExecTestConn(c2, len(open2)))
+	c2.Write([]byte("hello2"))
+	c1.Write([]byte("hello1"))
+	assert.Equal("hello1", readExecTestConn(c1, len("hello1")))
+	assert.Equal("hello2", readExecTestConn(c2, len("hello2")))
+
+	// the process closes a connection
+	c1.Write([]byte("bye"))
+	c1.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf, _ := ioutil.ReadAll(c1)
+	assert.Equal("bye", string(buf))
+
+	// a connection not reading data doesn't block others
+	slowPlugin, slow := net.Pipe()
+	defer slow.Close()
+	go p.Handle(slowPlugin, slowPlugin, nil)
+	slow.Write([]byte("flood"))
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	c2.Write([]byte("ping"))
+	assert.Equal("ping", readExecTestConn(c2, len("ping")))
+	assert.True(time.Since(start) < 2*time.Second)
+
+	// the process is restarted after it exits
+	c2.Write([]byte("exit"))
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	ioutil.ReadAll(c2)
+	var c3 *net.TCPConn
+	for i := 0; i < 30; i++ {
+		time.Sleep(100 * time.Millisecond)
+		c3 = newExecTestConn(t, p, nil)
+		open3 := "open " + c3.LocalAddr().String() + "\n"
+		if readExecTestConn(c3, len(open3)) == open3 {
+			break
+		}
+		c3.Close()
+		c3 = nil
+	}
+	if assert.NotNil(c3) {
+		c3.Write([]byte("again"))
+		assert.Equal("again", readExecTestConn(c3, len("again")))
+		c3.Close()
+	}
+}
@@ -16,12 +16,16 @@ package plugin
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	frpLog "github.com/fatedier/frp/utils/log"
 	frpNet "github.com/fatedier/frp/utils/net"
 
 	frpIo "github.com/fatedier/golib/io"
@@ -35,21 +39,41 @@ func init() {
 }
 
 type HttpProxy struct {
-	l          *Listener
-	s          *http.Server
-	AuthUser   string
-	AuthPasswd string
+	l         *Listener
+	s         *http.Server
+	creds     *credentials
+	acl       *destinationACL
+	transport *http.Transport
 }
 
 func NewHttpProxyPlugin(params map[string]string) (Plugin, error) {
-	user := params["plugin_http_user"]
-	passwd := params["plugin_http_passwd"]
+	acl, err := newDestinationACL(params["plugin_allow_destinations"], params["plugin_deny_destinations"])
+	if err != nil {
+		return nil, err
+	}
 	listener := NewProxyListener()
 
 	hp := &HttpProxy{
-		l:          listener,
-		AuthUser:   user,
-		AuthPasswd: passwd,
+		l: listener,
+		creds: &credentials{
+			user:   params["plugin_http_user"],
+			passwd: params["plugin_http_passwd"],
+			file:   params["plugin_credentials_file"],
+		},
+		acl: acl,
+	}
+	hp.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := splitHostPort(addr, 80)
+			if err != nil {
+				return nil, err
+			}
+			return acl.dial(host, port)
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
 	}
 
 	hp.s = &http.Server{
@@ -64,6 +88,11 @@ func (hp *HttpProxy) Name() string {
 	return PluginHttpProxy
 }
 
+// NeedUserAddr is true so requests are logged with addresses of users.
+func (hp *HttpProxy) NeedUserAddr() bool {
+	return true
+}
+
 func (hp *HttpProxy) Handle(conn io.ReadWriteCloser, realConn net.Conn, extraBufToLocal []byte) {
 	wrapConn := frpNet.WrapReadWriteCloserToConn(conn, realConn)
 
@@ -82,7 +111,7 @@ func (hp *HttpProxy) Handle(conn io.ReadWriteCloser, realConn net.Conn, extraBuf
 			wrapConn.Close()
 			return
 		}
-		hp.handleConnectReq(request, frpIo.WrapReadWriteCloser(bufRd, wrapConn, wrapConn.Close))
+		hp.handleConnectReq(request, frpIo.WrapReadWriteCloser(bufRd, wrapConn, wrapConn.Close), wrapConn.RemoteAddr())
 		return
 	}
 
@@ -97,7 +126,7 @@ func (hp *HttpProxy) Close() error {
 }
 
 func (hp *HttpProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	if ok := hp.Auth(req); !ok {
+	if _, ok := hp.authenticate(req); !ok {
 		rw.Header().Set("Proxy-Authenticate", "Basic")
 		rw.WriteHeader(http.StatusProxyAuthRequired)
 		return
@@ -113,10 +142,27 @@ func (hp *HttpProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (hp *HttpProxy) HttpHandler(rw http.ResponseWriter, req *http.Request) {
+	user, _ := hp.authenticate(req)
 	removeProxyHeaders(req)
 
-	resp, err := http.DefaultTransport.RoundTrip(req)
+	host, port, err := splitHostPort(req.URL.Host, 80)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err = hp.acl.resolve(host, port); err != nil {
+		frpLog.Info("http_proxy user [%s] from [%s] request [%s %s] error: %v", user, req.RemoteAddr, req.Method, req.URL, err)
+		if err == errDestinationDenied {
+			http.Error(rw, err.Error(), http.StatusForbidden)
+		} else {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	resp, err := hp.transport.RoundTrip(req)
 	if err != nil {
+		frpLog.Info("http_proxy user [%s] from [%s] request [%s %s] error: %v", user, req.RemoteAddr, req.Method, req.URL, err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -125,10 +171,9 @@ func (hp *HttpProxy) HttpHandler(rw http.ResponseWriter, req *http.Request) {
 	copyHeaders(rw.Header(), resp.Header)
 	rw.WriteHeader(resp.StatusCode)
 
-	_, err = io.Copy(rw, resp.Body)
-	if err != nil && err != io.EOF {
-		return
-	}
+	received, _ := io.Copy(rw, resp.Body)
+	frpLog.Info("http_proxy user [%s] from [%s] request [%s %s] status [%d], received [%d] bytes",
+		user, req.RemoteAddr, req.Method, req.URL, resp.StatusCode, received)
 }
 
 // deprecated
@@ -147,7 +192,13 @@ func (hp *HttpProxy) ConnectHandler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	remote, err := net.Dial("tcp", req.URL.Host)
+	host, port, err := splitHostPort(req.URL.Host, 443)
+	if err != nil {
+		http.Error(rw, "Failed", http.StatusBadRequest)
+		client.Close()
+		return
+	}
+	remote, err := hp.acl.dial(host, port)
 	if err != nil {
 		http.Error(rw, "Failed", http.StatusBadRequest)
 		client.Close()
@@ -159,43 +210,60 @@ func (hp *HttpProxy) ConnectHandler(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (hp *HttpProxy) Auth(req *http.Request) bool {
-	if hp.AuthUser == "" && hp.AuthPasswd == "" {
-		return true
+	_, ok := hp.authenticate(req)
+	return ok
+}
+
+// authenticate returns the user of req, it's "-" if no authentication is
+// required.
+func (hp *HttpProxy) authenticate(req *http.Request) (string, bool) {
+	if !hp.creds.required() {
+		return "-", true
 	}
 
 	s := strings.SplitN(req.Header.Get("Proxy-Authorization"), " ", 2)
 	if len(s) != 2 {
-		return false
+		return "", false
 	}
 
 	b, err := base64.StdEncoding.DecodeString(s[1])
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	pair := strings.SplitN(string(b), ":", 2)
 	if len(pair) != 2 {
-		return false
+		return "", false
 	}
 
-	if pair[0] != hp.AuthUser || pair[1] != hp.AuthPasswd {
-		return false
+	if !hp.creds.valid(pair[0], pair[1]) {
+		return "", false
 	}
-	return true
+	return pair[0], true
 }
 
-func (hp *HttpProxy) handleConnectReq(req *http.Request, rwc io.ReadWriteCloser) {
+func (hp *HttpProxy) handleConnectReq(req *http.Request, rwc io.ReadWriteCloser, remoteAddr net.Addr) {
 	defer rwc.Close()
-	if ok := hp.Auth(req); !ok {
+	user, ok := hp.authenticate(req)
+	if !ok {
 		res := getBadResponse()
 		res.Write(rwc)
 		return
 	}
 
-	remote, err := net.Dial("tcp", req.URL.Host)
+	statusCode := 400
+	host, port, err := splitHostPort(req.URL.Host, 443)
+	var remote net.Conn
+	if err == nil {
+		remote, err = hp.acl.dial(host, port)
+		if err == errDestinationDenied {
+			statusCode = 403
+		}
+	}
 	if err != nil {
+		frpLog.Info("http_proxy user [%s] from [%s] connect to [%s] error: %v", user, remoteAddr, req.URL.Host, err)
 		res := &http.Response{
-			StatusCode: 400,
+			StatusCode: statusCode,
 			Proto:      "HTTP/1.1",
 			ProtoMajor: 1,
 			ProtoMinor: 1,
@@ -205,7 +273,23 @@ func (hp *HttpProxy) handleConnectReq(req *http.Request, rwc io.ReadWriteCloser)
 	}
 	rwc.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
 
-	frpIo.Join(remote, rwc)
+	start := time.Now()
+	sent, received := frpIo.Join(remote, rwc)
+	frpLog.Info("http_proxy user [%s] from [%s] connection to [%s] closed, sent [%d] bytes, received [%d] bytes in %v",
+		user, remoteAddr, req.URL.Host, sent, received, time.Since(start))
+}
+
+// splitHostPort uses defaultPort if addr has no port.
+func splitHostPort(addr string, defaultPort int) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.Trim(addr, "[]"), defaultPort, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, port, nil
 }
 
 func copyHeaders(dst, src http.Header) {
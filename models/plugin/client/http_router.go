@@ -0,0 +1,309 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	frpLog "github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
+)
+
+const PluginHttpRouter = "http_router"
+
+func init() {
+	Register(PluginHttpRouter, NewHttpRouterPlugin)
+}
+
+// httpRoute forwards requests whose paths start with prefix to upstream.
+type httpRoute struct {
+	name   string
+	prefix string
+	// http://host:port, https://host:port or unix:/path/to/socket
+	upstream           string
+	hostHeaderRewrite  string
+	headers            map[string]string
+	stripPrefix        bool
+	healthCheckPath    string
+	insecureSkipVerify bool
+
+	scheme    string
+	host      string
+	transport *http.Transport
+	proxy     *httputil.ReverseProxy
+	healthy   bool
+	mu        sync.RWMutex
+}
+
+func (route *httpRoute) match(path string) bool {
+	if strings.HasSuffix(route.prefix, "/") {
+		return strings.HasPrefix(path, route.prefix)
+	}
+	return path == route.prefix || strings.HasPrefix(path, route.prefix+"/")
+}
+
+func (route *httpRoute) isHealthy() bool {
+	route.mu.RLock()
+	defer route.mu.RUnlock()
+	return route.healthy
+}
+
+func (route *httpRoute) setHealthy(healthy bool) {
+	route.mu.Lock()
+	changed := route.healthy != healthy
+	route.healthy = healthy
+	route.mu.Unlock()
+	if changed {
+		if healthy {
+			frpLog.Info("http_router upstream [%s] of route [%s] is healthy", route.upstream, route.name)
+		} else {
+			frpLog.Warn("http_router upstream [%s] of route [%s] is unhealthy", route.upstream, route.name)
+		}
+	}
+}
+
+// HttpRouterPlugin forwards http requests to local upstreams by path
+// prefixes, the longest prefix is matched first.
+type HttpRouterPlugin struct {
+	routes              []*httpRoute
+	healthCheckInterval time.Duration
+
+	l      *Listener
+	s      *http.Server
+	closed chan struct{}
+}
+
+// NewHttpRouterPlugin parses routes from params:
+//
+//	plugin_route_<name> = <path prefix> <upstream>
+//	plugin_host_header_rewrite_<name> = <host>
+//	plugin_header_<name>_<header> = <value>
+//	plugin_strip_prefix_<name> = true
+//	plugin_health_check_<name> = <path>
+//	plugin_insecure_skip_verify = true
+func NewHttpRouterPlugin(params map[string]string) (Plugin, error) {
+	p := &HttpRouterPlugin{
+		healthCheckInterval: 10 * time.Second,
+		closed:              make(chan struct{}),
+	}
+	if v := params["plugin_health_check_interval"]; v != "" {
+		interval, err := strconv.Atoi(v)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid plugin_health_check_interval [%s]", v)
+		}
+		p.healthCheckInterval = time.Duration(interval) * time.Second
+	}
+
+	routes := make(map[string]*httpRoute)
+	prefixes := make(map[string]string)
+	for k, v := range params {
+		if !strings.HasPrefix(k, "plugin_route_") {
+			continue
+		}
+		name := strings.TrimPrefix(k, "plugin_route_")
+		if name == "" || strings.Contains(name, "_") {
+			return nil, fmt.Errorf("invalid route name [%s], it can't be empty or contain '_'", name)
+		}
+		fields := strings.Fields(v)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "/") {
+			return nil, fmt.Errorf("%s should be in \"<path prefix> <upstream>\" format", k)
+		}
+		if other, ok := prefixes[fields[0]]; ok {
+			return nil, fmt.Errorf("routes [%s] and [%s] have the same path prefix [%s]", other, name, fields[0])
+		}
+		prefixes[fields[0]] = name
+		route := &httpRoute{
+			name:               name,
+			prefix:             fields[0],
+			upstream:           fields[1],
+			hostHeaderRewrite:  params["plugin_host_header_rewrite_"+name],
+			headers:            make(map[string]string),
+			stripPrefix:        params["plugin_strip_prefix_"+name] == "true",
+			healthCheckPath:    params["plugin_health_check_"+name],
+			insecureSkipVerify: params["plugin_insecure_skip_verify"] == "true",
+			healthy:            true,
+		}
+		if err := route.initProxy(); err != nil {
+			return nil, err
+		}
+		routes[name] = route
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("plugin_route_<name> is required")
+	}
+
+	for k, v := range params {
+		if !strings.HasPrefix(k, "plugin_header_") {
+			continue
+		}
+		arr := strings.SplitN(strings.TrimPrefix(k, "plugin_header_"), "_", 2)
+		route, ok := routes[arr[0]]
+		if !ok || len(arr) != 2 || arr[1] == "" {
+			return nil, fmt.Errorf("%s doesn't belong to any route", k)
+		}
+		route.headers[arr[1]] = v
+	}
+
+	for _, route := range routes {
+		p.routes = append(p.routes, route)
+	}
+	// prefixes are unique, so the order doesn't depend on the map iteration
+	sort.SliceStable(p.routes, func(i, j int) bool {
+		if len(p.routes[i].prefix) != len(p.routes[j].prefix) {
+			return len(p.routes[i].prefix) > len(p.routes[j].prefix)
+		}
+		return p.routes[i].prefix < p.routes[j].prefix
+	})
+
+	p.l = NewProxyListener()
+	p.s = &http.Server{
+		Handler: p,
+	}
+	go p.s.Serve(p.l)
+	go p.checkHealth()
+	return p, nil
+}
+
+func (route *httpRoute) initProxy() error {
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: route.insecureSkipVerify},
+	}
+	var scheme, host string
+	switch {
+	case strings.HasPrefix(route.upstream, "http://"):
+		scheme, host = "http", strings.TrimPrefix(route.upstream, "http://")
+	case strings.HasPrefix(route.upstream, "https://"):
+		scheme, host = "https", strings.TrimPrefix(route.upstream, "https://")
+	case strings.HasPrefix(route.upstream, "unix:"):
+		unixPath := strings.TrimPrefix(route.upstream, "unix:")
+		scheme, host = "http", "unix"
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", unixPath)
+		}
+	}
+	if host == "" || strings.Contains(host, "/") {
+		return fmt.Errorf("invalid upstream [%s] of route [%s]", route.upstream, route.name)
+	}
+	route.scheme, route.host, route.transport = scheme, host, tr
+
+	route.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = scheme
+			req.URL.Host = host
+			if route.stripPrefix {
+				req.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(route.prefix, "/")), "/")
+				req.URL.RawPath = ""
+				req.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(route.prefix, "/"))
+			}
+			if route.hostHeaderRewrite != "" {
+				req.Host = route.hostHeaderRewrite
+			}
+			for k, v := range route.headers {
+				req.Header.Set(k, v)
+			}
+		},
+		Transport: tr,
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			frpLog.Warn("http_router route [%s] request [%s] error: %v", route.name, req.URL.Path, err)
+			rw.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return nil
+}
+
+func (p *HttpRouterPlugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	for _, route := range p.routes {
+		if !route.match(req.URL.Path) {
+			continue
+		}
+		if !route.isHealthy() {
+			http.Error(rw, "upstream is unhealthy", http.StatusServiceUnavailable)
+			return
+		}
+		route.proxy.ServeHTTP(rw, req)
+		return
+	}
+	http.NotFound(rw, req)
+}
+
+// checkHealth sends requests to health check paths of routes periodically,
+// responses with 2xx or 3xx status mean the upstreams are healthy.
+func (p *HttpRouterPlugin) checkHealth() {
+	ticker := time.NewTicker(p.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, route := range p.routes {
+			if route.healthCheckPath != "" {
+				route.setHealthy(p.isUpstreamHealthy(route))
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+func (p *HttpRouterPlugin) isUpstreamHealthy(route *httpRoute) bool {
+	req, err := http.NewRequest("GET", route.scheme+"://"+route.host+route.healthCheckPath, nil)
+	if err != nil {
+		return false
+	}
+	if route.hostHeaderRewrite != "" {
+		req.Host = route.hostHeaderRewrite
+	}
+	client := &http.Client{
+		Transport: route.transport,
+		Timeout:   3 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+func (p *HttpRouterPlugin) Handle(conn io.ReadWriteCloser, realConn net.Conn, extraBufToLocal []byte) {
+	wrapConn := frpNet.WrapReadWriteCloserToConn(conn, realConn)
+	p.l.PutConn(wrapConn)
+}
+
+func (p *HttpRouterPlugin) Name() string {
+	return PluginHttpRouter
+}
+
+func (p *HttpRouterPlugin) Close() error {
+	close(p.closed)
+	return p.s.Close()
+}
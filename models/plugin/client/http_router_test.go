@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startHttpRouterTest serves the http_router plugin on a local listener.
+func startHttpRouterTest(t *testing.T, params map[string]string) (addr string, closeFn func()) {
+	p, err := NewHttpRouterPlugin(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.Handle(c, c, nil)
+		}
+	}()
+	return l.Addr().String(), func() {
+		l.Close()
+		p.Close()
+	}
+}
+
+// newHttpRouterUpstream returns a server which responds with its name, the
+// path and some headers of requests.
+func newHttpRouterUpstream(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(rw, "%s %s %s %s %s", name, req.URL.Path, req.Host,
+			req.Header.Get("X-Forwarded-Prefix"), req.Header.Get("X-From-Where"))
+	}))
+}
+
+func getHttpRouterTest(addr string, path string) (int, string, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(buf), err
+}
+
+func TestHttpRouterRouting(t *testing.T) {
+	assert := assert.New(t)
+
+	api, apiV2, web := newHttpRouterUpstream("api"), newHttpRouterUpstream("apiv2"), newHttpRouterUpstream("web")
+	defer api.Close()
+	defer apiV2.Close()
+	defer web.Close()
+
+	addr, closeFn := startHttpRouterTest(t, map[string]string{
+		"plugin_route_api":               "/api " + api.URL,
+		"plugin_route_apiv2":             "/api/v2/ " + apiV2.URL,
+		"plugin_route_web":               "/ " + web.URL,
+		"plugin_strip_prefix_api":        "true",
+		"plugin_strip_prefix_apiv2":      "true",
+		"plugin_host_header_rewrite_api": "example.com",
+		"plugin_header_web_X-From-Where": "frp",
+		"plugin_health_check_interval":   "10",
+	})
+	defer closeFn()
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		// the longest prefix is matched first, prefixes are stripped, the host
+		// is kept unless it is rewritten
+		{"/api/v2/users", "apiv2 /users " + addr + " /api/v2 "},
+		{"/api/v2", "api /v2 example.com /api "},
+		{"/api/users", "api /users example.com /api "},
+		{"/api", "api / example.com /api "},
+		// prefixes without '/' suffix only match whole path segments
+		{"/apix", "web /apix " + addr + "  frp"},
+		{"/", "web / " + addr + "  frp"},
+	}
+	for _, test := range tests {
+		code, body, err := getHttpRouterTest(addr, test.path)
+		if assert.NoError(err, test.path) {
+			assert.Equal(http.StatusOK, code, test.path)
+			assert.Equal(test.body, body, test.path)
+		}
+	}
+
+	// no route matches
+	addr2, closeFn2 := startHttpRouterTest(t, map[string]string{
+		"plugin_route_api": "/api " + api.URL,
+	})
+	defer closeFn2()
+	code, _, err := getHttpRouterTest(addr2, "/web")
+	assert.NoError(err)
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestHttpRouterHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	healthy := make(chan bool, 1)
+	healthy <- false
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/healthz" {
+			ok := <-healthy
+			healthy <- ok
+			if !ok {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		fmt.Fprint(rw, "ok")
+	}))
+	defer upstream.Close()
+
+	addr, closeFn := startHttpRouterTest(t, map[string]string{
+		"plugin_route_api":             "/ " + upstream.URL,
+		"plugin_health_check_api":      "/healthz",
+		"plugin_health_check_interval": "1",
+	})
+	defer closeFn()
+
+	var code int
+	for i := 0; i < 30; i++ {
+		code, _, _ = getHttpRouterTest(addr, "/")
+		if code == http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(http.StatusServiceUnavailable, code)
+
+	<-healthy
+	healthy <- true
+	for i := 0; i < 30; i++ {
+		code, _, _ = getHttpRouterTest(addr, "/")
+		if code == http.StatusOK {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(http.StatusOK, code)
+}
+
+func TestHttpRouterTLSVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, "ok")
+	}))
+	defer upstream.Close()
+
+	// certificates of https upstreams are verified by default
+	addr, closeFn := startHttpRouterTest(t, map[string]string{
+		"plugin_route_api": "/ " + upstream.URL,
+	})
+	defer closeFn()
+	code, _, err := getHttpRouterTest(addr, "/")
+	assert.NoError(err)
+	assert.Equal(http.StatusBadGateway, code)
+
+	addr2, closeFn2 := startHttpRouterTest(t, map[string]string{
+		"plugin_route_api":            "/ " + upstream.URL,
+		"plugin_insecure_skip_verify": "true",
+	})
+	defer closeFn2()
+	code, body, err := getHttpRouterTest(addr2, "/")
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("ok", body)
+}
+
+func TestHttpRouterParams(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, params := range []map[string]string{
+		{},
+		{"plugin_route_a_b": "/ http://127.0.0.1:80"},
+		{"plugin_route_api": "api http://127.0.0.1:80"},
+		{"plugin_route_api": "/api"},
+		{"plugin_route_api": "/api ftp://127.0.0.1:80"},
+		{"plugin_route_api": "/api http://127.0.0.1:80", "plugin_header_web_X-A": "a"},
+		{"plugin_route_api": "/api http://127.0.0.1:80", "plugin_health_check_interval": "0"},
+		// duplicate prefixes
+		{"plugin_route_api": "/api http://127.0.0.1:80", "plugin_route_api2": "/api http://127.0.0.1:81"},
+	} {
+		_, err := NewHttpRouterPlugin(params)
+		assert.Error(err, "%v", params)
+	}
+
+	// routes with prefixes of the same length are sorted by prefixes
+	p, err := NewHttpRouterPlugin(map[string]string{
+		"plugin_route_b":   "/b http://127.0.0.1:80",
+		"plugin_route_a":   "/a http://127.0.0.1:80",
+		"plugin_route_all": "/ http://127.0.0.1:80",
+		"plugin_route_api": "/api http://127.0.0.1:80",
+	})
+	if assert.NoError(err) {
+		defer p.Close()
+		var prefixes []string
+		for _, route := range p.(*HttpRouterPlugin).routes {
+			prefixes = append(prefixes, route.prefix)
+		}
+		assert.Equal([]string{"/api", "/a", "/b", "/"}, prefixes)
+	}
+}
@@ -50,6 +50,15 @@ type Plugin interface {
 	Close() error
 }
 
+// UserAddrPlugin is implemented by plugins which check addresses of users,
+// the remote address of realConn passed to Handle is the address of the user
+// instead of frps for them.
+type UserAddrPlugin interface {
+	Plugin
+
+	NeedUserAddr() bool
+}
+
 type Listener struct {
 	conns  chan net.Conn
 	closed bool
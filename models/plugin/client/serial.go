@@ -0,0 +1,223 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	frpLog "github.com/fatedier/frp/utils/log"
+
+	"github.com/creack/pty"
+	frpIo "github.com/fatedier/golib/io"
+)
+
+const PluginSerial = "serial"
+
+func init() {
+	Register(PluginSerial, NewSerialPlugin)
+}
+
+type serialConfig struct {
+	baudRate int
+	dataBits int
+	// "none", "odd" or "even"
+	parity   string
+	stopBits int
+}
+
+// SerialPlugin bridges work connections to a serial device. Only one
+// connection can use the device at the same time, others are closed.
+type SerialPlugin struct {
+	device string
+	cfg    serialConfig
+
+	// in pty mode, a pseudo-terminal is created instead of opening device
+	ptyMaster *os.File
+	ptySlave  *os.File
+	ptyLink   string
+	// connection which receives output of the pty
+	ptyConn io.ReadWriteCloser
+
+	busy bool
+	mu   sync.Mutex
+}
+
+func NewSerialPlugin(params map[string]string) (Plugin, error) {
+	sp := &SerialPlugin{
+		device: params["plugin_serial_device"],
+		cfg: serialConfig{
+			baudRate: 9600,
+			dataBits: 8,
+			parity:   "none",
+			stopBits: 1,
+		},
+		ptyLink: params["plugin_pty_link"],
+	}
+
+	var err error
+	if v := params["plugin_baud_rate"]; v != "" {
+		if sp.cfg.baudRate, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid plugin_baud_rate [%s]", v)
+		}
+	}
+	if v := params["plugin_data_bits"]; v != "" {
+		if sp.cfg.dataBits, err = strconv.Atoi(v); err != nil || sp.cfg.dataBits < 5 || sp.cfg.dataBits > 8 {
+			return nil, fmt.Errorf("plugin_data_bits should be between 5 and 8")
+		}
+	}
+	if v := params["plugin_parity"]; v != "" {
+		if v != "none" && v != "odd" && v != "even" {
+			return nil, fmt.Errorf("plugin_parity should be none, odd or even")
+		}
+		sp.cfg.parity = v
+	}
+	if v := params["plugin_stop_bits"]; v != "" {
+		if v != "1" && v != "2" {
+			return nil, fmt.Errorf("plugin_stop_bits should be 1 or 2")
+		}
+		sp.cfg.stopBits, _ = strconv.Atoi(v)
+	}
+	if err = checkSerialConfig(sp.cfg); err != nil {
+		return nil, err
+	}
+
+	if params["plugin_pty"] == "true" {
+		if err = sp.openPty(); err != nil {
+			return nil, err
+		}
+		return sp, nil
+	}
+	if sp.device == "" {
+		return nil, fmt.Errorf("plugin_serial_device not found")
+	}
+	return sp, nil
+}
+
+// openPty creates the pseudo-terminal, the slave side is kept open so reading
+// from the master doesn't fail when test programs close it.
+func (sp *SerialPlugin) openPty() error {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return fmt.Errorf("serial open pty error: %v", err)
+	}
+	if err = configureSerial(int(slave.Fd()), sp.cfg); err != nil {
+		master.Close()
+		slave.Close()
+		return err
+	}
+	sp.ptyMaster, sp.ptySlave = master, slave
+	sp.device = slave.Name()
+
+	if sp.ptyLink != "" {
+		os.Remove(sp.ptyLink)
+		if err = os.Symlink(sp.device, sp.ptyLink); err != nil {
+			sp.Close()
+			return fmt.Errorf("serial link pty [%s] to [%s] error: %v", sp.device, sp.ptyLink, err)
+		}
+	}
+	frpLog.Info("serial pty [%s] is created", sp.device)
+	go sp.readPty()
+	return nil
+}
+
+// readPty sends output of the pty to the current connection, it's dropped if
+// there is no connection like a serial line without listeners.
+func (sp *SerialPlugin) readPty() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := sp.ptyMaster.Read(buf)
+		if err != nil {
+			return
+		}
+		sp.mu.Lock()
+		conn := sp.ptyConn
+		sp.mu.Unlock()
+		if conn != nil {
+			conn.Write(buf[:n])
+		}
+	}
+}
+
+func (sp *SerialPlugin) Handle(conn io.ReadWriteCloser, realConn net.Conn, extraBufToLocal []byte) {
+	defer conn.Close()
+
+	sp.mu.Lock()
+	if sp.busy {
+		sp.mu.Unlock()
+		frpLog.Warn("serial device [%s] is in use by another connection", sp.device)
+		return
+	}
+	sp.busy = true
+	sp.mu.Unlock()
+	defer func() {
+		sp.mu.Lock()
+		sp.busy = false
+		sp.mu.Unlock()
+	}()
+
+	if sp.ptyMaster != nil {
+		sp.bridgePty(conn, extraBufToLocal)
+		return
+	}
+
+	port, err := openSerial(sp.device, sp.cfg)
+	if err != nil {
+		frpLog.Warn("serial open device [%s] error: %v", sp.device, err)
+		return
+	}
+	if len(extraBufToLocal) > 0 {
+		port.Write(extraBufToLocal)
+	}
+	// port is opened in non-blocking mode, so closing it stops reading
+	frpIo.Join(port, conn)
+}
+
+// bridgePty doesn't close the master when conn is closed, the pty is kept
+// for next connections.
+func (sp *SerialPlugin) bridgePty(conn io.ReadWriteCloser, extraBufToLocal []byte) {
+	sp.mu.Lock()
+	sp.ptyConn = conn
+	sp.mu.Unlock()
+	defer func() {
+		sp.mu.Lock()
+		sp.ptyConn = nil
+		sp.mu.Unlock()
+	}()
+
+	if len(extraBufToLocal) > 0 {
+		sp.ptyMaster.Write(extraBufToLocal)
+	}
+	io.Copy(sp.ptyMaster, conn)
+}
+
+func (sp *SerialPlugin) Name() string {
+	return PluginSerial
+}
+
+func (sp *SerialPlugin) Close() error {
+	if sp.ptyMaster != nil {
+		if sp.ptyLink != "" {
+			os.Remove(sp.ptyLink)
+		}
+		sp.ptySlave.Close()
+		return sp.ptyMaster.Close()
+	}
+	return nil
+}
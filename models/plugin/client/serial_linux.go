@@ -0,0 +1,113 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	1200:    unix.B1200,
+	2400:    unix.B2400,
+	4800:    unix.B4800,
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	2000000: unix.B2000000,
+	4000000: unix.B4000000,
+}
+
+func checkSerialConfig(cfg serialConfig) error {
+	if _, ok := baudRates[cfg.baudRate]; !ok {
+		return fmt.Errorf("unsupported baud rate [%d]", cfg.baudRate)
+	}
+	return nil
+}
+
+// openSerial opens device exclusively, it fails if the device is used by
+// other processes.
+func openSerial(device string, cfg serialConfig) (*os.File, error) {
+	fd, err := unix.Open(device, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("device is locked by another process")
+	}
+	if err = unix.IoctlSetInt(fd, unix.TIOCEXCL, 0); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	if err = configureSerial(fd, cfg); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	unix.IoctlSetInt(fd, unix.TCFLSH, unix.TCIOFLUSH)
+	// fd is non-blocking, so reading from the file can be interrupted by Close
+	return os.NewFile(uintptr(fd), device), nil
+}
+
+// configureSerial sets fd to raw mode with the line settings of cfg.
+func configureSerial(fd int, cfg serialConfig) error {
+	baud, ok := baudRates[cfg.baudRate]
+	if !ok {
+		return fmt.Errorf("unsupported baud rate [%d]", cfg.baudRate)
+	}
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CBAUD | unix.CRTSCTS
+	t.Cflag |= unix.CREAD | unix.CLOCAL | baud
+	switch cfg.dataBits {
+	case 5:
+		t.Cflag |= unix.CS5
+	case 6:
+		t.Cflag |= unix.CS6
+	case 7:
+		t.Cflag |= unix.CS7
+	default:
+		t.Cflag |= unix.CS8
+	}
+	switch cfg.parity {
+	case "odd":
+		t.Cflag |= unix.PARENB | unix.PARODD
+	case "even":
+		t.Cflag |= unix.PARENB
+	}
+	if cfg.stopBits == 2 {
+		t.Cflag |= unix.CSTOPB
+	}
+	t.Ispeed = baud
+	t.Ospeed = baud
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/assert"
+)
+
+func readSerialTest(r io.Reader, n int) string {
+	buf := make([]byte, n)
+	done := make(chan int, 1)
+	go func() {
+		n, _ := io.ReadFull(r, buf)
+		done <- n
+	}()
+	select {
+	case n = <-done:
+		return string(buf[:n])
+	case <-time.After(5 * time.Second):
+		return ""
+	}
+}
+
+func TestSerialDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	// the slave side of a pty pair is used as the serial device
+	master, slave, err := pty.Open()
+	if err != nil {
+		t.Skipf("open pty error: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	assert.NoError(configureSerial(int(master.Fd()), serialConfig{baudRate: 9600, dataBits: 8, parity: "none", stopBits: 1}))
+
+	p, err := NewSerialPlugin(map[string]string{
+		"plugin_serial_device": slave.Name(),
+		"plugin_baud_rate":     "115200",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	defer p.Close()
+
+	c1, pc1 := net.Pipe()
+	defer c1.Close()
+	go p.Handle(pc1, nil, []byte("extra "))
+
+	// data is forwarded in both directions
+	assert.Equal("extra ", readSerialTest(master, len("extra ")))
+	go c1.Write([]byte("to device"))
+	assert.Equal("to device", readSerialTest(master, len("to device")))
+	master.Write([]byte("from device"))
+	assert.Equal("from device", readSerialTest(c1, len("from device")))
+
+	// the device can't be opened by other processes while it's in use
+	_, err = openSerial(slave.Name(), serialConfig{baudRate: 9600, dataBits: 8, parity: "none", stopBits: 1})
+	assert.Error(err)
+
+	// the second connection is closed while the first one is using the device
+	c2, pc2 := net.Pipe()
+	defer c2.Close()
+	go p.Handle(pc2, nil, nil)
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c2.Read(make([]byte, 1))
+	assert.Equal(io.EOF, err)
+
+	// the device is released after the first connection is closed
+	c1.Close()
+	var c3 net.Conn
+	for i := 0; i < 30; i++ {
+		time.Sleep(100 * time.Millisecond)
+		conn, pc3 := net.Pipe()
+		go p.Handle(pc3, nil, []byte("again"))
+		if readSerialTest(master, len("again")) == "again" {
+			c3 = conn
+			break
+		}
+		conn.Close()
+	}
+	if assert.NotNil(c3) {
+		master.Write([]byte("ok"))
+		assert.Equal("ok", readSerialTest(c3, len("ok")))
+		c3.Close()
+	}
+}
+
+func TestSerialPty(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_serial")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	link := filepath.Join(dir, "ttyFRP")
+
+	p, err := NewSerialPlugin(map[string]string{
+		"plugin_pty":      "true",
+		"plugin_pty_link": link,
+	})
+	if err != nil {
+		t.Skipf("create pty error: %v", err)
+	}
+	defer p.Close()
+
+	// programs use the pty by the link
+	dev, err := os.OpenFile(link, os.O_RDWR, 0)
+	if !assert.NoError(err) {
+		return
+	}
+	defer dev.Close()
+
+	c1, pc1 := net.Pipe()
+	defer c1.Close()
+	go p.Handle(pc1, nil, nil)
+	go c1.Write([]byte("to program"))
+	assert.Equal("to program", readSerialTest(dev, len("to program")))
+	dev.Write([]byte("from program"))
+	assert.Equal("from program", readSerialTest(c1, len("from program")))
+
+	// the second connection is closed while the first one is using the pty
+	c2, pc2 := net.Pipe()
+	defer c2.Close()
+	go p.Handle(pc2, nil, nil)
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c2.Read(make([]byte, 1))
+	assert.Equal(io.EOF, err)
+
+	// the link is removed when the plugin is closed
+	p.Close()
+	_, err = os.Lstat(link)
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+)
+
+func checkSerialConfig(cfg serialConfig) error {
+	return nil
+}
+
+func openSerial(device string, cfg serialConfig) (*os.File, error) {
+	return nil, fmt.Errorf("serial devices are only supported on linux")
+}
+
+// line settings of pty are not changed on other platforms
+func configureSerial(fd int, cfg serialConfig) error {
+	return nil
+}
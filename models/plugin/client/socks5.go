@@ -15,14 +15,21 @@
 package plugin
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 
+	frpLog "github.com/fatedier/frp/utils/log"
 	frpNet "github.com/fatedier/frp/utils/net"
 
-	gosocks5 "github.com/armon/go-socks5"
+	frpIo "github.com/fatedier/golib/io"
 )
 
 const PluginSocks5 = "socks5"
@@ -31,39 +38,453 @@ func init() {
 	Register(PluginSocks5, NewSocks5Plugin)
 }
 
+const (
+	socks5Version = 5
+
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+
+	socks5CmdConnect      = 1
+	socks5CmdUdpAssociate = 3
+
+	socks5AtypIPv4   = 1
+	socks5AtypDomain = 3
+	socks5AtypIPv6   = 4
+
+	socks5RepSuccess          = 0
+	socks5RepFailure          = 1
+	socks5RepNotAllowed       = 2
+	socks5RepHostUnreachable  = 4
+	socks5RepConnRefused      = 5
+	socks5RepCmdNotSupported  = 7
+	socks5RepAtypNotSupported = 8
+)
+
 type Socks5Plugin struct {
-	Server *gosocks5.Server
+	creds *credentials
+	acl   *destinationACL
 
-	user   string
-	passwd string
+	// UDP ASSOCIATE is supported if udp is true, a udp port in
+	// [udpStartPort, udpEndPort] is used by each association.
+	udp            bool
+	udpBindIP      net.IP
+	udpAdvertiseIP net.IP
+	udpStartPort   int
+	udpEndPort     int
 }
 
 func NewSocks5Plugin(params map[string]string) (p Plugin, err error) {
-	user := params["plugin_user"]
-	passwd := params["plugin_passwd"]
+	sp := &Socks5Plugin{
+		creds: &credentials{
+			user:   params["plugin_user"],
+			passwd: params["plugin_passwd"],
+			file:   params["plugin_credentials_file"],
+		},
+		udp:       params["plugin_udp"] == "true",
+		udpBindIP: net.IPv4zero,
+	}
+	sp.acl, err = newDestinationACL(params["plugin_allow_destinations"], params["plugin_deny_destinations"])
+	if err != nil {
+		return nil, err
+	}
 
-	cfg := &gosocks5.Config{
-		Logger: log.New(ioutil.Discard, "", log.LstdFlags),
+	if v := params["plugin_udp_bind_ip"]; v != "" {
+		if sp.udpBindIP = net.ParseIP(v); sp.udpBindIP == nil {
+			return nil, fmt.Errorf("invalid plugin_udp_bind_ip [%s]", v)
+		}
 	}
-	if user != "" || passwd != "" {
-		cfg.Credentials = gosocks5.StaticCredentials(map[string]string{user: passwd})
+	if v := params["plugin_udp_advertise_ip"]; v != "" {
+		if sp.udpAdvertiseIP = net.ParseIP(v); sp.udpAdvertiseIP == nil {
+			return nil, fmt.Errorf("invalid plugin_udp_advertise_ip [%s]", v)
+		}
 	}
-	sp := &Socks5Plugin{}
-	sp.Server, err = gosocks5.New(cfg)
-	p = sp
-	return
+	if v := params["plugin_udp_ports"]; v != "" {
+		arr := strings.SplitN(v, "-", 2)
+		sp.udpStartPort, err = strconv.Atoi(arr[0])
+		sp.udpEndPort = sp.udpStartPort
+		if err == nil && len(arr) == 2 {
+			sp.udpEndPort, err = strconv.Atoi(arr[1])
+		}
+		if err != nil || sp.udpStartPort <= 0 || sp.udpEndPort > 65535 || sp.udpStartPort > sp.udpEndPort {
+			return nil, fmt.Errorf("invalid plugin_udp_ports [%s]", v)
+		}
+	}
+	return sp, nil
 }
 
 func (sp *Socks5Plugin) Handle(conn io.ReadWriteCloser, realConn net.Conn, extraBufToLocal []byte) {
 	defer conn.Close()
 	wrapConn := frpNet.WrapReadWriteCloserToConn(conn, realConn)
-	sp.Server.ServeConn(wrapConn)
+	rd := bufio.NewReader(io.MultiReader(bytes.NewReader(extraBufToLocal), wrapConn))
+
+	user, err := sp.authenticate(rd, wrapConn)
+	if err != nil {
+		frpLog.Debug("socks5 from [%s] authenticate error: %v", wrapConn.RemoteAddr(), err)
+		return
+	}
+	if user == "" {
+		user = "-"
+	}
+
+	// VER CMD RSV ATYP DST.ADDR DST.PORT
+	header := make([]byte, 3)
+	if _, err = io.ReadFull(rd, header); err != nil {
+		return
+	}
+	if header[0] != socks5Version {
+		return
+	}
+	host, port, err := readSocks5Addr(rd)
+	if err != nil {
+		writeSocks5Reply(wrapConn, socks5RepAtypNotSupported, nil)
+		return
+	}
+
+	switch header[1] {
+	case socks5CmdConnect:
+		sp.handleConnect(frpIo.WrapReadWriteCloser(rd, wrapConn, wrapConn.Close), wrapConn, user, host, port)
+	case socks5CmdUdpAssociate:
+		if !sp.udp {
+			writeSocks5Reply(wrapConn, socks5RepCmdNotSupported, nil)
+			return
+		}
+		sp.handleUdpAssociate(rd, wrapConn, user, host, port)
+	default:
+		writeSocks5Reply(wrapConn, socks5RepCmdNotSupported, nil)
+	}
+}
+
+// authenticate returns the user name, it's empty if no authentication is
+// required.
+func (sp *Socks5Plugin) authenticate(rd *bufio.Reader, w io.Writer) (string, error) {
+	// VER NMETHODS METHODS
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("unsupported socks version [%d]", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(rd, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socks5AuthNone)
+	if sp.creds.required() {
+		method = socks5AuthPassword
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		w.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return "", fmt.Errorf("no acceptable authentication methods")
+	}
+	if _, err := w.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5AuthNone {
+		return "", nil
+	}
+
+	// VER ULEN UNAME PLEN PASSWD
+	readString := func() (string, error) {
+		n, err := rd.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		buf := make([]byte, n)
+		_, err = io.ReadFull(rd, buf)
+		return string(buf), err
+	}
+	if _, err := rd.ReadByte(); err != nil {
+		return "", err
+	}
+	user, err := readString()
+	if err != nil {
+		return "", err
+	}
+	passwd, err := readString()
+	if err != nil {
+		return "", err
+	}
+	if !sp.creds.valid(user, passwd) {
+		w.Write([]byte{1, 1})
+		return "", fmt.Errorf("invalid user [%s] or password", user)
+	}
+	_, err = w.Write([]byte{1, 0})
+	return user, err
+}
+
+func (sp *Socks5Plugin) handleConnect(rwc io.ReadWriteCloser, conn net.Conn, user string, host string, port int) {
+	dest := net.JoinHostPort(host, strconv.Itoa(port))
+	remote, err := sp.acl.dial(host, port)
+	if err != nil {
+		rep := byte(socks5RepHostUnreachable)
+		if err == errDestinationDenied {
+			rep = socks5RepNotAllowed
+		} else if strings.Contains(err.Error(), "refused") {
+			rep = socks5RepConnRefused
+		}
+		frpLog.Info("socks5 user [%s] from [%s] connect to [%s] error: %v", user, conn.RemoteAddr(), dest, err)
+		writeSocks5Reply(conn, rep, nil)
+		return
+	}
+	if err = writeSocks5Reply(conn, socks5RepSuccess, remote.LocalAddr()); err != nil {
+		remote.Close()
+		return
+	}
+
+	start := time.Now()
+	sent, received := frpIo.Join(remote, rwc)
+	frpLog.Info("socks5 user [%s] from [%s] connection to [%s] closed, sent [%d] bytes, received [%d] bytes in %v",
+		user, conn.RemoteAddr(), dest, sent, received, time.Since(start))
+}
+
+func (sp *Socks5Plugin) listenUdp() (*net.UDPConn, error) {
+	var err error
+	for port := sp.udpStartPort; port <= sp.udpEndPort; port++ {
+		var udpConn *net.UDPConn
+		if udpConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: sp.udpBindIP, Port: port}); err == nil {
+			return udpConn, nil
+		}
+	}
+	return nil, err
+}
+
+// handleUdpAssociate relays udp packets until the tcp connection is closed.
+// host and port are the address which the client declares to send packets
+// from, they are zeros if the client doesn't know it.
+func (sp *Socks5Plugin) handleUdpAssociate(rd io.Reader, conn net.Conn, user string, host string, port int) {
+	udpConn, err := sp.listenUdp()
+	if err != nil {
+		frpLog.Warn("socks5 listen udp error: %v", err)
+		writeSocks5Reply(conn, socks5RepFailure, nil)
+		return
+	}
+	defer udpConn.Close()
+
+	// clients send packets to the advertised ip, it should be set if the udp
+	// port is exposed by another proxy
+	ip := sp.udpAdvertiseIP
+	if ip == nil {
+		if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok && addr != nil && !addr.IP.IsUnspecified() {
+			ip = addr.IP
+		} else {
+			ip = sp.udpBindIP
+		}
+	}
+	bindAddr := &net.UDPAddr{IP: ip, Port: udpConn.LocalAddr().(*net.UDPAddr).Port}
+	if err = writeSocks5Reply(conn, socks5RepSuccess, bindAddr); err != nil {
+		return
+	}
+
+	// only packets from the client are relayed, its ip is the tcp peer's ip
+	// if it's not declared
+	clientIP := net.ParseIP(host)
+	if clientIP == nil || clientIP.IsUnspecified() {
+		clientIP = nil
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr != nil {
+			clientIP = addr.IP
+		}
+	}
+	relay := &socks5UdpRelay{
+		conn:       udpConn,
+		acl:        sp.acl,
+		clientIP:   clientIP,
+		clientPort: port,
+		// packets relayed by udp proxies of frpc come from the local host
+		allowLoopback: sp.udpAdvertiseIP != nil,
+		targets:       make(map[string]*net.UDPAddr),
+		done:          make(chan struct{}),
+	}
+	start := time.Now()
+	go relay.run()
+	io.Copy(ioutil.Discard, rd)
+	udpConn.Close()
+	<-relay.done
+	frpLog.Info("socks5 user [%s] from [%s] udp association closed, sent [%d] bytes, received [%d] bytes in %v",
+		user, conn.RemoteAddr(), relay.sent, relay.received, time.Since(start))
+}
+
+// socks5UdpRelay forwards packets between the client and its targets. The
+// client is the sender of the first packet from the expected address, only
+// packets from targets which the client has sent packets to are sent back.
+type socks5UdpRelay struct {
+	conn *net.UDPConn
+	acl  *destinationACL
+	// clientIP and clientPort are expected addresses of the client, any port
+	// is allowed if clientPort is 0
+	clientIP      net.IP
+	clientPort    int
+	allowLoopback bool
+	client        *net.UDPAddr
+	// resolved targets by "host:port" in requests of the client
+	targets map[string]*net.UDPAddr
+
+	sent     int64
+	received int64
+	done     chan struct{}
+}
+
+func (r *socks5UdpRelay) run() {
+	defer close(r.done)
+	buf := make([]byte, 64*1024)
+	fromTargets := make(map[string]bool)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if r.client == nil && !fromTargets[addr.String()] {
+			if !r.isClient(addr) {
+				frpLog.Debug("socks5 udp packet from unexpected address [%s] is dropped", addr)
+				continue
+			}
+			r.client = addr
+		}
+
+		if addr.String() == r.client.String() {
+			target, data, err := r.parseRequest(buf[:n])
+			if err != nil {
+				frpLog.Debug("socks5 udp packet from [%s] is dropped: %v", addr, err)
+				continue
+			}
+			if _, err = r.conn.WriteToUDP(data, target); err == nil {
+				fromTargets[target.String()] = true
+				r.sent += int64(len(data))
+			}
+		} else if fromTargets[addr.String()] {
+			// RSV FRAG ATYP DST.ADDR DST.PORT DATA
+			packet := bytes.NewBuffer([]byte{0, 0, 0})
+			writeSocks5Addr(packet, addr)
+			packet.Write(buf[:n])
+			if _, err = r.conn.WriteToUDP(packet.Bytes(), r.client); err == nil {
+				r.received += int64(n)
+			}
+		}
+	}
+}
+
+// isClient returns true if addr is the expected address of the client.
+func (r *socks5UdpRelay) isClient(addr *net.UDPAddr) bool {
+	if r.allowLoopback && addr.IP.IsLoopback() {
+		return true
+	}
+	if r.clientIP == nil || !r.clientIP.Equal(addr.IP) {
+		return false
+	}
+	return r.clientPort == 0 || r.clientPort == addr.Port
+}
+
+func (r *socks5UdpRelay) parseRequest(packet []byte) (*net.UDPAddr, []byte, error) {
+	if len(packet) < 4 {
+		return nil, nil, fmt.Errorf("packet is too short")
+	}
+	if packet[2] != 0 {
+		return nil, nil, fmt.Errorf("fragments are not supported")
+	}
+	rd := bytes.NewReader(packet[3:])
+	host, port, err := readSocks5Addr(rd)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := packet[len(packet)-rd.Len():]
+
+	key := net.JoinHostPort(host, strconv.Itoa(port))
+	target, ok := r.targets[key]
+	if !ok {
+		ip, err := r.acl.resolve(host, port)
+		if err != nil {
+			return nil, nil, err
+		}
+		target = &net.UDPAddr{IP: ip, Port: port}
+		r.targets[key] = target
+	}
+	return target, data, nil
+}
+
+// readSocks5Addr reads ATYP DST.ADDR DST.PORT.
+func readSocks5Addr(rd io.Reader) (host string, port int, err error) {
+	atyp := make([]byte, 1)
+	if _, err = io.ReadFull(rd, atyp); err != nil {
+		return
+	}
+	var buf []byte
+	switch atyp[0] {
+	case socks5AtypIPv4:
+		buf = make([]byte, net.IPv4len)
+	case socks5AtypIPv6:
+		buf = make([]byte, net.IPv6len)
+	case socks5AtypDomain:
+		n := make([]byte, 1)
+		if _, err = io.ReadFull(rd, n); err != nil {
+			return
+		}
+		buf = make([]byte, n[0])
+	default:
+		err = fmt.Errorf("unsupported address type [%d]", atyp[0])
+		return
+	}
+	if _, err = io.ReadFull(rd, buf); err != nil {
+		return
+	}
+	if atyp[0] == socks5AtypDomain {
+		host = string(buf)
+	} else {
+		host = net.IP(buf).String()
+	}
+
+	portBuf := make([]byte, 2)
+	if _, err = io.ReadFull(rd, portBuf); err != nil {
+		return
+	}
+	port = int(binary.BigEndian.Uint16(portBuf))
+	return
+}
+
+func writeSocks5Addr(buf *bytes.Buffer, addr net.Addr) {
+	var ip net.IP
+	var port int
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		if v != nil {
+			ip, port = v.IP, v.Port
+		}
+	case *net.UDPAddr:
+		if v != nil {
+			ip, port = v.IP, v.Port
+		}
+	}
+	if ip4 := ip.To4(); ip4 != nil || ip == nil {
+		if ip4 == nil {
+			ip4 = net.IPv4zero.To4()
+		}
+		buf.WriteByte(socks5AtypIPv4)
+		buf.Write(ip4)
+	} else {
+		buf.WriteByte(socks5AtypIPv6)
+		buf.Write(ip.To16())
+	}
+	binary.Write(buf, binary.BigEndian, uint16(port))
+}
+
+// writeSocks5Reply writes VER REP RSV ATYP BND.ADDR BND.PORT.
+func writeSocks5Reply(w io.Writer, rep byte, addr net.Addr) error {
+	buf := bytes.NewBuffer([]byte{socks5Version, rep, 0})
+	writeSocks5Addr(buf, addr)
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
 func (sp *Socks5Plugin) Name() string {
 	return PluginSocks5
 }
 
+// NeedUserAddr is true since udp packets are only accepted from the user.
+func (sp *Socks5Plugin) NeedUserAddr() bool {
+	return true
+}
+
 func (sp *Socks5Plugin) Close() error {
 	return nil
 }
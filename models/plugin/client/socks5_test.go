@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocks5UdpRelayClient(t *testing.T) {
+	assert := assert.New(t)
+
+	listenUdp := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		assert.NoError(err)
+		return conn
+	}
+	relayConn, target, client, other := listenUdp(), listenUdp(), listenUdp(), listenUdp()
+	defer target.Close()
+	defer client.Close()
+	defer other.Close()
+
+	acl, err := newDestinationACL("", "")
+	assert.NoError(err)
+	relay := &socks5UdpRelay{
+		conn:       relayConn,
+		acl:        acl,
+		clientIP:   net.IPv4(127, 0, 0, 1),
+		clientPort: client.LocalAddr().(*net.UDPAddr).Port,
+		targets:    make(map[string]*net.UDPAddr),
+		done:       make(chan struct{}),
+	}
+	go relay.run()
+	defer func() {
+		relayConn.Close()
+		<-relay.done
+	}()
+
+	request := func(data string) []byte {
+		// RSV FRAG ATYP DST.ADDR DST.PORT DATA
+		packet := bytes.NewBuffer([]byte{0, 0, 0})
+		writeSocks5Addr(packet, target.LocalAddr())
+		packet.WriteString(data)
+		return packet.Bytes()
+	}
+	read := func(conn *net.UDPConn) string {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+
+	// packets from other addresses are dropped
+	_, err = other.WriteToUDP(request("from other"), relayConn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(err)
+	assert.Equal("", read(target))
+
+	_, err = client.WriteToUDP(request("from client"), relayConn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(err)
+	assert.Equal("from client", read(target))
+	_, err = target.WriteToUDP([]byte("reply"), relayConn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(err)
+	assert.True(bytes.HasSuffix([]byte(read(client)), []byte("reply")))
+
+	// packets relayed by udp proxies come from the local host
+	r := &socks5UdpRelay{clientIP: net.IPv4(10, 0, 0, 1), allowLoopback: true}
+	assert.True(r.isClient(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}))
+	assert.False(r.isClient(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1000}))
+	assert.True(r.isClient(other.LocalAddr().(*net.UDPAddr)))
+}
@@ -0,0 +1,592 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	frpLog "github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	frpIo "github.com/fatedier/golib/io"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+const PluginSshServer = "ssh_server"
+
+// permission extensions set by options in authorized_keys
+const (
+	sshExtNoPty            = "no-pty"
+	sshExtNoPortForwarding = "no-port-forwarding"
+)
+
+func init() {
+	Register(PluginSshServer, NewSshServerPlugin)
+}
+
+// SshServerPlugin serves SSH on work connections, users are authenticated by
+// public keys in an authorized_keys file. Commands run as the user of frpc.
+type SshServerPlugin struct {
+	authorizedKeysPath string
+	shell              string
+
+	allowShell         bool
+	allowExec          bool
+	allowSftp          bool
+	allowLocalForward  bool
+	allowRemoteForward bool
+	// gatewayPorts allows remote forwards to listen on addresses other than
+	// loopback
+	gatewayPorts bool
+
+	config *ssh.ServerConfig
+}
+
+func NewSshServerPlugin(params map[string]string) (Plugin, error) {
+	sp := &SshServerPlugin{
+		authorizedKeysPath: params["plugin_authorized_keys"],
+		shell:              params["plugin_shell"],
+		allowShell:         params["plugin_allow_shell"] != "false",
+		allowExec:          params["plugin_allow_exec"] != "false",
+		allowSftp:          params["plugin_allow_sftp"] != "false",
+		allowLocalForward:  params["plugin_allow_local_forward"] == "true",
+		allowRemoteForward: params["plugin_allow_remote_forward"] == "true",
+		gatewayPorts:       params["plugin_gateway_ports"] == "true",
+	}
+	if sp.authorizedKeysPath == "" {
+		return nil, fmt.Errorf("plugin_authorized_keys is required")
+	}
+	if _, err := sp.loadAuthorizedKeys(); err != nil {
+		return nil, err
+	}
+	if sp.shell == "" {
+		sp.shell = defaultShell()
+	}
+
+	hostKey, err := loadSshHostKey(params["plugin_host_key"])
+	if err != nil {
+		return nil, fmt.Errorf("load ssh host key error: %v", err)
+	}
+	sp.config = &ssh.ServerConfig{
+		PublicKeyCallback: sp.authenticate,
+		ServerVersion:     "SSH-2.0-frp",
+	}
+	sp.config.AddHostKey(hostKey)
+	return sp, nil
+}
+
+func (sp *SshServerPlugin) Handle(conn io.ReadWriteCloser, realConn net.Conn, extraBufToLocal []byte) {
+	wrapConn := frpNet.WrapReadWriteCloserToConn(conn, realConn)
+	sshConn, chans, reqs, err := ssh.NewServerConn(wrapConn, sp.config)
+	if err != nil {
+		frpLog.Debug("ssh_server handshake error: %v", err)
+		wrapConn.Close()
+		return
+	}
+	defer sshConn.Close()
+	frpLog.Info("ssh_server user [%s] from [%s] logged in with key [%s]",
+		sshConn.User(), sshConn.RemoteAddr(), sshConn.Permissions.Extensions["pubkey-fp"])
+
+	sc := &sshServerConn{
+		plugin:   sp,
+		conn:     sshConn,
+		forwards: make(map[string]net.Listener),
+	}
+	defer sc.closeForwards()
+	go sc.handleGlobalRequests(reqs)
+
+	for newCh := range chans {
+		switch newCh.ChannelType() {
+		case "session":
+			go sc.handleSession(newCh)
+		case "direct-tcpip":
+			go sc.handleDirectTcpip(newCh)
+		default:
+			newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+		}
+	}
+}
+
+func (sp *SshServerPlugin) Name() string {
+	return PluginSshServer
+}
+
+func (sp *SshServerPlugin) Close() error {
+	return nil
+}
+
+// authenticate reads the authorized_keys file for each login, so keys can be
+// changed without restarting frpc.
+func (sp *SshServerPlugin) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	keys, err := sp.loadAuthorizedKeys()
+	if err != nil {
+		frpLog.Warn("ssh_server load authorized keys error: %v", err)
+		return nil, err
+	}
+	perms, ok := keys[string(key.Marshal())]
+	if !ok {
+		return nil, fmt.Errorf("unknown public key for user [%s]", meta.User())
+	}
+	extensions := map[string]string{
+		"pubkey-fp": ssh.FingerprintSHA256(key),
+	}
+	for k, v := range perms.Extensions {
+		extensions[k] = v
+	}
+	return &ssh.Permissions{Extensions: extensions}, nil
+}
+
+// loadAuthorizedKeys returns permissions by marshaled public keys. Keys with
+// options other than no-pty, no-port-forwarding and restrict are ignored.
+func (sp *SshServerPlugin) loadAuthorizedKeys() (map[string]*ssh.Permissions, error) {
+	buf, err := ioutil.ReadFile(sp.authorizedKeysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*ssh.Permissions)
+	for len(buf) > 0 {
+		key, _, options, rest, err := ssh.ParseAuthorizedKey(buf)
+		if err != nil {
+			// no more valid keys
+			break
+		}
+		buf = rest
+
+		perms := &ssh.Permissions{Extensions: make(map[string]string)}
+		supported := true
+		for _, option := range options {
+			switch strings.ToLower(option) {
+			case "no-pty":
+				perms.Extensions[sshExtNoPty] = ""
+			case "no-port-forwarding":
+				perms.Extensions[sshExtNoPortForwarding] = ""
+			case "restrict":
+				perms.Extensions[sshExtNoPty] = ""
+				perms.Extensions[sshExtNoPortForwarding] = ""
+			default:
+				supported = false
+			}
+		}
+		if !supported {
+			frpLog.Warn("ssh_server key [%s] in [%s] is ignored, unsupported options: %s",
+				ssh.FingerprintSHA256(key), sp.authorizedKeysPath, strings.Join(options, ","))
+			continue
+		}
+		keys[string(key.Marshal())] = perms
+	}
+	return keys, nil
+}
+
+// loadSshHostKey loads the host key from path. If path doesn't exist, a new
+// key is generated and saved to it. If path is empty, a temporary key is
+// used and clients will see a different host key after frpc restarts.
+func loadSshHostKey(path string) (ssh.Signer, error) {
+	if path != "" {
+		buf, err := ioutil.ReadFile(path)
+		if err == nil {
+			return ssh.ParsePrivateKey(buf)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		frpLog.Warn("ssh_server plugin_host_key is not set, a temporary host key is used")
+		return signer, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+	frpLog.Info("ssh_server host key is generated and saved to [%s]", path)
+	return signer, nil
+}
+
+type sshServerConn struct {
+	plugin *SshServerPlugin
+	conn   *ssh.ServerConn
+
+	// listeners of remote port forwarding by "addr:port" requested
+	forwards map[string]net.Listener
+	mu       sync.Mutex
+}
+
+func (sc *sshServerConn) allowed(extension string) bool {
+	_, denied := sc.conn.Permissions.Extensions[extension]
+	return !denied
+}
+
+func (sc *sshServerConn) handleGlobalRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			sc.handleTcpipForward(req)
+		case "cancel-tcpip-forward":
+			sc.handleCancelTcpipForward(req)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+type sshForwardRequest struct {
+	Addr string
+	Port uint32
+}
+
+type sshForwardedTcpip struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// handleTcpipForward listens on the device for remote port forwarding.
+func (sc *sshServerConn) handleTcpipForward(req *ssh.Request) {
+	var payload sshForwardRequest
+	if !sc.plugin.allowRemoteForward || !sc.allowed(sshExtNoPortForwarding) ||
+		ssh.Unmarshal(req.Payload, &payload) != nil {
+		req.Reply(false, nil)
+		return
+	}
+
+	bindAddr := sshForwardBindAddr(payload.Addr, sc.plugin.gatewayPorts)
+	l, err := net.Listen("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		frpLog.Warn("ssh_server remote forward listen on [%s:%d] error: %v", bindAddr, payload.Port, err)
+		req.Reply(false, nil)
+		return
+	}
+	key := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+	sc.mu.Lock()
+	if old, ok := sc.forwards[key]; ok {
+		old.Close()
+	}
+	sc.forwards[key] = l
+	sc.mu.Unlock()
+
+	port := uint32(l.Addr().(*net.TCPAddr).Port)
+	if payload.Port == 0 {
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+	} else {
+		req.Reply(true, nil)
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				originAddr, originPort := splitSshAddr(c.RemoteAddr())
+				ch, reqs, err := sc.conn.OpenChannel("forwarded-tcpip", ssh.Marshal(&sshForwardedTcpip{
+					Addr:       payload.Addr,
+					Port:       port,
+					OriginAddr: originAddr,
+					OriginPort: originPort,
+				}))
+				if err != nil {
+					c.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				frpIo.Join(ch, c)
+			}()
+		}
+	}()
+}
+
+// sshForwardBindAddr returns the address remote forwards listen on. Like
+// GatewayPorts of sshd, addr requested by the client is only used if
+// gatewayPorts is true, or loopback is used.
+func sshForwardBindAddr(addr string, gatewayPorts bool) string {
+	if gatewayPorts {
+		return addr
+	}
+	if ip := net.ParseIP(addr); ip != nil && ip.IsLoopback() && ip.To4() == nil {
+		return "::1"
+	}
+	return "127.0.0.1"
+}
+
+func (sc *sshServerConn) handleCancelTcpipForward(req *ssh.Request) {
+	var payload sshForwardRequest
+	if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	key := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+	sc.mu.Lock()
+	l, ok := sc.forwards[key]
+	delete(sc.forwards, key)
+	sc.mu.Unlock()
+	if ok {
+		l.Close()
+	}
+	req.Reply(ok, nil)
+}
+
+func (sc *sshServerConn) closeForwards() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for key, l := range sc.forwards {
+		l.Close()
+		delete(sc.forwards, key)
+	}
+}
+
+// handleDirectTcpip connects to an address from the device for local port
+// forwarding.
+func (sc *sshServerConn) handleDirectTcpip(newCh ssh.NewChannel) {
+	if !sc.plugin.allowLocalForward || !sc.allowed(sshExtNoPortForwarding) {
+		newCh.Reject(ssh.Prohibited, "port forwarding is not allowed")
+		return
+	}
+	var payload sshForwardedTcpip
+	if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	c, err := net.DialTimeout("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))), 10*time.Second)
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		c.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	frpIo.Join(ch, c)
+}
+
+type sshPtyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+type sshWindowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type sshSession struct {
+	sc  *sshServerConn
+	ch  ssh.Channel
+	env []string
+
+	pty     *sshPtyRequest
+	ptyFile *os.File
+	started bool
+	mu      sync.Mutex
+}
+
+func (sc *sshServerConn) handleSession(newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	s := &sshSession{sc: sc, ch: ch}
+	sp := sc.plugin
+
+	for req := range reqs {
+		ok := false
+		switch req.Type {
+		case "env":
+			var payload struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				s.env = append(s.env, payload.Name+"="+payload.Value)
+				ok = true
+			}
+		case "pty-req":
+			var payload sshPtyRequest
+			if sc.allowed(sshExtNoPty) && ssh.Unmarshal(req.Payload, &payload) == nil {
+				s.pty = &payload
+				ok = true
+			}
+		case "window-change":
+			var payload sshWindowChange
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				s.resize(payload.Columns, payload.Rows)
+				ok = true
+			}
+		case "shell":
+			ok = sp.allowShell && s.start("")
+		case "exec":
+			var payload struct{ Command string }
+			if sp.allowExec && ssh.Unmarshal(req.Payload, &payload) == nil {
+				ok = s.start(payload.Command)
+			}
+		case "subsystem":
+			var payload struct{ Name string }
+			if sp.allowSftp && ssh.Unmarshal(req.Payload, &payload) == nil && payload.Name == "sftp" {
+				ok = s.startSftp()
+			}
+		}
+		req.Reply(ok, nil)
+	}
+}
+
+func (s *sshSession) resize(columns uint32, rows uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pty != nil {
+		s.pty.Columns, s.pty.Rows = columns, rows
+	}
+	if s.ptyFile != nil {
+		setPtySize(s.ptyFile, columns, rows)
+	}
+}
+
+// start runs the shell, or command by the shell if it's not empty. The
+// channel is closed after it exits.
+func (s *sshSession) start(command string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return false
+	}
+
+	var cmd *exec.Cmd
+	if command == "" {
+		cmd = exec.Command(s.sc.plugin.shell)
+	} else {
+		cmd = exec.Command(s.sc.plugin.shell, shellCommandArgs(command)...)
+	}
+	cmd.Env = append(os.Environ(), s.env...)
+	if home, err := os.UserHomeDir(); err == nil {
+		cmd.Dir = home
+	}
+
+	// output is copied to the channel completely before it's closed
+	outputDone := make(chan struct{})
+	if s.pty != nil {
+		cmd.Env = append(cmd.Env, "TERM="+s.pty.Term)
+		f, err := startPty(cmd, s.pty.Columns, s.pty.Rows)
+		if err != nil {
+			frpLog.Warn("ssh_server start [%s] with pty error: %v", cmd.Path, err)
+			return false
+		}
+		s.ptyFile = f
+		go io.Copy(f, s.ch)
+		go func() {
+			io.Copy(s.ch, f)
+			close(outputDone)
+		}()
+	} else {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return false
+		}
+		cmd.Stdout = s.ch
+		cmd.Stderr = s.ch.Stderr()
+		if err = cmd.Start(); err != nil {
+			frpLog.Warn("ssh_server start [%s] error: %v", cmd.Path, err)
+			return false
+		}
+		go func() {
+			io.Copy(stdin, s.ch)
+			stdin.Close()
+		}()
+		close(outputDone)
+	}
+	s.started = true
+
+	go func() {
+		err := cmd.Wait()
+		if s.ptyFile != nil {
+			<-outputDone
+			s.ptyFile.Close()
+		}
+		status := 0
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = exitErr.ExitCode()
+		} else if err != nil {
+			status = 255
+		}
+		s.ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		s.ch.Close()
+	}()
+	return true
+}
+
+func (s *sshSession) startSftp() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return false
+	}
+
+	server, err := sftp.NewServer(s.ch)
+	if err != nil {
+		return false
+	}
+	s.started = true
+	go func() {
+		if err := server.Serve(); err != nil && err != io.EOF {
+			frpLog.Debug("ssh_server sftp error: %v", err)
+		}
+		server.Close()
+		s.ch.Close()
+	}()
+	return true
+}
+
+func splitSshAddr(addr net.Addr) (string, uint32) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String(), 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, uint32(p)
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package plugin
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+func defaultShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+func shellCommandArgs(command string) []string {
+	return []string{"-c", command}
+}
+
+func startPty(cmd *exec.Cmd, columns uint32, rows uint32) (*os.File, error) {
+	return pty.StartWithSize(cmd, &pty.Winsize{Cols: uint16(columns), Rows: uint16(rows)})
+}
+
+func setPtySize(f *os.File, columns uint32, rows uint32) {
+	pty.Setsize(f, &pty.Winsize{Cols: uint16(columns), Rows: uint16(rows)})
+}
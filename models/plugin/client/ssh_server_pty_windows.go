@@ -0,0 +1,39 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func defaultShell() string {
+	if shell := os.Getenv("COMSPEC"); shell != "" {
+		return shell
+	}
+	return "cmd.exe"
+}
+
+func shellCommandArgs(command string) []string {
+	return []string{"/C", command}
+}
+
+// sessions without pty are still available on windows
+func startPty(cmd *exec.Cmd, columns uint32, rows uint32) (*os.File, error) {
+	return nil, errors.New("pty is not supported on windows")
+}
+
+func setPtySize(f *os.File, columns uint32, rows uint32) {}
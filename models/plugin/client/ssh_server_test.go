@@ -0,0 +1,382 @@
+package plugin
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSshTestKey(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func authorizedKeyLine(options string, signer ssh.Signer) string {
+	line := string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	if options != "" {
+		line = options + " " + line
+	}
+	return line
+}
+
+// startSshTestServer serves the ssh_server plugin on a local listener.
+func startSshTestServer(t *testing.T, dir string, authorizedKeys string, params map[string]string) (addr string, closeFn func()) {
+	keysPath := filepath.Join(dir, "authorized_keys")
+	if err := ioutil.WriteFile(keysPath, []byte(authorizedKeys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	all := map[string]string{
+		"plugin_authorized_keys": keysPath,
+		"plugin_shell":           "/bin/sh",
+	}
+	for k, v := range params {
+		all[k] = v
+	}
+	p, err := NewSshServerPlugin(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.Handle(c, c, nil)
+		}
+	}()
+	return l.Addr().String(), func() {
+		l.Close()
+		p.Close()
+	}
+}
+
+func dialSshTestServer(addr string, auth ...ssh.AuthMethod) (*ssh.Client, error) {
+	return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            auth,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestSshServerAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_ssh_server")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	key1, key2, key3 := newSshTestKey(t), newSshTestKey(t), newSshTestKey(t)
+	addr, closeFn := startSshTestServer(t, dir,
+		authorizedKeyLine("", key1)+authorizedKeyLine(`command="ls"`, key2), nil)
+	defer closeFn()
+
+	client, err := dialSshTestServer(addr, ssh.PublicKeys(key1))
+	assert.NoError(err)
+	if client != nil {
+		client.Close()
+	}
+
+	// keys with unsupported options are ignored
+	_, err = dialSshTestServer(addr, ssh.PublicKeys(key2))
+	assert.Error(err)
+	// unknown key
+	_, err = dialSshTestServer(addr, ssh.PublicKeys(key3))
+	assert.Error(err)
+	// password is not supported
+	_, err = dialSshTestServer(addr, ssh.Password("secret"))
+	assert.Error(err)
+
+	// authorized_keys is read again for each login
+	f, err := os.OpenFile(filepath.Join(dir, "authorized_keys"), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(err)
+	f.WriteString(authorizedKeyLine("", key3))
+	f.Close()
+	client, err = dialSshTestServer(addr, ssh.PublicKeys(key3))
+	assert.NoError(err)
+	if client != nil {
+		client.Close()
+	}
+}
+
+func TestSshServerSession(t *testing.T) {
+	assert := assert.New(t)
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run by /bin/sh and pty is not supported")
+	}
+
+	dir, err := ioutil.TempDir("", "frp_ssh_server")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	key, noPtyKey, restrictedKey := newSshTestKey(t), newSshTestKey(t), newSshTestKey(t)
+	authorizedKeys := authorizedKeyLine("", key) + authorizedKeyLine("no-pty", noPtyKey) +
+		authorizedKeyLine("restrict", restrictedKey)
+	addr, closeFn := startSshTestServer(t, dir, authorizedKeys, nil)
+	defer closeFn()
+
+	client, err := dialSshTestServer(addr, ssh.PublicKeys(key))
+	if !assert.NoError(err) {
+		return
+	}
+	defer client.Close()
+
+	// exec with stdin and output
+	sess, err := client.NewSession()
+	assert.NoError(err)
+	sess.Stdin = strings.NewReader("abc")
+	out, err := sess.Output("cat; echo hello")
+	assert.NoError(err)
+	assert.Equal("abchello\n", string(out))
+
+	// exit status
+	sess, err = client.NewSession()
+	assert.NoError(err)
+	err = sess.Run("exit 3")
+	if exitErr, ok := err.(*ssh.ExitError); assert.True(ok, "%v", err) {
+		assert.Equal(3, exitErr.ExitStatus())
+	}
+
+	// exec with pty
+	sess, err = client.NewSession()
+	assert.NoError(err)
+	assert.NoError(sess.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	out, err = sess.Output("tty")
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(string(out), "/dev/"), string(out))
+
+	// pty is denied by no-pty and restrict, commands still work
+	for _, k := range []ssh.Signer{noPtyKey, restrictedKey} {
+		c, err := dialSshTestServer(addr, ssh.PublicKeys(k))
+		if !assert.NoError(err) {
+			continue
+		}
+		sess, err = c.NewSession()
+		assert.NoError(err)
+		assert.Error(sess.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+		out, err = sess.Output("echo hello")
+		assert.NoError(err)
+		assert.Equal("hello\n", string(out))
+		c.Close()
+	}
+
+	// exec and shell can be disabled
+	addr2, closeFn2 := startSshTestServer(t, dir, authorizedKeys, map[string]string{
+		"plugin_allow_exec":  "false",
+		"plugin_allow_shell": "false",
+	})
+	defer closeFn2()
+	client2, err := dialSshTestServer(addr2, ssh.PublicKeys(key))
+	if !assert.NoError(err) {
+		return
+	}
+	defer client2.Close()
+	sess, err = client2.NewSession()
+	assert.NoError(err)
+	assert.Error(sess.Run("echo hello"))
+	sess, err = client2.NewSession()
+	assert.NoError(err)
+	assert.Error(sess.Shell())
+}
+
+func TestSshServerSftp(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_ssh_server")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	key := newSshTestKey(t)
+	addr, closeFn := startSshTestServer(t, dir, authorizedKeyLine("", key), nil)
+	defer closeFn()
+	client, err := dialSshTestServer(addr, ssh.PublicKeys(key))
+	if !assert.NoError(err) {
+		return
+	}
+	defer client.Close()
+
+	sc, err := sftp.NewClient(client)
+	if !assert.NoError(err) {
+		return
+	}
+	defer sc.Close()
+	f, err := sc.Create(filepath.Join(dir, "upload.txt"))
+	assert.NoError(err)
+	f.Write([]byte("uploaded"))
+	f.Close()
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "upload.txt"))
+	assert.NoError(err)
+	assert.Equal("uploaded", string(buf))
+
+	f, err = sc.Open(filepath.Join(dir, "authorized_keys"))
+	assert.NoError(err)
+	buf, err = ioutil.ReadAll(f)
+	assert.NoError(err)
+	assert.Equal(authorizedKeyLine("", key), string(buf))
+	f.Close()
+
+	// sftp can be disabled
+	addr2, closeFn2 := startSshTestServer(t, dir, authorizedKeyLine("", key), map[string]string{
+		"plugin_allow_sftp": "false",
+	})
+	defer closeFn2()
+	client2, err := dialSshTestServer(addr2, ssh.PublicKeys(key))
+	if !assert.NoError(err) {
+		return
+	}
+	defer client2.Close()
+	_, err = sftp.NewClient(client2)
+	assert.Error(err)
+}
+
+func TestSshServerForwarding(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_ssh_server")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	checkEcho := func(c net.Conn) {
+		defer c.Close()
+		c.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := io.ReadFull(c, buf)
+		assert.NoError(err)
+		assert.Equal("ping", string(buf))
+	}
+
+	key, noForwardKey, restrictedKey := newSshTestKey(t), newSshTestKey(t), newSshTestKey(t)
+	authorizedKeys := authorizedKeyLine("", key) + authorizedKeyLine("no-port-forwarding", noForwardKey) +
+		authorizedKeyLine("restrict", restrictedKey)
+	addr, closeFn := startSshTestServer(t, dir, authorizedKeys, map[string]string{
+		"plugin_allow_local_forward":  "true",
+		"plugin_allow_remote_forward": "true",
+	})
+	defer closeFn()
+
+	client, err := dialSshTestServer(addr, ssh.PublicKeys(key))
+	if !assert.NoError(err) {
+		return
+	}
+	defer client.Close()
+
+	// local forward
+	c, err := client.Dial("tcp", echo.Addr().String())
+	if assert.NoError(err) {
+		checkEcho(c)
+	}
+
+	// remote forward listens on loopback even if all addresses are requested
+	l, err := client.Listen("tcp", "0.0.0.0:0")
+	if assert.NoError(err) {
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(c, c)
+					c.Close()
+				}()
+			}
+		}()
+		port := l.Addr().(*net.TCPAddr).Port
+		c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if assert.NoError(err) {
+			checkEcho(c)
+		}
+		l.Close()
+	}
+
+	// denied by authorized_keys options
+	for _, k := range []ssh.Signer{noForwardKey, restrictedKey} {
+		c, err := dialSshTestServer(addr, ssh.PublicKeys(k))
+		if !assert.NoError(err) {
+			continue
+		}
+		_, err = c.Dial("tcp", echo.Addr().String())
+		assert.Error(err)
+		_, err = c.Listen("tcp", "127.0.0.1:0")
+		assert.Error(err)
+		c.Close()
+	}
+
+	// disabled by default
+	addr2, closeFn2 := startSshTestServer(t, dir, authorizedKeys, nil)
+	defer closeFn2()
+	client2, err := dialSshTestServer(addr2, ssh.PublicKeys(key))
+	if !assert.NoError(err) {
+		return
+	}
+	defer client2.Close()
+	_, err = client2.Dial("tcp", echo.Addr().String())
+	assert.Error(err)
+	_, err = client2.Listen("tcp", "127.0.0.1:0")
+	assert.Error(err)
+}
+
+func TestSshForwardBindAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		addr         string
+		gatewayPorts bool
+		bindAddr     string
+	}{
+		{"", false, "127.0.0.1"},
+		{"0.0.0.0", false, "127.0.0.1"},
+		{"192.168.1.2", false, "127.0.0.1"},
+		{"localhost", false, "127.0.0.1"},
+		{"::1", false, "::1"},
+		{"::", false, "127.0.0.1"},
+		{"", true, ""},
+		{"0.0.0.0", true, "0.0.0.0"},
+		{"192.168.1.2", true, "192.168.1.2"},
+	}
+	for _, test := range tests {
+		assert.Equal(test.bindAddr, sshForwardBindAddr(test.addr, test.gatewayPorts), test.addr)
+	}
+}
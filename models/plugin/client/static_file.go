@@ -15,13 +15,22 @@
 package plugin
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/fatedier/frp/models/config"
 	frpNet "github.com/fatedier/frp/utils/net"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/net/webdav"
 )
 
 const PluginStaticFile = "static_file"
@@ -36,6 +45,12 @@ type StaticFilePlugin struct {
 	httpUser    string
 	httpPasswd  string
 
+	// webdav mode
+	webdav        bool
+	readOnly      bool
+	permissions   []*pathPermission
+	maxUploadSize int64
+
 	l *Listener
 	s *http.Server
 }
@@ -46,15 +61,18 @@ func NewStaticFilePlugin(params map[string]string) (Plugin, error) {
 	httpUser := params["plugin_http_user"]
 	httpPasswd := params["plugin_http_passwd"]
 
-	listener := NewProxyListener()
-
 	sp := &StaticFilePlugin{
 		localPath:   localPath,
 		stripPrefix: stripPrefix,
 		httpUser:    httpUser,
 		httpPasswd:  httpPasswd,
-
-		l: listener,
+		webdav:      params["plugin_webdav"] == "true",
+		readOnly:    params["plugin_read_only"] == "true",
+	}
+	if sp.webdav {
+		if err := sp.parseWebdavParams(params); err != nil {
+			return nil, err
+		}
 	}
 	var prefix string
 	if stripPrefix != "" {
@@ -65,7 +83,18 @@ func NewStaticFilePlugin(params map[string]string) (Plugin, error) {
 
 	router := mux.NewRouter()
 	router.Use(frpNet.NewHttpAuthMiddleware(httpUser, httpPasswd).Middleware)
-	router.PathPrefix(prefix).Handler(frpNet.MakeHttpGzipHandler(http.StripPrefix(prefix, http.FileServer(http.Dir(localPath))))).Methods("GET")
+	if sp.webdav {
+		router.PathPrefix(prefix).Handler(sp.webdavHandler(&webdav.Handler{
+			Prefix:     strings.TrimSuffix(prefix, "/"),
+			FileSystem: &permissionFileSystem{FileSystem: webdav.Dir(localPath), sp: sp},
+			LockSystem: webdav.NewMemLS(),
+		}))
+	} else {
+		router.PathPrefix(prefix).Handler(frpNet.MakeHttpGzipHandler(http.StripPrefix(prefix, http.FileServer(http.Dir(localPath))))).Methods("GET")
+	}
+
+	listener := NewProxyListener()
+	sp.l = listener
 	sp.s = &http.Server{
 		Handler: router,
 	}
@@ -87,3 +116,196 @@ func (sp *StaticFilePlugin) Close() error {
 	sp.l.Close()
 	return nil
 }
+
+type filePermission int
+
+const (
+	permissionNone filePermission = iota
+	permissionReadOnly
+	permissionReadWrite
+)
+
+// pathPermission is the permission of the path and all files under it.
+type pathPermission struct {
+	path       string
+	permission filePermission
+}
+
+// parseWebdavParams parses "plugin_path_permission_<name> = <path> <rw|ro|none>"
+// and "plugin_max_upload_size".
+func (sp *StaticFilePlugin) parseWebdavParams(params map[string]string) error {
+	for k, v := range params {
+		if !strings.HasPrefix(k, "plugin_path_permission_") {
+			continue
+		}
+		fields := strings.Fields(v)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "/") {
+			return fmt.Errorf("%s should be in \"<path> <rw|ro|none>\" format", k)
+		}
+		rule := &pathPermission{path: path.Clean(fields[0])}
+		switch fields[1] {
+		case "rw":
+			rule.permission = permissionReadWrite
+		case "ro":
+			rule.permission = permissionReadOnly
+		case "none":
+			rule.permission = permissionNone
+		default:
+			return fmt.Errorf("%s has an invalid permission [%s]", k, fields[1])
+		}
+		sp.permissions = append(sp.permissions, rule)
+	}
+	// the longest path is matched first
+	sort.Slice(sp.permissions, func(i, j int) bool {
+		return len(sp.permissions[i].path) > len(sp.permissions[j].path)
+	})
+
+	if v := params["plugin_max_upload_size"]; v != "" {
+		size, err := config.NewBandwidthQuantity(v)
+		if err != nil {
+			return fmt.Errorf("plugin_max_upload_size error: %v", err)
+		}
+		sp.maxUploadSize = size.Bytes()
+	}
+	return nil
+}
+
+// permission returns the permission of name which is relative to
+// plugin_local_path. Nothing can be modified if plugin_read_only is true.
+func (sp *StaticFilePlugin) permission(name string) filePermission {
+	name = path.Clean("/" + name)
+	permission := permissionReadWrite
+	for _, rule := range sp.permissions {
+		if rule.path == "/" || name == rule.path || strings.HasPrefix(name, rule.path+"/") {
+			permission = rule.permission
+			break
+		}
+	}
+	if sp.readOnly && permission > permissionReadOnly {
+		permission = permissionReadOnly
+	}
+	return permission
+}
+
+// webdavHandler checks permissions of requests before they are served by h.
+func (sp *StaticFilePlugin) webdavHandler(h *webdav.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := permissionReadWrite
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS", "PROPFIND", "COPY":
+			required = permissionReadOnly
+		}
+		status := sp.checkPermission(strings.TrimPrefix(r.URL.Path, h.Prefix), required)
+		if status == http.StatusOK && (r.Method == "COPY" || r.Method == "MOVE") {
+			dest, err := url.Parse(r.Header.Get("Destination"))
+			if err != nil || !strings.HasPrefix(dest.Path, h.Prefix) {
+				status = http.StatusBadGateway
+			} else {
+				status = sp.checkPermission(strings.TrimPrefix(dest.Path, h.Prefix), permissionReadWrite)
+			}
+		}
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		if r.Method == "PUT" && sp.maxUploadSize > 0 {
+			if r.ContentLength > sp.maxUploadSize {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, sp.maxUploadSize)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (sp *StaticFilePlugin) checkPermission(name string, required filePermission) int {
+	switch permission := sp.permission(name); {
+	case permission == permissionNone:
+		return http.StatusNotFound
+	case permission < required:
+		return http.StatusForbidden
+	}
+	return http.StatusOK
+}
+
+// permissionFileSystem hides files without permission from directory listings
+// and refuses to modify read-only files.
+type permissionFileSystem struct {
+	webdav.FileSystem
+	sp *StaticFilePlugin
+}
+
+func (fs *permissionFileSystem) check(name string, required filePermission) error {
+	switch permission := fs.sp.permission(name); {
+	case permission == permissionNone:
+		return os.ErrNotExist
+	case permission < required:
+		return os.ErrPermission
+	}
+	return nil
+}
+
+func (fs *permissionFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.check(name, permissionReadWrite); err != nil {
+		return err
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+func (fs *permissionFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	required := permissionReadOnly
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		required = permissionReadWrite
+	}
+	if err := fs.check(name, required); err != nil {
+		return nil, err
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &permissionFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *permissionFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if err := fs.check(name, permissionReadWrite); err != nil {
+		return err
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+func (fs *permissionFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fs.check(oldName, permissionReadWrite); err != nil {
+		return err
+	}
+	if err := fs.check(newName, permissionReadWrite); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+func (fs *permissionFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := fs.check(name, permissionReadOnly); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+type permissionFile struct {
+	webdav.File
+	fs   *permissionFileSystem
+	name string
+}
+
+func (f *permissionFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if f.fs.sp.permission(path.Join(f.name, info.Name())) != permissionNone {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticFilePermission(t *testing.T) {
+	assert := assert.New(t)
+
+	params := map[string]string{
+		"plugin_webdav":            "true",
+		"plugin_path_permission_a": "/a ro",
+		"plugin_path_permission_b": "/a/b rw",
+		"plugin_path_permission_c": "/a/b/c none",
+	}
+	p, err := NewStaticFilePlugin(params)
+	assert.NoError(err)
+	defer p.Close()
+	sp := p.(*StaticFilePlugin)
+
+	tests := []struct {
+		name       string
+		permission filePermission
+		readOnly   filePermission
+	}{
+		{"/", permissionReadWrite, permissionReadOnly},
+		{"/ab", permissionReadWrite, permissionReadOnly},
+		{"/a", permissionReadOnly, permissionReadOnly},
+		{"a/x", permissionReadOnly, permissionReadOnly},
+		{"/a/bc", permissionReadOnly, permissionReadOnly},
+		{"/a/b", permissionReadWrite, permissionReadOnly},
+		{"/a/b/x", permissionReadWrite, permissionReadOnly},
+		{"/a/b/c/x", permissionNone, permissionNone},
+		{"/a/b/../b/c", permissionNone, permissionNone},
+	}
+	for _, test := range tests {
+		sp.readOnly = false
+		assert.Equal(test.permission, sp.permission(test.name), test.name)
+		sp.readOnly = true
+		assert.Equal(test.readOnly, sp.permission(test.name), test.name)
+	}
+}
+
+func TestStaticFileWebdav(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_static_file")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"pub/f.txt", "logs/l.txt", "secret/s.txt"} {
+		assert.NoError(os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+
+	newPlugin := func(readOnly string) *StaticFilePlugin {
+		p, err := NewStaticFilePlugin(map[string]string{
+			"plugin_local_path":             dir,
+			"plugin_webdav":                 "true",
+			"plugin_read_only":              readOnly,
+			"plugin_path_permission_logs":   "/logs ro",
+			"plugin_path_permission_pub":    "/pub rw",
+			"plugin_path_permission_secret": "/secret none",
+		})
+		assert.NoError(err)
+		return p.(*StaticFilePlugin)
+	}
+	sp := newPlugin("false")
+	defer sp.Close()
+
+	serve := func(sp *StaticFilePlugin, method string, name string, dest string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+name, nil)
+		if dest != "" {
+			req.Header.Set("Destination", dest)
+		}
+		if method == "PROPFIND" {
+			req.Header.Set("Depth", "1")
+		}
+		rw := httptest.NewRecorder()
+		sp.s.Handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// files with none permission are hidden
+	rw := serve(sp, "PROPFIND", "/", "")
+	assert.Equal(http.StatusMultiStatus, rw.Code)
+	assert.True(strings.Contains(rw.Body.String(), "/pub/"))
+	assert.True(strings.Contains(rw.Body.String(), "/logs/"))
+	assert.False(strings.Contains(rw.Body.String(), "secret"))
+	assert.Equal(http.StatusNotFound, serve(sp, "GET", "/secret/s.txt", "").Code)
+	assert.Equal(http.StatusOK, serve(sp, "GET", "/logs/l.txt", "").Code)
+
+	// destinations of MOVE and COPY are checked
+	assert.Equal(http.StatusForbidden, serve(sp, "MOVE", "/pub/f.txt", "http://example.com/logs/f.txt").Code)
+	assert.Equal(http.StatusNotFound, serve(sp, "COPY", "/pub/f.txt", "http://example.com/secret/f.txt").Code)
+	assert.Equal(http.StatusForbidden, serve(sp, "MOVE", "/logs/l.txt", "http://example.com/pub/l.txt").Code)
+	assert.Equal(http.StatusCreated, serve(sp, "COPY", "/logs/l.txt", "http://example.com/pub/l.txt").Code)
+	assert.Equal(http.StatusCreated, serve(sp, "MOVE", "/pub/f.txt", "http://example.com/pub/g.txt").Code)
+	_, err = os.Stat(filepath.Join(dir, "pub/g.txt"))
+	assert.NoError(err)
+	_, err = os.Stat(filepath.Join(dir, "secret/f.txt"))
+	assert.True(os.IsNotExist(err))
+
+	// nothing can be changed with plugin_read_only even if the path is rw
+	ro := newPlugin("true")
+	defer ro.Close()
+	assert.Equal(http.StatusForbidden, serve(ro, "PUT", "/pub/new.txt", "").Code)
+	assert.Equal(http.StatusForbidden, serve(ro, "DELETE", "/pub/g.txt", "").Code)
+	assert.Equal(http.StatusForbidden, serve(ro, "COPY", "/pub/g.txt", "http://example.com/pub/h.txt").Code)
+	assert.Equal(http.StatusOK, serve(ro, "GET", "/pub/g.txt", "").Code)
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	clientPlugin "github.com/fatedier/frp/models/plugin/client"
+	"github.com/fatedier/frp/utils/log"
+)
+
+const PluginHttpHeader = "http_header"
+
+func init() {
+	Register(PluginHttpHeader, NewHttpHeaderPlugin)
+}
+
+// HttpHeaderPlugin sends http requests of local users to the proxy with
+// extra headers, such as credentials of the service which local users
+// shouldn't know.
+type HttpHeaderPlugin struct {
+	hostHeaderRewrite string
+	headers           map[string]string
+
+	l *clientPlugin.Listener
+	s *http.Server
+}
+
+func NewHttpHeaderPlugin(params map[string]string, dial DialFunc) (Plugin, error) {
+	p := &HttpHeaderPlugin{
+		hostHeaderRewrite: params["plugin_host_header_rewrite"],
+		headers:           make(map[string]string),
+		l:                 clientPlugin.NewProxyListener(),
+	}
+	for k, v := range params {
+		if !strings.HasPrefix(k, "plugin_header_") {
+			continue
+		}
+		if k = strings.TrimPrefix(k, "plugin_header_"); k != "" {
+			p.headers[k] = v
+		}
+	}
+
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = req.Host
+			if p.hostHeaderRewrite != "" {
+				req.Host = p.hostHeaderRewrite
+			}
+			for k, v := range p.headers {
+				req.Header.Set(k, v)
+			}
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dial()
+			},
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			log.Warn("visitor http_header request [%s] error: %v", req.URL.Path, err)
+			rw.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	p.s = &http.Server{
+		Handler: rp,
+	}
+	go p.s.Serve(p.l)
+	return p, nil
+}
+
+func (p *HttpHeaderPlugin) Handle(conn net.Conn) {
+	p.l.PutConn(conn)
+}
+
+func (p *HttpHeaderPlugin) Name() string {
+	return PluginHttpHeader
+}
+
+func (p *HttpHeaderPlugin) Close() error {
+	p.s.Close()
+	p.l.Close()
+	return nil
+}
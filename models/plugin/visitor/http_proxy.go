@@ -0,0 +1,180 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	clientPlugin "github.com/fatedier/frp/models/plugin/client"
+	"github.com/fatedier/frp/utils/log"
+
+	frpIo "github.com/fatedier/golib/io"
+	"golang.org/x/net/proxy"
+)
+
+const PluginHttpProxy = "http_proxy"
+
+func init() {
+	Register(PluginHttpProxy, NewHttpProxyPlugin)
+}
+
+// HttpProxy is a local http proxy, requests are sent through the socks5
+// plugin of the proxy, so one visitor can reach all services of the network
+// where the proxy is.
+type HttpProxy struct {
+	user      string
+	passwd    string
+	dialer    proxy.Dialer
+	transport *http.Transport
+
+	l *clientPlugin.Listener
+	s *http.Server
+}
+
+func NewHttpProxyPlugin(params map[string]string, dial DialFunc) (Plugin, error) {
+	var auth *proxy.Auth
+	if params["plugin_remote_user"] != "" || params["plugin_remote_passwd"] != "" {
+		auth = &proxy.Auth{
+			User:     params["plugin_remote_user"],
+			Password: params["plugin_remote_passwd"],
+		}
+	}
+	// the address is useless, connections to the proxy are opened by dial
+	dialer, err := proxy.SOCKS5("tcp", "visitor", auth, tunnelDialer(dial))
+	if err != nil {
+		return nil, err
+	}
+
+	hp := &HttpProxy{
+		user:   params["plugin_http_user"],
+		passwd: params["plugin_http_passwd"],
+		dialer: dialer,
+		l:      clientPlugin.NewProxyListener(),
+	}
+	hp.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+		MaxIdleConns:    100,
+		IdleConnTimeout: 90 * time.Second,
+	}
+	hp.s = &http.Server{
+		Handler: hp,
+	}
+	go hp.s.Serve(hp.l)
+	return hp, nil
+}
+
+func (hp *HttpProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !hp.auth(req) {
+		rw.Header().Set("Proxy-Authenticate", "Basic")
+		rw.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	if req.Method == http.MethodConnect {
+		hp.handleConnect(rw, req)
+		return
+	}
+
+	req.RequestURI = ""
+	for _, h := range []string{"Proxy-Connection", "Connection", "Proxy-Authenticate", "Proxy-Authorization", "TE", "Trailers", "Transfer-Encoding", "Upgrade"} {
+		req.Header.Del(h)
+	}
+	resp, err := hp.transport.RoundTrip(req)
+	if err != nil {
+		log.Info("visitor http_proxy request [%s %s] error: %v", req.Method, req.URL, err)
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			rw.Header().Add(k, v)
+		}
+	}
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+}
+
+func (hp *HttpProxy) handleConnect(rw http.ResponseWriter, req *http.Request) {
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	host := req.URL.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+	remote, err := hp.dialer.Dial("tcp", host)
+	if err != nil {
+		log.Info("visitor http_proxy connect to [%s] error: %v", host, err)
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	client, bufRw, err := hj.Hijack()
+	if err != nil {
+		remote.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	frpIo.Join(remote, frpIo.WrapReadWriteCloser(bufRw.Reader, client, client.Close))
+}
+
+func (hp *HttpProxy) auth(req *http.Request) bool {
+	if hp.user == "" && hp.passwd == "" {
+		return true
+	}
+	s := strings.SplitN(req.Header.Get("Proxy-Authorization"), " ", 2)
+	if len(s) != 2 {
+		return false
+	}
+	b, err := base64.StdEncoding.DecodeString(s[1])
+	if err != nil {
+		return false
+	}
+	pair := strings.SplitN(string(b), ":", 2)
+	return len(pair) == 2 && equalString(pair[0], hp.user) && equalString(pair[1], hp.passwd)
+}
+
+func (hp *HttpProxy) Handle(conn net.Conn) {
+	hp.l.PutConn(conn)
+}
+
+func (hp *HttpProxy) Name() string {
+	return PluginHttpProxy
+}
+
+func (hp *HttpProxy) Close() error {
+	hp.s.Close()
+	hp.l.Close()
+	return nil
+}
+
+// tunnelDialer opens connections to the proxy for any addresses.
+type tunnelDialer DialFunc
+
+func (d tunnelDialer) Dial(network, addr string) (net.Conn, error) {
+	return d()
+}
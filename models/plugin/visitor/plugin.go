@@ -0,0 +1,56 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+)
+
+// Creators is used for create plugins to handle connections of local users.
+var creators = make(map[string]CreatorFn)
+
+// DialFunc opens a new connection to the proxy of the visitor.
+type DialFunc func() (net.Conn, error)
+
+// params has prefix "plugin_"
+type CreatorFn func(params map[string]string, dial DialFunc) (Plugin, error)
+
+func Register(name string, fn CreatorFn) {
+	creators[name] = fn
+}
+
+func Create(name string, params map[string]string, dial DialFunc) (p Plugin, err error) {
+	if fn, ok := creators[name]; ok {
+		p, err = fn(params, dial)
+	} else {
+		err = fmt.Errorf("visitor plugin [%s] is not registered", name)
+	}
+	return
+}
+
+// Plugin handles connections accepted by the listener of a visitor instead of
+// joining them with connections to the proxy directly.
+type Plugin interface {
+	Name() string
+
+	Handle(conn net.Conn)
+	Close() error
+}
+
+func equalString(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
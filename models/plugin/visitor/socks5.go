@@ -0,0 +1,184 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/fatedier/frp/utils/log"
+
+	frpIo "github.com/fatedier/golib/io"
+)
+
+const PluginSocks5 = "socks5"
+
+func init() {
+	Register(PluginSocks5, NewSocks5Plugin)
+}
+
+const (
+	socks5Version          = 5
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+)
+
+// Socks5Plugin authenticates local users by itself and the proxy by the
+// remote user and password, so that local programs don't need credentials
+// of the socks5 plugin of the proxy. Requests after authentication are sent
+// to the proxy as they are.
+type Socks5Plugin struct {
+	user         string
+	passwd       string
+	remoteUser   string
+	remotePasswd string
+	dial         DialFunc
+}
+
+func NewSocks5Plugin(params map[string]string, dial DialFunc) (Plugin, error) {
+	return &Socks5Plugin{
+		user:         params["plugin_user"],
+		passwd:       params["plugin_passwd"],
+		remoteUser:   params["plugin_remote_user"],
+		remotePasswd: params["plugin_remote_passwd"],
+		dial:         dial,
+	}, nil
+}
+
+func (sp *Socks5Plugin) Handle(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	if err := sp.authenticate(rd, conn); err != nil {
+		log.Debug("visitor socks5 from [%s] authenticate error: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	remote, err := sp.dial()
+	if err == nil {
+		if err = socks5ClientHandshake(remote, sp.remoteUser, sp.remotePasswd); err != nil {
+			remote.Close()
+		}
+	}
+	if err != nil {
+		log.Warn("visitor socks5 connect to proxy error: %v", err)
+		// general failure
+		conn.Write([]byte{socks5Version, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	frpIo.Join(frpIo.WrapReadWriteCloser(rd, conn, conn.Close), remote)
+}
+
+// authenticate reads the greeting of the local user.
+func (sp *Socks5Plugin) authenticate(rd *bufio.Reader, w io.Writer) error {
+	// VER NMETHODS METHODS
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return err
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("unsupported socks version [%d]", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(rd, methods); err != nil {
+		return err
+	}
+
+	method := byte(socks5AuthNone)
+	if sp.user != "" || sp.passwd != "" {
+		method = socks5AuthPassword
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		w.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return fmt.Errorf("no acceptable authentication methods")
+	}
+	if _, err := w.Write([]byte{socks5Version, method}); err != nil {
+		return err
+	}
+	if method == socks5AuthNone {
+		return nil
+	}
+
+	// VER ULEN UNAME PLEN PASSWD
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		return err
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(rd, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(rd, buf[:1]); err != nil {
+		return err
+	}
+	passwd := make([]byte, buf[0])
+	if _, err := io.ReadFull(rd, passwd); err != nil {
+		return err
+	}
+	if !equalString(string(user), sp.user) || !equalString(string(passwd), sp.passwd) {
+		w.Write([]byte{1, 1})
+		return fmt.Errorf("invalid user [%s] or password", user)
+	}
+	_, err := w.Write([]byte{1, 0})
+	return err
+}
+
+// socks5ClientHandshake authenticates with the socks5 server on the other
+// side of conn.
+func socks5ClientHandshake(conn io.ReadWriter, user string, passwd string) error {
+	method := byte(socks5AuthNone)
+	if user != "" || passwd != "" {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version || reply[1] != method {
+		return fmt.Errorf("authentication method is not accepted by the proxy")
+	}
+	if method == socks5AuthNone {
+		return nil
+	}
+
+	buf := bytes.NewBuffer([]byte{1, byte(len(user))})
+	buf.WriteString(user)
+	buf.WriteByte(byte(len(passwd)))
+	buf.WriteString(passwd)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("authentication is rejected by the proxy")
+	}
+	return nil
+}
+
+func (sp *Socks5Plugin) Name() string {
+	return PluginSocks5
+}
+
+func (sp *Socks5Plugin) Close() error {
+	return nil
+}
@@ -39,7 +39,15 @@ func GetContent(m *msg.UdpPacket) (buf []byte, err error) {
 	return
 }
 
-func ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UdpPacket, sendCh chan<- *msg.UdpPacket) {
+// UserConn is where an udp proxy reads packets from users and writes the
+// responses to, *net.UDPConn implements it.
+type UserConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	Close() error
+}
+
+func ForwardUserConn(udpConn UserConn, readCh <-chan *msg.UdpPacket, sendCh chan<- *msg.UdpPacket) {
 	// read
 	go func() {
 		for udpMsg := range readCh {
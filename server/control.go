@@ -128,6 +128,9 @@ type Control struct {
 	// last time got the Ping message
 	lastPing time.Time
 
+	// closed when the next Pong message is received, for Probe
+	probeChs []chan struct{}
+
 	// A new run id will be generated when a new client login.
 	// If run id got from login message has same run id, it means it's the same client, so we can
 	// replace old controller instantly.
@@ -284,6 +287,56 @@ func (ctl *Control) GetWorkConn() (workConn net.Conn, err error) {
 	return
 }
 
+// Probe sends a Ping message to client over the control connection and waits
+// for the Pong message. Groups use it to check their members without taking
+// work connections.
+func (ctl *Control) Probe() (err error) {
+	ch := make(chan struct{})
+	ctl.mu.Lock()
+	ctl.probeChs = append(ctl.probeChs, ch)
+	ctl.mu.Unlock()
+	defer ctl.removeProbeCh(ch)
+
+	timeout := time.After(time.Duration(ctl.serverCfg.UserConnTimeout) * time.Second)
+	err = errors.PanicToError(func() {
+		select {
+		case ctl.sendCh <- &msg.Ping{}:
+		case <-timeout:
+		}
+	})
+	if err != nil {
+		return frpErr.ErrCtlClosed
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return fmt.Errorf("timeout waiting for pong from client")
+	}
+}
+
+func (ctl *Control) removeProbeCh(ch chan struct{}) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	for i, c := range ctl.probeChs {
+		if c == ch {
+			ctl.probeChs = append(ctl.probeChs[:i], ctl.probeChs[i+1:]...)
+			return
+		}
+	}
+}
+
+// handlePong finishes all running probes.
+func (ctl *Control) handlePong() {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	for _, ch := range ctl.probeChs {
+		close(ch)
+	}
+	ctl.probeChs = nil
+}
+
 func (ctl *Control) Replaced(newCtl *Control) {
 	xl := ctl.xl
 	xl.Info("Replaced by client [%s]", newCtl.runId)
@@ -534,6 +587,8 @@ func (ctl *Control) manager() {
 				ctl.lastPing = time.Now()
 				xl.Debug("receive heartbeat")
 				ctl.sendCh <- &msg.Pong{}
+			case *msg.Pong:
+				ctl.handlePong()
 			}
 		}
 	}
@@ -556,7 +611,7 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 
 	// NewProxy will return a interface Proxy.
 	// In fact it create different proxies by different proxy type, we just call run() here.
-	pxy, err := proxy.NewProxy(ctl.ctx, userInfo, ctl.rc, ctl.poolCount, ctl.GetWorkConn, pxyConf, ctl.serverCfg)
+	pxy, err := proxy.NewProxy(ctl.ctx, userInfo, ctl.rc, ctl.poolCount, ctl.GetWorkConn, ctl.Probe, pxyConf, ctl.serverCfg)
 	if err != nil {
 		return remoteAddr, err
 	}
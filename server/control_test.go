@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/config"
+	frpErr "github.com/fatedier/frp/models/errors"
+	"github.com/fatedier/frp/models/msg"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	serverCfg := config.GetDefaultServerConf()
+	serverCfg.UserConnTimeout = 1
+	ctl := &Control{
+		sendCh:    make(chan msg.Message, 10),
+		serverCfg: serverCfg,
+	}
+
+	// A Ping message is sent and the probe succeeds by the Pong message.
+	go func() {
+		m := <-ctl.sendCh
+		_, ok := m.(*msg.Ping)
+		assert.True(ok)
+		ctl.handlePong()
+	}()
+	assert.NoError(ctl.Probe())
+	assert.Len(ctl.probeChs, 0)
+
+	// No Pong message.
+	start := time.Now()
+	assert.Error(ctl.Probe())
+	assert.True(time.Since(start) >= time.Second)
+	assert.Len(ctl.probeChs, 0)
+	<-ctl.sendCh
+
+	close(ctl.sendCh)
+	assert.Equal(frpErr.ErrCtlClosed, ctl.Probe())
+}
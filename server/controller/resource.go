@@ -31,9 +31,15 @@ type ResourceController struct {
 	// Tcp Group Controller
 	TcpGroupCtl *group.TcpGroupCtl
 
+	// Udp Group Controller
+	UdpGroupCtl *group.UdpGroupCtl
+
 	// HTTP Group Controller
 	HTTPGroupCtl *group.HTTPGroupController
 
+	// HTTPS Group Controller
+	HTTPSGroupCtl *group.HTTPSGroupController
+
 	// TCP Mux Group Controller
 	TcpMuxGroupCtl *group.TcpMuxGroupCtl
 
@@ -49,6 +55,15 @@ type ResourceController struct {
 	// For https proxies, route requests to different clients by hostname and other information
 	VhostHttpsMuxer *vhost.HttpsMuxer
 
+	// For https proxies with tls termination, decrypt requests and forward them as http requests
+	HttpsTerminator *vhost.HttpsTerminator
+
+	// Cache responses of http proxies, shared by HttpReverseProxy and HttpsTerminator
+	HttpCache *vhost.HttpCache
+
+	// Domains of http proxies in maintenance mode
+	HttpMaintenance *vhost.Maintenance
+
 	// Controller for nat hole connections
 	NatHoleController *nathole.NatHoleController
 
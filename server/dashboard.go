@@ -48,7 +48,12 @@ func (svr *Service) RunDashboardServer(addr string, port int) (err error) {
 	router.HandleFunc("/api/serverinfo", svr.ApiServerInfo).Methods("GET")
 	router.HandleFunc("/api/proxy/{type}", svr.ApiProxyByType).Methods("GET")
 	router.HandleFunc("/api/proxy/{type}/{name}", svr.ApiProxyByTypeAndName).Methods("GET")
+	router.HandleFunc("/api/proxy/{type}/{name}/captures", svr.ApiProxyCaptures).Methods("GET", "DELETE")
+	router.HandleFunc("/api/proxy/{type}/{name}/captures/{id}/replay", svr.ApiReplayProxyCapture).Methods("POST")
 	router.HandleFunc("/api/traffic/{name}", svr.ApiProxyTraffic).Methods("GET")
+	router.HandleFunc("/api/cache", svr.ApiPurgeHttpCache).Methods("DELETE")
+	router.HandleFunc("/api/maintenance", svr.ApiMaintenance).Methods("GET")
+	router.HandleFunc("/api/maintenance/{domain}", svr.ApiDomainMaintenance).Methods("PUT", "DELETE")
 
 	// view
 	router.Handle("/favicon.ico", http.FileServer(assets.FileSystem)).Methods("GET")
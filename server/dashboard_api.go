@@ -16,13 +16,17 @@ package server
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/consts"
 	"github.com/fatedier/frp/models/metrics/mem"
 	"github.com/fatedier/frp/utils/log"
 	"github.com/fatedier/frp/utils/version"
+	"github.com/fatedier/frp/utils/vhost"
 
 	"github.com/gorilla/mux"
 )
@@ -117,6 +121,7 @@ type HttpOutConf struct {
 type HttpsOutConf struct {
 	BaseOutConf
 	config.DomainConf
+	TlsTermination bool `json:"tls_termination"`
 }
 
 type StcpOutConf struct {
@@ -335,3 +340,219 @@ func (svr *Service) ApiProxyTraffic(w http.ResponseWriter, r *http.Request) {
 	buf, _ := json.Marshal(&trafficResp)
 	res.Msg = string(buf)
 }
+
+// api/cache?domain=:domain&path=:path
+type PurgeHttpCacheResp struct {
+	Purged int `json:"purged"`
+}
+
+// ApiPurgeHttpCache removes cached http responses of the domain whose paths
+// start with path, all responses are removed if both are empty.
+func (svr *Service) ApiPurgeHttpCache(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+	log.Info("Http request: [%s]", r.URL.Path)
+
+	if svr.rc.HttpCache == nil {
+		res.Code = 404
+		res.Msg = "http cache is not enabled"
+		return
+	}
+
+	query := r.URL.Query()
+	purged := svr.rc.HttpCache.Purge(query.Get("domain"), query.Get("path"))
+	log.Info("purge http cache of domain [%s] path [%s]: %d responses", query.Get("domain"), query.Get("path"), purged)
+	buf, _ := json.Marshal(&PurgeHttpCacheResp{Purged: purged})
+	res.Msg = string(buf)
+}
+
+// api/maintenance
+type GetMaintenanceResp struct {
+	Domains []vhost.MaintenanceDomain `json:"domains"`
+}
+
+func (svr *Service) ApiMaintenance(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+	log.Info("Http request: [%s]", r.URL.Path)
+
+	buf, _ := json.Marshal(&GetMaintenanceResp{Domains: svr.rc.HttpMaintenance.List()})
+	res.Msg = string(buf)
+}
+
+// api/maintenance/:domain?retry_after=:seconds
+// PUT puts the domain into maintenance mode, DELETE brings it back.
+func (svr *Service) ApiDomainMaintenance(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	params := mux.Vars(r)
+	domain := params["domain"]
+
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+	log.Info("Http request: [%s]", r.URL.Path)
+
+	if r.Method == "DELETE" {
+		if !svr.rc.HttpMaintenance.Unset(domain) {
+			res.Code = 404
+			res.Msg = "domain is not in maintenance mode"
+			return
+		}
+		log.Info("domain [%s] leaves maintenance mode", domain)
+		return
+	}
+
+	retryAfter := svr.cfg.HttpMaintenanceRetryAfter
+	if tmpStr := r.URL.Query().Get("retry_after"); tmpStr != "" {
+		v, err := strconv.ParseInt(tmpStr, 10, 64)
+		if err != nil || v < 0 {
+			res.Code = 400
+			res.Msg = "invalid retry_after"
+			return
+		}
+		retryAfter = v
+	}
+	svr.rc.HttpMaintenance.Set(domain, time.Duration(retryAfter)*time.Second)
+	log.Info("domain [%s] enters maintenance mode", domain)
+}
+
+// api/proxy/:type/:name/captures
+type GetProxyCapturesResp struct {
+	Name     string           `json:"name"`
+	Captures []*vhost.Capture `json:"captures"`
+}
+
+// getProxyInspector returns the inspector of an online http or https proxy.
+func (svr *Service) getProxyInspector(proxyType string, name string) (inspector *vhost.Inspector, code int, msg string) {
+	pxy, ok := svr.pxyManager.GetByName(name)
+	if !ok || pxy.GetConf().GetBaseInfo().ProxyType != proxyType {
+		return nil, 404, "no proxy info found"
+	}
+	inspector = pxy.GetInspector()
+	if inspector == nil {
+		return nil, 404, "http_inspect is not enabled for this proxy"
+	}
+	return inspector, 200, ""
+}
+
+// GET lists captured requests and responses, DELETE removes them.
+func (svr *Service) ApiProxyCaptures(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	params := mux.Vars(r)
+	proxyType := params["type"]
+	name := params["name"]
+
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+	log.Info("Http request: [%s]", r.URL.Path)
+
+	inspector, code, msg := svr.getProxyInspector(proxyType, name)
+	if inspector == nil {
+		res.Code = code
+		res.Msg = msg
+		return
+	}
+	if r.Method == "DELETE" {
+		inspector.Clear()
+		return
+	}
+
+	buf, _ := json.Marshal(&GetProxyCapturesResp{
+		Name:     name,
+		Captures: inspector.List(),
+	})
+	res.Msg = string(buf)
+}
+
+// api/proxy/:type/:name/captures/:id/replay
+func (svr *Service) ApiReplayProxyCapture(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+	params := mux.Vars(r)
+	proxyType := params["type"]
+	name := params["name"]
+
+	defer func() {
+		log.Info("Http response [%s]: code [%d]", r.URL.Path, res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			w.Write([]byte(res.Msg))
+		}
+	}()
+	log.Info("Http request: [%s]", r.URL.Path)
+
+	inspector, code, msg := svr.getProxyInspector(proxyType, name)
+	if inspector == nil {
+		res.Code = code
+		res.Msg = msg
+		return
+	}
+	id, err := strconv.ParseUint(params["id"], 10, 64)
+	if err != nil {
+		res.Code = 400
+		res.Msg = "invalid capture id"
+		return
+	}
+	capture := inspector.Get(id)
+	if capture == nil {
+		res.Code = 404
+		res.Msg = "no capture found"
+		return
+	}
+
+	// the request is replayed by the proxy which captured it, even if it's
+	// in a load balancing group
+	var createConnFn vhost.CreateConnFunc
+	if pxy, ok := svr.pxyManager.GetByName(name); ok {
+		if p, ok := pxy.(interface {
+			GetRealConn(remoteAddr string) (net.Conn, error)
+		}); ok {
+			createConnFn = p.GetRealConn
+		}
+	}
+	if createConnFn == nil {
+		res.Code = 404
+		res.Msg = "no proxy info found"
+		return
+	}
+
+	var resp *vhost.CapturedResponse
+	switch {
+	case proxyType == consts.HttpProxy && svr.rc.HttpReverseProxy != nil:
+		resp, err = svr.rc.HttpReverseProxy.Replay(capture, createConnFn)
+	case proxyType == consts.HttpsProxy && svr.rc.HttpsTerminator != nil:
+		resp, err = svr.rc.HttpsTerminator.Replay(capture, createConnFn)
+	default:
+		res.Code = 400
+		res.Msg = "replay is not supported for this proxy"
+		return
+	}
+	if err != nil {
+		res.Code = 500
+		res.Msg = err.Error()
+		return
+	}
+	log.Info("replay request [%d] of proxy [%s]", id, name)
+	buf, _ := json.Marshal(resp)
+	res.Msg = string(buf)
+}
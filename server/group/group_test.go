@@ -0,0 +1,115 @@
+package group
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/server/ports"
+	"github.com/fatedier/frp/utils/vhost"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTcpGroupFailover(t *testing.T) {
+	assert := assert.New(t)
+	ctl := NewTcpGroupCtl(ports.NewPortManager("tcp", "127.0.0.1", map[int]struct{}{}), HealthCheckOptions{
+		MaxFails:  1,
+		EjectTime: time.Hour,
+	})
+
+	l1, _, err := ctl.Listen("a", "test", "key", "127.0.0.1", 0, nil)
+	assert.NoError(err)
+	defer l1.Close()
+	l2, _, err := ctl.Listen("b", "test", "key", "127.0.0.1", 0, nil)
+	assert.NoError(err)
+	defer l2.Close()
+
+	accept := func(l net.Listener) chan net.Conn {
+		ch := make(chan net.Conn, 2)
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				ch <- c
+			}
+		}()
+		return ch
+	}
+	ch1, ch2 := accept(l1), accept(l2)
+
+	userConn, err := net.Dial("tcp", l1.Addr().String())
+	assert.NoError(err)
+	defer userConn.Close()
+
+	var first, second chan net.Conn
+	var c net.Conn
+	select {
+	case c = <-ch1:
+		first, second = ch1, ch2
+	case c = <-ch2:
+		first, second = ch2, ch1
+	case <-time.After(time.Second):
+		t.Fatal("no member accepted the connection")
+	}
+
+	// work connection of the first member fails, the same user connection is
+	// dispatched to the other member
+	gc, ok := c.(MemberConn)
+	assert.True(ok)
+	assert.True(gc.Failover(errors.New("no work connection")))
+	select {
+	case c2 := <-second:
+		assert.Equal(c, c2)
+	case <-first:
+		t.Fatal("connection is dispatched to the failed member again")
+	case <-time.After(time.Second):
+		t.Fatal("connection is not dispatched to the next member")
+	}
+
+	// no member is left for this connection
+	assert.False(gc.Failover(errors.New("no work connection")))
+}
+
+func TestHTTPGroupRetryNextMember(t *testing.T) {
+	assert := assert.New(t)
+	ctl := NewHTTPGroupController(vhost.NewVhostRouters(), HealthCheckOptions{
+		MaxFails:  1,
+		EjectTime: time.Hour,
+	})
+
+	calls := make(map[string]int)
+	newRoute := func(name string, fail bool) vhost.VhostRouteConfig {
+		return vhost.VhostRouteConfig{
+			Domain: "example.com",
+			CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+				calls[name]++
+				if fail {
+					return nil, errors.New("no work connection")
+				}
+				c, _ := net.Pipe()
+				return c, nil
+			},
+		}
+	}
+	assert.NoError(ctl.Register("a", "test", "key", newRoute("a", true), nil))
+	assert.NoError(ctl.Register("b", "test", "key", newRoute("b", false), nil))
+
+	g := ctl.groups[httpGroupIndex("test", "example.com", "")]
+	for i := 0; i < 4; i++ {
+		conn, err := g.createConn("127.0.0.1:10000")
+		assert.NoError(err)
+		conn.Close()
+	}
+	// the failed member is tried once, then ejected
+	assert.Equal(1, calls["a"])
+	assert.Equal(4, calls["b"])
+	assert.False(g.health.isAvailable("a"))
+
+	ctl.UnRegister("b", "test", "example.com", "")
+	_, err := g.createConn("127.0.0.1:10000")
+	assert.Error(err)
+}
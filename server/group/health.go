@@ -0,0 +1,205 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package group
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/utils/log"
+)
+
+const maxEjectTimeMultiple = 10
+
+// HealthCheckOptions configures how a group detects failing members and skips
+// them when selecting where to route a new user connection.
+type HealthCheckOptions struct {
+	// MaxFails is the number of consecutive failures after which a member is
+	// ejected from selection. If it is 0, members are never ejected.
+	MaxFails int
+	// EjectTime is how long an ejected member will be skipped. It grows with
+	// each consecutive ejection of the same member, up to 10 times.
+	EjectTime time.Duration
+	// CheckInterval is the interval of active probes to all members. If it is
+	// 0, failures are only detected from user connections.
+	CheckInterval time.Duration
+}
+
+// ProbeFunc checks whether a group member is able to serve a new connection.
+type ProbeFunc func() error
+
+// MemberConn is implemented by user connections dispatched by a group. The
+// proxy handling the connection reports the result back, so the group can
+// track member failures and retry another member.
+type MemberConn interface {
+	// ReportSuccess marks the member serving this connection as healthy.
+	ReportSuccess()
+	// Failover records a failure of the current member and hands the
+	// connection to the next available one. If it returns false, no member is
+	// left and the caller is still responsible for closing the connection.
+	Failover(err error) bool
+}
+
+type memberStatus struct {
+	probe        ProbeFunc
+	fails        int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// memberHealth tracks the status of all members in one group.
+type memberHealth struct {
+	group   string
+	opts    HealthCheckOptions
+	members map[string]*memberStatus
+	closeCh chan struct{}
+	mu      sync.Mutex
+}
+
+func newMemberHealth(group string, opts HealthCheckOptions) *memberHealth {
+	mh := &memberHealth{
+		group:   group,
+		opts:    opts,
+		members: make(map[string]*memberStatus),
+		closeCh: make(chan struct{}),
+	}
+	if opts.CheckInterval > 0 {
+		go mh.checkWorker()
+	}
+	return mh
+}
+
+func (mh *memberHealth) add(name string, probe ProbeFunc) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	mh.members[name] = &memberStatus{
+		probe: probe,
+	}
+}
+
+func (mh *memberHealth) remove(name string) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	delete(mh.members, name)
+}
+
+func (mh *memberHealth) close() {
+	close(mh.closeCh)
+}
+
+func (mh *memberHealth) isAvailable(name string) bool {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	s, ok := mh.members[name]
+	if !ok {
+		return false
+	}
+	return !time.Now().Before(s.ejectedUntil)
+}
+
+func (mh *memberHealth) reportSuccess(name string) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	s, ok := mh.members[name]
+	if !ok {
+		return
+	}
+	if !s.ejectedUntil.IsZero() {
+		log.Info("group [%s] member [%s] is healthy again", mh.group, name)
+	}
+	s.fails = 0
+	s.ejections = 0
+	s.ejectedUntil = time.Time{}
+}
+
+func (mh *memberHealth) reportFailure(name string, err error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	s, ok := mh.members[name]
+	if !ok {
+		return
+	}
+	s.fails++
+	if mh.opts.MaxFails <= 0 || s.fails < mh.opts.MaxFails {
+		return
+	}
+
+	if s.ejections < maxEjectTimeMultiple {
+		s.ejections++
+	}
+	ejectTime := time.Duration(s.ejections) * mh.opts.EjectTime
+	s.fails = 0
+	s.ejectedUntil = time.Now().Add(ejectTime)
+	log.Warn("group [%s] member [%s] is ejected for %v, last error: %v", mh.group, name, ejectTime, err)
+}
+
+// pick selects a member from names in round robin order beginning at start,
+// skipping members in tried. Ejected members are only used if all other
+// members have been tried, so a fully ejected group still serves requests.
+func (mh *memberHealth) pick(names []string, start uint64, tried map[string]struct{}) (name string, ok bool) {
+	fallback := ""
+	for i := 0; i < len(names); i++ {
+		n := names[int((start+uint64(i))%uint64(len(names)))]
+		if _, ok := tried[n]; ok {
+			continue
+		}
+		if mh.isAvailable(n) {
+			return n, true
+		}
+		if fallback == "" {
+			fallback = n
+		}
+	}
+	if fallback != "" {
+		return fallback, true
+	}
+	return "", false
+}
+
+func (mh *memberHealth) checkWorker() {
+	ticker := time.NewTicker(mh.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mh.closeCh:
+			return
+		case <-ticker.C:
+		}
+
+		probes := make(map[string]ProbeFunc)
+		mh.mu.Lock()
+		for name, s := range mh.members {
+			if s.probe != nil {
+				probes[name] = s.probe
+			}
+		}
+		mh.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for name, probe := range probes {
+			wg.Add(1)
+			go func(name string, probe ProbeFunc) {
+				defer wg.Done()
+				if err := probe(); err != nil {
+					mh.reportFailure(name, err)
+				} else {
+					mh.reportSuccess(name)
+				}
+			}(name, probe)
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,56 @@
+package group
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemberHealthEject(t *testing.T) {
+	assert := assert.New(t)
+	mh := newMemberHealth("test", HealthCheckOptions{
+		MaxFails:  2,
+		EjectTime: time.Hour,
+	})
+	defer mh.close()
+	mh.add("a", nil)
+	mh.add("b", nil)
+	names := []string{"a", "b"}
+
+	mh.reportFailure("a", errors.New("failed"))
+	assert.True(mh.isAvailable("a"))
+	mh.reportFailure("a", errors.New("failed"))
+	assert.False(mh.isAvailable("a"))
+
+	// ejected member is skipped
+	for i := uint64(0); i < 4; i++ {
+		name, ok := mh.pick(names, i, nil)
+		assert.True(ok)
+		assert.Equal("b", name)
+	}
+
+	// ejected member is still used when no other member is left
+	name, ok := mh.pick(names, 0, map[string]struct{}{"b": {}})
+	assert.True(ok)
+	assert.Equal("a", name)
+
+	_, ok = mh.pick(names, 0, map[string]struct{}{"a": {}, "b": {}})
+	assert.False(ok)
+
+	mh.reportSuccess("a")
+	assert.True(mh.isAvailable("a"))
+}
+
+func TestMemberHealthNeverEject(t *testing.T) {
+	assert := assert.New(t)
+	mh := newMemberHealth("test", HealthCheckOptions{})
+	defer mh.close()
+	mh.add("a", nil)
+
+	for i := 0; i < 10; i++ {
+		mh.reportFailure("a", errors.New("failed"))
+	}
+	assert.True(mh.isAvailable("a"))
+}
@@ -14,18 +14,21 @@ type HTTPGroupController struct {
 
 	vhostRouter *vhost.VhostRouters
 
+	healthOpts HealthCheckOptions
+
 	mu sync.Mutex
 }
 
-func NewHTTPGroupController(vhostRouter *vhost.VhostRouters) *HTTPGroupController {
+func NewHTTPGroupController(vhostRouter *vhost.VhostRouters, healthOpts HealthCheckOptions) *HTTPGroupController {
 	return &HTTPGroupController{
 		groups:      make(map[string]*HTTPGroup),
 		vhostRouter: vhostRouter,
+		healthOpts:  healthOpts,
 	}
 }
 
 func (ctl *HTTPGroupController) Register(proxyName, group, groupKey string,
-	routeConfig vhost.VhostRouteConfig) (err error) {
+	routeConfig vhost.VhostRouteConfig, probe ProbeFunc) (err error) {
 
 	indexKey := httpGroupIndex(group, routeConfig.Domain, routeConfig.Location)
 	ctl.mu.Lock()
@@ -36,7 +39,7 @@ func (ctl *HTTPGroupController) Register(proxyName, group, groupKey string,
 	}
 	ctl.mu.Unlock()
 
-	return g.Register(proxyName, group, groupKey, routeConfig)
+	return g.Register(proxyName, group, groupKey, routeConfig, probe)
 }
 
 func (ctl *HTTPGroupController) UnRegister(proxyName, group, domain, location string) {
@@ -63,6 +66,7 @@ type HTTPGroup struct {
 	createFuncs map[string]vhost.CreateConnFunc
 	pxyNames    []string
 	index       uint64
+	health      *memberHealth
 	ctl         *HTTPGroupController
 	mu          sync.RWMutex
 }
@@ -76,7 +80,7 @@ func NewHTTPGroup(ctl *HTTPGroupController) *HTTPGroup {
 }
 
 func (g *HTTPGroup) Register(proxyName, group, groupKey string,
-	routeConfig vhost.VhostRouteConfig) (err error) {
+	routeConfig vhost.VhostRouteConfig, probe ProbeFunc) (err error) {
 
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -93,6 +97,7 @@ func (g *HTTPGroup) Register(proxyName, group, groupKey string,
 		g.groupKey = groupKey
 		g.domain = routeConfig.Domain
 		g.location = routeConfig.Location
+		g.health = newMemberHealth(group, g.ctl.healthOpts)
 	} else {
 		if g.group != group || g.domain != routeConfig.Domain || g.location != routeConfig.Location {
 			err = ErrGroupParamsInvalid
@@ -109,6 +114,7 @@ func (g *HTTPGroup) Register(proxyName, group, groupKey string,
 	}
 	g.createFuncs[proxyName] = routeConfig.CreateConnFn
 	g.pxyNames = append(g.pxyNames, proxyName)
+	g.health.add(proxyName, probe)
 	return nil
 }
 
@@ -122,33 +128,52 @@ func (g *HTTPGroup) UnRegister(proxyName string) (isEmpty bool) {
 			break
 		}
 	}
+	g.health.remove(proxyName)
 
 	if len(g.createFuncs) == 0 {
 		isEmpty = true
 		g.ctl.vhostRouter.Del(g.domain, g.location)
+		g.health.close()
 	}
 	return
 }
 
+// createConn selects members in round robin order, skipping ejected ones. If a
+// member fails to create a connection, the next one will be tried.
 func (g *HTTPGroup) createConn(remoteAddr string) (net.Conn, error) {
-	var f vhost.CreateConnFunc
+	var lastErr error
+	tried := make(map[string]struct{})
 	newIndex := atomic.AddUint64(&g.index, 1)
+	for {
+		var f vhost.CreateConnFunc
+
+		g.mu.RLock()
+		group := g.group
+		domain := g.domain
+		location := g.location
+		name, ok := g.health.pick(g.pxyNames, newIndex, tried)
+		if ok {
+			f = g.createFuncs[name]
+		}
+		g.mu.RUnlock()
 
-	g.mu.RLock()
-	group := g.group
-	domain := g.domain
-	location := g.location
-	if len(g.pxyNames) > 0 {
-		name := g.pxyNames[int(newIndex)%len(g.pxyNames)]
-		f, _ = g.createFuncs[name]
-	}
-	g.mu.RUnlock()
+		if f == nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, fmt.Errorf("no CreateConnFunc for http group [%s], domain [%s], location [%s]", group, domain, location)
+		}
+		tried[name] = struct{}{}
 
-	if f == nil {
-		return nil, fmt.Errorf("no CreateConnFunc for http group [%s], domain [%s], location [%s]", group, domain, location)
+		conn, err := f(remoteAddr)
+		if err != nil {
+			g.health.reportFailure(name, err)
+			lastErr = err
+			continue
+		}
+		g.health.reportSuccess(name)
+		return conn, nil
 	}
-
-	return f(remoteAddr)
 }
 
 func httpGroupIndex(group, domain, location string) string {
@@ -0,0 +1,296 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package group
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fatedier/frp/models/consts"
+	"github.com/fatedier/frp/utils/vhost"
+	"github.com/fatedier/frp/utils/xlog"
+)
+
+// HTTPSGroupController manage all HTTPSGroups
+type HTTPSGroupController struct {
+	groups map[string]*HTTPSGroup
+
+	// httpsMuxer routes connections by SNI
+	httpsMuxer *vhost.HttpsMuxer
+
+	// healthOpts is used by all groups to detect failing members
+	healthOpts HealthCheckOptions
+	mu         sync.Mutex
+}
+
+// NewHTTPSGroupController return a new HTTPSGroupController
+func NewHTTPSGroupController(httpsMuxer *vhost.HttpsMuxer, healthOpts HealthCheckOptions) *HTTPSGroupController {
+	return &HTTPSGroupController{
+		groups:     make(map[string]*HTTPSGroup),
+		httpsMuxer: httpsMuxer,
+		healthOpts: healthOpts,
+	}
+}
+
+// Listen is the wrapper for HTTPSGroup's Listen
+// If there are no group for this domain, we will create one here
+func (ctl *HTTPSGroupController) Listen(proxyName, group, groupKey, strategy string,
+	routeConfig vhost.VhostRouteConfig, probe ProbeFunc) (l net.Listener, err error) {
+
+	indexKey := httpsGroupIndex(group, routeConfig.Domain)
+	ctl.mu.Lock()
+	g, ok := ctl.groups[indexKey]
+	if !ok {
+		g = NewHTTPSGroup(ctl)
+		ctl.groups[indexKey] = g
+	}
+	ctl.mu.Unlock()
+
+	return g.Listen(proxyName, group, groupKey, strategy, routeConfig, probe)
+}
+
+// RemoveGroup remove HTTPSGroup from controller
+func (ctl *HTTPSGroupController) RemoveGroup(group, domain string) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	delete(ctl.groups, httpsGroupIndex(group, domain))
+}
+
+// HTTPSGroup route connections for one SNI domain to different proxies
+type HTTPSGroup struct {
+	group    string
+	groupKey string
+	domain   string
+	strategy string
+
+	index   uint64
+	vhostLn net.Listener
+	lns     []*HTTPSGroupListener
+	health  *memberHealth
+	ctl     *HTTPSGroupController
+	mu      sync.Mutex
+}
+
+// NewHTTPSGroup return a new HTTPSGroup
+func NewHTTPSGroup(ctl *HTTPSGroupController) *HTTPSGroup {
+	return &HTTPSGroup{
+		lns: make([]*HTTPSGroupListener, 0),
+		ctl: ctl,
+	}
+}
+
+// Listen will return a new HTTPSGroupListener
+// if HTTPSGroup already has a listener on https muxer, just add a new HTTPSGroupListener
+// otherwise, listen for the domain on https muxer
+func (g *HTTPSGroup) Listen(proxyName, group, groupKey, strategy string,
+	routeConfig vhost.VhostRouteConfig, probe ProbeFunc) (ln *HTTPSGroupListener, err error) {
+
+	if strategy == "" {
+		strategy = consts.RoundRobinGroupStrategy
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.lns) == 0 {
+		// the first listener, listen for the domain on https muxer
+		xl := xlog.New().AppendPrefix(group)
+		vhostLn, errRet := g.ctl.httpsMuxer.Listen(xlog.NewContext(context.Background(), xl), &routeConfig)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		ln = newHTTPSGroupListener(proxyName, g)
+
+		g.group = group
+		g.groupKey = groupKey
+		g.domain = routeConfig.Domain
+		g.strategy = strategy
+		g.vhostLn = vhostLn
+		g.lns = append(g.lns, ln)
+		g.health = newMemberHealth(group, g.ctl.healthOpts)
+		go g.worker()
+	} else {
+		if g.group != group || g.domain != routeConfig.Domain || g.strategy != strategy {
+			err = ErrGroupParamsInvalid
+			return
+		}
+		if g.groupKey != groupKey {
+			err = ErrGroupAuthFailed
+			return
+		}
+		for _, tmpLn := range g.lns {
+			if tmpLn.proxyName == proxyName {
+				err = ErrProxyRepeated
+				return
+			}
+		}
+		ln = newHTTPSGroupListener(proxyName, g)
+		g.lns = append(g.lns, ln)
+	}
+	g.health.add(proxyName, probe)
+	return
+}
+
+// worker is called when the listener on https muxer has been created
+func (g *HTTPSGroup) worker() {
+	for {
+		c, err := g.vhostLn.Accept()
+		if err != nil {
+			return
+		}
+		gc := &HTTPSGroupConn{
+			Conn:  c,
+			group: g,
+			tried: make(map[string]struct{}),
+		}
+		if !g.dispatch(gc) {
+			c.Close()
+		}
+	}
+}
+
+// start returns where to begin selecting a member for the connection.
+// With sticky strategy, connections from the same client ip always prefer
+// the same member.
+func (g *HTTPSGroup) start(c net.Conn) uint64 {
+	if g.strategy == consts.StickyGroupStrategy {
+		host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+		if err == nil {
+			h := fnv.New64a()
+			h.Write([]byte(host))
+			return h.Sum64()
+		}
+	}
+	return atomic.AddUint64(&g.index, 1)
+}
+
+// dispatch hands the connection to the next available member which has not
+// been tried for it yet. It returns false if there is no member left.
+func (g *HTTPSGroup) dispatch(c *HTTPSGroupConn) bool {
+	start := g.start(c)
+	for {
+		g.mu.Lock()
+		names := make([]string, 0, len(g.lns))
+		lns := make(map[string]*HTTPSGroupListener, len(g.lns))
+		for _, ln := range g.lns {
+			names = append(names, ln.proxyName)
+			lns[ln.proxyName] = ln
+		}
+		g.mu.Unlock()
+
+		if len(names) == 0 {
+			return false
+		}
+		name, ok := g.health.pick(names, start, c.tried)
+		if !ok {
+			return false
+		}
+		c.tried[name] = struct{}{}
+		c.member = name
+
+		ln := lns[name]
+		select {
+		case ln.acceptCh <- c:
+			return true
+		case <-ln.closeCh:
+			// this member is closing, try the next one
+		}
+	}
+}
+
+// CloseListener remove the HTTPSGroupListener from the HTTPSGroup
+func (g *HTTPSGroup) CloseListener(ln *HTTPSGroupListener) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, tmpLn := range g.lns {
+		if tmpLn == ln {
+			g.lns = append(g.lns[:i], g.lns[i+1:]...)
+			break
+		}
+	}
+	g.health.remove(ln.proxyName)
+	if len(g.lns) == 0 {
+		g.vhostLn.Close()
+		g.health.close()
+		g.ctl.RemoveGroup(g.group, g.domain)
+	}
+}
+
+// HTTPSGroupConn is a user connection dispatched by HTTPSGroup to one member.
+type HTTPSGroupConn struct {
+	net.Conn
+
+	group  *HTTPSGroup
+	member string
+	tried  map[string]struct{}
+}
+
+func (c *HTTPSGroupConn) ReportSuccess() {
+	c.group.health.reportSuccess(c.member)
+}
+
+func (c *HTTPSGroupConn) Failover(err error) bool {
+	c.group.health.reportFailure(c.member, err)
+	return c.group.dispatch(c)
+}
+
+// HTTPSGroupListener
+type HTTPSGroupListener struct {
+	proxyName string
+	group     *HTTPSGroup
+
+	acceptCh chan net.Conn
+	closeCh  chan struct{}
+}
+
+func newHTTPSGroupListener(proxyName string, group *HTTPSGroup) *HTTPSGroupListener {
+	return &HTTPSGroupListener{
+		proxyName: proxyName,
+		group:     group,
+		acceptCh:  make(chan net.Conn),
+		closeCh:   make(chan struct{}),
+	}
+}
+
+// Accept will accept connections dispatched to this listener by HTTPSGroup
+func (ln *HTTPSGroupListener) Accept() (c net.Conn, err error) {
+	select {
+	case <-ln.closeCh:
+		return nil, ErrListenerClosed
+	case c = <-ln.acceptCh:
+		return c, nil
+	}
+}
+
+func (ln *HTTPSGroupListener) Addr() net.Addr {
+	return (*net.TCPAddr)(nil)
+}
+
+// Close close the listener
+func (ln *HTTPSGroupListener) Close() (err error) {
+	close(ln.closeCh)
+
+	// remove self from HTTPSGroup
+	ln.group.CloseListener(ln)
+	return
+}
+
+func httpsGroupIndex(group, domain string) string {
+	return fmt.Sprintf("%s_%s", group, domain)
+}
@@ -0,0 +1,87 @@
+package group
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/consts"
+	"github.com/fatedier/frp/utils/vhost"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSGroupStrategy(t *testing.T) {
+	assert := assert.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer l.Close()
+	muxer, err := vhost.NewHttpsMuxer(l, 5*time.Second)
+	assert.NoError(err)
+	ctl := NewHTTPSGroupController(muxer, HealthCheckOptions{})
+
+	// accepted sends a client hello for the domain and returns the index of
+	// the member which gets it
+	acceptCh := make(chan int, 10)
+	acceptFrom := func(i int, ln net.Listener) {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+				acceptCh <- i
+			}
+		}()
+	}
+	accepted := func() int {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(err) {
+			return -1
+		}
+		defer c.Close()
+		go tls.Client(c, &tls.Config{ServerName: "example.com"}).Handshake()
+
+		select {
+		case i := <-acceptCh:
+			return i
+		case <-time.After(2 * time.Second):
+			return -1
+		}
+	}
+
+	routeConfig := vhost.VhostRouteConfig{Domain: "example.com"}
+	ln1, err := ctl.Listen("a", "test", "key", consts.StickyGroupStrategy, routeConfig, nil)
+	assert.NoError(err)
+	ln2, err := ctl.Listen("b", "test", "key", consts.StickyGroupStrategy, routeConfig, nil)
+	assert.NoError(err)
+	_, err = ctl.Listen("c", "test", "key", consts.RoundRobinGroupStrategy, routeConfig, nil)
+	assert.Equal(ErrGroupParamsInvalid, err)
+	acceptFrom(0, ln1)
+	acceptFrom(1, ln2)
+
+	first := accepted()
+	assert.NotEqual(-1, first)
+	for i := 0; i < 3; i++ {
+		assert.Equal(first, accepted())
+	}
+	ln1.Close()
+	ln2.Close()
+
+	// round robin is the default strategy
+	ln1, err = ctl.Listen("a", "test", "key", "", routeConfig, nil)
+	assert.NoError(err)
+	ln2, err = ctl.Listen("b", "test", "key", "", routeConfig, nil)
+	assert.NoError(err)
+	acceptFrom(0, ln1)
+	acceptFrom(1, ln2)
+	got := map[int]bool{}
+	for i := 0; i < 4; i++ {
+		got[accepted()] = true
+	}
+	assert.Equal(map[int]bool{0: true, 1: true}, got)
+	ln1.Close()
+	ln2.Close()
+}
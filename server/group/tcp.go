@@ -18,10 +18,9 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/fatedier/frp/server/ports"
-
-	gerr "github.com/fatedier/golib/errors"
 )
 
 // TcpGroupCtl manage all TcpGroups
@@ -30,21 +29,25 @@ type TcpGroupCtl struct {
 
 	// portManager is used to manage port
 	portManager *ports.PortManager
-	mu          sync.Mutex
+
+	// healthOpts is used by all groups to detect failing members
+	healthOpts HealthCheckOptions
+	mu         sync.Mutex
 }
 
 // NewTcpGroupCtl return a new TcpGroupCtl
-func NewTcpGroupCtl(portManager *ports.PortManager) *TcpGroupCtl {
+func NewTcpGroupCtl(portManager *ports.PortManager, healthOpts HealthCheckOptions) *TcpGroupCtl {
 	return &TcpGroupCtl{
 		groups:      make(map[string]*TcpGroup),
 		portManager: portManager,
+		healthOpts:  healthOpts,
 	}
 }
 
 // Listen is the wrapper for TcpGroup's Listen
 // If there are no group, we will create one here
 func (tgc *TcpGroupCtl) Listen(proxyName string, group string, groupKey string,
-	addr string, port int) (l net.Listener, realPort int, err error) {
+	addr string, port int, probe ProbeFunc) (l net.Listener, realPort int, err error) {
 
 	tgc.mu.Lock()
 	tcpGroup, ok := tgc.groups[group]
@@ -54,7 +57,7 @@ func (tgc *TcpGroupCtl) Listen(proxyName string, group string, groupKey string,
 	}
 	tgc.mu.Unlock()
 
-	return tcpGroup.Listen(proxyName, group, groupKey, addr, port)
+	return tcpGroup.Listen(proxyName, group, groupKey, addr, port, probe)
 }
 
 // RemoveGroup remove TcpGroup from controller
@@ -72,27 +75,28 @@ type TcpGroup struct {
 	port     int
 	realPort int
 
-	acceptCh chan net.Conn
-	index    uint64
-	tcpLn    net.Listener
-	lns      []*TcpGroupListener
-	ctl      *TcpGroupCtl
-	mu       sync.Mutex
+	index  uint64
+	tcpLn  net.Listener
+	lns    []*TcpGroupListener
+	health *memberHealth
+	ctl    *TcpGroupCtl
+	mu     sync.Mutex
 }
 
 // NewTcpGroup return a new TcpGroup
 func NewTcpGroup(ctl *TcpGroupCtl) *TcpGroup {
 	return &TcpGroup{
-		lns:      make([]*TcpGroupListener, 0),
-		ctl:      ctl,
-		acceptCh: make(chan net.Conn),
+		lns: make([]*TcpGroupListener, 0),
+		ctl: ctl,
 	}
 }
 
 // Listen will return a new TcpGroupListener
 // if TcpGroup already has a listener, just add a new TcpGroupListener to the queues
 // otherwise, listen on the real address
-func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr string, port int) (ln *TcpGroupListener, realPort int, err error) {
+func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr string, port int,
+	probe ProbeFunc) (ln *TcpGroupListener, realPort int, err error) {
+
 	tg.mu.Lock()
 	defer tg.mu.Unlock()
 	if len(tg.lns) == 0 {
@@ -106,7 +110,7 @@ func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr
 			err = errRet
 			return
 		}
-		ln = newTcpGroupListener(group, tg, tcpLn.Addr())
+		ln = newTcpGroupListener(group, proxyName, tg, tcpLn.Addr())
 
 		tg.group = group
 		tg.groupKey = groupKey
@@ -115,9 +119,7 @@ func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr
 		tg.realPort = realPort
 		tg.tcpLn = tcpLn
 		tg.lns = append(tg.lns, ln)
-		if tg.acceptCh == nil {
-			tg.acceptCh = make(chan net.Conn)
-		}
+		tg.health = newMemberHealth(group, tg.ctl.healthOpts)
 		go tg.worker()
 	} else {
 		// address and port in the same group must be equal
@@ -133,10 +135,17 @@ func (tg *TcpGroup) Listen(proxyName string, group string, groupKey string, addr
 			err = ErrGroupAuthFailed
 			return
 		}
-		ln = newTcpGroupListener(group, tg, tg.lns[0].Addr())
+		for _, tmpLn := range tg.lns {
+			if tmpLn.proxyName == proxyName {
+				err = ErrProxyRepeated
+				return
+			}
+		}
+		ln = newTcpGroupListener(group, proxyName, tg, tg.lns[0].Addr())
 		realPort = tg.realPort
 		tg.lns = append(tg.lns, ln)
 	}
+	tg.health.add(proxyName, probe)
 	return
 }
 
@@ -147,17 +156,48 @@ func (tg *TcpGroup) worker() {
 		if err != nil {
 			return
 		}
-		err = gerr.PanicToError(func() {
-			tg.acceptCh <- c
-		})
-		if err != nil {
-			return
+		gc := &TcpGroupConn{
+			Conn:  c,
+			group: tg,
+			tried: make(map[string]struct{}),
+		}
+		if !tg.dispatch(gc) {
+			c.Close()
 		}
 	}
 }
 
-func (tg *TcpGroup) Accept() <-chan net.Conn {
-	return tg.acceptCh
+// dispatch hands the connection to the next available member which has not
+// been tried for it yet. It returns false if there is no member left.
+func (tg *TcpGroup) dispatch(c *TcpGroupConn) bool {
+	for {
+		tg.mu.Lock()
+		names := make([]string, 0, len(tg.lns))
+		lns := make(map[string]*TcpGroupListener, len(tg.lns))
+		for _, ln := range tg.lns {
+			names = append(names, ln.proxyName)
+			lns[ln.proxyName] = ln
+		}
+		tg.mu.Unlock()
+
+		if len(names) == 0 {
+			return false
+		}
+		name, ok := tg.health.pick(names, atomic.AddUint64(&tg.index, 1), c.tried)
+		if !ok {
+			return false
+		}
+		c.tried[name] = struct{}{}
+		c.member = name
+
+		ln := lns[name]
+		select {
+		case ln.acceptCh <- c:
+			return true
+		case <-ln.closeCh:
+			// this member is closing, try the next one
+		}
+	}
 }
 
 // CloseListener remove the TcpGroupListener from the TcpGroup
@@ -170,42 +210,61 @@ func (tg *TcpGroup) CloseListener(ln *TcpGroupListener) {
 			break
 		}
 	}
+	tg.health.remove(ln.proxyName)
 	if len(tg.lns) == 0 {
-		close(tg.acceptCh)
 		tg.tcpLn.Close()
+		tg.health.close()
 		tg.ctl.portManager.Release(tg.realPort)
 		tg.ctl.RemoveGroup(tg.group)
 	}
 }
 
+// TcpGroupConn is a user connection dispatched by TcpGroup to one member.
+type TcpGroupConn struct {
+	net.Conn
+
+	group  *TcpGroup
+	member string
+	tried  map[string]struct{}
+}
+
+func (c *TcpGroupConn) ReportSuccess() {
+	c.group.health.reportSuccess(c.member)
+}
+
+func (c *TcpGroupConn) Failover(err error) bool {
+	c.group.health.reportFailure(c.member, err)
+	return c.group.dispatch(c)
+}
+
 // TcpGroupListener
 type TcpGroupListener struct {
 	groupName string
+	proxyName string
 	group     *TcpGroup
 
-	addr    net.Addr
-	closeCh chan struct{}
+	addr     net.Addr
+	acceptCh chan net.Conn
+	closeCh  chan struct{}
 }
 
-func newTcpGroupListener(name string, group *TcpGroup, addr net.Addr) *TcpGroupListener {
+func newTcpGroupListener(name string, proxyName string, group *TcpGroup, addr net.Addr) *TcpGroupListener {
 	return &TcpGroupListener{
 		groupName: name,
+		proxyName: proxyName,
 		group:     group,
 		addr:      addr,
+		acceptCh:  make(chan net.Conn),
 		closeCh:   make(chan struct{}),
 	}
 }
 
-// Accept will accept connections from TcpGroup
+// Accept will accept connections dispatched to this listener by TcpGroup
 func (ln *TcpGroupListener) Accept() (c net.Conn, err error) {
-	var ok bool
 	select {
 	case <-ln.closeCh:
 		return nil, ErrListenerClosed
-	case c, ok = <-ln.group.Accept():
-		if !ok {
-			return nil, ErrListenerClosed
-		}
+	case c = <-ln.acceptCh:
 		return c, nil
 	}
 }
@@ -0,0 +1,297 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package group
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/server/ports"
+
+	"github.com/fatedier/golib/pool"
+)
+
+// packets from one source address are routed to the same member until the
+// flow has been idle for this long
+const udpFlowIdleTimeout = 60 * time.Second
+
+// UdpGroupCtl manage all UdpGroups
+type UdpGroupCtl struct {
+	groups map[string]*UdpGroup
+
+	// portManager is used to manage port
+	portManager *ports.PortManager
+	mu          sync.Mutex
+}
+
+// NewUdpGroupCtl return a new UdpGroupCtl
+func NewUdpGroupCtl(portManager *ports.PortManager) *UdpGroupCtl {
+	return &UdpGroupCtl{
+		groups:      make(map[string]*UdpGroup),
+		portManager: portManager,
+	}
+}
+
+// Listen is the wrapper for UdpGroup's Listen
+// If there are no group, we will create one here
+func (ugc *UdpGroupCtl) Listen(proxyName string, group string, groupKey string,
+	addr string, port int) (m *UdpGroupMember, realPort int, err error) {
+
+	ugc.mu.Lock()
+	udpGroup, ok := ugc.groups[group]
+	if !ok {
+		udpGroup = NewUdpGroup(ugc)
+		ugc.groups[group] = udpGroup
+	}
+	ugc.mu.Unlock()
+
+	return udpGroup.Listen(proxyName, group, groupKey, addr, port)
+}
+
+// RemoveGroup remove UdpGroup from controller
+func (ugc *UdpGroupCtl) RemoveGroup(group string) {
+	ugc.mu.Lock()
+	defer ugc.mu.Unlock()
+	delete(ugc.groups, group)
+}
+
+type udpFlow struct {
+	member     *UdpGroupMember
+	lastActive time.Time
+}
+
+// UdpGroup route packets to different proxies, all packets from the same
+// source address are sent to the same proxy while it stays in the group
+type UdpGroup struct {
+	group    string
+	groupKey string
+	addr     string
+	port     int
+	realPort int
+
+	index       uint64
+	udpConn     *net.UDPConn
+	members     []*UdpGroupMember
+	flows       map[string]*udpFlow
+	lastCleanup time.Time
+	ctl         *UdpGroupCtl
+	mu          sync.Mutex
+}
+
+// NewUdpGroup return a new UdpGroup
+func NewUdpGroup(ctl *UdpGroupCtl) *UdpGroup {
+	return &UdpGroup{
+		members: make([]*UdpGroupMember, 0),
+		flows:   make(map[string]*udpFlow),
+		ctl:     ctl,
+	}
+}
+
+// Listen will return a new UdpGroupMember
+// if UdpGroup already has a udp listener, just add a new member to it
+// otherwise, listen on the real address
+func (ug *UdpGroup) Listen(proxyName string, group string, groupKey string,
+	addr string, port int) (m *UdpGroupMember, realPort int, err error) {
+
+	ug.mu.Lock()
+	defer ug.mu.Unlock()
+	if len(ug.members) == 0 {
+		// the first member, listen on the real address
+		realPort, err = ug.ctl.portManager.Acquire(proxyName, port)
+		if err != nil {
+			return
+		}
+		udpAddr, errRet := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", addr, realPort))
+		if errRet != nil {
+			ug.ctl.portManager.Release(realPort)
+			err = errRet
+			return
+		}
+		udpConn, errRet := net.ListenUDP("udp", udpAddr)
+		if errRet != nil {
+			ug.ctl.portManager.Release(realPort)
+			err = errRet
+			return
+		}
+		m = newUdpGroupMember(proxyName, ug)
+
+		ug.group = group
+		ug.groupKey = groupKey
+		ug.addr = addr
+		ug.port = port
+		ug.realPort = realPort
+		ug.udpConn = udpConn
+		ug.members = append(ug.members, m)
+		go ug.worker(udpConn)
+	} else {
+		// address and port in the same group must be equal
+		if ug.group != group || ug.addr != addr {
+			err = ErrGroupParamsInvalid
+			return
+		}
+		if ug.port != port {
+			err = ErrGroupDifferentPort
+			return
+		}
+		if ug.groupKey != groupKey {
+			err = ErrGroupAuthFailed
+			return
+		}
+		for _, tmpM := range ug.members {
+			if tmpM.proxyName == proxyName {
+				err = ErrProxyRepeated
+				return
+			}
+		}
+		m = newUdpGroupMember(proxyName, ug)
+		realPort = ug.realPort
+		ug.members = append(ug.members, m)
+	}
+	return
+}
+
+// worker is called when the real udp listener has been created
+func (ug *UdpGroup) worker(udpConn *net.UDPConn) {
+	buf := pool.GetBuf(1500)
+	defer pool.PutBuf(buf)
+	for {
+		n, remoteAddr, err := udpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if m := ug.route(remoteAddr); m != nil {
+			m.deliver(buf[:n], remoteAddr)
+		}
+	}
+}
+
+// route returns the member which packets from remoteAddr should be sent to.
+// New flows and flows whose member has left the group are assigned to the
+// next member in round robin order.
+func (ug *UdpGroup) route(remoteAddr *net.UDPAddr) *UdpGroupMember {
+	ug.mu.Lock()
+	defer ug.mu.Unlock()
+	if len(ug.members) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Sub(ug.lastCleanup) > udpFlowIdleTimeout {
+		for key, flow := range ug.flows {
+			if now.Sub(flow.lastActive) > udpFlowIdleTimeout {
+				delete(ug.flows, key)
+			}
+		}
+		ug.lastCleanup = now
+	}
+
+	key := remoteAddr.String()
+	flow, ok := ug.flows[key]
+	if !ok {
+		ug.index++
+		flow = &udpFlow{
+			member: ug.members[int(ug.index%uint64(len(ug.members)))],
+		}
+		ug.flows[key] = flow
+	}
+	flow.lastActive = now
+	return flow.member
+}
+
+// closeMember remove the UdpGroupMember from the UdpGroup
+// flows of this member will be routed to other members
+func (ug *UdpGroup) closeMember(m *UdpGroupMember) {
+	ug.mu.Lock()
+	defer ug.mu.Unlock()
+	for i, tmpM := range ug.members {
+		if tmpM == m {
+			ug.members = append(ug.members[:i], ug.members[i+1:]...)
+			break
+		}
+	}
+	for key, flow := range ug.flows {
+		if flow.member == m {
+			delete(ug.flows, key)
+		}
+	}
+	if len(ug.members) == 0 {
+		ug.udpConn.Close()
+		ug.ctl.portManager.Release(ug.realPort)
+		ug.ctl.RemoveGroup(ug.group)
+	}
+}
+
+type udpGroupPacket struct {
+	buf  []byte
+	addr *net.UDPAddr
+}
+
+// UdpGroupMember is the view of the shared udp port for one proxy in the group.
+// It only reads packets routed to this proxy.
+type UdpGroupMember struct {
+	proxyName string
+	group     *UdpGroup
+
+	packetCh  chan *udpGroupPacket
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func newUdpGroupMember(proxyName string, group *UdpGroup) *UdpGroupMember {
+	return &UdpGroupMember{
+		proxyName: proxyName,
+		group:     group,
+		packetCh:  make(chan *udpGroupPacket, 1024),
+		closeCh:   make(chan struct{}),
+	}
+}
+
+// deliver queues a packet for this member, it will be dropped if the queue is full
+func (m *UdpGroupMember) deliver(buf []byte, addr *net.UDPAddr) {
+	p := &udpGroupPacket{
+		buf:  append([]byte(nil), buf...),
+		addr: addr,
+	}
+	select {
+	case m.packetCh <- p:
+	default:
+	}
+}
+
+// ReadFromUDP reads the next packet routed to this member
+func (m *UdpGroupMember) ReadFromUDP(b []byte) (n int, addr *net.UDPAddr, err error) {
+	select {
+	case <-m.closeCh:
+		return 0, nil, ErrListenerClosed
+	case p := <-m.packetCh:
+		return copy(b, p.buf), p.addr, nil
+	}
+}
+
+// WriteToUDP sends a packet to the user through the shared udp port
+func (m *UdpGroupMember) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	return m.group.udpConn.WriteToUDP(b, addr)
+}
+
+// Close remove self from UdpGroup
+func (m *UdpGroupMember) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closeCh)
+		m.group.closeMember(m)
+	})
+	return nil
+}
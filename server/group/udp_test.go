@@ -0,0 +1,80 @@
+package group
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/server/ports"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUdpGroupFlowAffinity(t *testing.T) {
+	assert := assert.New(t)
+	ctl := NewUdpGroupCtl(ports.NewPortManager("udp", "127.0.0.1", map[int]struct{}{}))
+
+	m1, port, err := ctl.Listen("a", "test", "key", "127.0.0.1", 0)
+	assert.NoError(err)
+	m2, port2, err := ctl.Listen("b", "test", "key", "127.0.0.1", 0)
+	assert.NoError(err)
+	assert.Equal(port, port2)
+	_, _, err = ctl.Listen("c", "test", "wrong", "127.0.0.1", 0)
+	assert.Equal(ErrGroupAuthFailed, err)
+
+	readFrom := func(m *UdpGroupMember) chan string {
+		ch := make(chan string, 10)
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				n, _, err := m.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				ch <- string(buf[:n])
+			}
+		}()
+		return ch
+	}
+	ch1, ch2 := readFrom(m1), readFrom(m2)
+
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	assert.NoError(err)
+	defer conn.Close()
+
+	recv := func() (from *UdpGroupMember, content string) {
+		select {
+		case content = <-ch1:
+			return m1, content
+		case content = <-ch2:
+			return m2, content
+		case <-time.After(time.Second):
+			return nil, ""
+		}
+	}
+
+	// all packets from one source go to the same member
+	conn.Write([]byte("1"))
+	first, content := recv()
+	assert.NotNil(first)
+	assert.Equal("1", content)
+	for i := 0; i < 3; i++ {
+		conn.Write([]byte("2"))
+		m, _ := recv()
+		assert.Equal(first, m)
+	}
+
+	// flow is moved to the other member after its member leaves
+	first.Close()
+	conn.Write([]byte("3"))
+	m, content := recv()
+	assert.NotNil(m)
+	assert.NotEqual(first, m)
+	assert.Equal("3", content)
+
+	m.Close()
+	ctl.mu.Lock()
+	assert.Len(ctl.groups, 0)
+	ctl.mu.Unlock()
+}
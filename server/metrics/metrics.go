@@ -13,6 +13,9 @@ type ServerMetrics interface {
 	CloseConnection(name string, proxyType string)
 	AddTrafficIn(name string, proxyType string, trafficBytes int64)
 	AddTrafficOut(name string, proxyType string, trafficBytes int64)
+	// AddXtcpConnection counts connections of xtcp proxies by path, "p2p" for
+	// nat hole attempts and "relay" for connections relayed by frps.
+	AddXtcpConnection(name string, path string)
 }
 
 var Server ServerMetrics = noopServerMetrics{}
@@ -36,3 +39,4 @@ func (noopServerMetrics) OpenConnection(name string, proxyType string)
 func (noopServerMetrics) CloseConnection(name string, proxyType string)                   {}
 func (noopServerMetrics) AddTrafficIn(name string, proxyType string, trafficBytes int64)  {}
 func (noopServerMetrics) AddTrafficOut(name string, proxyType string, trafficBytes int64) {}
+func (noopServerMetrics) AddXtcpConnection(name string, path string)                      {}
@@ -37,13 +37,11 @@ type HttpProxy struct {
 
 func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 	xl := pxy.xl
-	routeConfig := vhost.VhostRouteConfig{
-		RewriteHost:  pxy.cfg.HostHeaderRewrite,
-		Headers:      pxy.cfg.Headers,
-		Username:     pxy.cfg.HttpUser,
-		Password:     pxy.cfg.HttpPwd,
-		CreateConnFn: pxy.GetRealConn,
+	routeConfig, err := newHttpRouteConfig(&pxy.cfg.HttpRouteConf, &pxy.cfg.LimitConf, pxy.GetRealConn)
+	if err != nil {
+		return
 	}
+	pxy.inspector = routeConfig.Inspector
 
 	locations := pxy.cfg.Locations
 	if len(locations) == 0 {
@@ -70,7 +68,7 @@ func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 
 			// handle group
 			if pxy.cfg.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, routeConfig, pxy.probe)
 				if err != nil {
 					return
 				}
@@ -88,6 +86,11 @@ func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 					pxy.rc.HttpReverseProxy.UnRegister(tmpDomain, tmpLocation)
 				})
 			}
+			tmpRouteConfig := routeConfig
+			pxy.closeFuncs = append(pxy.closeFuncs, func() {
+				// visitors get maintenance pages instead of 404 for a while
+				pxy.rc.HttpReverseProxy.AddTombstone(tmpRouteConfig)
+			})
 			addrs = append(addrs, util.CanonicalAddr(routeConfig.Domain, int(pxy.serverCfg.VhostHttpPort)))
 			xl.Info("http proxy listen for host [%s] location [%s] group [%s]", routeConfig.Domain, routeConfig.Location, pxy.cfg.Group)
 		}
@@ -102,7 +105,7 @@ func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 
 			// handle group
 			if pxy.cfg.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, routeConfig, pxy.probe)
 				if err != nil {
 					return
 				}
@@ -119,6 +122,11 @@ func (pxy *HttpProxy) Run() (remoteAddr string, err error) {
 					pxy.rc.HttpReverseProxy.UnRegister(tmpDomain, tmpLocation)
 				})
 			}
+			tmpRouteConfig := routeConfig
+			pxy.closeFuncs = append(pxy.closeFuncs, func() {
+				// visitors get maintenance pages instead of 404 for a while
+				pxy.rc.HttpReverseProxy.AddTombstone(tmpRouteConfig)
+			})
 			addrs = append(addrs, util.CanonicalAddr(tmpDomain, pxy.serverCfg.VhostHttpPort))
 
 			xl.Info("http proxy listen for host [%s] location [%s] group [%s]", routeConfig.Domain, routeConfig.Location, pxy.cfg.Group)
@@ -133,6 +141,66 @@ func (pxy *HttpProxy) GetConf() config.ProxyConf {
 }
 
 func (pxy *HttpProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err error) {
+	return pxy.getHttpWorkConn(&pxy.cfg.BaseProxyConf, remoteAddr)
+}
+
+// newHttpRouteConfig creates the route config registered to http reverse proxy
+func newHttpRouteConfig(cfg *config.HttpRouteConf, limitCfg *config.LimitConf,
+	createConnFn vhost.CreateConnFunc) (routeConfig vhost.VhostRouteConfig, err error) {
+	pathRewrites := make([]vhost.PathRewriteRule, 0, len(cfg.PathRewrites))
+	for _, rule := range cfg.PathRewrites {
+		pattern, replacement, errRet := config.ParsePathRewrite(rule)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		pathRewrites = append(pathRewrites, vhost.PathRewriteRule{
+			Pattern:     pattern,
+			Replacement: replacement,
+		})
+	}
+
+	routeConfig = vhost.VhostRouteConfig{
+		RewriteHost:            cfg.HostHeaderRewrite,
+		Headers:                cfg.Headers,
+		Username:               cfg.HttpUser,
+		Password:               cfg.HttpPwd,
+		ResponseHeaders:        cfg.ResponseHeaders,
+		RemoveRequestHeaders:   cfg.RemoveRequestHeaders,
+		RemoveResponseHeaders:  cfg.RemoveResponseHeaders,
+		DisableXForwardedFor:   cfg.DisableXForwardedFor,
+		DisableXForwardedProto: cfg.DisableXForwardedProto,
+		AddXRealIp:             cfg.AddXRealIp,
+		LocalHttp2:             cfg.LocalHttp2,
+		Cache:                  cfg.HttpCache,
+		Compression:            cfg.HttpCompression,
+		StripPrefix:            cfg.StripPrefix,
+		PathRewrites:           pathRewrites,
+		AddPrefix:              cfg.AddPrefix,
+		ErrorPages:             cfg.ErrorPages,
+
+		Users:                      cfg.HttpUsers,
+		BearerTokens:               cfg.HttpBearerTokens,
+		ForwardAuthUrl:             cfg.HttpForwardAuthUrl,
+		ForwardAuthResponseHeaders: cfg.HttpForwardAuthResponseHeaders,
+
+		// the limiter and the inspector are shared by all domains and
+		// locations of this proxy
+		Limiter:   newConnLimiter(limitCfg),
+		Inspector: vhost.NewInspector(cfg.HttpInspect),
+
+		CreateConnFn: createConnFn,
+	}
+	if cfg.HttpOidcIssuer != "" {
+		routeConfig.Oidc = vhost.NewOidcAuth(cfg.HttpOidcIssuer, cfg.HttpOidcClientId,
+			cfg.HttpOidcClientSecret, cfg.HttpOidcAllowedEmails)
+	}
+	return
+}
+
+// getHttpWorkConn gets a work connection for http requests forwarded by frps,
+// it's used by http proxies and https proxies with tls termination enabled.
+func (pxy *BaseProxy) getHttpWorkConn(cfg *config.BaseProxyConf, remoteAddr string) (workConn net.Conn, err error) {
 	xl := pxy.xl
 	rAddr, errRet := net.ResolveTCPAddr("tcp", remoteAddr)
 	if errRet != nil {
@@ -147,30 +215,26 @@ func (pxy *HttpProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err err
 	}
 
 	var rwc io.ReadWriteCloser = tmpConn
-	if pxy.cfg.UseEncryption {
+	if cfg.UseEncryption {
 		rwc, err = frpIo.WithEncryption(rwc, []byte(pxy.serverCfg.Token))
 		if err != nil {
 			xl.Error("create encryption stream error: %v", err)
 			return
 		}
 	}
-	if pxy.cfg.UseCompression {
+	if cfg.UseCompression {
 		rwc = frpIo.WithCompression(rwc)
 	}
 	workConn = frpNet.WrapReadWriteCloserToConn(rwc, tmpConn)
-	workConn = frpNet.WrapStatsConn(workConn, pxy.updateStatsAfterClosedConn)
-	metrics.Server.OpenConnection(pxy.GetName(), pxy.GetConf().GetBaseInfo().ProxyType)
+	workConn = frpNet.WrapStatsConn(workConn, func(totalRead, totalWrite int64) {
+		metrics.Server.CloseConnection(pxy.name, cfg.ProxyType)
+		metrics.Server.AddTrafficIn(pxy.name, cfg.ProxyType, totalWrite)
+		metrics.Server.AddTrafficOut(pxy.name, cfg.ProxyType, totalRead)
+	})
+	metrics.Server.OpenConnection(pxy.name, cfg.ProxyType)
 	return
 }
 
-func (pxy *HttpProxy) updateStatsAfterClosedConn(totalRead, totalWrite int64) {
-	name := pxy.GetName()
-	proxyType := pxy.GetConf().GetBaseInfo().ProxyType
-	metrics.Server.CloseConnection(name, proxyType)
-	metrics.Server.AddTrafficIn(name, proxyType, totalWrite)
-	metrics.Server.AddTrafficOut(name, proxyType, totalRead)
-}
-
 func (pxy *HttpProxy) Close() {
 	pxy.BaseProxy.Close()
 	for _, closeFn := range pxy.closeFuncs {
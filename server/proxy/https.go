@@ -15,6 +15,7 @@
 package proxy
 
 import (
+	"net"
 	"strings"
 
 	"github.com/fatedier/frp/models/config"
@@ -25,10 +26,15 @@ import (
 type HttpsProxy struct {
 	*BaseProxy
 	cfg *config.HttpsProxyConf
+
+	closeFuncs []func()
 }
 
 func (pxy *HttpsProxy) Run() (remoteAddr string, err error) {
-	xl := pxy.xl
+	if pxy.cfg.TlsTermination {
+		return pxy.runTlsTermination()
+	}
+
 	routeConfig := &vhost.VhostRouteConfig{}
 
 	defer func() {
@@ -43,24 +49,22 @@ func (pxy *HttpsProxy) Run() (remoteAddr string, err error) {
 		}
 
 		routeConfig.Domain = domain
-		l, errRet := pxy.rc.VhostHttpsMuxer.Listen(pxy.ctx, routeConfig)
+		l, errRet := pxy.listen(routeConfig)
 		if errRet != nil {
 			err = errRet
 			return
 		}
-		xl.Info("https proxy listen for host [%s]", routeConfig.Domain)
 		pxy.listeners = append(pxy.listeners, l)
 		addrs = append(addrs, util.CanonicalAddr(routeConfig.Domain, pxy.serverCfg.VhostHttpsPort))
 	}
 
 	if pxy.cfg.SubDomain != "" {
 		routeConfig.Domain = pxy.cfg.SubDomain + "." + pxy.serverCfg.SubDomainHost
-		l, errRet := pxy.rc.VhostHttpsMuxer.Listen(pxy.ctx, routeConfig)
+		l, errRet := pxy.listen(routeConfig)
 		if errRet != nil {
 			err = errRet
 			return
 		}
-		xl.Info("https proxy listen for host [%s]", routeConfig.Domain)
 		pxy.listeners = append(pxy.listeners, l)
 		addrs = append(addrs, util.CanonicalAddr(routeConfig.Domain, int(pxy.serverCfg.VhostHttpsPort)))
 	}
@@ -70,10 +74,93 @@ func (pxy *HttpsProxy) Run() (remoteAddr string, err error) {
 	return
 }
 
+// listen registers the domain on https muxer directly, or through the https
+// group if this proxy is in a group.
+func (pxy *HttpsProxy) listen(routeConfig *vhost.VhostRouteConfig) (net.Listener, error) {
+	xl := pxy.xl
+	if pxy.cfg.Group != "" {
+		l, err := pxy.rc.HTTPSGroupCtl.Listen(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, pxy.cfg.GroupStrategy,
+			*routeConfig, pxy.probe)
+		if err != nil {
+			return nil, err
+		}
+		xl.Info("https proxy listen for host [%s] group [%s]", routeConfig.Domain, pxy.cfg.Group)
+		return l, nil
+	}
+
+	l, err := pxy.rc.VhostHttpsMuxer.Listen(pxy.ctx, routeConfig)
+	if err != nil {
+		return nil, err
+	}
+	xl.Info("https proxy listen for host [%s]", routeConfig.Domain)
+	return l, nil
+}
+
+// runTlsTermination registers all domains to https terminator of frps, https
+// requests are decrypted by frps and forwarded to this proxy as http requests.
+func (pxy *HttpsProxy) runTlsTermination() (remoteAddr string, err error) {
+	xl := pxy.xl
+	routeConfig, err := newHttpRouteConfig(&pxy.cfg.HttpRouteConf, &pxy.cfg.LimitConf, pxy.GetRealConn)
+	if err != nil {
+		return
+	}
+	pxy.inspector = routeConfig.Inspector
+
+	locations := pxy.cfg.Locations
+	if len(locations) == 0 {
+		locations = []string{""}
+	}
+
+	defer func() {
+		if err != nil {
+			pxy.Close()
+		}
+	}()
+
+	domains := make([]string, 0)
+	for _, domain := range pxy.cfg.CustomDomains {
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if pxy.cfg.SubDomain != "" {
+		domains = append(domains, pxy.cfg.SubDomain+"."+pxy.serverCfg.SubDomainHost)
+	}
+
+	addrs := make([]string, 0)
+	for _, domain := range domains {
+		routeConfig.Domain = domain
+		for _, location := range locations {
+			routeConfig.Location = location
+			tmpDomain := routeConfig.Domain
+			tmpLocation := routeConfig.Location
+
+			err = pxy.rc.HttpsTerminator.Register(pxy.ctx, routeConfig)
+			if err != nil {
+				return
+			}
+			pxy.closeFuncs = append(pxy.closeFuncs, func() {
+				pxy.rc.HttpsTerminator.UnRegister(tmpDomain, tmpLocation)
+			})
+			addrs = append(addrs, util.CanonicalAddr(routeConfig.Domain, pxy.serverCfg.VhostHttpsPort))
+			xl.Info("https proxy listen for host [%s] location [%s] with tls termination", routeConfig.Domain, routeConfig.Location)
+		}
+	}
+	remoteAddr = strings.Join(addrs, ",")
+	return
+}
+
+func (pxy *HttpsProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err error) {
+	return pxy.getHttpWorkConn(&pxy.cfg.BaseProxyConf, remoteAddr)
+}
+
 func (pxy *HttpsProxy) GetConf() config.ProxyConf {
 	return pxy.cfg
 }
 
 func (pxy *HttpsProxy) Close() {
 	pxy.BaseProxy.Close()
+	for _, closeFn := range pxy.closeFuncs {
+		closeFn()
+	}
 }
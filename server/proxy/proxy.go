@@ -26,8 +26,11 @@ import (
 	"github.com/fatedier/frp/models/msg"
 	plugin "github.com/fatedier/frp/models/plugin/server"
 	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/server/group"
 	"github.com/fatedier/frp/server/metrics"
+	"github.com/fatedier/frp/utils/limit"
 	frpNet "github.com/fatedier/frp/utils/net"
+	"github.com/fatedier/frp/utils/vhost"
 	"github.com/fatedier/frp/utils/xlog"
 
 	frpIo "github.com/fatedier/golib/io"
@@ -35,6 +38,9 @@ import (
 
 type GetWorkConnFn func() (net.Conn, error)
 
+// ProbeFn checks if the client is still responsive without work connections.
+type ProbeFn func() error
+
 type Proxy interface {
 	Context() context.Context
 	Run() (remoteAddr string, err error)
@@ -44,6 +50,8 @@ type Proxy interface {
 	GetUsedPortsNum() int
 	GetResourceController() *controller.ResourceController
 	GetUserInfo() plugin.UserInfo
+	GetConnLimiter() *limit.ConnLimiter
+	GetInspector() *vhost.Inspector
 	Close()
 }
 
@@ -54,8 +62,11 @@ type BaseProxy struct {
 	usedPortsNum  int
 	poolCount     int
 	getWorkConnFn GetWorkConnFn
+	probeFn       ProbeFn
 	serverCfg     config.ServerCommonConf
 	userInfo      plugin.UserInfo
+	connLimiter   *limit.ConnLimiter
+	inspector     *vhost.Inspector
 
 	mu  sync.RWMutex
 	xl  *xlog.Logger
@@ -82,6 +93,18 @@ func (pxy *BaseProxy) GetUserInfo() plugin.UserInfo {
 	return pxy.userInfo
 }
 
+// GetConnLimiter returns the limiter of user connections, nil if there is no
+// limit.
+func (pxy *BaseProxy) GetConnLimiter() *limit.ConnLimiter {
+	return pxy.connLimiter
+}
+
+// GetInspector returns the recorder of http requests and responses, nil if
+// it's not enabled.
+func (pxy *BaseProxy) GetInspector() *vhost.Inspector {
+	return pxy.inspector
+}
+
 func (pxy *BaseProxy) Close() {
 	xl := xlog.FromContextSafe(pxy.ctx)
 	xl.Info("proxy closing")
@@ -93,6 +116,11 @@ func (pxy *BaseProxy) Close() {
 // GetWorkConnFromPool try to get a new work connections from pool
 // for quickly response, we immediately send the StartWorkConn message to frpc after take out one from pool
 func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn, err error) {
+	return pxy.getWorkConnFromPool(src, dst, false)
+}
+
+// getWorkConnFromPool sets relay in the StartWorkConn message.
+func (pxy *BaseProxy) getWorkConnFromPool(src, dst net.Addr, relay bool) (workConn net.Conn, err error) {
 	xl := xlog.FromContextSafe(pxy.ctx)
 	// try all connections from the pool
 	for i := 0; i < pxy.poolCount+1; i++ {
@@ -128,6 +156,7 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn,
 			DstAddr:   dstAddr,
 			DstPort:   uint16(dstPort),
 			Error:     "",
+			Relay:     relay,
 		})
 		if err != nil {
 			xl.Warn("failed to send message to work connection from pool: %v, times: %d", err, i)
@@ -144,6 +173,13 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn,
 	return
 }
 
+// probe checks if the client of this proxy is still responsive. It is used by
+// groups to actively check their members. Work connections are not used, or
+// frpc would dial the local service and drain the pool for every probe.
+func (pxy *BaseProxy) probe() error {
+	return pxy.probeFn()
+}
+
 // startListenHandler start a goroutine handler for each listener.
 // p: p will just be passed to handler(Proxy, frpNet.Conn).
 // handler: each proxy type can set different handler function to deal with connections accepted from listeners.
@@ -167,7 +203,7 @@ func (pxy *BaseProxy) startListenHandler(p Proxy, handler func(Proxy, net.Conn,
 }
 
 func NewProxy(ctx context.Context, userInfo plugin.UserInfo, rc *controller.ResourceController, poolCount int,
-	getWorkConnFn GetWorkConnFn, pxyConf config.ProxyConf, serverCfg config.ServerCommonConf) (pxy Proxy, err error) {
+	getWorkConnFn GetWorkConnFn, probeFn ProbeFn, pxyConf config.ProxyConf, serverCfg config.ServerCommonConf) (pxy Proxy, err error) {
 
 	xl := xlog.FromContextSafe(ctx).Spawn().AppendPrefix(pxyConf.GetBaseInfo().ProxyName)
 	basePxy := BaseProxy{
@@ -176,6 +212,7 @@ func NewProxy(ctx context.Context, userInfo plugin.UserInfo, rc *controller.Reso
 		listeners:     make([]net.Listener, 0),
 		poolCount:     poolCount,
 		getWorkConnFn: getWorkConnFn,
+		probeFn:       probeFn,
 		serverCfg:     serverCfg,
 		xl:            xl,
 		ctx:           xlog.NewContext(ctx, xl),
@@ -184,11 +221,13 @@ func NewProxy(ctx context.Context, userInfo plugin.UserInfo, rc *controller.Reso
 	switch cfg := pxyConf.(type) {
 	case *config.TcpProxyConf:
 		basePxy.usedPortsNum = 1
+		basePxy.connLimiter = newConnLimiter(&cfg.LimitConf)
 		pxy = &TcpProxy{
 			BaseProxy: &basePxy,
 			cfg:       cfg,
 		}
 	case *config.TcpMuxProxyConf:
+		basePxy.connLimiter = newConnLimiter(&cfg.LimitConf)
 		pxy = &TcpMuxProxy{
 			BaseProxy: &basePxy,
 			cfg:       cfg,
@@ -199,6 +238,10 @@ func NewProxy(ctx context.Context, userInfo plugin.UserInfo, rc *controller.Reso
 			cfg:       cfg,
 		}
 	case *config.HttpsProxyConf:
+		// requests are limited by the http route if tls termination is enabled
+		if !cfg.TlsTermination {
+			basePxy.connLimiter = newConnLimiter(&cfg.LimitConf)
+		}
 		pxy = &HttpsProxy{
 			BaseProxy: &basePxy,
 			cfg:       cfg,
@@ -230,11 +273,33 @@ func NewProxy(ctx context.Context, userInfo plugin.UserInfo, rc *controller.Reso
 	return
 }
 
+func newConnLimiter(cfg *config.LimitConf) *limit.ConnLimiter {
+	return limit.NewConnLimiter(limit.ConnLimiterOptions{
+		Rate:               cfg.RateLimit,
+		MaxConcurrent:      cfg.MaxConcurrent,
+		PerIpRate:          cfg.PerIpRateLimit,
+		PerIpMaxConcurrent: cfg.PerIpMaxConcurrent,
+	})
+}
+
 // HandleUserTcpConnection is used for incoming tcp user connections.
 // It can be used for tcp, http, https type.
 func HandleUserTcpConnection(pxy Proxy, userConn net.Conn, serverCfg config.ServerCommonConf) {
 	xl := xlog.FromContextSafe(pxy.Context())
-	defer userConn.Close()
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			userConn.Close()
+		}
+	}()
+
+	remoteIp, _, _ := net.SplitHostPort(userConn.RemoteAddr().String())
+	release, ok := pxy.GetConnLimiter().Acquire(remoteIp)
+	if !ok {
+		xl.Warn("the user conn [%s] was rejected by connection limits", userConn.RemoteAddr().String())
+		return
+	}
+	defer release()
 
 	// server plugin hook
 	rc := pxy.GetResourceController()
@@ -252,10 +317,19 @@ func HandleUserTcpConnection(pxy Proxy, userConn net.Conn, serverCfg config.Serv
 
 	// try all connections from the pool
 	workConn, err := pxy.GetWorkConnFromPool(userConn.RemoteAddr(), userConn.LocalAddr())
+	memberConn, isMemberConn := userConn.(group.MemberConn)
 	if err != nil {
+		// if this user connection comes from a group, let another member handle it
+		if isMemberConn && memberConn.Failover(err) {
+			xl.Info("user conn [%s] is handed over to another member in group", content.RemoteAddr)
+			handedOver = true
+		}
 		return
 	}
 	defer workConn.Close()
+	if isMemberConn {
+		memberConn.ReportSuccess()
+	}
 
 	var local io.ReadWriteCloser = workConn
 	cfg := pxy.GetConf().GetBaseInfo()
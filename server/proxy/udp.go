@@ -34,8 +34,9 @@ type UdpProxy struct {
 
 	realPort int
 
-	// udpConn is the listener of udp packages
-	udpConn *net.UDPConn
+	// udpConn is the listener of udp packages, it's a member of the shared
+	// udp port if proxy is in a group
+	udpConn udp.UserConn
 
 	// there are always only one workConn at the same time
 	// get another one if it closed
@@ -55,32 +56,40 @@ type UdpProxy struct {
 
 func (pxy *UdpProxy) Run() (remoteAddr string, err error) {
 	xl := pxy.xl
-	pxy.realPort, err = pxy.rc.UdpPortManager.Acquire(pxy.name, pxy.cfg.RemotePort)
-	if err != nil {
-		return
-	}
-	defer func() {
+	if pxy.cfg.Group != "" {
+		member, realPort, errRet := pxy.rc.UdpGroupCtl.Listen(pxy.name, pxy.cfg.Group, pxy.cfg.GroupKey, pxy.serverCfg.ProxyBindAddr, pxy.cfg.RemotePort)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		pxy.realPort = realPort
+		pxy.udpConn = member
+		xl.Info("udp proxy listen port [%d] in group [%s]", pxy.realPort, pxy.cfg.Group)
+	} else {
+		pxy.realPort, err = pxy.rc.UdpPortManager.Acquire(pxy.name, pxy.cfg.RemotePort)
 		if err != nil {
+			return
+		}
+		addr, errRet := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", pxy.serverCfg.ProxyBindAddr, pxy.realPort))
+		if errRet != nil {
 			pxy.rc.UdpPortManager.Release(pxy.realPort)
+			err = errRet
+			return
 		}
-	}()
+		udpConn, errRet := net.ListenUDP("udp", addr)
+		if errRet != nil {
+			pxy.rc.UdpPortManager.Release(pxy.realPort)
+			err = errRet
+			xl.Warn("listen udp port error: %v", err)
+			return
+		}
+		pxy.udpConn = udpConn
+		xl.Info("udp proxy listen port [%d]", pxy.realPort)
+	}
 
 	remoteAddr = fmt.Sprintf(":%d", pxy.realPort)
 	pxy.cfg.RemotePort = pxy.realPort
-	addr, errRet := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", pxy.serverCfg.ProxyBindAddr, pxy.realPort))
-	if errRet != nil {
-		err = errRet
-		return
-	}
-	udpConn, errRet := net.ListenUDP("udp", addr)
-	if errRet != nil {
-		err = errRet
-		xl.Warn("listen udp port error: %v", err)
-		return
-	}
-	xl.Info("udp proxy listen port [%d]", pxy.cfg.RemotePort)
 
-	pxy.udpConn = udpConn
 	pxy.sendCh = make(chan *msg.UdpPacket, 1024)
 	pxy.readCh = make(chan *msg.UdpPacket, 1024)
 	pxy.checkCloseCh = make(chan int)
@@ -196,7 +205,7 @@ func (pxy *UdpProxy) Run() (remoteAddr string, err error) {
 	// Response will be wrapped to be forwarded by work connection to server.
 	// Close readCh and sendCh at the end.
 	go func() {
-		udp.ForwardUserConn(udpConn, pxy.readCh, pxy.sendCh)
+		udp.ForwardUserConn(pxy.udpConn, pxy.readCh, pxy.sendCh)
 		pxy.Close()
 	}()
 	return remoteAddr, nil
@@ -223,5 +232,7 @@ func (pxy *UdpProxy) Close() {
 		close(pxy.readCh)
 		close(pxy.sendCh)
 	}
-	pxy.rc.UdpPortManager.Release(pxy.realPort)
+	if pxy.cfg.Group == "" {
+		pxy.rc.UdpPortManager.Release(pxy.realPort)
+	}
 }
@@ -16,9 +16,13 @@ package proxy
 
 import (
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/fatedier/frp/models/config"
 	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/models/nathole"
+	"github.com/fatedier/frp/server/metrics"
 
 	"github.com/fatedier/golib/errors"
 )
@@ -38,6 +42,22 @@ func (pxy *XtcpProxy) Run() (remoteAddr string, err error) {
 		err = fmt.Errorf("xtcp is not supported in frps")
 		return
 	}
+
+	// Visitors fall back to relay connections like stcp if nat hole fails.
+	if pxy.serverCfg.AllowXtcpRelay {
+		listener, errRet := pxy.rc.VisitorManager.Listen(pxy.GetName(), pxy.cfg.Sk)
+		if errRet != nil {
+			err = errRet
+			return
+		}
+		pxy.listeners = append(pxy.listeners, listener)
+		pxy.startListenHandler(pxy, func(p Proxy, conn net.Conn, serverCfg config.ServerCommonConf) {
+			xl.Debug("relay a xtcp visitor connection")
+			metrics.Server.AddXtcpConnection(pxy.GetName(), "relay")
+			HandleUserTcpConnection(p, conn, serverCfg)
+		})
+	}
+
 	sidCh := pxy.rc.NatHoleController.ListenClient(pxy.GetName(), pxy.cfg.Sk)
 	go func() {
 		for {
@@ -46,7 +66,7 @@ func (pxy *XtcpProxy) Run() (remoteAddr string, err error) {
 				break
 			case sidRequest := <-sidCh:
 				sr := sidRequest
-				workConn, errRet := pxy.GetWorkConnFromPool(nil, nil)
+				workConn, errRet := pxy.BaseProxy.GetWorkConnFromPool(nil, nil)
 				if errRet != nil {
 					continue
 				}
@@ -77,6 +97,14 @@ func (pxy *XtcpProxy) Run() (remoteAddr string, err error) {
 					case sr.NotifyCh <- struct{}{}:
 					default:
 					}
+
+					// only nat holes reported by visitors are counted,
+					// others may be relayed later
+					select {
+					case <-sr.OkCh:
+						metrics.Server.AddXtcpConnection(pxy.GetName(), "p2p")
+					case <-time.After(2 * time.Duration(nathole.NatHoleTimeout) * time.Second):
+					}
 				}()
 			}
 		}
@@ -84,12 +112,21 @@ func (pxy *XtcpProxy) Run() (remoteAddr string, err error) {
 	return
 }
 
+// GetWorkConnFromPool is used by HandleUserTcpConnection for visitor
+// connections relayed by frps, so frpc knows they are not nat holes.
+func (pxy *XtcpProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn, err error) {
+	return pxy.getWorkConnFromPool(src, dst, true)
+}
+
 func (pxy *XtcpProxy) GetConf() config.ProxyConf {
 	return pxy.cfg
 }
 
 func (pxy *XtcpProxy) Close() {
 	pxy.BaseProxy.Close()
+	if pxy.serverCfg.AllowXtcpRelay {
+		pxy.rc.VisitorManager.CloseListener(pxy.GetName())
+	}
 	pxy.rc.NatHoleController.CloseClient(pxy.GetName())
 	errors.PanicToError(func() {
 		close(pxy.closeCh)
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/config"
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/models/nathole"
+	plugin "github.com/fatedier/frp/models/plugin/server"
+	"github.com/fatedier/frp/server/controller"
+	"github.com/fatedier/frp/utils/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXtcpProxyRelay(t *testing.T) {
+	assert := assert.New(t)
+
+	natHoleCtl, err := nathole.NewNatHoleController("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &controller.ResourceController{
+		VisitorManager:    controller.NewVisitorManager(),
+		NatHoleController: natHoleCtl,
+		PluginManager:     plugin.NewManager(),
+	}
+
+	workConns := make(chan net.Conn, 1)
+	getWorkConnFn := func() (net.Conn, error) {
+		workConn, frpc := net.Pipe()
+		workConns <- frpc
+		return workConn, nil
+	}
+	newProxy := func(allowRelay bool) Proxy {
+		serverCfg := config.GetDefaultServerConf()
+		serverCfg.AllowXtcpRelay = allowRelay
+		cfg := &config.XtcpProxyConf{Sk: "abcdefg"}
+		cfg.ProxyName = "xtcp"
+		cfg.ProxyType = "xtcp"
+		pxy, err := NewProxy(context.Background(), plugin.UserInfo{}, rc, 0, getWorkConnFn,
+			func() error { return nil }, cfg, serverCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = pxy.Run(); err != nil {
+			t.Fatal(err)
+		}
+		return pxy
+	}
+	newVisitorConn := func() (net.Conn, error) {
+		conn, visitor := net.Pipe()
+		now := time.Now().Unix()
+		err := rc.VisitorManager.NewConn("xtcp", conn, now, util.GetAuthKey("abcdefg", now), false, false)
+		return visitor, err
+	}
+
+	// visitor connections are rejected by default
+	pxy := newProxy(false)
+	_, err = newVisitorConn()
+	assert.Error(err)
+	pxy.Close()
+
+	// relayed visitor connections get work connections marked as relay
+	pxy = newProxy(true)
+	defer pxy.Close()
+	visitor, err := newVisitorConn()
+	if !assert.NoError(err) {
+		return
+	}
+	defer visitor.Close()
+
+	var frpc net.Conn
+	select {
+	case frpc = <-workConns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no work connection is requested")
+	}
+	defer frpc.Close()
+	frpc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var m msg.StartWorkConn
+	if assert.NoError(msg.ReadMsgInto(frpc, &m)) {
+		assert.True(m.Relay)
+		assert.Equal("xtcp", m.ProxyName)
+	}
+
+	go visitor.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = frpc.Read(buf)
+	assert.NoError(err)
+	assert.Equal("ping", string(buf))
+}
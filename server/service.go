@@ -51,6 +51,8 @@ import (
 
 	"github.com/fatedier/golib/net/mux"
 	fmux "github.com/hashicorp/yamux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const (
@@ -138,17 +140,43 @@ func NewService(cfg config.ServerCommonConf) (svr *Service, err error) {
 	}
 	svr.rc.PluginManager = svr.pluginManager
 
+	groupHealthOpts := group.HealthCheckOptions{
+		MaxFails:      int(cfg.GroupMaxFails),
+		EjectTime:     time.Duration(cfg.GroupEjectTime) * time.Second,
+		CheckInterval: time.Duration(cfg.GroupHealthCheckInterval) * time.Second,
+	}
+
 	// Init group controller
-	svr.rc.TcpGroupCtl = group.NewTcpGroupCtl(svr.rc.TcpPortManager)
+	svr.rc.TcpGroupCtl = group.NewTcpGroupCtl(svr.rc.TcpPortManager, groupHealthOpts)
+
+	// Init udp group controller
+	svr.rc.UdpGroupCtl = group.NewUdpGroupCtl(svr.rc.UdpPortManager)
 
 	// Init HTTP group controller
-	svr.rc.HTTPGroupCtl = group.NewHTTPGroupController(svr.httpVhostRouter)
+	svr.rc.HTTPGroupCtl = group.NewHTTPGroupController(svr.httpVhostRouter, groupHealthOpts)
 
 	// Init TCP mux group controller
 	svr.rc.TcpMuxGroupCtl = group.NewTcpMuxGroupCtl(svr.rc.TcpMuxHttpConnectMuxer)
 
 	// Init 404 not found page
 	vhost.NotFoundPagePath = cfg.Custom404Page
+	vhost.ErrorPagesDir = cfg.CustomErrorPagesDir
+
+	// Init maintenance mode of http proxies
+	svr.rc.HttpMaintenance = vhost.NewMaintenance()
+
+	// Init cache of http responses
+	if cfg.HttpCacheMaxSizeMb > 0 {
+		svr.rc.HttpCache, err = vhost.NewHttpCache(vhost.HttpCacheOptions{
+			MaxSize:       cfg.HttpCacheMaxSizeMb * 1024 * 1024,
+			MaxObjectSize: cfg.HttpCacheMaxObjectSizeKb * 1024,
+			Dir:           cfg.HttpCacheDir,
+		})
+		if err != nil {
+			err = fmt.Errorf("Create http cache error, %v", err)
+			return
+		}
+	}
 
 	var (
 		httpMuxOn  bool
@@ -194,21 +222,81 @@ func NewService(cfg config.ServerCommonConf) (svr *Service, err error) {
 	})
 	svr.websocketListener = frpNet.NewWebsocketListener(websocketLn)
 
+	// Create https vhost muxer.
+	if cfg.VhostHttpsPort > 0 {
+		var l net.Listener
+		if httpsMuxOn {
+			l = svr.muxer.ListenHttps(1)
+		} else {
+			l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.ProxyBindAddr, cfg.VhostHttpsPort))
+			if err != nil {
+				err = fmt.Errorf("Create server listener error, %v", err)
+				return
+			}
+		}
+
+		svr.rc.VhostHttpsMuxer, err = vhost.NewHttpsMuxer(l, vhostReadWriteTimeout)
+		if err != nil {
+			err = fmt.Errorf("Create vhost httpsMuxer error, %v", err)
+			return
+		}
+		log.Info("https service listen on %s:%d", cfg.ProxyBindAddr, cfg.VhostHttpsPort)
+
+		// Init HTTPS group controller
+		svr.rc.HTTPSGroupCtl = group.NewHTTPSGroupController(svr.rc.VhostHttpsMuxer, groupHealthOpts)
+
+		// Init https terminator if frps holds certificates
+		if cfg.IsTlsTerminationEnabled() {
+			svr.rc.HttpsTerminator, err = vhost.NewHttpsTerminator(svr.rc.VhostHttpsMuxer, vhost.HttpsTerminatorOptions{
+				CertFile:               cfg.VhostHttpsCertFile,
+				KeyFile:                cfg.VhostHttpsKeyFile,
+				AcmeEnable:             cfg.AcmeEnable,
+				AcmeEmail:              cfg.AcmeEmail,
+				AcmeDirectoryUrl:       cfg.AcmeDirectoryUrl,
+				AcmeCacheDir:           cfg.AcmeCacheDir,
+				AcmeCaFile:             cfg.AcmeCaFile,
+				ResponseHeaderTimeoutS: cfg.VhostHttpTimeout,
+				HttpCache:              svr.rc.HttpCache,
+				Maintenance:            svr.rc.HttpMaintenance,
+			})
+			if err != nil {
+				err = fmt.Errorf("Create https terminator error, %v", err)
+				return
+			}
+			log.Info("tls termination for https proxies is enabled")
+		}
+	}
+
 	// Create http vhost muxer.
 	if cfg.VhostHttpPort > 0 {
 		rp := vhost.NewHttpReverseProxy(vhost.HttpReverseProxyOptions{
 			ResponseHeaderTimeoutS: cfg.VhostHttpTimeout,
+			Cache:                  svr.rc.HttpCache,
+			Maintenance:            svr.rc.HttpMaintenance,
+			TombstoneTTLS:          cfg.HttpTombstoneTtl,
+			MaintenanceRetryAfterS: cfg.HttpMaintenanceRetryAfter,
 		}, svr.httpVhostRouter)
 		svr.rc.HttpReverseProxy = rp
 
 		address := fmt.Sprintf("%s:%d", cfg.ProxyBindAddr, cfg.VhostHttpPort)
+		var handler http.Handler = rp
+		if svr.rc.HttpsTerminator != nil {
+			// answer acme challenges for https proxies with tls termination
+			handler = svr.rc.HttpsTerminator.HTTPHandler(rp)
+		}
 		server := &http.Server{
-			Addr:    address,
-			Handler: rp,
+			Addr: address,
+			// visitors can use HTTP/2 without TLS by prior knowledge or upgrade
+			Handler: h2c.NewHandler(handler, &http2.Server{}),
 		}
 		var l net.Listener
 		if httpMuxOn {
-			l = svr.muxer.ListenHttp(1)
+			// HttpMatchFunc only matches HTTP methods, but h2c connections
+			// with prior knowledge start with "PRI * HTTP/2.0", they would
+			// never reach the h2c handler of server without matching it here
+			l = svr.muxer.Listen(1, mux.HttpNeedBytesNum, func(data []byte) bool {
+				return mux.HttpMatchFunc(data) || bytes.HasPrefix(data, []byte("PRI"))
+			})
 		} else {
 			l, err = net.Listen("tcp", address)
 			if err != nil {
@@ -220,27 +308,6 @@ func NewService(cfg config.ServerCommonConf) (svr *Service, err error) {
 		log.Info("http service listen on %s:%d", cfg.ProxyBindAddr, cfg.VhostHttpPort)
 	}
 
-	// Create https vhost muxer.
-	if cfg.VhostHttpsPort > 0 {
-		var l net.Listener
-		if httpsMuxOn {
-			l = svr.muxer.ListenHttps(1)
-		} else {
-			l, err = net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.ProxyBindAddr, cfg.VhostHttpsPort))
-			if err != nil {
-				err = fmt.Errorf("Create server listener error, %v", err)
-				return
-			}
-		}
-
-		svr.rc.VhostHttpsMuxer, err = vhost.NewHttpsMuxer(l, vhostReadWriteTimeout)
-		if err != nil {
-			err = fmt.Errorf("Create vhost httpsMuxer error, %v", err)
-			return
-		}
-		log.Info("https service listen on %s:%d", cfg.ProxyBindAddr, cfg.VhostHttpsPort)
-	}
-
 	// frp tls listener
 	svr.tlsListener = svr.muxer.Listen(1, 1, func(data []byte) bool {
 		return int(data[0]) == frpNet.FRP_TLS_HEAD_BYTE
@@ -250,13 +317,20 @@ func NewService(cfg config.ServerCommonConf) (svr *Service, err error) {
 	if cfg.BindUdpPort > 0 {
 		var nc *nathole.NatHoleController
 		addr := fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.BindUdpPort)
-		nc, err = nathole.NewNatHoleController(addr)
+		discoveryAddr := ""
+		if cfg.NatHoleDiscoveryPort > 0 {
+			discoveryAddr = fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.NatHoleDiscoveryPort)
+		}
+		nc, err = nathole.NewNatHoleController(addr, discoveryAddr)
 		if err != nil {
 			err = fmt.Errorf("Create nat hole controller error, %v", err)
 			return
 		}
 		svr.rc.NatHoleController = nc
 		log.Info("nat hole udp service listen on %s:%d", cfg.BindAddr, cfg.BindUdpPort)
+		if discoveryAddr != "" {
+			log.Info("nat hole discovery udp service listen on %s", discoveryAddr)
+		}
 	}
 
 	var statsEnable bool
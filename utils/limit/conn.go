@@ -0,0 +1,176 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idle per source ip states are removed after this duration
+var ipStateExpire = time.Minute
+
+// ConnLimiterOptions sets limits of new connections or requests, zero values
+// mean no limit.
+type ConnLimiterOptions struct {
+	// Rate is the number of new connections or requests allowed per second.
+	Rate float64
+	// MaxConcurrent is the number of connections or requests allowed at the
+	// same time.
+	MaxConcurrent int
+	// PerIpRate and PerIpMaxConcurrent are the same as Rate and MaxConcurrent
+	// but are counted for each source ip.
+	PerIpRate          float64
+	PerIpMaxConcurrent int
+}
+
+func (opts ConnLimiterOptions) IsEmpty() bool {
+	return opts.Rate <= 0 && opts.MaxConcurrent <= 0 && opts.PerIpRate <= 0 && opts.PerIpMaxConcurrent <= 0
+}
+
+// ConnLimiter limits the rate and the concurrency of connections or requests
+// in total and from each source ip. A nil ConnLimiter allows everything.
+type ConnLimiter struct {
+	opts    ConnLimiterOptions
+	limiter *rate.Limiter
+	active  int
+
+	ips       map[string]*ipState
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type ipState struct {
+	limiter  *rate.Limiter
+	active   int
+	lastSeen time.Time
+}
+
+// NewConnLimiter returns nil if opts has no limit.
+func NewConnLimiter(opts ConnLimiterOptions) *ConnLimiter {
+	if opts.IsEmpty() {
+		return nil
+	}
+	l := &ConnLimiter{
+		opts:      opts,
+		ips:       make(map[string]*ipState),
+		lastSweep: time.Now(),
+	}
+	if opts.Rate > 0 {
+		l.limiter = newRateLimiter(opts.Rate)
+	}
+	return l
+}
+
+// Acquire checks if a new connection or request from ip is allowed. If it's
+// allowed, release should be called after it's finished.
+func (l *ConnLimiter) Acquire(ip string) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	if l.opts.MaxConcurrent > 0 && l.active >= l.opts.MaxConcurrent {
+		return nil, false
+	}
+
+	var st *ipState
+	if l.opts.PerIpRate > 0 || l.opts.PerIpMaxConcurrent > 0 {
+		st = l.ips[ip]
+		if st == nil {
+			st = &ipState{}
+			if l.opts.PerIpRate > 0 {
+				st.limiter = newRateLimiter(l.opts.PerIpRate)
+			}
+			l.ips[ip] = st
+		}
+		st.lastSeen = now
+		if l.opts.PerIpMaxConcurrent > 0 && st.active >= l.opts.PerIpMaxConcurrent {
+			return nil, false
+		}
+	}
+
+	// reserve tokens from both limiters and give them back if any one is
+	// exhausted
+	var ipRsv, rsv *rate.Reservation
+	if st != nil && st.limiter != nil {
+		if ipRsv = reserve(st.limiter, now); ipRsv == nil {
+			return nil, false
+		}
+	}
+	if l.limiter != nil {
+		if rsv = reserve(l.limiter, now); rsv == nil {
+			if ipRsv != nil {
+				ipRsv.CancelAt(now)
+			}
+			return nil, false
+		}
+	}
+
+	l.active++
+	if st != nil {
+		st.active++
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.active--
+			if st != nil {
+				st.active--
+				st.lastSeen = time.Now()
+			}
+		})
+	}
+	return release, true
+}
+
+// sweep removes states of source ips which have been idle for a while.
+func (l *ConnLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < ipStateExpire {
+		return
+	}
+	l.lastSweep = now
+	for ip, st := range l.ips {
+		if st.active == 0 && now.Sub(st.lastSeen) >= ipStateExpire {
+			delete(l.ips, ip)
+		}
+	}
+}
+
+func newRateLimiter(r float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(r), int(math.Max(1, math.Ceil(r))))
+}
+
+// reserve takes one token from limiter if there is one available now.
+func reserve(limiter *rate.Limiter, now time.Time) *rate.Reservation {
+	r := limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return nil
+	}
+	if r.DelayFrom(now) > 0 {
+		r.CancelAt(now)
+		return nil
+	}
+	return r
+}
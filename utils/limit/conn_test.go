@@ -0,0 +1,63 @@
+package limit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimiterConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewConnLimiter(ConnLimiterOptions{MaxConcurrent: 3, PerIpMaxConcurrent: 2})
+	r1, ok := l.Acquire("1.1.1.1")
+	assert.True(ok)
+	_, ok = l.Acquire("1.1.1.1")
+	assert.True(ok)
+	_, ok = l.Acquire("1.1.1.1")
+	assert.False(ok, "per ip limit")
+
+	_, ok = l.Acquire("2.2.2.2")
+	assert.True(ok)
+	_, ok = l.Acquire("3.3.3.3")
+	assert.False(ok, "total limit")
+
+	r1()
+	r1() // release is idempotent
+	_, ok = l.Acquire("3.3.3.3")
+	assert.True(ok)
+	_, ok = l.Acquire("1.1.1.1")
+	assert.False(ok)
+}
+
+func TestConnLimiterRate(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewConnLimiter(ConnLimiterOptions{Rate: 3, PerIpRate: 2})
+	for i := 0; i < 2; i++ {
+		release, ok := l.Acquire("1.1.1.1")
+		assert.True(ok)
+		release()
+	}
+	_, ok := l.Acquire("1.1.1.1")
+	assert.False(ok, "per ip limit")
+
+	_, ok = l.Acquire("2.2.2.2")
+	assert.True(ok)
+	_, ok = l.Acquire("3.3.3.3")
+	assert.False(ok, "total limit")
+	// tokens of 3.3.3.3 are given back
+	assert.Len(l.ips, 3)
+	assert.True(l.ips["3.3.3.3"].limiter.AllowN(time.Now(), 2))
+}
+
+func TestNilConnLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	l := NewConnLimiter(ConnLimiterOptions{})
+	assert.Nil(l)
+	release, ok := l.Acquire("1.1.1.1")
+	assert.True(ok)
+	release()
+}
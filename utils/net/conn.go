@@ -121,6 +121,25 @@ func (conn *WrapReadWriteCloserConn) SetWriteDeadline(t time.Time) error {
 	return &net.OpError{Op: "set", Net: "wrap", Source: nil, Addr: nil, Err: errors.New("deadline not supported")}
 }
 
+// RemoteAddrConn reports remoteAddr as its remote address, it's used to tell
+// the address of users instead of frps.
+type RemoteAddrConn struct {
+	net.Conn
+
+	remoteAddr net.Addr
+}
+
+func WrapRemoteAddrConn(c net.Conn, remoteAddr net.Addr) net.Conn {
+	return &RemoteAddrConn{
+		Conn:       c,
+		remoteAddr: remoteAddr,
+	}
+}
+
+func (conn *RemoteAddrConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
 type CloseNotifyConn struct {
 	net.Conn
 
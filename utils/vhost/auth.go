@@ -0,0 +1,455 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	frpLog "github.com/fatedier/frp/utils/log"
+	"github.com/fatedier/frp/utils/util"
+
+	"github.com/coreos/go-oidc"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	OidcCallbackPath = "/.frp/oidc/callback"
+
+	oidcSessionCookie  = "frp_oidc_session"
+	oidcStateCookie    = "frp_oidc_state"
+	oidcSessionTimeout = 24 * time.Hour
+	oidcStateTimeout   = 10 * time.Minute
+)
+
+// oidcKey is the random key of the process which keys of OidcAuth are derived
+// from, since all values of OidcAuth are known by clients.
+var oidcKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+var forwardAuthClient = &http.Client{
+	Timeout: 10 * time.Second,
+	// redirects from the auth endpoint are returned to the client
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// authRequired returns true if any authentication option is set
+func (routeCfg *VhostRouteConfig) authRequired() bool {
+	return routeCfg.basicAuthRequired() || len(routeCfg.BearerTokens) > 0 ||
+		routeCfg.ForwardAuthUrl != "" || routeCfg.Oidc != nil
+}
+
+func (routeCfg *VhostRouteConfig) basicAuthRequired() bool {
+	return routeCfg.Username != "" || routeCfg.Password != "" || len(routeCfg.Users) > 0
+}
+
+// authenticate checks the request by authentication options of routeCfg. If
+// the request is not allowed, the response is written to rw.
+func (rp *HttpReverseProxy) authenticate(rw http.ResponseWriter, req *http.Request, routeCfg *VhostRouteConfig) (user string, ok bool) {
+	if routeCfg == nil || !routeCfg.authRequired() {
+		return "", true
+	}
+
+	if basicUser, passwd, hasBasic := req.BasicAuth(); hasBasic && routeCfg.basicAuthRequired() {
+		if checkBasicAuth(routeCfg, basicUser, passwd) {
+			return basicUser, true
+		}
+	}
+	if token := bearerToken(req); token != "" {
+		for _, t := range routeCfg.BearerTokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return "", true
+			}
+		}
+	}
+	if routeCfg.Oidc != nil {
+		// the session cookie is removed so it's never sent to backends
+		session := popCookie(req, oidcSessionCookie)
+		if user, ok = routeCfg.Oidc.checkSession(req, session); ok {
+			return
+		}
+	}
+
+	switch {
+	case routeCfg.ForwardAuthUrl != "":
+		return "", forwardAuth(rw, req, routeCfg)
+	case routeCfg.Oidc != nil:
+		routeCfg.Oidc.redirectToLogin(rw, req)
+	case routeCfg.basicAuthRequired():
+		rw.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		writeErrorPage(rw, req, util.GetHostFromAddr(req.Host), http.StatusUnauthorized, routeCfg)
+	default:
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="Restricted"`)
+		writeErrorPage(rw, req, util.GetHostFromAddr(req.Host), http.StatusUnauthorized, routeCfg)
+	}
+	return "", false
+}
+
+func checkBasicAuth(routeCfg *VhostRouteConfig, user, passwd string) bool {
+	if (routeCfg.Username != "" || routeCfg.Password != "") &&
+		routeCfg.Username == user && routeCfg.Password == passwd {
+		return true
+	}
+	if hash, ok := routeCfg.Users[user]; ok {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)) == nil
+	}
+	return false
+}
+
+// popCookie removes the cookie named name from req and returns its value.
+func popCookie(req *http.Request, name string) (value string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name == name {
+			value = c.Value
+			continue
+		}
+		req.AddCookie(c)
+	}
+	return
+}
+
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// forwardAuth sends the headers of req to ForwardAuthUrl. The request is
+// allowed if it returns 2xx, otherwise its response is written to rw.
+func forwardAuth(rw http.ResponseWriter, req *http.Request, routeCfg *VhostRouteConfig) bool {
+	authReq, err := http.NewRequest("GET", routeCfg.ForwardAuthUrl, nil)
+	if err != nil {
+		frpLog.Warn("create forward auth request error: %v", err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+	authReq = authReq.WithContext(req.Context())
+	for k, vs := range req.Header {
+		authReq.Header[k] = vs
+	}
+	authReq.Header.Del("Content-Length")
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	authReq.Header.Set("X-Forwarded-Method", req.Method)
+	authReq.Header.Set("X-Forwarded-Proto", proto)
+	authReq.Header.Set("X-Forwarded-Host", req.Host)
+	authReq.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		authReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	resp, err := forwardAuthClient.Do(authReq)
+	if err != nil {
+		frpLog.Warn("forward auth request to [%s] error: %v", routeCfg.ForwardAuthUrl, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		for _, h := range routeCfg.ForwardAuthResponseHeaders {
+			if v := resp.Header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			} else {
+				req.Header.Del(h)
+			}
+		}
+		return true
+	}
+
+	for k, vs := range resp.Header {
+		rw.Header()[k] = vs
+	}
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+	return false
+}
+
+// OidcAuth logins users by OIDC authorization code flow. Logged in users get
+// a session cookie bound to the domain, which is signed by a key derived from
+// the random key of the process, the issuer and the client id. So routes on
+// the same domain only accept sessions of each other if they use the same
+// OIDC client.
+type OidcAuth struct {
+	issuer        string
+	clientId      string
+	clientSecret  string
+	allowedEmails map[string]struct{}
+	key           []byte
+
+	// provider is discovered when it's used at the first time
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	mu       sync.Mutex
+}
+
+func NewOidcAuth(issuer, clientId, clientSecret string, allowedEmails []string) *OidcAuth {
+	a := &OidcAuth{
+		issuer:        issuer,
+		clientId:      clientId,
+		clientSecret:  clientSecret,
+		allowedEmails: make(map[string]struct{}),
+	}
+	for _, email := range allowedEmails {
+		a.allowedEmails[strings.ToLower(email)] = struct{}{}
+	}
+	mac := hmac.New(sha256.New, oidcKey)
+	mac.Write([]byte(issuer + "\n" + clientId))
+	a.key = mac.Sum(nil)
+	return a
+}
+
+func (a *OidcAuth) getProvider() (*oidc.Provider, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.provider == nil {
+		// the context is also used to fetch keys of the provider later, so
+		// it can't be the context of a request
+		provider, err := oidc.NewProvider(context.Background(), a.issuer)
+		if err != nil {
+			return nil, err
+		}
+		a.provider = provider
+		a.verifier = provider.Verifier(&oidc.Config{ClientID: a.clientId})
+	}
+	return a.provider, nil
+}
+
+func (a *OidcAuth) oauth2Config(req *http.Request, provider *oidc.Provider) *oauth2.Config {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return &oauth2.Config{
+		ClientID:     a.clientId,
+		ClientSecret: a.clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  scheme + "://" + req.Host + OidcCallbackPath,
+		Scopes:       []string{oidc.ScopeOpenID, "email"},
+	}
+}
+
+// checkSession verifies the session is signed for the domain of req by the
+// same OIDC client and the user is still allowed.
+func (a *OidcAuth) checkSession(req *http.Request, session string) (user string, ok bool) {
+	if session == "" {
+		return "", false
+	}
+	value, ok := a.verify(session)
+	if !ok {
+		return "", false
+	}
+	arrs := strings.SplitN(value, "|", 2)
+	if len(arrs) != 2 || !strings.EqualFold(arrs[0], util.GetHostFromAddr(req.Host)) {
+		return "", false
+	}
+	user = arrs[1]
+	if !a.emailAllowed(user) {
+		return "", false
+	}
+	return user, true
+}
+
+func (a *OidcAuth) emailAllowed(email string) bool {
+	if len(a.allowedEmails) == 0 {
+		return true
+	}
+	_, ok := a.allowedEmails[strings.ToLower(email)]
+	return ok
+}
+
+// redirectToLogin saves the original url in a state cookie and redirects
+// the client to the OIDC provider.
+func (a *OidcAuth) redirectToLogin(rw http.ResponseWriter, req *http.Request) {
+	provider, err := a.getProvider()
+	if err != nil {
+		frpLog.Warn("get oidc provider [%s] error: %v", a.issuer, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(nonce)
+	http.SetCookie(rw, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    a.sign(state+"|"+req.URL.RequestURI(), oidcStateTimeout),
+		Path:     OidcCallbackPath,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+	})
+	http.Redirect(rw, req, a.oauth2Config(req, provider).AuthCodeURL(state), http.StatusFound)
+}
+
+// handleCallback exchanges the authorization code for an ID token, and saves
+// the user in a session cookie if the user is allowed.
+func (a *OidcAuth) handleCallback(rw http.ResponseWriter, req *http.Request, state string, originalUri string) {
+	if req.URL.Query().Get("state") != state {
+		http.Error(rw, "invalid oidc state", http.StatusBadRequest)
+		return
+	}
+	provider, err := a.getProvider()
+	if err != nil {
+		frpLog.Warn("get oidc provider [%s] error: %v", a.issuer, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := a.oauth2Config(req, provider).Exchange(req.Context(), req.URL.Query().Get("code"))
+	if err != nil {
+		frpLog.Warn("exchange oidc token error: %v", err)
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(rw, "no id_token in oidc token response", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := a.verifier.Verify(req.Context(), rawIdToken)
+	if err != nil {
+		frpLog.Warn("verify oidc id token error: %v", err)
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var claims struct {
+		Email string `json:"email"`
+	}
+	idToken.Claims(&claims)
+	user := idToken.Subject
+	if claims.Email != "" {
+		user = claims.Email
+	}
+	if len(a.allowedEmails) > 0 && (claims.Email == "" || !a.emailAllowed(claims.Email)) {
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     oidcSessionCookie,
+		Value:    a.sign(util.GetHostFromAddr(req.Host)+"|"+user, oidcSessionTimeout),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+	})
+	http.Redirect(rw, req, originalUri, http.StatusFound)
+}
+
+// sign returns "<base64 value>.<expire unix time>.<hmac>"
+func (a *OidcAuth) sign(value string, timeout time.Duration) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value)) + "." +
+		strconv.FormatInt(time.Now().Add(timeout).Unix(), 10)
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *OidcAuth) verify(signed string) (value string, ok bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload := signed[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", false
+	}
+
+	arrs := strings.SplitN(payload, ".", 2)
+	if len(arrs) != 2 {
+		return "", false
+	}
+	expire, err := strconv.ParseInt(arrs[1], 10, 64)
+	if err != nil || time.Now().Unix() > expire {
+		return "", false
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(arrs[0])
+	if err != nil {
+		return "", false
+	}
+	return string(buf), true
+}
+
+// serveOidcCallback finds the route of the original url saved in the state
+// cookie and handles the callback by its OidcAuth. It returns false if there
+// is no such route.
+func (rp *HttpReverseProxy) serveOidcCallback(rw http.ResponseWriter, req *http.Request) bool {
+	c, err := req.Cookie(oidcStateCookie)
+	if err != nil {
+		return false
+	}
+	// the state cookie can only be verified by OidcAuth of the route, so the
+	// original url is decoded before verifying to find the route
+	buf, err := base64.RawURLEncoding.DecodeString(strings.SplitN(c.Value, ".", 2)[0])
+	if err != nil {
+		return false
+	}
+	state := strings.SplitN(string(buf), "|", 2)
+	if len(state) != 2 {
+		return false
+	}
+	u, err := url.Parse(state[1])
+	if err != nil {
+		return false
+	}
+	routeCfg := rp.GetRouteConfig(util.GetHostFromAddr(req.Host), u.Path)
+	if routeCfg == nil || routeCfg.Oidc == nil {
+		return false
+	}
+	if _, ok := routeCfg.Oidc.verify(c.Value); !ok {
+		http.Error(rw, "invalid oidc state", http.StatusBadRequest)
+		return true
+	}
+
+	http.SetCookie(rw, &http.Cookie{Name: oidcStateCookie, Path: OidcCallbackPath, MaxAge: -1})
+	routeCfg.Oidc.handleCallback(rw, req, state[0], u.RequestURI())
+	return true
+}
@@ -0,0 +1,184 @@
+package vhost
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAuthTestProxy(t *testing.T, routeCfg VhostRouteConfig) (*HttpReverseProxy, func()) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cookie", r.Header.Get("Cookie"))
+		w.Write([]byte(r.Header.Get("X-Forwarded-User") + "|" + r.Header.Get("X-Auth-User")))
+	}))
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	routeCfg.Domain = "example.com"
+	routeCfg.CreateConnFn = func(remoteAddr string) (net.Conn, error) {
+		return net.Dial("tcp", backend.Listener.Addr().String())
+	}
+	if err := rp.Register(routeCfg); err != nil {
+		t.Fatal(err)
+	}
+	return rp, backend.Close
+}
+
+func serve(rp *HttpReverseProxy, setup func(req *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://example.com/a?b=c", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if setup != nil {
+		setup(req)
+	}
+	rw := httptest.NewRecorder()
+	rp.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestBasicAndBearerAuth(t *testing.T) {
+	assert := assert.New(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass1"), bcrypt.MinCost)
+	assert.NoError(err)
+	rp, closeFn := newAuthTestProxy(t, VhostRouteConfig{
+		Username:     "admin",
+		Password:     "admin",
+		Users:        map[string]string{"user1": string(hash)},
+		BearerTokens: []string{"token1"},
+	})
+	defer closeFn()
+
+	rw := serve(rp, nil)
+	assert.Equal(http.StatusUnauthorized, rw.Code)
+	assert.Contains(rw.Header().Get("WWW-Authenticate"), "Basic")
+
+	rw = serve(rp, func(req *http.Request) { req.SetBasicAuth("admin", "admin") })
+	assert.Equal(http.StatusOK, rw.Code)
+	rw = serve(rp, func(req *http.Request) {
+		req.SetBasicAuth("user1", "pass1")
+		req.Header.Set("X-Forwarded-User", "admin")
+	})
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("user1|", rw.Body.String())
+	rw = serve(rp, func(req *http.Request) { req.SetBasicAuth("user1", "wrong") })
+	assert.Equal(http.StatusUnauthorized, rw.Code)
+
+	rw = serve(rp, func(req *http.Request) { req.Header.Set("Authorization", "Bearer token1") })
+	assert.Equal(http.StatusOK, rw.Code)
+	rw = serve(rp, func(req *http.Request) { req.Header.Set("Authorization", "Bearer token2") })
+	assert.Equal(http.StatusUnauthorized, rw.Code)
+}
+
+func TestForwardAuth(t *testing.T) {
+	assert := assert.New(t)
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cookie") != "session=ok" {
+			w.Header().Set("Location", "https://login.example.com/?rd="+url.QueryEscape(r.Header.Get("X-Forwarded-Uri")))
+			w.WriteHeader(http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Auth-User", "user1")
+	}))
+	defer authServer.Close()
+
+	rp, closeFn := newAuthTestProxy(t, VhostRouteConfig{
+		ForwardAuthUrl:             authServer.URL,
+		ForwardAuthResponseHeaders: []string{"X-Auth-User"},
+	})
+	defer closeFn()
+
+	rw := serve(rp, nil)
+	assert.Equal(http.StatusFound, rw.Code)
+	assert.Equal("https://login.example.com/?rd=%2Fa%3Fb%3Dc", rw.Header().Get("Location"))
+
+	rw = serve(rp, func(req *http.Request) {
+		req.Header.Set("Cookie", "session=ok")
+		req.Header.Set("X-Auth-User", "admin")
+	})
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("|user1", rw.Body.String())
+}
+
+func TestOidcAuth(t *testing.T) {
+	assert := assert.New(t)
+	var issuer string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/auth",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/keys",
+		})
+	}))
+	defer provider.Close()
+	issuer = provider.URL
+
+	oidcAuth := NewOidcAuth(issuer, "frp", "secret", nil)
+	rp, closeFn := newAuthTestProxy(t, VhostRouteConfig{
+		Oidc: oidcAuth,
+	})
+	defer closeFn()
+
+	// redirect to the provider without a session
+	rw := serve(rp, nil)
+	assert.Equal(http.StatusFound, rw.Code)
+	location, err := url.Parse(rw.Header().Get("Location"))
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(location.String(), issuer+"/auth"))
+	assert.Equal("http://example.com"+OidcCallbackPath, location.Query().Get("redirect_uri"))
+
+	// the state cookie is only valid for the callback with the same state
+	cookie := rw.Result().Cookies()[0]
+	assert.Equal(oidcStateCookie, cookie.Name)
+	rw = serve(rp, func(req *http.Request) {
+		req.URL, _ = url.Parse("http://example.com" + OidcCallbackPath + "?state=wrong")
+		req.AddCookie(cookie)
+	})
+	assert.Equal(http.StatusBadRequest, rw.Code)
+
+	// signed session, the session cookie is not sent to the backend
+	rw = serve(rp, func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: "a", Value: "b"})
+		req.AddCookie(&http.Cookie{Name: oidcSessionCookie, Value: oidcAuth.sign("example.com|user1@example.com", oidcSessionTimeout)})
+	})
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("user1@example.com|", rw.Body.String())
+	assert.Equal("a=b", rw.Header().Get("X-Cookie"))
+
+	// session of other domains
+	rw = serve(rp, func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: oidcSessionCookie, Value: oidcAuth.sign("other.com|user1@example.com", oidcSessionTimeout)})
+	})
+	assert.Equal(http.StatusFound, rw.Code)
+
+	// allowed emails are checked for sessions of routes with other allowed emails
+	strict := NewOidcAuth(issuer, "frp", "secret", []string{"user2@example.com"})
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	_, ok := strict.checkSession(req, oidcAuth.sign("example.com|user1@example.com", oidcSessionTimeout))
+	assert.False(ok)
+	user, ok := strict.checkSession(req, oidcAuth.sign("example.com|User2@example.com", oidcSessionTimeout))
+	assert.True(ok)
+	assert.Equal("User2@example.com", user)
+
+	// sessions of routes with other issuers or clients on the same domain
+	for _, other := range []*OidcAuth{
+		NewOidcAuth(issuer, "other", "secret", nil),
+		NewOidcAuth("https://other.example.com", "frp", "secret", nil),
+	} {
+		rw = serve(rp, func(req *http.Request) {
+			req.AddCookie(&http.Cookie{Name: oidcSessionCookie, Value: other.sign("example.com|user1@example.com", oidcSessionTimeout)})
+		})
+		assert.Equal(http.StatusFound, rw.Code)
+	}
+
+	// forged session
+	forged := &OidcAuth{key: []byte("secret")}
+	rw = serve(rp, func(req *http.Request) {
+		req.AddCookie(&http.Cookie{Name: oidcSessionCookie, Value: forged.sign("example.com|user1@example.com", oidcSessionTimeout)})
+	})
+	assert.Equal(http.StatusFound, rw.Code)
+}
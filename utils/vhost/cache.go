@@ -0,0 +1,558 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	frpLog "github.com/fatedier/frp/utils/log"
+)
+
+const cacheFileSuffix = ".frpcache"
+
+type HttpCacheOptions struct {
+	// MaxSize is the max total size of cached responses in bytes.
+	MaxSize int64
+	// MaxObjectSize is the max size of a single cached response in bytes.
+	MaxObjectSize int64
+	// Dir stores bodies of cached responses if it's not empty, otherwise they
+	// are kept in memory.
+	Dir string
+}
+
+// HttpCache caches responses of GET requests keyed by domain and path
+// according to their Cache-Control, Expires, ETag and Last-Modified headers.
+// The least recently used responses are removed if the size exceeds the
+// limit.
+type HttpCache struct {
+	opts HttpCacheOptions
+
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	seq     uint64
+	mu      sync.Mutex
+}
+
+type cacheEntry struct {
+	key    string
+	domain string
+	path   string
+
+	status int
+	header http.Header
+	body   []byte
+	// file stores the body if it's not empty
+	file string
+	size int64
+
+	// responseTime is the time when the response is received, initialAge is
+	// the value of its Age header.
+	responseTime time.Time
+	initialAge   time.Duration
+	lifetime     time.Duration
+	// varyValues are values of request headers listed in the Vary header.
+	varyValues map[string]string
+}
+
+func NewHttpCache(opts HttpCacheOptions) (*HttpCache, error) {
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+			return nil, err
+		}
+		// bodies stored by previous processes are useless
+		files, _ := filepath.Glob(filepath.Join(opts.Dir, "*"+cacheFileSuffix))
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}
+	return &HttpCache{
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}, nil
+}
+
+// Purge removes cached responses of domain whose paths start with
+// pathPrefix. Empty domain or pathPrefix matches all. It returns the number
+// of removed responses.
+func (c *HttpCache) Purge(domain string, pathPrefix string) int {
+	domain = strings.ToLower(domain)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	for _, elem := range c.entries {
+		e := elem.Value.(*cacheEntry)
+		if (domain == "" || e.domain == domain) && strings.HasPrefix(e.path, pathPrefix) {
+			c.removeElement(elem)
+			count++
+		}
+	}
+	return count
+}
+
+// Size returns the number of cached responses and their total size.
+func (c *HttpCache) Size() (count int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
+
+// Serve serves req from the cache if there is a fresh response, otherwise
+// req is served by next and the response is cached if it's allowed. If the
+// route requires authentication, only public responses are cached since they
+// are shared by all users.
+func (c *HttpCache) Serve(rw http.ResponseWriter, req *http.Request, domain string, authRequired bool, next http.Handler) {
+	domain = strings.ToLower(domain)
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	key := scheme + "://" + domain + req.URL.RequestURI()
+	if req.Method != "GET" && req.Method != "HEAD" {
+		// unsafe methods may change the resource
+		c.remove(key)
+		next.ServeHTTP(rw, req)
+		return
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		next.ServeHTTP(rw, req)
+		return
+	}
+
+	now := time.Now()
+	e := c.get(key, req)
+	if e != nil {
+		if _, noCache := reqCC["no-cache"]; !noCache && e.age(now) < e.lifetime {
+			if c.serveEntry(rw, req, e, now, "HIT") {
+				return
+			}
+			e = nil
+		}
+	}
+	if req.Method != "GET" {
+		next.ServeHTTP(rw, req)
+		return
+	}
+
+	w := &cacheWriter{
+		ResponseWriter: rw,
+		cache:          c,
+		req:            req,
+		key:            key,
+		domain:         domain,
+		authRequired:   authRequired,
+	}
+	// stale responses are revalidated if the client doesn't send its own
+	// conditions
+	if e != nil && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		etag, lastModified := e.header.Get("ETag"), e.header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			outReq := req.Clone(req.Context())
+			if etag != "" {
+				outReq.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				outReq.Header.Set("If-Modified-Since", lastModified)
+			}
+			w.stale = e
+			next.ServeHTTP(w, outReq)
+			w.finish()
+			return
+		}
+	}
+	next.ServeHTTP(w, req)
+	w.finish()
+}
+
+func (c *HttpCache) get(key string, req *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*cacheEntry)
+	for name, value := range e.varyValues {
+		if req.Header.Get(name) != value {
+			return nil
+		}
+	}
+	c.lru.MoveToFront(elem)
+	return e
+}
+
+func (c *HttpCache) add(e *cacheEntry) {
+	if e.file == "" && c.opts.Dir != "" {
+		c.mu.Lock()
+		c.seq++
+		seq := c.seq
+		c.mu.Unlock()
+
+		file := filepath.Join(c.opts.Dir, strconv.FormatUint(seq, 10)+cacheFileSuffix)
+		if err := ioutil.WriteFile(file, e.body, 0600); err != nil {
+			frpLog.Warn("write http cache file [%s] error: %v", file, err)
+			return
+		}
+		e.file = file
+		e.body = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[e.key]; ok {
+		c.removeElement(elem)
+	}
+	for c.size+e.size > c.opts.MaxSize && c.lru.Len() > 0 {
+		c.removeElement(c.lru.Back())
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+}
+
+// replace replaces old with e which shares the same body.
+func (c *HttpCache) replace(old *cacheEntry, e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[old.key]; ok && elem.Value == old {
+		elem.Value = e
+	}
+}
+
+func (c *HttpCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *HttpCache) removeElement(elem *list.Element) {
+	e := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if e.file != "" {
+		os.Remove(e.file)
+	}
+}
+
+// serveEntry returns false if the body of e is not available.
+func (c *HttpCache) serveEntry(rw http.ResponseWriter, req *http.Request, e *cacheEntry, now time.Time, status string) bool {
+	var body io.ReadCloser
+	if e.file != "" && req.Method != "HEAD" && !isNotModified(req, e.header) {
+		f, err := os.Open(e.file)
+		if err != nil {
+			// it has been removed
+			return false
+		}
+		body = f
+		defer body.Close()
+	}
+
+	header := rw.Header()
+	for k, v := range e.header {
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set("Age", strconv.FormatInt(int64(e.age(now)/time.Second), 10))
+	header.Set("X-Cache", status)
+	if isNotModified(req, e.header) {
+		header.Del("Content-Length")
+		rw.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	header.Set("Content-Length", strconv.FormatInt(e.size, 10))
+	rw.WriteHeader(e.status)
+	if req.Method == "HEAD" {
+		return true
+	}
+	if body != nil {
+		io.Copy(rw, body)
+	} else {
+		rw.Write(e.body)
+	}
+	return true
+}
+
+// newEntry returns nil if the response can't be cached.
+func (c *HttpCache) newEntry(req *http.Request, status int, header http.Header, domain string, key string, authRequired bool) *cacheEntry {
+	if req.Method != "GET" || status != http.StatusOK {
+		return nil
+	}
+	if header.Get("Set-Cookie") != "" || strings.TrimSpace(header.Get("Vary")) == "*" {
+		return nil
+	}
+	cc := parseCacheControl(header)
+	if _, ok := cc["no-store"]; ok {
+		return nil
+	}
+	if _, ok := cc["private"]; ok {
+		return nil
+	}
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	if req.Header.Get("Authorization") != "" && !public && !sMaxAge {
+		return nil
+	}
+	if authRequired && !public {
+		return nil
+	}
+	if cl := header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err != nil || n > c.opts.MaxObjectSize {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	lifetime := freshnessLifetime(header, cc, now)
+	if lifetime <= 0 && header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+		return nil
+	}
+
+	e := &cacheEntry{
+		key:          key,
+		domain:       domain,
+		path:         req.URL.Path,
+		status:       status,
+		header:       header.Clone(),
+		responseTime: now,
+		lifetime:     lifetime,
+		varyValues:   make(map[string]string),
+	}
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		e.initialAge = time.Duration(age) * time.Second
+	}
+	for _, name := range header.Values("Vary") {
+		for _, v := range strings.Split(name, ",") {
+			if v = http.CanonicalHeaderKey(strings.TrimSpace(v)); v != "" {
+				e.varyValues[v] = req.Header.Get(v)
+			}
+		}
+	}
+	return e
+}
+
+// revalidated returns a copy of e updated by headers of a 304 response.
+func (e *cacheEntry) revalidated(header http.Header) *cacheEntry {
+	ne := *e
+	ne.header = e.header.Clone()
+	for _, k := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified", "Vary"} {
+		if v, ok := header[k]; ok {
+			ne.header[k] = v
+		}
+	}
+	now := time.Now()
+	ne.responseTime = now
+	ne.initialAge = 0
+	ne.lifetime = freshnessLifetime(ne.header, parseCacheControl(ne.header), now)
+	return &ne
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.responseTime)
+}
+
+// freshnessLifetime is calculated by s-maxage, max-age or Expires. Responses
+// without them are always revalidated.
+func freshnessLifetime(header http.Header, cc map[string]string, now time.Time) time.Duration {
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			seconds, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value := part, ""
+			if i := strings.Index(part, "="); i >= 0 {
+				name, value = part[:i], strings.Trim(part[i+1:], `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// isNotModified checks conditional headers of req against the ETag and
+// Last-Modified headers of a cached response.
+func isNotModified(req *http.Request, header http.Header) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+		return !lastModified.After(since)
+	}
+	return false
+}
+
+// cacheWriter records the response written by HttpReverseProxy. A 304
+// response of a revalidation request is not sent to the client, the cached
+// response is sent instead.
+type cacheWriter struct {
+	http.ResponseWriter
+	cache *HttpCache
+	// req is the request from the client
+	req    *http.Request
+	key    string
+	domain string
+	// authRequired is true if the route requires authentication
+	authRequired bool
+	// stale is the cached response being revalidated
+	stale *cacheEntry
+
+	wroteHeader bool
+	notModified bool
+	header      http.Header
+	entry       *cacheEntry
+	buf         bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusNotModified && w.stale != nil {
+		w.notModified = true
+		w.header = w.ResponseWriter.Header().Clone()
+		return
+	}
+
+	w.entry = w.cache.newEntry(w.req, status, w.ResponseWriter.Header(), w.domain, w.key, w.authRequired)
+	if w.stale != nil && w.entry == nil {
+		// the resource can't be cached any more
+		w.cache.remove(w.key)
+	}
+	w.ResponseWriter.Header().Set("X-Cache", "MISS")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(p), nil
+	}
+	if w.entry != nil {
+		if int64(w.buf.Len()+len(p)) > w.cache.opts.MaxObjectSize {
+			w.entry = nil
+			w.buf = bytes.Buffer{}
+		} else {
+			w.buf.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *cacheWriter) Flush() {
+	if w.notModified {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *cacheWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// finish is called after the response is written completely.
+func (w *cacheWriter) finish() {
+	if w.notModified {
+		e := w.stale.revalidated(w.header)
+		w.cache.replace(w.stale, e)
+		// the headers of the 304 response are replaced by the cached ones
+		for k := range w.ResponseWriter.Header() {
+			delete(w.ResponseWriter.Header(), k)
+		}
+		if !w.cache.serveEntry(w.ResponseWriter, w.req, e, time.Now(), "REVALIDATED") {
+			w.ResponseWriter.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	if w.entry == nil {
+		return
+	}
+	if cl := w.entry.header.Get("Content-Length"); cl != "" && cl != strconv.Itoa(w.buf.Len()) {
+		// the body is incomplete
+		return
+	}
+	w.entry.body = w.buf.Bytes()
+	w.entry.size = int64(len(w.entry.body))
+	w.entry.header.Del("Age")
+	w.cache.add(w.entry)
+}
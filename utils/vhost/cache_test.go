@@ -0,0 +1,269 @@
+package vhost
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpCache(t *testing.T) {
+	assert := assert.New(t)
+
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/static/app.js":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/static/etag.js":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		w.Write([]byte("body of " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	dir, err := ioutil.TempDir("", "frp_http_cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	cache, err := NewHttpCache(HttpCacheOptions{MaxSize: 1024, MaxObjectSize: 1024, Dir: dir})
+	assert.NoError(err)
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{Cache: cache}, NewVhostRouters())
+	err = rp.Register(VhostRouteConfig{
+		Domain: "example.com",
+		Cache:  true,
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	serve := func(method string, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+path, nil)
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		rw := httptest.NewRecorder()
+		rp.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// fresh response
+	rw := serve("GET", "/static/app.js", nil)
+	assert.Equal("MISS", rw.Header().Get("X-Cache"))
+	rw = serve("GET", "/static/app.js", nil)
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("HIT", rw.Header().Get("X-Cache"))
+	assert.Equal("body of /static/app.js", rw.Body.String())
+	assert.EqualValues(1, atomic.LoadInt32(&hits))
+	rw = serve("HEAD", "/static/app.js", nil)
+	assert.Equal("HIT", rw.Header().Get("X-Cache"))
+	assert.Equal(0, rw.Body.Len())
+
+	// revalidated by ETag
+	serve("GET", "/static/etag.js", nil)
+	rw = serve("GET", "/static/etag.js", nil)
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("REVALIDATED", rw.Header().Get("X-Cache"))
+	assert.Equal("body of /static/etag.js", rw.Body.String())
+	assert.EqualValues(3, atomic.LoadInt32(&hits))
+	// conditions of the client are sent to the local service
+	rw = serve("GET", "/static/etag.js", http.Header{"If-None-Match": []string{`"v1"`}})
+	assert.Equal(http.StatusNotModified, rw.Code)
+	assert.EqualValues(4, atomic.LoadInt32(&hits))
+
+	// not cached
+	serve("GET", "/private", nil)
+	rw = serve("GET", "/private", nil)
+	assert.Equal("MISS", rw.Header().Get("X-Cache"))
+	rw = serve("GET", "/static/app.js", http.Header{"Cache-Control": []string{"no-store"}})
+	assert.Equal("", rw.Header().Get("X-Cache"))
+
+	count, size := cache.Size()
+	assert.Equal(2, count)
+	assert.EqualValues(len("body of /static/app.js")+len("body of /static/etag.js"), size)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(files, 2)
+
+	// unsafe methods invalidate the response
+	serve("POST", "/static/app.js", nil)
+	rw = serve("GET", "/static/app.js", nil)
+	assert.Equal("MISS", rw.Header().Get("X-Cache"))
+
+	assert.Equal(0, cache.Purge("other.com", ""))
+	assert.Equal(2, cache.Purge("example.com", "/static/"))
+	count, _ = cache.Size()
+	assert.Equal(0, count)
+	files, _ = ioutil.ReadDir(dir)
+	assert.Len(files, 0)
+}
+
+func TestHttpCacheWithAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	// users are authenticated by cookies, so requests have no Authorization
+	// header
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("user")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Auth-User", c.Value)
+	}))
+	defer authServer.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		w.Write([]byte(r.Header.Get("X-Auth-User") + " " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	cache, err := NewHttpCache(HttpCacheOptions{MaxSize: 1024, MaxObjectSize: 1024})
+	assert.NoError(err)
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{Cache: cache}, NewVhostRouters())
+	err = rp.Register(VhostRouteConfig{
+		Domain:                     "example.com",
+		Cache:                      true,
+		ForwardAuthUrl:             authServer.URL,
+		ForwardAuthResponseHeaders: []string{"X-Auth-User"},
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	serve := func(path string, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com"+path, nil)
+		if user != "" {
+			req.AddCookie(&http.Cookie{Name: "user", Value: user})
+		}
+		rw := httptest.NewRecorder()
+		rp.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// responses for one user are not served to others
+	rw := serve("/private", "user1")
+	assert.Equal("MISS", rw.Header().Get("X-Cache"))
+	assert.Equal("user1 /private", rw.Body.String())
+	rw = serve("/private", "user2")
+	assert.Equal("MISS", rw.Header().Get("X-Cache"))
+	assert.Equal("user2 /private", rw.Body.String())
+
+	// public responses are shared by authenticated users
+	serve("/public", "user1")
+	rw = serve("/public", "user2")
+	assert.Equal("HIT", rw.Header().Get("X-Cache"))
+	assert.Equal("user1 /public", rw.Body.String())
+	rw = serve("/public", "")
+	assert.Equal(http.StatusUnauthorized, rw.Code)
+}
+
+func TestHttpCacheEviction(t *testing.T) {
+	assert := assert.New(t)
+
+	cache, err := NewHttpCache(HttpCacheOptions{MaxSize: 10, MaxObjectSize: 10})
+	assert.NoError(err)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.add(&cacheEntry{key: key, body: []byte("1234"), size: 4})
+	}
+	count, size := cache.Size()
+	assert.Equal(2, count)
+	assert.EqualValues(8, size)
+	assert.Nil(cache.get("a", httptest.NewRequest("GET", "/", nil)))
+}
+
+func TestIsNotModified(t *testing.T) {
+	assert := assert.New(t)
+
+	header := http.Header{}
+	header.Set("ETag", `"v1"`)
+	header.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+	newReq := func(k, v string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(k, v)
+		return req
+	}
+	assert.True(isNotModified(newReq("If-None-Match", `W/"v1"`), header))
+	assert.True(isNotModified(newReq("If-None-Match", `"v0", "v1"`), header))
+	assert.False(isNotModified(newReq("If-None-Match", `"v2"`), header))
+	assert.True(isNotModified(newReq("If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT"), header))
+	assert.False(isNotModified(newReq("If-Modified-Since", "Sun, 01 Jan 2006 15:04:05 GMT"), header))
+}
+
+func TestHttpCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	content := strings.Repeat("compress me ", 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image.png" {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(content))
+	}))
+	defer backend.Close()
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:      "example.com",
+		Compression: true,
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	serve := func(path string, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com"+path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rw := httptest.NewRecorder()
+		rp.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := serve("/", "gzip, deflate")
+	assert.Equal("gzip", rw.Header().Get("Content-Encoding"))
+	assert.Equal(`W/"v1"`, rw.Header().Get("ETag"))
+	gr, err := gzip.NewReader(rw.Body)
+	assert.NoError(err)
+	buf, err := ioutil.ReadAll(gr)
+	assert.NoError(err)
+	assert.Equal(content, string(buf))
+
+	rw = serve("/", "gzip, br")
+	assert.Equal("br", rw.Header().Get("Content-Encoding"))
+	buf, err = ioutil.ReadAll(brotli.NewReader(rw.Body))
+	assert.NoError(err)
+	assert.Equal(content, string(buf))
+
+	rw = serve("/", "br;q=0")
+	assert.Equal("", rw.Header().Get("Content-Encoding"))
+	assert.Equal(content, rw.Body.String())
+
+	rw = serve("/image.png", "gzip")
+	assert.Equal("", rw.Header().Get("Content-Encoding"))
+	assert.Equal(content, rw.Body.String())
+}
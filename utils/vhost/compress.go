@@ -0,0 +1,167 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// responses smaller than this are not worth compressing
+const minCompressSize = 1024
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter compresses responses by brotli or gzip if the client
+// accepts them and the content type is compressible.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	wroteHeader bool
+	w           compressor
+}
+
+// newCompressWriter returns nil if the client accepts neither brotli nor gzip.
+func newCompressWriter(rw http.ResponseWriter, req *http.Request) *compressWriter {
+	if req.Method == "HEAD" {
+		return nil
+	}
+	encoding := acceptedEncoding(req.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil
+	}
+	return &compressWriter{
+		ResponseWriter: rw,
+		encoding:       encoding,
+	}
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.ResponseWriter.Header()
+	if status == http.StatusOK && header.Get("Content-Encoding") == "" && isCompressible(header) {
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		header.Set("Content-Encoding", w.encoding)
+		header.Add("Vary", "Accept-Encoding")
+		// the compressed body is not byte to byte equal to the original one
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		if w.encoding == "br" {
+			w.w = brotli.NewWriterLevel(w.ResponseWriter, brotli.DefaultCompression)
+		} else {
+			w.w = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.w != nil {
+		return w.w.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) Flush() {
+	if w.w != nil {
+		w.w.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Close writes the remaining compressed data.
+func (w *compressWriter) Close() error {
+	if w.w != nil {
+		return w.w.Close()
+	}
+	return nil
+}
+
+// acceptedEncoding returns "br", "gzip" or "" by Accept-Encoding header,
+// brotli is preferred.
+func acceptedEncoding(accept string) string {
+	gzipOk := false
+	for _, part := range strings.Split(accept, ",") {
+		name, q := part, ""
+		if i := strings.Index(part, ";"); i >= 0 {
+			name, q = part[:i], strings.TrimSpace(part[i+1:])
+		}
+		if strings.HasPrefix(q, "q=") {
+			if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v <= 0 {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "br":
+			return "br"
+		case "gzip":
+			gzipOk = true
+		}
+	}
+	if gzipOk {
+		return "gzip"
+	}
+	return ""
+}
+
+func isCompressible(header http.Header) bool {
+	if cl, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && cl < minCompressSize {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/x-javascript",
+		"application/xml", "application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
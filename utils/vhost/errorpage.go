@@ -0,0 +1,233 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	htmlTemplate "html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+	"time"
+
+	frpLog "github.com/fatedier/frp/utils/log"
+)
+
+var (
+	// ErrorPagesDir is a directory of error page templates named by status
+	// codes, such as 502.html and 502.json. Templates in its sub directories
+	// named by domains are used for these domains first.
+	ErrorPagesDir = ""
+)
+
+const defaultErrorPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>{{.StatusText}}</title>
+<style>
+    body {
+        width: 35em;
+        margin: 0 auto;
+        font-family: Tahoma, Verdana, Arial, sans-serif;
+    }
+</style>
+</head>
+<body>
+<h1>{{.Status}} {{.StatusText}}</h1>
+<p>Sorry, the page you are looking for is currently unavailable.<br/>
+Please try again later.</p>
+<p>Request ID: {{.RequestId}}<br/>
+Host: {{.Host}}<br/>
+Time: {{.Timestamp}}</p>
+<p>The server is powered by <a href="https://github.com/fatedier/frp">frp</a>.</p>
+</body>
+</html>
+`
+
+// ErrorPageInfo is the data used to execute error page templates.
+type ErrorPageInfo struct {
+	Status     int    `json:"status"`
+	StatusText string `json:"error"`
+	RequestId  string `json:"request_id"`
+	Host       string `json:"host"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// statusError is returned by the transport of HttpReverseProxy to tell which
+// error page should be used.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+// errorStatus returns the status code of the error returned by transport.
+func errorStatus(err error) int {
+	if se, ok := err.(*statusError); ok {
+		return se.status
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// writeErrorPage writes an error page of status code to rw. JSON is used if
+// the client accepts JSON but not HTML. Templates are searched in ErrorPages
+// of routeCfg, then the directory of host in ErrorPagesDir, then
+// ErrorPagesDir. The default page is used if there is no template.
+func writeErrorPage(rw http.ResponseWriter, req *http.Request, host string, status int, routeCfg *VhostRouteConfig) {
+	writeNamedErrorPage(rw, req, host, status, []string{strconv.Itoa(status)}, routeCfg)
+}
+
+// writeNamedErrorPage is the same as writeErrorPage, but templates are named
+// by names in order instead of the status code.
+func writeNamedErrorPage(rw http.ResponseWriter, req *http.Request, host string, status int,
+	names []string, routeCfg *VhostRouteConfig) {
+	accept := req.Header.Get("Accept")
+	isJson := strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+
+	requestId := req.Header.Get("X-Request-Id")
+	if requestId == "" {
+		requestId = newRequestId()
+	}
+	info := &ErrorPageInfo{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		RequestId:  requestId,
+		Host:       strings.ToLower(host),
+		Timestamp:  time.Now().Format(time.RFC3339),
+	}
+
+	var (
+		name string
+		tpl  string
+	)
+	for _, name = range names {
+		if isJson {
+			name += ".json"
+		} else {
+			name += ".html"
+		}
+		if tpl = getErrorPageTemplate(name, info.Host, routeCfg); tpl != "" {
+			break
+		}
+	}
+	content, err := renderErrorPage(tpl, info, isJson)
+	if err != nil {
+		frpLog.Warn("render error page [%s] error: %v", name, err)
+		content = nil
+	}
+	if content == nil {
+		if isJson {
+			content, _ = json.Marshal(info)
+		} else if status == http.StatusNotFound {
+			content = getNotFoundPageContent()
+		} else {
+			content, _ = renderErrorPage(defaultErrorPage, info, false)
+		}
+	}
+
+	if isJson {
+		rw.Header().Set("Content-Type", "application/json")
+	} else {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	rw.Header().Set("X-Request-Id", requestId)
+	rw.WriteHeader(status)
+	rw.Write(content)
+}
+
+// getErrorPageTemplate returns "" if there is no template named by name.
+func getErrorPageTemplate(name string, host string, routeCfg *VhostRouteConfig) string {
+	if routeCfg != nil {
+		if tpl, ok := routeCfg.ErrorPages[name]; ok {
+			return tpl
+		}
+	}
+	if ErrorPagesDir == "" {
+		return ""
+	}
+
+	paths := make([]string, 0, 2)
+	// host is used as a directory name, it should not contain path separators
+	if host != "" && !strings.HasPrefix(host, ".") && !strings.ContainsAny(host, `/\`) {
+		paths = append(paths, filepath.Join(ErrorPagesDir, host, name))
+	}
+	paths = append(paths, filepath.Join(ErrorPagesDir, name))
+	for _, path := range paths {
+		if buf, err := ioutil.ReadFile(path); err == nil {
+			return string(buf)
+		}
+	}
+	return ""
+}
+
+func renderErrorPage(tpl string, info *ErrorPageInfo, isJson bool) ([]byte, error) {
+	if tpl == "" {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if isJson {
+		t, err := textTemplate.New("error").Parse(tpl)
+		if err != nil {
+			return nil, err
+		}
+		// request id and host are sent by clients, values are escaped to be
+		// used in JSON strings like "{{.Host}}"
+		escaped := &ErrorPageInfo{
+			Status:     info.Status,
+			StatusText: jsonEscape(info.StatusText),
+			RequestId:  jsonEscape(info.RequestId),
+			Host:       jsonEscape(info.Host),
+			Timestamp:  jsonEscape(info.Timestamp),
+		}
+		if err = t.Execute(&buf, escaped); err != nil {
+			return nil, err
+		}
+	} else {
+		t, err := htmlTemplate.New("error").Parse(tpl)
+		if err != nil {
+			return nil, err
+		}
+		if err = t.Execute(&buf, info); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonEscape returns s encoded as a JSON string without the quotes.
+func jsonEscape(s string) string {
+	buf, _ := json.Marshal(s)
+	return string(buf[1 : len(buf)-1])
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,105 @@
+package vhost
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorPages(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "frp_error_pages")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	assert.NoError(os.Mkdir(filepath.Join(dir, "other.com"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "502.html"), []byte("bad gateway {{.Host}}"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "other.com", "404.html"), []byte("{{.Status}} {{.RequestId}}"), 0644))
+	ErrorPagesDir = dir
+	defer func() {
+		ErrorPagesDir = ""
+	}()
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err = rp.Register(VhostRouteConfig{
+		Domain:     "example.com",
+		Location:   "/offline",
+		ErrorPages: map[string]string{"503.html": "offline {{.Host}}"},
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return nil, fmt.Errorf("no work connection")
+		},
+	})
+	assert.NoError(err)
+	err = rp.Register(VhostRouteConfig{
+		Domain:   "example.com",
+		Location: "/broken",
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			c2.Close()
+			return c1, nil
+		},
+	})
+	assert.NoError(err)
+
+	serve := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		rw := httptest.NewRecorder()
+		rp.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// template of the route
+	rw := serve("http://example.com/offline", nil)
+	assert.Equal(http.StatusServiceUnavailable, rw.Code)
+	assert.Equal("offline example.com", rw.Body.String())
+
+	// template in the directory
+	rw = serve("http://example.com/broken", nil)
+	assert.Equal(http.StatusBadGateway, rw.Code)
+	assert.Equal("bad gateway example.com", rw.Body.String())
+
+	// template in the directory of domain
+	rw = serve("http://other.com/", http.Header{"X-Request-Id": []string{"abc"}})
+	assert.Equal(http.StatusNotFound, rw.Code)
+	assert.Equal("404 abc", rw.Body.String())
+	assert.Equal("abc", rw.Header().Get("X-Request-Id"))
+
+	// default JSON page
+	rw = serve("http://example.com/offline", http.Header{"Accept": []string{"application/json"}})
+	assert.Equal(http.StatusServiceUnavailable, rw.Code)
+	assert.Equal("application/json", rw.Header().Get("Content-Type"))
+	info := &ErrorPageInfo{}
+	assert.NoError(json.Unmarshal(rw.Body.Bytes(), info))
+	assert.Equal(http.StatusServiceUnavailable, info.Status)
+	assert.Equal("example.com", info.Host)
+	assert.Equal(rw.Header().Get("X-Request-Id"), info.RequestId)
+	assert.NotEmpty(info.RequestId)
+
+	// values from clients are escaped in JSON templates
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "502.json"),
+		[]byte(`{"id": "{{.RequestId}}", "host": "{{.Host}}", "status": {{.Status}}}`), 0644))
+	requestId := `x", "admin": true, "y": "<\`
+	rw = serve("http://example.com/broken", http.Header{
+		"Accept":       []string{"application/json"},
+		"X-Request-Id": []string{requestId},
+	})
+	assert.Equal(http.StatusBadGateway, rw.Code)
+	var body map[string]interface{}
+	assert.NoError(json.Unmarshal(rw.Body.Bytes(), &body))
+	assert.Equal(map[string]interface{}{
+		"id":     requestId,
+		"host":   "example.com",
+		"status": float64(http.StatusBadGateway),
+	}, body)
+}
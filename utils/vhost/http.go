@@ -22,13 +22,17 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	frpLog "github.com/fatedier/frp/utils/log"
 	"github.com/fatedier/frp/utils/util"
 
 	"github.com/fatedier/golib/pool"
+	"golang.org/x/net/http2"
 )
 
 var (
@@ -37,11 +41,29 @@ var (
 
 type HttpReverseProxyOptions struct {
 	ResponseHeaderTimeoutS int64
+	// Cache stores responses of routes with Cache enabled, it's optional.
+	Cache *HttpCache
+	// Maintenance records domains in maintenance mode, it's optional.
+	Maintenance *Maintenance
+	// Routes of offline proxies are kept as tombstones for TombstoneTTLS
+	// seconds to serve maintenance pages, 0 means they are removed at once.
+	TombstoneTTLS int64
+	// MaintenanceRetryAfterS is the Retry-After header of maintenance pages
+	// served by tombstones.
+	MaintenanceRetryAfterS int64
 }
 
 type HttpReverseProxy struct {
 	proxy       *ReverseProxy
 	vhostRouter *VhostRouters
+	cache       *HttpCache
+	maintenance *Maintenance
+
+	tombstones     *VhostRouters
+	tombstoneByKey map[string]*tombstone
+	tombstoneTTL   time.Duration
+	retryAfter     time.Duration
+	tombstoneMu    sync.Mutex
 
 	responseHeaderTimeout time.Duration
 }
@@ -53,6 +75,12 @@ func NewHttpReverseProxy(option HttpReverseProxyOptions, vhostRouter *VhostRoute
 	rp := &HttpReverseProxy{
 		responseHeaderTimeout: time.Duration(option.ResponseHeaderTimeoutS) * time.Second,
 		vhostRouter:           vhostRouter,
+		cache:                 option.Cache,
+		maintenance:           option.Maintenance,
+		tombstones:            NewVhostRouters(),
+		tombstoneByKey:        make(map[string]*tombstone),
+		tombstoneTTL:          time.Duration(option.TombstoneTTLS) * time.Second,
+		retryAfter:            time.Duration(option.MaintenanceRetryAfterS) * time.Second,
 	}
 	proxy := &ReverseProxy{
 		Director: func(req *http.Request) {
@@ -65,27 +93,64 @@ func NewHttpReverseProxy(option HttpReverseProxyOptions, vhostRouter *VhostRoute
 			}
 			req.URL.Host = req.Host
 
+			routeCfg := rp.GetRouteConfig(oldHost, url)
+			if routeCfg != nil {
+				if path := rewritePath(req.URL.Path, routeCfg); path != req.URL.Path {
+					req.URL.Path = path
+					req.URL.RawPath = ""
+				}
+				for _, h := range routeCfg.RemoveRequestHeaders {
+					req.Header.Del(h)
+				}
+			}
+			setForwardedHeaders(req, routeCfg)
+
 			headers := rp.GetHeaders(oldHost, url)
 			for k, v := range headers {
 				req.Header.Set(k, v)
 			}
 		},
-		Transport: &http.Transport{
-			ResponseHeaderTimeout: rp.responseHeaderTimeout,
-			DisableKeepAlives:     true,
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				url := ctx.Value("url").(string)
-				host := util.GetHostFromAddr(ctx.Value("host").(string))
-				remote := ctx.Value("remote").(string)
-				return rp.CreateConnection(host, url, remote)
+		ModifyResponse: func(res *http.Response) error {
+			url := res.Request.Context().Value("url").(string)
+			host := util.GetHostFromAddr(res.Request.Context().Value("host").(string))
+			routeCfg := rp.GetRouteConfig(host, url)
+			if routeCfg == nil {
+				return nil
+			}
+			if location := res.Header.Get("Location"); location != "" {
+				res.Header.Set("Location", restoreLocation(location, host, routeCfg))
+			}
+			for _, h := range routeCfg.RemoveResponseHeaders {
+				res.Header.Del(h)
+			}
+			for k, v := range routeCfg.ResponseHeaders {
+				res.Header.Set(k, v)
+			}
+			return nil
+		},
+		Transport: &routeTransport{
+			rp: rp,
+			http1: &http.Transport{
+				ResponseHeaderTimeout: rp.responseHeaderTimeout,
+				DisableKeepAlives:     true,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					url := ctx.Value("url").(string)
+					host := util.GetHostFromAddr(ctx.Value("host").(string))
+					remote := ctx.Value("remote").(string)
+					return rp.createConnection(ctx, host, url, remote)
+				},
+			},
+			http2: &http2.Transport{
+				AllowHTTP: true,
 			},
 		},
 		BufferPool: newWrapPool(),
 		ErrorLog:   log.New(newWrapLogger(), "", 0),
 		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
 			frpLog.Warn("do http proxy request error: %v", err)
-			rw.WriteHeader(http.StatusNotFound)
-			rw.Write(getNotFoundPageContent())
+			url := req.Context().Value("url").(string)
+			host := util.GetHostFromAddr(req.Context().Value("host").(string))
+			writeErrorPage(rw, req, host, errorStatus(err), rp.GetRouteConfig(host, url))
 		},
 	}
 	rp.proxy = proxy
@@ -123,71 +188,234 @@ func (rp *HttpReverseProxy) GetHeaders(domain string, location string) (headers
 	return
 }
 
+// GetRouteConfig returns the route config for domain and location, nil if not found
+func (rp *HttpReverseProxy) GetRouteConfig(domain string, location string) *VhostRouteConfig {
+	vr, ok := rp.getVhost(domain, location)
+	if ok {
+		return vr.payload.(*VhostRouteConfig)
+	}
+	return nil
+}
+
 // CreateConnection create a new connection by route config
 func (rp *HttpReverseProxy) CreateConnection(domain string, location string, remoteAddr string) (net.Conn, error) {
 	vr, ok := rp.getVhost(domain, location)
 	if ok {
 		fn := vr.payload.(*VhostRouteConfig).CreateConnFn
 		if fn != nil {
-			return fn(remoteAddr)
+			conn, err := fn(remoteAddr)
+			if err != nil {
+				// frpc is not able to provide a work connection now
+				return nil, &statusError{status: http.StatusServiceUnavailable, err: err}
+			}
+			return conn, nil
 		}
 	}
-	return nil, fmt.Errorf("%v: %s %s", ErrNoDomain, domain, location)
+	return nil, &statusError{
+		status: http.StatusNotFound,
+		err:    fmt.Errorf("%v: %s %s", ErrNoDomain, domain, location),
+	}
 }
 
-func (rp *HttpReverseProxy) CheckAuth(domain, location, user, passwd string) bool {
-	vr, ok := rp.getVhost(domain, location)
-	if ok {
-		checkUser := vr.payload.(*VhostRouteConfig).Username
-		checkPasswd := vr.payload.(*VhostRouteConfig).Password
-		if (checkUser != "" || checkPasswd != "") && (checkUser != user || checkPasswd != passwd) {
-			return false
+// createConnection is the same as CreateConnection, but replayed requests
+// are sent to the proxy which captured them.
+func (rp *HttpReverseProxy) createConnection(ctx context.Context, domain string, location string, remoteAddr string) (net.Conn, error) {
+	if fn, ok := ctx.Value(replayKey{}).(CreateConnFunc); ok && fn != nil {
+		conn, err := fn(remoteAddr)
+		if err != nil {
+			return nil, &statusError{status: http.StatusServiceUnavailable, err: err}
 		}
+		return conn, nil
 	}
-	return true
+	return rp.CreateConnection(domain, location, remoteAddr)
 }
 
 // getVhost get vhost router by domain and location
 func (rp *HttpReverseProxy) getVhost(domain string, location string) (vr *VhostRouter, ok bool) {
-	// first we check the full hostname
-	// if not exist, then check the wildcard_domain such as *.example.com
-	vr, ok = rp.vhostRouter.Get(domain, location)
-	if ok {
+	// exact, wildcard and regexp domains are matched by vhostRouter
+	return rp.vhostRouter.Get(domain, location)
+}
+
+func (rp *HttpReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	domain := util.GetHostFromAddr(req.Host)
+	location := req.URL.Path
+	if location == OidcCallbackPath && rp.serveOidcCallback(rw, req) {
 		return
 	}
 
-	domainSplit := strings.Split(domain, ".")
-	if len(domainSplit) < 3 {
-		return nil, false
+	routeCfg := rp.GetRouteConfig(domain, location)
+	if retryAfter, ok := rp.maintenance.Get(domain); ok {
+		writeMaintenancePage(rw, req, domain, retryAfter, routeCfg)
+		return
 	}
-
-	for {
-		if len(domainSplit) < 3 {
-			return nil, false
+	if routeCfg == nil {
+		if ts := rp.getTombstone(domain, location); ts != nil {
+			frpLog.Debug("http request for host [%s] path [%s] matches an offline proxy", domain, location)
+			writeMaintenancePage(rw, req, domain, rp.retryAfter, ts.routeCfg)
+			return
+		}
+	}
+	if routeCfg != nil && routeCfg.Limiter != nil {
+		clientIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+		release, ok := routeCfg.Limiter.Acquire(clientIP)
+		if !ok {
+			frpLog.Debug("http request for host [%s] path [%s] from [%s] is rate limited", domain, location, req.RemoteAddr)
+			writeErrorPage(rw, req, domain, http.StatusTooManyRequests, routeCfg)
+			return
 		}
+		defer release()
+	}
 
-		domainSplit[0] = "*"
-		domain = strings.Join(domainSplit, ".")
-		vr, ok = rp.vhostRouter.Get(domain, location)
-		if ok {
-			return vr, true
+	user, ok := "", true
+	if isReplay(req) {
+		// the user was authenticated when the request was captured
+		user = req.Header.Get("X-Forwarded-User")
+	} else {
+		user, ok = rp.authenticate(rw, req, routeCfg)
+	}
+	if !ok {
+		return
+	}
+	if routeCfg != nil && routeCfg.authRequired() {
+		// the user can't be set by clients
+		req.Header.Del("X-Forwarded-User")
+		if user != "" {
+			req.Header.Set("X-Forwarded-User", user)
 		}
-		domainSplit = domainSplit[1:]
 	}
-}
 
-func (rp *HttpReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	domain := util.GetHostFromAddr(req.Host)
-	location := req.URL.Path
-	user, passwd, _ := req.BasicAuth()
-	if !rp.CheckAuth(domain, location, user, passwd) {
-		rw.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	// protocol upgrades like websocket are neither cached nor compressed
+	if routeCfg == nil || req.Header.Get("Upgrade") != "" {
+		rp.proxy.ServeHTTP(rw, req)
+		return
+	}
+	// replays should reach the local service and return the original response
+	replay := isReplay(req)
+	if routeCfg.Compression && !replay {
+		if cw := newCompressWriter(rw, req); cw != nil {
+			defer cw.Close()
+			rw = cw
+		}
+	}
+	if routeCfg.Inspector != nil {
+		iw := routeCfg.Inspector.inspect(rw, req)
+		defer iw.finish()
+		rw = iw
+	}
+	if routeCfg.Cache && rp.cache != nil && !replay {
+		rp.cache.Serve(rw, req, domain, routeCfg.authRequired(), rp.proxy)
 		return
 	}
 	rp.proxy.ServeHTTP(rw, req)
 }
 
+// setForwardedHeaders tells the local service about the original client
+// by X-Forwarded-For, X-Forwarded-Proto and X-Real-IP headers.
+func setForwardedHeaders(req *http.Request, routeCfg *VhostRouteConfig) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = ""
+	}
+
+	if clientIP != "" && (routeCfg == nil || !routeCfg.DisableXForwardedFor) {
+		// If we aren't the first proxy retain prior
+		// X-Forwarded-For information as a comma+space
+		// separated list and fold multiple headers into one.
+		xff := clientIP
+		if prior, ok := req.Header["X-Forwarded-For"]; ok {
+			xff = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		req.Header.Set("X-Forwarded-For", xff)
+	}
+
+	if routeCfg == nil || !routeCfg.DisableXForwardedProto {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	if clientIP != "" && routeCfg != nil && routeCfg.AddXRealIp {
+		req.Header.Set("X-Real-IP", clientIP)
+	}
+}
+
+// PathRewriteRule rewrites request paths matched by Pattern to Replacement
+type PathRewriteRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// rewritePath returns the path forwarded to the local service. StripPrefix is
+// removed first, then the first matched PathRewrites rule is applied, at last
+// AddPrefix is added.
+func rewritePath(path string, routeCfg *VhostRouteConfig) string {
+	if routeCfg.StripPrefix != "" {
+		path, _ = trimPathPrefix(path, routeCfg.StripPrefix)
+	}
+	for _, rule := range routeCfg.PathRewrites {
+		if rule.Pattern.MatchString(path) {
+			path = rule.Pattern.ReplaceAllString(path, rule.Replacement)
+			break
+		}
+	}
+	if routeCfg.AddPrefix != "" {
+		path = joinPath(routeCfg.AddPrefix, path)
+	}
+	return path
+}
+
+// restoreLocation rewrites the Location header of redirects to the path
+// which clients see. Only StripPrefix and AddPrefix can be restored, paths
+// rewritten by PathRewrites are kept as they are.
+func restoreLocation(location string, host string, routeCfg *VhostRouteConfig) string {
+	if routeCfg.StripPrefix == "" && routeCfg.AddPrefix == "" {
+		return location
+	}
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(u.Path, "/") {
+		return location
+	}
+	// redirects to other sites are not changed
+	if u.Host != "" && u.Hostname() != host && u.Hostname() != util.GetHostFromAddr(routeCfg.RewriteHost) {
+		return location
+	}
+
+	path := u.Path
+	if routeCfg.AddPrefix != "" {
+		var ok bool
+		if path, ok = trimPathPrefix(path, routeCfg.AddPrefix); !ok {
+			return location
+		}
+	}
+	if routeCfg.StripPrefix != "" {
+		path = joinPath(routeCfg.StripPrefix, path)
+	}
+	u.Path = path
+	u.RawPath = ""
+	return u.String()
+}
+
+// trimPathPrefix removes prefix from path if prefix matches whole segments of
+// path, the result always starts with '/'.
+func trimPathPrefix(path string, prefix string) (string, bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	switch {
+	case prefix == "":
+		return path, true
+	case path == prefix:
+		return "/", true
+	case strings.HasPrefix(path, prefix+"/"):
+		return path[len(prefix):], true
+	}
+	return path, false
+}
+
+func joinPath(prefix string, path string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
 type wrapPool struct{}
 
 func newWrapPool() *wrapPool { return &wrapPool{} }
@@ -0,0 +1,169 @@
+package vhost
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/utils/limit"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestHttpReverseProxyHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Powered-By", "test")
+		w.Header().Set("X-Debug", r.Header.Get("X-Debug"))
+		w.Header().Set("X-Got-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Got-Forwarded-Proto", r.Header.Get("X-Forwarded-Proto"))
+		w.Header().Set("X-Got-Real-IP", r.Header.Get("X-Real-IP"))
+	}))
+	defer backend.Close()
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:                "example.com",
+		ResponseHeaders:       map[string]string{"Server": "frp"},
+		RemoveRequestHeaders:  []string{"X-Debug"},
+		RemoveResponseHeaders: []string{"X-Powered-By"},
+		DisableXForwardedFor:  true,
+		AddXRealIp:            true,
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Debug", "1")
+	rw := httptest.NewRecorder()
+	rp.ServeHTTP(rw, req)
+
+	header := rw.Result().Header
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("frp", header.Get("Server"))
+	assert.Equal("", header.Get("X-Powered-By"))
+	assert.Equal("", header.Get("X-Debug"))
+	assert.Equal("", header.Get("X-Got-Forwarded-For"))
+	assert.Equal("http", header.Get("X-Got-Forwarded-Proto"))
+	assert.Equal("10.0.0.1", header.Get("X-Got-Real-IP"))
+}
+
+func TestRewritePath(t *testing.T) {
+	assert := assert.New(t)
+	routeCfg := &VhostRouteConfig{
+		StripPrefix: "/device42",
+		PathRewrites: []PathRewriteRule{
+			{Pattern: regexp.MustCompile(`^/old/(.*)$`), Replacement: "/new/$1"},
+			{Pattern: regexp.MustCompile(`^/old/a$`), Replacement: "/never"},
+		},
+		AddPrefix: "/api",
+	}
+	assert.Equal("/api/", rewritePath("/device42", routeCfg))
+	assert.Equal("/api/index.html", rewritePath("/device42/index.html", routeCfg))
+	assert.Equal("/api/new/a", rewritePath("/device42/old/a", routeCfg))
+	assert.Equal("/api/device421/a", rewritePath("/device421/a", routeCfg))
+
+	assert.Equal("/device42/login", restoreLocation("/api/login", "example.com", routeCfg))
+	assert.Equal("http://example.com/device42/login?a=1", restoreLocation("http://example.com/api/login?a=1", "example.com", routeCfg))
+	assert.Equal("http://other.com/api/login", restoreLocation("http://other.com/api/login", "example.com", routeCfg))
+	assert.Equal("/login", restoreLocation("/login", "example.com", routeCfg))
+}
+
+func TestHttpReverseProxyLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:  "example.com",
+		Limiter: limit.NewConnLimiter(limit.ConnLimiterOptions{PerIpMaxConcurrent: 1}),
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	serve := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = remoteAddr
+		rw := httptest.NewRecorder()
+		rp.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- serve("10.0.0.1:1234")
+	}()
+	// wait for the first request to reach the backend
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(http.StatusTooManyRequests, serve("10.0.0.1:1235"))
+
+	close(release)
+	assert.Equal(http.StatusOK, serve("10.0.0.2:1234"))
+	assert.Equal(http.StatusOK, <-done)
+	assert.Equal(http.StatusOK, serve("10.0.0.1:1235"))
+}
+
+func TestHttpReverseProxyHttp2(t *testing.T) {
+	assert := assert.New(t)
+
+	// a gRPC like backend which only speaks h2c and sends trailers
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-Proto", r.Proto)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:     "example.com",
+		LocalHttp2: true,
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+	frontend := httptest.NewServer(h2c.NewHandler(rp, &http2.Server{}))
+	defer frontend.Close()
+
+	// visitors use h2c with prior knowledge
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, frontend.Listener.Addr().String())
+			},
+		},
+	}
+	res, err := client.Get("http://example.com/")
+	if assert.NoError(err) {
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.NoError(err)
+		assert.Equal("HTTP/2.0", res.Proto)
+		assert.Equal("HTTP/2.0", res.Header.Get("X-Backend-Proto"))
+		assert.Equal("hello", string(body))
+		assert.Equal("0", res.Trailer.Get("Grpc-Status"))
+	}
+}
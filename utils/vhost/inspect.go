@@ -0,0 +1,324 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bodies of captured requests and responses are truncated to this size
+const inspectMaxBodySize = 16 * 1024
+
+// values of these headers are credentials, they are not recorded
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+const redactedValue = "[redacted]"
+
+// replayKey marks requests sent by Replay in their contexts, the value is the
+// CreateConnFunc of the proxy which captured the request.
+type replayKey struct{}
+
+type CapturedRequest struct {
+	Method        string      `json:"method"`
+	Scheme        string      `json:"scheme"`
+	Host          string      `json:"host"`
+	Uri           string      `json:"uri"`
+	Proto         string      `json:"proto"`
+	RemoteAddr    string      `json:"remote_addr"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body"`
+	BodyTruncated bool        `json:"body_truncated"`
+}
+
+type CapturedResponse struct {
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body"`
+	BodyTruncated bool        `json:"body_truncated"`
+}
+
+// Capture is a request and its response recorded by Inspector.
+type Capture struct {
+	Id         uint64            `json:"id"`
+	Time       time.Time         `json:"time"`
+	DurationMs int64             `json:"duration_ms"`
+	Request    *CapturedRequest  `json:"request"`
+	Response   *CapturedResponse `json:"response"`
+}
+
+// Inspector records the last requests and responses of a proxy for
+// debugging.
+type Inspector struct {
+	max      int
+	captures []*Capture
+	seq      uint64
+	mu       sync.RWMutex
+}
+
+// NewInspector returns nil if max is not positive.
+func NewInspector(max int) *Inspector {
+	if max <= 0 {
+		return nil
+	}
+	return &Inspector{
+		max:      max,
+		captures: make([]*Capture, 0, max),
+	}
+}
+
+// List returns captures from the newest to the oldest.
+func (i *Inspector) List() []*Capture {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	list := make([]*Capture, 0, len(i.captures))
+	for j := len(i.captures) - 1; j >= 0; j-- {
+		list = append(list, i.captures[j])
+	}
+	return list
+}
+
+// Get returns nil if there is no capture with id.
+func (i *Inspector) Get(id uint64) *Capture {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for _, c := range i.captures {
+		if c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (i *Inspector) Clear() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.captures = i.captures[:0]
+}
+
+func (i *Inspector) add(c *Capture) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.seq++
+	c.Id = i.seq
+	if len(i.captures) >= i.max {
+		copy(i.captures, i.captures[1:])
+		i.captures = i.captures[:len(i.captures)-1]
+	}
+	i.captures = append(i.captures, c)
+}
+
+// inspect records req and the response written to the returned writer, the
+// capture is added after finish of the writer is called.
+func (i *Inspector) inspect(rw http.ResponseWriter, req *http.Request) *inspectWriter {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	body := &limitedBuffer{limit: inspectMaxBodySize}
+	req.Body = &captureBody{ReadCloser: req.Body, buf: body}
+	return &inspectWriter{
+		ResponseWriter: rw,
+		inspector:      i,
+		start:          time.Now(),
+		req: &CapturedRequest{
+			Method:     req.Method,
+			Scheme:     scheme,
+			Host:       req.Host,
+			Uri:        req.URL.RequestURI(),
+			Proto:      req.Proto,
+			RemoteAddr: req.RemoteAddr,
+			Header:     redactHeader(req.Header),
+		},
+		reqBody:  body,
+		respBody: &limitedBuffer{limit: inspectMaxBodySize},
+	}
+}
+
+// Replay sends the request of c to this reverse proxy again, the response is
+// returned and also recorded by the inspector of the route. Credentials are
+// not recorded, so the request was authenticated when it was captured and it
+// is not authenticated again. The request is sent by createConnFn of the proxy
+// which captured it, so it doesn't go to another proxy of a load balancing
+// group, and it's neither served from the cache nor compressed.
+func (rp *HttpReverseProxy) Replay(c *Capture, createConnFn CreateConnFunc) (*CapturedResponse, error) {
+	r := c.Request
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.Uri, strings.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), replayKey{}, createConnFn))
+	req.Host = r.Host
+	req.RequestURI = r.Uri
+	req.RemoteAddr = r.RemoteAddr
+	req.Header = r.Header.Clone()
+	for _, h := range redactedHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Del("Content-Length")
+	req.ContentLength = int64(len(r.Body))
+	if r.Scheme == "https" {
+		req.TLS = &tls.ConnectionState{ServerName: r.Host}
+	}
+
+	w := &inspectWriter{
+		ResponseWriter: &discardWriter{header: make(http.Header)},
+		respBody:       &limitedBuffer{limit: inspectMaxBodySize},
+	}
+	rp.ServeHTTP(w, req)
+	return w.response(), nil
+}
+
+// inspectWriter records the response written by HttpReverseProxy.
+type inspectWriter struct {
+	http.ResponseWriter
+	inspector *Inspector
+	start     time.Time
+	req       *CapturedRequest
+	reqBody   *limitedBuffer
+
+	wroteHeader bool
+	status      int
+	header      http.Header
+	respBody    *limitedBuffer
+}
+
+func (w *inspectWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	w.header = redactHeader(w.ResponseWriter.Header())
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *inspectWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.respBody.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *inspectWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *inspectWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *inspectWriter) response() *CapturedResponse {
+	body, truncated := w.respBody.get()
+	return &CapturedResponse{
+		Status:        w.status,
+		Header:        w.header,
+		Body:          body,
+		BodyTruncated: truncated,
+	}
+}
+
+// finish adds the capture to the inspector.
+func (w *inspectWriter) finish() {
+	w.req.Body, w.req.BodyTruncated = w.reqBody.get()
+	w.inspector.add(&Capture{
+		Time:       w.start,
+		DurationMs: int64(time.Since(w.start) / time.Millisecond),
+		Request:    w.req,
+		Response:   w.response(),
+	})
+}
+
+// redactHeader returns a copy of header whose credentials are redacted.
+func redactHeader(header http.Header) http.Header {
+	h := header.Clone()
+	for _, name := range redactedHeaders {
+		if vs, ok := h[name]; ok {
+			for j := range vs {
+				vs[j] = redactedValue
+			}
+		}
+	}
+	return h
+}
+
+// isReplay returns true if req is sent by Replay.
+func isReplay(req *http.Request) bool {
+	_, ok := req.Context().Value(replayKey{}).(CreateConnFunc)
+	return ok
+}
+
+// captureBody copies the request body to buf while it's read.
+type captureBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (b *captureBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return
+}
+
+// limitedBuffer keeps the first limit bytes written to it, it can be written
+// and read by different goroutines.
+type limitedBuffer struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+	mu        sync.Mutex
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if left := b.limit - b.buf.Len(); len(p) > left {
+		b.truncated = true
+		p = p[:left]
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) get() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), b.truncated
+}
+
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardWriter) WriteHeader(status int) {}
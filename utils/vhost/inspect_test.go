@@ -0,0 +1,175 @@
+package vhost
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspector(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("got " + string(body)))
+	}))
+	defer backend.Close()
+
+	inspector := NewInspector(2)
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:    "example.com",
+		Inspector: inspector,
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	for _, body := range []string{"a", "b", strings.Repeat("c", inspectMaxBodySize+1)} {
+		req := httptest.NewRequest("POST", "http://example.com/api?x=1", strings.NewReader(body))
+		req.Header.Set("X-Test", "1")
+		rp.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	captures := inspector.List()
+	assert.Len(captures, 2)
+	c := captures[1]
+	assert.EqualValues(2, c.Id)
+	assert.Equal("POST", c.Request.Method)
+	assert.Equal("example.com", c.Request.Host)
+	assert.Equal("/api?x=1", c.Request.Uri)
+	assert.Equal("1", c.Request.Header.Get("X-Test"))
+	assert.Equal("b", c.Request.Body)
+	assert.Equal(http.StatusCreated, c.Response.Status)
+	assert.Equal("POST", c.Response.Header.Get("X-Method"))
+	assert.Equal("got b", c.Response.Body)
+	assert.False(c.Response.BodyTruncated)
+	assert.True(captures[0].Request.BodyTruncated)
+	assert.Len(captures[0].Request.Body, inspectMaxBodySize)
+	assert.Nil(inspector.Get(1))
+
+	resp, err := rp.Replay(c, nil)
+	assert.NoError(err)
+	assert.Equal(http.StatusCreated, resp.Status)
+	assert.Equal("got b", resp.Body)
+	captures = inspector.List()
+	assert.EqualValues(4, captures[0].Id)
+	assert.Equal("b", captures[0].Request.Body)
+
+	inspector.Clear()
+	assert.Len(inspector.List(), 0)
+}
+
+func TestInspectorRedactsCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.Write([]byte(r.Header.Get("Authorization") + "|" + r.Header.Get("Cookie")))
+	}))
+	defer backend.Close()
+
+	inspector := NewInspector(10)
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{}, NewVhostRouters())
+	err := rp.Register(VhostRouteConfig{
+		Domain:       "example.com",
+		Inspector:    inspector,
+		BearerTokens: []string{"token1"},
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Authorization", "Bearer token1")
+	req.Header.Set("Proxy-Authorization", "Basic dXNlcjpwYXNz")
+	req.AddCookie(&http.Cookie{Name: "a", Value: "b"})
+	rw := httptest.NewRecorder()
+	rp.ServeHTTP(rw, req)
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("Bearer token1|a=b", rw.Body.String())
+	assert.Equal("session=secret", rw.Header().Get("Set-Cookie"))
+
+	c := inspector.List()[0]
+	for _, h := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+		assert.Equal(redactedValue, c.Request.Header.Get(h), h)
+	}
+	assert.Equal(redactedValue, c.Response.Header.Get("Set-Cookie"))
+
+	// replayed requests are not authenticated again and redacted values are
+	// not sent
+	resp, err := rp.Replay(c, nil)
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.Status)
+	assert.Equal("|", resp.Body)
+
+	// other requests are still authenticated
+	rw = httptest.NewRecorder()
+	rp.ServeHTTP(rw, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(http.StatusUnauthorized, rw.Code)
+}
+
+func TestInspectorReplayToCapturingProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(name + " " + strings.Repeat("x", 1024)))
+		}))
+	}
+	member1, member2 := newBackend("member1"), newBackend("member2")
+	defer member1.Close()
+	defer member2.Close()
+	dial := func(backend *httptest.Server) CreateConnFunc {
+		return func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "frp_http_cache")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	cache, err := NewHttpCache(HttpCacheOptions{MaxSize: 1024 * 1024, MaxObjectSize: 1024 * 1024, Dir: dir})
+	assert.NoError(err)
+
+	// the route of a group is served by member1 now
+	inspector := NewInspector(10)
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{Cache: cache}, NewVhostRouters())
+	err = rp.Register(VhostRouteConfig{
+		Domain:       "example.com",
+		Cache:        true,
+		Compression:  true,
+		Inspector:    inspector,
+		CreateConnFn: dial(member1),
+	})
+	assert.NoError(err)
+
+	req := httptest.NewRequest("GET", "http://example.com/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rw := httptest.NewRecorder()
+	rp.ServeHTTP(rw, req)
+	assert.Equal("gzip", rw.Header().Get("Content-Encoding"))
+	count, _ := cache.Size()
+	assert.Equal(1, count)
+
+	// the request captured by member2 is sent to member2, the cached response
+	// of member1 is not used and the response is not compressed
+	c := inspector.List()[0]
+	resp, err := rp.Replay(c, dial(member2))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.Status)
+	assert.True(strings.HasPrefix(resp.Body, "member2 "))
+	assert.Equal("", resp.Header.Get("Content-Encoding"))
+	assert.Equal("", resp.Header.Get("X-Cache"))
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceDomain is a domain in maintenance mode.
+type MaintenanceDomain struct {
+	Domain     string `json:"domain"`
+	RetryAfter int64  `json:"retry_after"`
+}
+
+// Maintenance records domains in maintenance mode, requests for them get
+// maintenance pages even if their proxies are online.
+type Maintenance struct {
+	domains map[string]time.Duration
+	mu      sync.RWMutex
+}
+
+func NewMaintenance() *Maintenance {
+	return &Maintenance{
+		domains: make(map[string]time.Duration),
+	}
+}
+
+// Set puts domain into maintenance mode, retryAfter is sent to clients by
+// the Retry-After header.
+func (m *Maintenance) Set(domain string, retryAfter time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.domains[strings.ToLower(domain)] = retryAfter
+}
+
+// Unset returns false if domain is not in maintenance mode.
+func (m *Maintenance) Unset(domain string) bool {
+	domain = strings.ToLower(domain)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.domains[domain]
+	delete(m.domains, domain)
+	return ok
+}
+
+func (m *Maintenance) Get(domain string) (retryAfter time.Duration, ok bool) {
+	if m == nil {
+		return 0, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	retryAfter, ok = m.domains[strings.ToLower(domain)]
+	return
+}
+
+// List returns all domains in maintenance mode sorted by names.
+func (m *Maintenance) List() []MaintenanceDomain {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]MaintenanceDomain, 0, len(m.domains))
+	for domain, retryAfter := range m.domains {
+		list = append(list, MaintenanceDomain{
+			Domain:     domain,
+			RetryAfter: int64(retryAfter / time.Second),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Domain < list[j].Domain
+	})
+	return list
+}
+
+// tombstone is the route config of an offline proxy, it's kept for a while
+// to serve maintenance pages instead of 404.
+type tombstone struct {
+	routeCfg *VhostRouteConfig
+	expire   time.Time
+}
+
+// AddTombstone keeps routeCfg of an offline proxy for TombstoneTTL. Requests
+// which don't match any online route but match it get maintenance pages.
+func (rp *HttpReverseProxy) AddTombstone(routeCfg VhostRouteConfig) {
+	if rp.tombstoneTTL <= 0 {
+		return
+	}
+	routeCfg.CreateConnFn = nil
+	routeCfg.Limiter = nil
+
+	now := time.Now()
+	key := routeCfg.Domain + " " + routeCfg.Location
+	rp.tombstoneMu.Lock()
+	defer rp.tombstoneMu.Unlock()
+	// remove expired tombstones of proxies which are not visited any more
+	for k, ts := range rp.tombstoneByKey {
+		if now.After(ts.expire) {
+			rp.tombstones.Del(ts.routeCfg.Domain, ts.routeCfg.Location)
+			delete(rp.tombstoneByKey, k)
+		}
+	}
+
+	rp.tombstones.Del(routeCfg.Domain, routeCfg.Location)
+	ts := &tombstone{
+		routeCfg: &routeCfg,
+		expire:   now.Add(rp.tombstoneTTL),
+	}
+	if err := rp.tombstones.Add(routeCfg.Domain, routeCfg.Location, ts); err != nil {
+		return
+	}
+	rp.tombstoneByKey[key] = ts
+}
+
+// getTombstone returns nil if there is no tombstone for domain and location.
+func (rp *HttpReverseProxy) getTombstone(domain string, location string) *tombstone {
+	vr, ok := rp.tombstones.Get(domain, location)
+	if !ok {
+		return nil
+	}
+	ts := vr.payload.(*tombstone)
+	if time.Now().After(ts.expire) {
+		return nil
+	}
+	return ts
+}
+
+// writeMaintenancePage writes a 503 page with Retry-After header. Templates
+// named "maintenance" are used first, then those of 503.
+func writeMaintenancePage(rw http.ResponseWriter, req *http.Request, host string, retryAfter time.Duration, routeCfg *VhostRouteConfig) {
+	if retryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.FormatInt(int64(retryAfter/time.Second), 10))
+	}
+	writeNamedErrorPage(rw, req, host, http.StatusServiceUnavailable, []string{"maintenance", "503"}, routeCfg)
+}
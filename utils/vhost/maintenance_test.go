@@ -0,0 +1,78 @@
+package vhost
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("online"))
+	}))
+	defer backend.Close()
+
+	maintenance := NewMaintenance()
+	rp := NewHttpReverseProxy(HttpReverseProxyOptions{
+		Maintenance:            maintenance,
+		TombstoneTTLS:          60,
+		MaintenanceRetryAfterS: 30,
+	}, NewVhostRouters())
+	routeCfg := VhostRouteConfig{
+		Domain:     "example.com",
+		Location:   "/app",
+		ErrorPages: map[string]string{"maintenance.html": "back soon"},
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	}
+	assert.NoError(rp.Register(routeCfg))
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		rw := httptest.NewRecorder()
+		rp.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := serve("http://example.com/app")
+	assert.Equal(http.StatusOK, rw.Code)
+	assert.Equal("online", rw.Body.String())
+
+	// maintenance mode of a live domain
+	maintenance.Set("Example.com", 2*time.Minute)
+	assert.Equal([]MaintenanceDomain{{Domain: "example.com", RetryAfter: 120}}, maintenance.List())
+	rw = serve("http://example.com/app")
+	assert.Equal(http.StatusServiceUnavailable, rw.Code)
+	assert.Equal("120", rw.Header().Get("Retry-After"))
+	assert.Equal("back soon", rw.Body.String())
+	assert.True(maintenance.Unset("example.com"))
+	assert.False(maintenance.Unset("example.com"))
+
+	// tombstone of an offline proxy
+	rp.UnRegister(routeCfg.Domain, routeCfg.Location)
+	rp.AddTombstone(routeCfg)
+	rw = serve("http://example.com/app/index.html")
+	assert.Equal(http.StatusServiceUnavailable, rw.Code)
+	assert.Equal("30", rw.Header().Get("Retry-After"))
+	assert.Equal("back soon", rw.Body.String())
+	rw = serve("http://example.com/other")
+	assert.Equal(http.StatusNotFound, rw.Code)
+
+	// online again
+	assert.NoError(rp.Register(routeCfg))
+	rw = serve("http://example.com/app")
+	assert.Equal(http.StatusOK, rw.Code)
+
+	// expired tombstone
+	rp.UnRegister(routeCfg.Domain, routeCfg.Location)
+	rp.tombstoneByKey["example.com /app"].expire = time.Now().Add(-time.Second)
+	rw = serve("http://example.com/app")
+	assert.Equal(http.StatusNotFound, rw.Code)
+}
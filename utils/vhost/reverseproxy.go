@@ -11,7 +11,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -240,15 +239,10 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		outreq.Header.Set("Upgrade", reqUpType)
 	}
 
-	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-		// If we aren't the first proxy retain prior
-		// X-Forwarded-For information as a comma+space
-		// separated list and fold multiple headers into one.
-		if prior, ok := outreq.Header["X-Forwarded-For"]; ok {
-			clientIP = strings.Join(prior, ", ") + ", " + clientIP
-		}
-		outreq.Header.Set("X-Forwarded-For", clientIP)
-	}
+	// =============================
+	// Modified for frp
+	// X-Forwarded-For is set by Director of HttpReverseProxy
+	// =============================
 
 	res, err := transport.RoundTrip(outreq)
 	if err != nil {
@@ -371,7 +365,15 @@ func (p *ReverseProxy) flushInterval(req *http.Request, res *http.Response) time
 		return -1 // negative means immediately
 	}
 
-	// TODO: more specific cases? e.g. res.ContentLength == -1?
+	// =============================
+	// Modified for frp
+	// Streaming responses without Content-Length, such as gRPC responses,
+	// are flushed immediately.
+	if res.ContentLength == -1 {
+		return -1
+	}
+	// =============================
+
 	return p.FlushInterval
 }
 
@@ -2,6 +2,9 @@ package vhost
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
 	"sort"
 	"strings"
 	"sync"
@@ -11,9 +14,19 @@ var (
 	ErrRouterConfigConflict = errors.New("router config conflict")
 )
 
+// VhostRouters routes requests by host and path. Besides exact domains like
+// "www.example.com", a domain can be a wildcard domain with one '*' matching
+// any non-empty string like "api-*.example.com", or a regexp prefixed by '~'
+// like "~^api[0-9]+\.example\.com$". Exact domains take precedence over
+// wildcard domains, longer wildcard domains take precedence over shorter
+// ones, and regexp domains are tried at last, longer ones first.
 type VhostRouters struct {
 	RouterByDomain map[string][]*VhostRouter
-	mutex          sync.RWMutex
+
+	// sorted by priority
+	wildcards []*hostPattern
+	regexps   []*hostPattern
+	mutex     sync.RWMutex
 }
 
 type VhostRouter struct {
@@ -23,9 +36,78 @@ type VhostRouter struct {
 	payload interface{}
 }
 
+// hostPattern is a wildcard or regexp domain
+type hostPattern struct {
+	domain string
+
+	// wildcard domain is split to prefix and suffix by '*'
+	prefix string
+	suffix string
+
+	re *regexp.Regexp
+	// canonical is the simplified form of the regexp, regexps written in
+	// different ways but with the same canonical form are the same pattern
+	canonical string
+}
+
+func newHostPattern(domain string) (p *hostPattern, err error) {
+	p = &hostPattern{
+		domain: domain,
+	}
+	switch {
+	case strings.HasPrefix(domain, "~"):
+		if p.re, err = regexp.Compile(domain[1:]); err != nil {
+			return nil, fmt.Errorf("invalid regexp domain [%s]: %v", domain, err)
+		}
+		re, _ := syntax.Parse(domain[1:], syntax.Perl)
+		p.canonical = re.Simplify().String()
+	case strings.Count(domain, "*") == 1:
+		i := strings.Index(domain, "*")
+		p.prefix, p.suffix = domain[:i], domain[i+1:]
+	case strings.Contains(domain, "*"):
+		return nil, fmt.Errorf("wildcard domain [%s] should contain only one '*'", domain)
+	default:
+		// exact domain
+		return nil, nil
+	}
+	return p, nil
+}
+
+func (p *hostPattern) match(host string) bool {
+	if p.re != nil {
+		return p.re.MatchString(host)
+	}
+	return len(host) > len(p.prefix)+len(p.suffix) &&
+		strings.HasPrefix(host, p.prefix) && strings.HasSuffix(host, p.suffix)
+}
+
+// overlap returns true if some host can be matched by both wildcard domains
+func (p *hostPattern) overlap(cmp *hostPattern) bool {
+	return (strings.HasPrefix(p.prefix, cmp.prefix) || strings.HasPrefix(cmp.prefix, p.prefix)) &&
+		(strings.HasSuffix(p.suffix, cmp.suffix) || strings.HasSuffix(cmp.suffix, p.suffix))
+}
+
+// byPriority sorts host patterns by length, then by domain
+type byPriority []*hostPattern
+
+func (a byPriority) Len() int {
+	return len(a)
+}
+func (a byPriority) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a byPriority) Less(i, j int) bool {
+	if len(a[i].domain) != len(a[j].domain) {
+		return len(a[i].domain) > len(a[j].domain)
+	}
+	return a[i].domain < a[j].domain
+}
+
 func NewVhostRouters() *VhostRouters {
 	return &VhostRouters{
 		RouterByDomain: make(map[string][]*VhostRouter),
+		wildcards:      make([]*hostPattern, 0),
+		regexps:        make([]*hostPattern, 0),
 	}
 }
 
@@ -37,8 +119,41 @@ func (r *VhostRouters) Add(domain, location string, payload interface{}) error {
 		return ErrRouterConfigConflict
 	}
 
+	p, err := newHostPattern(domain)
+	if err != nil {
+		return err
+	}
+	if p != nil && p.re == nil {
+		// wildcard domains with the same length have the same priority,
+		// so they can't match the same host with the same location
+		for _, tmp := range r.wildcards {
+			if tmp.domain != domain && len(tmp.domain) == len(domain) && tmp.overlap(p) {
+				if _, exist := r.exist(tmp.domain, location); exist {
+					return fmt.Errorf("%v: [%s] overlaps with [%s]", ErrRouterConfigConflict, domain, tmp.domain)
+				}
+			}
+		}
+	} else if p != nil {
+		// the same regexp written in another way is rejected like the same
+		// exact domain
+		for _, tmp := range r.regexps {
+			if tmp.domain != domain && tmp.canonical == p.canonical {
+				if _, exist := r.exist(tmp.domain, location); exist {
+					return fmt.Errorf("%v: [%s] is the same as [%s]", ErrRouterConfigConflict, domain, tmp.domain)
+				}
+			}
+		}
+	}
+
 	vrs, found := r.RouterByDomain[domain]
 	if !found {
+		if p != nil && p.re == nil {
+			r.wildcards = append(r.wildcards, p)
+			sort.Sort(byPriority(r.wildcards))
+		} else if p != nil {
+			r.regexps = append(r.regexps, p)
+			sort.Sort(byPriority(r.regexps))
+		}
 		vrs = make([]*VhostRouter, 0, 1)
 	}
 
@@ -68,14 +183,43 @@ func (r *VhostRouters) Del(domain, location string) {
 			newVrs = append(newVrs, vr)
 		}
 	}
-	r.RouterByDomain[domain] = newVrs
+	if len(newVrs) > 0 {
+		r.RouterByDomain[domain] = newVrs
+		return
+	}
+
+	delete(r.RouterByDomain, domain)
+	r.wildcards = removeHostPattern(r.wildcards, domain)
+	r.regexps = removeHostPattern(r.regexps, domain)
 }
 
+// Get returns the router for host and path by the priority of domains
 func (r *VhostRouters) Get(host, path string) (vr *VhostRouter, exist bool) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
-	vrs, found := r.RouterByDomain[host]
+	if vr, exist = r.get(host, path); exist {
+		return
+	}
+	for _, p := range r.wildcards {
+		if p.match(host) {
+			if vr, exist = r.get(p.domain, path); exist {
+				return
+			}
+		}
+	}
+	for _, p := range r.regexps {
+		if p.match(host) {
+			if vr, exist = r.get(p.domain, path); exist {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (r *VhostRouters) get(domain, path string) (vr *VhostRouter, exist bool) {
+	vrs, found := r.RouterByDomain[domain]
 	if !found {
 		return
 	}
@@ -87,7 +231,7 @@ func (r *VhostRouters) Get(host, path string) (vr *VhostRouter, exist bool) {
 		}
 	}
 
-	return
+	return nil, false
 }
 
 func (r *VhostRouters) exist(host, path string) (vr *VhostRouter, exist bool) {
@@ -102,7 +246,16 @@ func (r *VhostRouters) exist(host, path string) (vr *VhostRouter, exist bool) {
 		}
 	}
 
-	return
+	return nil, false
+}
+
+func removeHostPattern(patterns []*hostPattern, domain string) []*hostPattern {
+	for i, p := range patterns {
+		if p.domain == domain {
+			return append(patterns[:i], patterns[i+1:]...)
+		}
+	}
+	return patterns
 }
 
 // sort by location
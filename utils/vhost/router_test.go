@@ -0,0 +1,68 @@
+package vhost
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVhostRoutersPriority(t *testing.T) {
+	assert := assert.New(t)
+	r := NewVhostRouters()
+	for _, domain := range []string{
+		"api.example.com",
+		"*.example.com",
+		"api-*.example.com",
+		`~^api-[0-9]+\.example\.org$`,
+		`~\.org$`,
+	} {
+		assert.NoError(r.Add(domain, "", domain))
+	}
+
+	get := func(host string) string {
+		vr, ok := r.Get(host, "/")
+		if !ok {
+			return ""
+		}
+		return vr.payload.(string)
+	}
+	assert.Equal("api.example.com", get("api.example.com"))
+	assert.Equal("api-*.example.com", get("api-1.example.com"))
+	assert.Equal("*.example.com", get("www.example.com"))
+	assert.Equal("*.example.com", get("a.b.example.com"))
+	assert.Equal("", get("example.com"))
+	assert.Equal(`~^api-[0-9]+\.example\.org$`, get("api-1.example.org"))
+	assert.Equal(`~\.org$`, get("www.example.org"))
+
+	// routes with a location of less specific domains are still matched
+	assert.NoError(r.Add("*.example.com", "/static", "static"))
+	vr, ok := r.Get("api-1.example.com", "/static/a.png")
+	assert.True(ok)
+	assert.Equal("api-*.example.com", vr.payload)
+
+	r.Del("api-*.example.com", "")
+	assert.Equal("*.example.com", get("api-1.example.com"))
+}
+
+func TestVhostRoutersConflict(t *testing.T) {
+	assert := assert.New(t)
+	r := NewVhostRouters()
+	assert.NoError(r.Add("api-*.example.com", "", nil))
+	assert.Equal(ErrRouterConfigConflict, r.Add("api-*.example.com", "", nil))
+
+	// both match api-v1x.example.com with the same priority
+	assert.Error(r.Add("*-v1x.example.com", "", nil))
+	assert.NoError(r.Add("*-v1x.example.com", "/v1", nil))
+	assert.NoError(r.Add("*-v1x.example.net", "", nil))
+
+	assert.Error(r.Add("a*b*.example.com", "", nil))
+	assert.Error(r.Add("~[", "", nil))
+
+	// the same regexp is rejected, even written in another way
+	assert.NoError(r.Add(`~^api\.example\.org$`, "", nil))
+	assert.Equal(ErrRouterConfigConflict, r.Add(`~^api\.example\.org$`, "", nil))
+	assert.Error(r.Add(`~^(?:api)\.example\.org$`, "", nil))
+	assert.Error(r.Add(`~^api[.]example[.]org$`, "", nil))
+	assert.NoError(r.Add(`~^api[.]example[.]org$`, "/v1", nil))
+	assert.NoError(r.Add(`~^api[0-9]\.example\.org$`, "", nil))
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	frpNet "github.com/fatedier/frp/utils/net"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+)
+
+type HttpsTerminatorOptions struct {
+	// static certificate, optional if acme is enabled
+	CertFile string
+	KeyFile  string
+
+	AcmeEnable       bool
+	AcmeEmail        string
+	AcmeDirectoryUrl string
+	AcmeCacheDir     string
+	AcmeCaFile       string
+
+	ResponseHeaderTimeoutS int64
+	HttpCache              *HttpCache
+	Maintenance            *Maintenance
+}
+
+// HttpsTerminator decrypts https connections for registered domains with its
+// own certificates, and serves the requests by a HttpReverseProxy.
+type HttpsTerminator struct {
+	muxer *HttpsMuxer
+	rp    *HttpReverseProxy
+
+	cert        *tls.Certificate
+	acmeManager *autocert.Manager
+
+	// listeners on https muxer for all registered domains
+	domains map[string]*terminatedDomain
+	ln      *frpNet.CustomListener
+	mu      sync.Mutex
+}
+
+type terminatedDomain struct {
+	ln   *Listener
+	refs int
+}
+
+func NewHttpsTerminator(muxer *HttpsMuxer, options HttpsTerminatorOptions) (*HttpsTerminator, error) {
+	t := &HttpsTerminator{
+		muxer: muxer,
+		rp: NewHttpReverseProxy(HttpReverseProxyOptions{
+			ResponseHeaderTimeoutS: options.ResponseHeaderTimeoutS,
+			Cache:                  options.HttpCache,
+			Maintenance:            options.Maintenance,
+		}, NewVhostRouters()),
+		domains: make(map[string]*terminatedDomain),
+		ln:      frpNet.NewCustomListener(),
+	}
+
+	if options.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate error: %v", err)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("parse certificate error: %v", err)
+		}
+		t.cert = &cert
+	}
+
+	if options.AcmeEnable {
+		client := &acme.Client{
+			DirectoryURL: options.AcmeDirectoryUrl,
+		}
+		if options.AcmeCaFile != "" {
+			caPem, err := ioutil.ReadFile(options.AcmeCaFile)
+			if err != nil {
+				return nil, fmt.Errorf("read acme ca file error: %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPem) {
+				return nil, fmt.Errorf("no certificate found in acme ca file")
+			}
+			client.HTTPClient = &http.Client{
+				Transport: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: &tls.Config{RootCAs: pool},
+				},
+			}
+		}
+		t.acmeManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(options.AcmeCacheDir),
+			HostPolicy: t.hostPolicy,
+			Email:      options.AcmeEmail,
+			Client:     client,
+		}
+	}
+
+	if t.cert == nil && t.acmeManager == nil {
+		return nil, fmt.Errorf("no certificate file and acme is disabled")
+	}
+
+	server := &http.Server{
+		Handler:  t.rp,
+		ErrorLog: log.New(newWrapLogger(), "", 0),
+	}
+	if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+		return nil, fmt.Errorf("configure http2 error: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: t.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+	go server.Serve(tls.NewListener(t.ln, tlsConfig))
+	return t, nil
+}
+
+// HTTPHandler returns a handler which answers ACME HTTP-01 challenges and
+// passes other requests to fallback.
+func (t *HttpsTerminator) HTTPHandler(fallback http.Handler) http.Handler {
+	if t.acmeManager == nil {
+		return fallback
+	}
+	return t.acmeManager.HTTPHandler(fallback)
+}
+
+// Register listens for the domain of routeCfg on https muxer if it's the first
+// route of this domain, and registers the route config to reverse proxy.
+func (t *HttpsTerminator) Register(ctx context.Context, routeCfg VhostRouteConfig) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.domains[routeCfg.Domain]
+	if !ok {
+		l, errRet := t.muxer.Listen(ctx, &VhostRouteConfig{Domain: routeCfg.Domain})
+		if errRet != nil {
+			return errRet
+		}
+		d = &terminatedDomain{ln: l}
+		go t.acceptWorker(l)
+	}
+
+	if err = t.rp.Register(routeCfg); err != nil {
+		if !ok {
+			d.ln.Close()
+		}
+		return
+	}
+	d.refs++
+	t.domains[routeCfg.Domain] = d
+	return nil
+}
+
+// Replay sends the captured request to the reverse proxy of terminated
+// https requests again.
+func (t *HttpsTerminator) Replay(c *Capture, createConnFn CreateConnFunc) (*CapturedResponse, error) {
+	return t.rp.Replay(c, createConnFn)
+}
+
+// UnRegister removes the route config, the domain will not be listened on
+// https muxer after all its routes are removed.
+func (t *HttpsTerminator) UnRegister(domain string, location string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rp.UnRegister(domain, location)
+	d, ok := t.domains[domain]
+	if !ok {
+		return
+	}
+	d.refs--
+	if d.refs <= 0 {
+		d.ln.Close()
+		delete(t.domains, domain)
+	}
+}
+
+func (t *HttpsTerminator) acceptWorker(l *Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		if err = t.ln.PutConn(c); err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+func (t *HttpsTerminator) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if t.acmeManager == nil {
+		return t.cert, nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if t.cert != nil && t.cert.Leaf.VerifyHostname(name) == nil {
+		return t.cert, nil
+	}
+	return t.acmeManager.GetCertificate(hello)
+}
+
+// hostPolicy only allows ACME certificates for registered domains.
+func (t *HttpsTerminator) hostPolicy(ctx context.Context, host string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.domains[host]; ok {
+		return nil
+	}
+	return fmt.Errorf("domain [%s] is not registered for tls termination", host)
+}
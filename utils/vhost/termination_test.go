@@ -0,0 +1,108 @@
+package vhost
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestCert(t *testing.T, dir string, domain string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return
+}
+
+func TestHttpsTerminator(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "frp-termination")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir, "example.com")
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.Header.Get("X-Forwarded-Proto") + " " + r.Header.Get("X-From-Frp")))
+	}))
+	defer backend.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer l.Close()
+	muxer, err := NewHttpsMuxer(l, 5*time.Second)
+	assert.NoError(err)
+	terminator, err := NewHttpsTerminator(muxer, HttpsTerminatorOptions{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+	assert.NoError(err)
+
+	err = terminator.Register(context.Background(), VhostRouteConfig{
+		Domain:      "example.com",
+		RewriteHost: "backend.local",
+		Headers:     map[string]string{"X-From-Frp": "1"},
+		CreateConnFn: func(remoteAddr string) (net.Conn, error) {
+			return net.Dial("tcp", backend.Listener.Addr().String())
+		},
+	})
+	assert.NoError(err)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("tcp", l.Addr().String())
+			},
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Get("https://example.com/")
+	if assert.NoError(err) {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal("backend.local https 1", string(body))
+	}
+
+	// domain is not listened on https muxer after unregister
+	terminator.UnRegister("example.com", "")
+	_, err = client.Get("https://example.com/")
+	assert.Error(err)
+}
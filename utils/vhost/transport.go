@@ -0,0 +1,89 @@
+// Copyright 2020 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhost
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fatedier/frp/utils/util"
+
+	"golang.org/x/net/http2"
+)
+
+// routeTransport sends requests to local services by HTTP/1.1, or by HTTP/2
+// without TLS if LocalHttp2 of the route is true. A new work connection is
+// used for each request.
+type routeTransport struct {
+	rp    *HttpReverseProxy
+	http1 *http.Transport
+	http2 *http2.Transport
+}
+
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.Context().Value("url").(string)
+	host := util.GetHostFromAddr(req.Context().Value("host").(string))
+	routeCfg := t.rp.GetRouteConfig(host, url)
+	if routeCfg == nil || !routeCfg.LocalHttp2 {
+		return t.http1.RoundTrip(req)
+	}
+
+	remote := req.Context().Value("remote").(string)
+	conn, err := t.rp.createConnection(req.Context(), host, url, remote)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := t.http2.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the work connection is closed if no response header is received in time
+	timer := time.AfterFunc(t.rp.responseHeaderTimeout, func() {
+		cc.Close()
+	})
+	res, err := cc.RoundTrip(req)
+	if !timer.Stop() {
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, &statusError{
+			status: http.StatusGatewayTimeout,
+			err:    fmt.Errorf("timeout awaiting response headers"),
+		}
+	}
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+	res.Body = &clientConnBody{ReadCloser: res.Body, cc: cc}
+	return res, nil
+}
+
+// clientConnBody closes the HTTP/2 connection after the response body is
+// closed.
+type clientConnBody struct {
+	io.ReadCloser
+	cc *http2.ClientConn
+}
+
+func (b *clientConnBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cc.Close()
+	return err
+}
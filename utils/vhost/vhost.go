@@ -19,6 +19,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fatedier/frp/utils/limit"
 	"github.com/fatedier/frp/utils/log"
 	frpNet "github.com/fatedier/frp/utils/net"
 	"github.com/fatedier/frp/utils/xlog"
@@ -66,6 +67,44 @@ type VhostRouteConfig struct {
 	Password    string
 	Headers     map[string]string
 
+	ResponseHeaders        map[string]string
+	RemoveRequestHeaders   []string
+	RemoveResponseHeaders  []string
+	DisableXForwardedFor   bool
+	DisableXForwardedProto bool
+	AddXRealIp             bool
+
+	// LocalHttp2 forwards requests to the local service by HTTP/2 without
+	// TLS, it's required by gRPC services.
+	LocalHttp2 bool
+
+	// Cache allows responses to be stored in the HttpCache of the reverse
+	// proxy, Compression compresses responses by gzip or brotli.
+	Cache       bool
+	Compression bool
+
+	StripPrefix  string
+	PathRewrites []PathRewriteRule
+	AddPrefix    string
+
+	// ErrorPages are error page templates named like 502.html and 502.json.
+	ErrorPages map[string]string
+
+	// authentication options besides Username and Password
+	Users                      map[string]string
+	BearerTokens               []string
+	ForwardAuthUrl             string
+	ForwardAuthResponseHeaders []string
+	Oidc                       *OidcAuth
+
+	// Limiter limits requests in total and from each client ip, requests
+	// over the limits get 429 responses. It's nil if there is no limit.
+	Limiter *limit.ConnLimiter
+
+	// Inspector records requests and responses for debugging, it's nil if
+	// it's not enabled.
+	Inspector *Inspector
+
 	CreateConnFn CreateConnFunc
 }
 
@@ -90,32 +129,12 @@ func (v *VhostMuxer) Listen(ctx context.Context, cfg *VhostRouteConfig) (l *List
 }
 
 func (v *VhostMuxer) getListener(name, path string) (l *Listener, exist bool) {
-	// first we check the full hostname
-	// if not exist, then check the wildcard_domain such as *.example.com
+	// exact, wildcard and regexp domains are matched by registryRouter
 	vr, found := v.registryRouter.Get(name, path)
 	if found {
 		return vr.payload.(*Listener), true
 	}
-
-	domainSplit := strings.Split(name, ".")
-	if len(domainSplit) < 3 {
-		return
-	}
-
-	for {
-		if len(domainSplit) < 3 {
-			return
-		}
-
-		domainSplit[0] = "*"
-		name = strings.Join(domainSplit, ".")
-
-		vr, found = v.registryRouter.Get(name, path)
-		if found {
-			return vr.payload.(*Listener), true
-		}
-		domainSplit = domainSplit[1:]
-	}
+	return
 }
 
 func (v *VhostMuxer) run() {